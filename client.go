@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client speaks the protocol of a Store's Handler on a remote gosh instance.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a Client for a Handler reachable at baseURL, e.g.,
+// "https://example.org/gosh". A nil httpClient uses http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// UploadOptions configures an Upload by a Client. All fields are optional.
+type UploadOptions struct {
+	Filename    string
+	ContentType string
+
+	// Lifetime of the new Item. Zero uses the server's default lifetime.
+	Lifetime time.Duration
+
+	BurnAfterReading bool
+}
+
+// Upload stores the content of r as a new Item on the server.
+func (c *Client) Upload(r io.Reader, opts UploadOptions) (*UploadResult, error) {
+	query := make(url.Values)
+	if opts.Filename != "" {
+		query.Set("filename", opts.Filename)
+	}
+	if opts.BurnAfterReading {
+		query.Set(formBurnAfterReading, "1")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/?"+query.Encode(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if opts.ContentType != "" {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+	if opts.Lifetime > 0 {
+		req.Header.Set("X-Expires-In", fmt.Sprintf("%ds", int64(opts.Lifetime.Seconds())))
+	}
+
+	resp, err := c.do(req, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result UploadResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Download fetches an Item's content, which must be closed afterwards. The
+// returned Item is restored from the response's headers and thus limited to
+// its ID, Filename, ContentType, Size, and Checksum. The Size is -1 if
+// unknown, e.g., for a compressed Item passed through gzip encoded.
+func (c *Client) Download(id string) (io.ReadCloser, *Item, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	item := &Item{
+		ID:          id,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		Checksum:    strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		item.Filename = params["filename"]
+	}
+
+	return resp.Body, item, nil
+}
+
+// Delete removes an Item from the server, authorized by its DeletionKey.
+func (c *Client) Delete(id, token string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// do sends a request and converts a response with another than the expected
+// status code into an error by responseError.
+func (c *Client) do(req *http.Request, expected int) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != expected {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError restores the error of a failed response. Known errors are
+// identified by the Handler's ErrorCode header or, as a fallback, by the HTTP
+// status code. Otherwise, an error with the response's status is returned.
+func responseError(resp *http.Response) error {
+	if codeName := resp.Header.Get(errorCodeHeader); codeName != "" {
+		for _, errorCode := range errorCodes {
+			if errorCode.code.String() == codeName {
+				return errorCode.err
+			}
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusRequestEntityTooLarge:
+		return ErrFileTooBig
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return fmt.Errorf("server responded %s: %s", resp.Status, msg)
+	}
+	return fmt.Errorf("server responded %s", resp.Status)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	limiter, err := NewTokenBucketLimiter(0.01, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(store.Handler(WithRateLimiter(limiter), WithMaxUploadBytes(64)))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/", nil)
+
+	result, err := client.Upload(strings.NewReader("hello world"), UploadOptions{
+		Filename:    "hello.txt",
+		ContentType: "text/plain",
+		Lifetime:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if result.URL != server.URL+"/"+result.ID {
+		t.Fatalf("Upload resulted in URL %q", result.URL)
+	} else if lifetime := time.Until(result.Expires); lifetime < 59*time.Minute || lifetime > time.Hour {
+		t.Fatalf("Upload expires in %v", lifetime)
+	}
+
+	f, item, err := client.Download(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "hello world" {
+		t.Fatalf("Download data is %q", data)
+	}
+
+	storedItem, err := store.Get(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Item{
+		ID:          result.ID,
+		Filename:    "hello.txt",
+		ContentType: "text/plain",
+		Size:        11,
+		Checksum:    storedItem.Checksum,
+	}
+	if !reflect.DeepEqual(*item, expected) {
+		t.Fatalf("Download resulted in Item %+v, expected %+v", *item, expected)
+	}
+
+	// The server's errors are restored as the package's errors.
+	if _, _, err := client.Download("nope"); err != ErrNotFound {
+		t.Fatalf("Download of unknown Item resulted in %v", err)
+	} else if err := client.Delete(result.ID, "nope"); err != ErrUnauthorized {
+		t.Fatalf("Deletion with wrong token resulted in %v", err)
+	} else if err := client.Delete("nope", "nope"); err != ErrNotFound {
+		t.Fatalf("Deletion of unknown Item resulted in %v", err)
+	} else if _, err := client.Upload(bytes.NewReader(make([]byte, 128)), UploadOptions{}); err != ErrFileTooBig {
+		t.Fatalf("Oversized upload resulted in %v", err)
+	} else if _, err := client.Upload(strings.NewReader("foo"), UploadOptions{Lifetime: time.Hour}); err != ErrRateLimited {
+		t.Fatalf("Rate limited upload resulted in %v", err)
+	}
+
+	if err := client.Delete(result.ID, result.DeletionKey); err != nil {
+		t.Fatal(err)
+	} else if _, _, err := client.Download(result.ID); err != ErrNotFound {
+		t.Fatalf("Download of deleted Item resulted in %v", err)
+	}
+}
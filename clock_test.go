@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock is a Clock only advancing by calling Advance.
+type manualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// manualTicker is a Ticker of a manualClock.
+type manualTicker struct {
+	clock    *manualClock
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+	resets   []time.Duration
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{now: now}
+}
+
+func (clock *manualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+func (clock *manualClock) NewTicker(d time.Duration) Ticker {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	ticker := &manualTicker{
+		clock:    clock,
+		interval: d,
+		next:     clock.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	clock.tickers = append(clock.tickers, ticker)
+	return ticker
+}
+
+// Advance the clock and fire all tickers being due, dropping ticks like a
+// time.Ticker does for slow receivers.
+func (clock *manualClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(d)
+	for _, ticker := range clock.tickers {
+		for !ticker.next.After(clock.now) {
+			select {
+			case ticker.c <- ticker.next:
+			default:
+			}
+			ticker.next = ticker.next.Add(ticker.interval)
+		}
+	}
+}
+
+func (ticker *manualTicker) C() <-chan time.Time {
+	return ticker.c
+}
+
+func (ticker *manualTicker) Reset(d time.Duration) {
+	ticker.clock.mutex.Lock()
+	defer ticker.clock.mutex.Unlock()
+
+	ticker.interval = d
+	ticker.next = ticker.clock.now.Add(d)
+	ticker.resets = append(ticker.resets, d)
+}
+
+func (ticker *manualTicker) Stop() {
+	ticker.clock.mutex.Lock()
+	defer ticker.clock.mutex.Unlock()
+
+	for i, t := range ticker.clock.tickers {
+		if t == ticker {
+			ticker.clock.tickers = append(ticker.clock.tickers[:i], ticker.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+func TestStoreManualClock(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithClock(clock),
+		WithCleanupInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: clock.Now().Add(90 * time.Second)}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First tick, the Item is not yet expired.
+	clock.Advance(time.Minute)
+	if _, err := store.Get(itemId); err != nil {
+		t.Fatalf("Item should not be expired yet: %v", err)
+	}
+
+	// Second tick, the Item is now expired and should be swept.
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, err := store.Count(); err != nil {
+			t.Fatal(err)
+		} else if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expired Item was not swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreStartStopCleanup(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithCleanupInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	putExpired := func() {
+		item := Item{Expires: clock.Now().Add(-time.Second)}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a running cleanup, the expired Item stays.
+	putExpired()
+	clock.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Store has %d Items without a cleanup, expected 1", n)
+	}
+
+	// Starting twice must not launch a second job.
+	store.StartCleanup()
+	store.StartCleanup()
+
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, err := store.Count(); err != nil {
+			t.Fatal(err)
+		} else if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expired Item was not swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store.StopCleanup()
+	store.StopCleanup()
+
+	// After stopping, no further sweeps should happen.
+	putExpired()
+	clock.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Store has %d Items after stopping the cleanup, expected 1", n)
+	}
+
+	// Close must stop a running cleanup, and starting afterwards is a no-op.
+	store.StartCleanup()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store.StartCleanup()
+}
+
+func TestStoreCleanupJitter(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithClock(clock),
+		WithCleanupInterval(time.Minute),
+		WithCleanupJitter(0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const (
+		minInterval = 54 * time.Second
+		maxInterval = 66 * time.Second
+		ticks       = 10
+	)
+
+	clock.mutex.Lock()
+	ticker := clock.tickers[0]
+	intervals := []time.Duration{ticker.interval}
+	clock.mutex.Unlock()
+
+	// Each tick resets the ticker to a new randomized interval.
+	for i := 1; i <= ticks; i++ {
+		clock.Advance(maxInterval)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			clock.mutex.Lock()
+			resets := len(ticker.resets)
+			clock.mutex.Unlock()
+
+			if resets >= i {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatalf("Ticker was not reset after tick %d", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	clock.mutex.Lock()
+	intervals = append(intervals, ticker.resets...)
+	clock.mutex.Unlock()
+
+	varies := false
+	for _, interval := range intervals {
+		if interval < minInterval || interval > maxInterval {
+			t.Fatalf("Interval %v exceeds [%v, %v]", interval, minInterval, maxInterval)
+		} else if interval != intervals[0] {
+			varies = true
+		}
+	}
+	if !varies {
+		t.Fatalf("Intervals do not vary: %v", intervals)
+	}
+}
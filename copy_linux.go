@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileRange copies the remaining content of src into dst by
+// copy_file_range(2), which happens entirely within the kernel.
+//
+// The returned ok is false if the fast path was not applicable, e.g., due to
+// different file systems, and nothing was copied. Then, the caller must fall
+// back to another copy method.
+func copyFileRange(dst, src *os.File) (written int64, ok bool, err error) {
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, 1<<30, 0)
+		if err != nil {
+			unsupported := errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) ||
+				errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP)
+			if written == 0 && unsupported {
+				return 0, false, nil
+			}
+			return written, true, err
+		}
+
+		if n == 0 {
+			return written, true, nil
+		}
+		written += int64(n)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// copyFileRange has no implementation for those platforms.
+func copyFileRange(dst, src *os.File) (written int64, ok bool, err error) {
+	return 0, false, nil
+}
@@ -4,15 +4,16 @@ go 1.21
 
 require (
 	github.com/akamensky/base58 v0.0.0-20210829145138-ce8bf8802e8f
+	github.com/dgraph-io/badger/v4 v4.1.0
 	github.com/oxzi/syscallset-go v0.1.5
 	github.com/timshannon/badgerhold/v4 v4.0.3
+	golang.org/x/crypto v0.18.0
 	golang.org/x/sys v0.16.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/dgraph-io/badger/v4 v4.1.0 // indirect
 	github.com/dgraph-io/ristretto v0.1.1 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/elastic/go-seccomp-bpf v1.3.0 // indirect
@@ -31,10 +31,31 @@ type Config struct {
 	Store struct {
 		Path string
 
+		InlineSize string `yaml:"inline_size"`
+
+		MaxConcurrentWrites int `yaml:"max_concurrent_writes"`
+		MaxConcurrentReads  int `yaml:"max_concurrent_reads"`
+
+		MaxItems int64 `yaml:"max_items"`
+
+		SyncWrites bool   `yaml:"sync_writes"`
+		FileSync   string `yaml:"file_sync"`
+
+		UploadTimeout time.Duration `yaml:"upload_timeout"`
+
+		ExpiryPolicy string        `yaml:"expiry_policy"`
+		ExpiryGrace  time.Duration `yaml:"expiry_grace"`
+
+		ChecksumAlgorithm string `yaml:"checksum_algorithm"`
+
+		StrictContentType bool `yaml:"strict_content_type"`
+
 		IdGenerator struct {
-			Type   string `yaml:"type"`
-			Length int    `yaml:"length"`
-			File   string `yaml:"file"`
+			Type     string  `yaml:"type"`
+			Length   int     `yaml:"length"`
+			File     string  `yaml:"file"`
+			Alphabet string  `yaml:"alphabet"`
+			MaxUsage float64 `yaml:"max_usage"`
 		} `yaml:"id_generator"`
 	}
 
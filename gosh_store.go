@@ -40,13 +40,33 @@ func mainStore(conf Config) {
 	slog.Debug("Starting store child", slog.Any("config", conf.Store))
 
 	var idGenerator func() (string, error)
+	var idSpace float64
 	switch conf.Store.IdGenerator.Type {
 	case "random":
 		idGenerator = randomIdGenerator(conf.Store.IdGenerator.Length)
+		idSpace = randomIdSpace(conf.Store.IdGenerator.Length)
+
+	case "alphabet":
+		var err error
+		idGenerator, err = alphabetIdGenerator(conf.Store.IdGenerator.Alphabet, conf.Store.IdGenerator.Length)
+		if err != nil {
+			slog.Error("Failed to create alphabet ID generator", slog.Any("error", err))
+			os.Exit(1)
+		}
+		idSpace = alphabetIdSpace(conf.Store.IdGenerator.Alphabet, conf.Store.IdGenerator.Length)
+
+	case "ulid":
+		var err error
+		idGenerator, err = ulidIdGenerator(conf.Store.IdGenerator.Length, nil)
+		if err != nil {
+			slog.Error("Failed to create ULID generator", slog.Any("error", err))
+			os.Exit(1)
+		}
+		idSpace = randomIdSpace(conf.Store.IdGenerator.Length)
 
 	case "wordlist":
 		var err error
-		idGenerator, err = wordlistIdGenerator(conf.Store.IdGenerator.File, conf.Store.IdGenerator.Length)
+		idGenerator, idSpace, err = wordlistIdGenerator(conf.Store.IdGenerator.File, conf.Store.IdGenerator.Length)
 		if err != nil {
 			slog.Error("Failed to create wordlist ID generator", slog.Any("error", err))
 			os.Exit(1)
@@ -58,6 +78,55 @@ func mainStore(conf Config) {
 		os.Exit(1)
 	}
 
+	var expiryPolicy ExpiryPolicy
+	switch conf.Store.ExpiryPolicy {
+	case "", "delete_on_access":
+		expiryPolicy = ExpiryDeleteOnAccess
+
+	case "hide_only":
+		expiryPolicy = ExpiryHideOnly
+
+	case "ignore":
+		expiryPolicy = ExpiryIgnore
+
+	default:
+		slog.Error("Failed to configure the expiry policy as it is unknown",
+			slog.String("policy", conf.Store.ExpiryPolicy))
+		os.Exit(1)
+	}
+
+	var fileSync FileSync
+	switch conf.Store.FileSync {
+	case "":
+		if conf.Store.SyncWrites {
+			fileSync = FileSyncAlways
+		}
+
+	case "never":
+		fileSync = FileSyncNever
+
+	case "on_close":
+		fileSync = FileSyncOnClose
+
+	case "always":
+		fileSync = FileSyncAlways
+
+	default:
+		slog.Error("Failed to configure the file sync policy as it is unknown",
+			slog.String("policy", conf.Store.FileSync))
+		os.Exit(1)
+	}
+
+	var inlineSize int64
+	if conf.Store.InlineSize != "" {
+		var err error
+		inlineSize, err = ParseBytesize(conf.Store.InlineSize)
+		if err != nil {
+			slog.Error("Failed to parse inline size", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
 	err := ensureStoreDir(conf.Store.Path, conf.User, conf.Group)
 	if err != nil {
 		slog.Error("Failed to prepare store directory", slog.Any("error", err))
@@ -102,12 +171,41 @@ func mainStore(conf Config) {
 		os.Exit(1)
 	}
 
-	store, err := NewStore("/", idGenerator, true)
+	storeOpts := []Option{
+		WithIdGenerator(idGenerator),
+		WithCleanup(true),
+		WithExpiryPolicy(expiryPolicy),
+		WithExpiryGrace(conf.Store.ExpiryGrace),
+		WithInlineSize(inlineSize),
+		WithMaxConcurrentWrites(conf.Store.MaxConcurrentWrites),
+		WithMaxConcurrentReads(conf.Store.MaxConcurrentReads),
+		WithMaxItems(conf.Store.MaxItems),
+		WithSyncWrites(conf.Store.SyncWrites),
+		WithFileSyncPolicy(fileSync),
+		WithUploadTimeout(conf.Store.UploadTimeout),
+	}
+	if conf.Store.ChecksumAlgorithm != "" {
+		storeOpts = append(storeOpts, WithChecksumAlgorithm(conf.Store.ChecksumAlgorithm))
+	}
+	if conf.Store.StrictContentType {
+		storeOpts = append(storeOpts, WithStrictContentType(nil))
+	}
+	if conf.Store.IdGenerator.MaxUsage > 0 {
+		storeOpts = append(storeOpts, WithIdSpace(idSpace, conf.Store.IdGenerator.MaxUsage))
+	}
+
+	store, err := NewStore("/", storeOpts...)
 	if err != nil {
 		slog.Error("Failed to create store", slog.Any("error", err))
 		os.Exit(1)
 	}
 
+	err = store.Migrate()
+	if err != nil {
+		slog.Error("Failed to migrate store", slog.Any("error", err))
+		os.Exit(1)
+	}
+
 	rpcConn, err := unixConnFromFile(os.NewFile(3, ""))
 	if err != nil {
 		slog.Error("Failed to create Unix Domain Socket from FD", slog.Any("error", err))
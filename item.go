@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,8 @@ import (
 	"time"
 
 	"github.com/akamensky/base58"
+	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -66,31 +69,249 @@ func NewOwnerTypes(r *http.Request) (owners map[OwnerType]net.IP, err error) {
 	return
 }
 
+// Disposition selects whether an Item is displayed by the browser or
+// downloaded as a file, as the type of its Content-Disposition header.
+type Disposition string
+
+const (
+	// DispositionAuto displays only images and plain text inline, being the
+	// default for an empty Disposition.
+	DispositionAuto Disposition = ""
+
+	DispositionInline     Disposition = "inline"
+	DispositionAttachment Disposition = "attachment"
+)
+
 // Item describes an uploaded file.
 type Item struct {
 	ID string `badgerhold:"key"`
 
 	DeletionKey string
 
+	// Slug is an optional human-readable alternative to the ID, unique among
+	// all Slugs and IDs. It is indexed, see Item.Indexes.
+	Slug string
+
 	BurnAfterReading bool
 
 	Filename    string
 	ContentType string
 
+	// Disposition of the Item when being served, see DispositionAuto.
+	Disposition Disposition
+
+	// Size of the Item's content in bytes.
+	Size int64
+
+	// Checksum of the Item's content as a hex encoded digest, calculated by
+	// the ChecksumAlgorithm, e.g., "sha256". It is indexed, see Item.Indexes.
+	Checksum          string
+	ChecksumAlgorithm string
+
+	// Blob is the name of the Item's file within the storage directory if it
+	// differs from its ID, e.g., its Checksum for BlobByChecksum.
+	Blob string
+
+	// Created is the time of the Item's upload.
 	Created time.Time
-	Expires time.Time `badgerholdIndex:"Expires"`
+
+	// Expires is the time after which the Item is deleted. Items to be kept
+	// forever use NoExpiry. Being zero, a Store's WithDefaultTTL applies.
+	Expires time.Time
+
+	// HideAfter optionally hides an Item before it Expires. Afterwards, it is
+	// not found anymore, but still available to Store.GetForced until being
+	// deleted after Expires.
+	HideAfter time.Time
+
+	// LastAccess is the time of this Item's upload or last retrieval. It is
+	// only set if enabled by WithLastAccess.
+	LastAccess time.Time
+
+	// Downloads counts how often this Item's content was read completely.
+	Downloads int64
+
+	// NotifyURL optionally receives a FirstDownloadNotification by POST after
+	// the Item's first complete download.
+	NotifyURL string
+
+	// Version is incremented by each change of the Item's metadata, e.g., by
+	// Store.Extend, but not by downloads. It allows optimistic concurrency by
+	// Store.UpdateCAS.
+	Version uint64
+
+	// LockedUntil makes an Item immutable until this time has passed. Before,
+	// it can neither be deleted nor get an earlier expiry date, except by
+	// Store.ForceDelete.
+	LockedUntil time.Time
 
 	Owner map[OwnerType]net.IP
+
+	// OwnerKey is the owner of the API key which uploaded the Item, if the
+	// Handler requires WithUploadKeys. Unlike Owner, it is no IP address.
+	OwnerKey string
+
+	// Inline holds the content of small Items stored within the database
+	// instead of as a file, limited by the Store's configuration.
+	Inline []byte
+
+	// Compressed and Encrypted describe the transformations applied to the
+	// Item's file. Inline Items are never transformed.
+	Compressed bool
+	Encrypted  bool
+
+	// PasswordHash is an optional bcrypt hash of a password required to
+	// retrieve this Item's content. Being empty, no password is required.
+	PasswordHash []byte
+
+	// KeyCheck verifies the client supplied key of an Item encrypted by
+	// Store.PutWithKey or Store.PutWithPassphrase, without revealing it. For a
+	// passphrase, KeySalt is the salt to derive the key from. The key itself
+	// is never stored.
+	KeyCheck []byte
+	KeySalt  []byte
+}
+
+// ClientEncrypted checks if the Item's content is encrypted by a client
+// supplied key, see Store.PutWithKey.
+func (i Item) ClientEncrypted() bool {
+	return len(i.KeyCheck) > 0
+}
+
+// SetPassword sets the PasswordHash from a plaintext password. An empty
+// password removes the password protection.
+func (i *Item) SetPassword(password string) error {
+	if password == "" {
+		i.PasswordHash = nil
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	i.PasswordHash = hash
+	return nil
+}
+
+// CheckPassword verifies a password against the PasswordHash. Items without a
+// PasswordHash accept any password.
+func (i Item) CheckPassword(password string) bool {
+	if len(i.PasswordHash) == 0 {
+		return true
+	}
+
+	return bcrypt.CompareHashAndPassword(i.PasswordHash, []byte(password)) == nil
+}
+
+// CheckDeletionKey verifies a key against the DeletionKey in constant time.
+// Items without a DeletionKey accept no key.
+func (i Item) CheckDeletionKey(key string) bool {
+	if i.DeletionKey == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(key), []byte(i.DeletionKey)) == 1
+}
+
+// Locked reports whether this Item's LockedUntil retention lasts beyond now.
+func (i Item) Locked(now time.Time) bool {
+	return now.Before(i.LockedUntil)
+}
+
+// NoExpiry is the Expires of Items which never expire, e.g., explicitly
+// bypassing a Store's WithDefaultTTL. It is a time far beyond any cleanup.
+var NoExpiry = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+// NeverExpires reports whether this Item's Expires is NoExpiry.
+func (i Item) NeverExpires() bool {
+	return !i.Expires.Before(NoExpiry)
+}
+
+// Expired reports whether this Item's Expires has passed by now, e.g., for an
+// Item returned by Store.Peek.
+func (i Item) Expired(now time.Time) bool {
+	return i.Expires.Before(now)
+}
+
+// Hidden reports whether this Item's HideAfter has passed by now.
+func (i Item) Hidden(now time.Time) bool {
+	return !i.HideAfter.IsZero() && !now.Before(i.HideAfter)
+}
+
+// Type implements badgerhold.Storer, keeping the name of the reflected type.
+func (i Item) Type() string {
+	return "Item"
+}
+
+// Indexes implements badgerhold.Storer to index the Slug, Checksum, and
+// Expires. In contrast to a badgerholdIndex tag, empty Slugs and Checksums are
+// not indexed. Otherwise, all Items without one would share one index entry,
+// being read and rewritten by each write and letting unrelated writes conflict.
+//
+// Items of the same content still share the entry of their Checksum, e.g.,
+// deduplicated by BlobByChecksum. Its size and the cost of each write to one
+// of these Items grows with the amount of duplicates.
+func (i Item) Indexes() map[string]badgerhold.Index {
+	return itemIndexes
+}
+
+var itemIndexes = map[string]badgerhold.Index{
+	"Slug": {IndexFunc: itemIndexFunc(func(i *Item) (interface{}, bool) {
+		return i.Slug, i.Slug != ""
+	})},
+	"Checksum": {IndexFunc: itemIndexFunc(func(i *Item) (interface{}, bool) {
+		return i.Checksum, i.Checksum != ""
+	})},
+	"Expires": {IndexFunc: itemIndexFunc(func(i *Item) (interface{}, bool) {
+		return i.Expires, true
+	})},
+}
+
+// itemIndexFunc creates a badgerhold.Index's IndexFunc for the field's
+// value, encoded like for a badgerholdIndex tag. Values not to be indexed
+// are skipped by returning false.
+func itemIndexFunc(field func(*Item) (interface{}, bool)) func(string, interface{}) ([]byte, error) {
+	return func(_ string, value interface{}) ([]byte, error) {
+		var i *Item
+		switch v := value.(type) {
+		case *Item:
+			i = v
+		case Item:
+			i = &v
+		default:
+			return nil, fmt.Errorf("cannot index %T as an Item", value)
+		}
+
+		v, ok := field(i)
+		if !ok {
+			return nil, nil
+		}
+		return badgerhold.DefaultEncode(v)
+	}
 }
 
 var (
 	ErrLifetimeTooLong = errors.New("Lifetime is greater than maximum lifetime")
 
+	ErrLifetimeTooShort = errors.New("Lifetime is less than minimum lifetime")
+
 	ErrFileTooBig = errors.New("File size is greater than maxium filesize")
 
 	filenamePattern = regexp.MustCompile(`[^0-9A-Za-z-_.]`)
 )
 
+// newDeletionKey creates a random DeletionKey for a new Item.
+func newDeletionKey() (string, error) {
+	delKeyBuff := make([]byte, 24)
+	_, err := rand.Read(delKeyBuff)
+	if err != nil {
+		return "", err
+	}
+	return string(base58.Encode(delKeyBuff)), nil
+}
+
 // NewItemFromRequest creates a new Item based on a Request.
 //
 // The ID will be left empty. Furthermore, if no error has occurred, a file
@@ -126,12 +347,10 @@ func NewItemFromRequest(r *http.Request, maxSize int64, maxLifetime time.Duratio
 		return
 	}
 
-	delKeyBuff := make([]byte, 24)
-	_, err = rand.Read(delKeyBuff)
+	item.DeletionKey, err = newDeletionKey()
 	if err != nil {
 		return
 	}
-	item.DeletionKey = string(base58.Encode(delKeyBuff))
 
 	if burnAfterReading := r.FormValue(formBurnAfterReading); burnAfterReading == "1" {
 		item.BurnAfterReading = true
@@ -2,19 +2,37 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
 	"math/big"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/akamensky/base58"
+	"github.com/dgraph-io/badger/v4"
 	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -26,6 +44,61 @@ const (
 // the requested ID.
 var ErrNotFound = errors.New("No Item found for this ID")
 
+// ErrStoreClosed is returned when accessing a Store after it was closed.
+var ErrStoreClosed = errors.New("Store is closed")
+
+// ErrAlreadyLocked is returned by NewStore if the database is already in use,
+// e.g., by another running instance.
+var ErrAlreadyLocked = errors.New("Store is already in use by another instance")
+
+// ErrIncompatibleDatabaseVersion is returned by NewStore if the database was
+// created by an incompatible badger version, e.g., after a dependency update.
+var ErrIncompatibleDatabaseVersion = errors.New("Store's database was created by an incompatible badger version, migrate it using the previous gosh version")
+
+// ErrIDSpaceNearlyFull is returned by Store.Put if the amount of Items exceeds
+// the configured fraction of the ID space, set by WithIdSpace.
+var ErrIDSpaceNearlyFull = errors.New("ID space is nearly exhausted, increase the ID length")
+
+// ErrTooManyOpenFiles is returned if the process ran out of file descriptors
+// while opening an Item's file, wrapping the original error, or if the limit
+// of WithMaxConcurrentReads was reached.
+var ErrTooManyOpenFiles = errors.New("Too many open files")
+
+// ErrItemLimitReached is returned by Store.Put if the amount of Items reached
+// the limit set by WithMaxItems.
+var ErrItemLimitReached = errors.New("Store holds the maximum amount of Items")
+
+// ErrSlugTaken is returned by Store.Put if an Item's Slug is already in use,
+// either as another Item's Slug or as an ID.
+var ErrSlugTaken = errors.New("Slug is already in use")
+
+// ErrInvalidSlug is returned by Store.Put for a Slug with characters other than
+// alphanumerics, dashes, and underscores.
+var ErrInvalidSlug = errors.New("Slug contains invalid characters")
+
+// ErrVersionConflict is returned by Store.UpdateCAS if the Item was changed in
+// the meantime, having another than the expected Version.
+var ErrVersionConflict = errors.New("Item was modified concurrently")
+
+// slugPattern matches valid Slugs.
+var slugPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// ErrUploadTimeout is returned by Store.Put if reading the file made no
+// progress for the duration set by WithUploadTimeout.
+var ErrUploadTimeout = errors.New("Upload made no progress and timed out")
+
+// ErrUnauthorized is returned by Store.GetFileWithPassword for a wrong
+// password and by Store.GetFileWithKey for a wrong key or passphrase.
+var ErrUnauthorized = errors.New("Wrong password for this Item")
+
+// ErrInvalidRange is returned by Store.GetFileRange for a range exceeding the
+// Item's content.
+var ErrInvalidRange = errors.New("Range is not within the Item's content")
+
+// ErrLocked is returned for attempts to delete or to shorten the lifetime of
+// an Item before its LockedUntil retention has passed.
+var ErrLocked = errors.New("Item is locked until its retention has passed")
+
 // BadgerLogWapper implements badger.Logger to forward logs to log/slog.
 type BadgerLogWapper struct {
 	*slog.Logger
@@ -47,14 +120,25 @@ func (logger *BadgerLogWapper) Debugf(f string, args ...interface{}) {
 	logger.Logger.Debug(fmt.Sprintf(f, args...), slog.String("producer", "badger"))
 }
 
+// randomIdSpace returns the amount of possible IDs for randomIdGenerator.
+func randomIdSpace(length int) float64 {
+	return math.Pow(2, float64(8*length))
+}
+
 // randomIdGenerator returns an ID generator for the "random" type.
 func randomIdGenerator(length int) func() (string, error) {
+	return randomIdGeneratorFrom(length, rand.Reader)
+}
+
+// randomIdGeneratorFrom works like randomIdGenerator, but reads from the
+// given random source, e.g., the Store's WithRandomSource for its default.
+func randomIdGeneratorFrom(length int, random io.Reader) func() (string, error) {
 	return func() (string, error) {
 		// n bytes or randomness, which would be for n = 4:
 		// 4*8 = 32 Bits of randomness; 2^32 = 4 294 967 296 possible combinations
 		idBuff := make([]byte, length)
 
-		_, err := rand.Read(idBuff)
+		_, err := io.ReadFull(random, idBuff)
 		if err != nil {
 			return "", err
 		}
@@ -63,11 +147,136 @@ func randomIdGenerator(length int) func() (string, error) {
 	}
 }
 
-// wordlistIdGenerator returns an ID generator for the "wordlist" type.
-func wordlistIdGenerator(sourceFile string, length int) (func() (string, error), error) {
+// alphabetIdGenerator returns an ID generator for the "alphabet" type,
+// creating IDs of length characters from the alphabet. Like for Slugs, the
+// alphabet is limited to alphanumerics, dashes, and underscores, being safe
+// within an URL's path.
+//
+// Each character is sampled uniformly for any alphabet size by rejecting
+// random bytes which would otherwise result in a modulo bias.
+func alphabetIdGenerator(alphabet string, length int) (func() (string, error), error) {
+	if length < 1 {
+		return nil, fmt.Errorf("ID length must be positive, not %d", length)
+	}
+
+	chars := []rune(alphabet)
+	if len(chars) < 2 {
+		return nil, fmt.Errorf("alphabet must have at least 2 characters, not %d", len(chars))
+	} else if !slugPattern.MatchString(alphabet) {
+		return nil, fmt.Errorf("alphabet %q must only contain alphanumerics, dashes, and underscores", alphabet)
+	}
+
+	seen := make(map[rune]struct{}, len(chars))
+	for _, c := range chars {
+		if _, ok := seen[c]; ok {
+			return nil, fmt.Errorf("alphabet contains %q multiple times", c)
+		}
+		seen[c] = struct{}{}
+	}
+
+	// Bytes at or above limit would favor the first characters.
+	limit := 256 - 256%len(chars)
+
+	return func() (string, error) {
+		id := make([]rune, 0, length)
+		buff := make([]byte, length)
+
+		for len(id) < length {
+			_, err := rand.Read(buff)
+			if err != nil {
+				return "", err
+			}
+
+			for _, b := range buff {
+				if int(b) >= limit {
+					continue
+				}
+
+				id = append(id, chars[int(b)%len(chars)])
+				if len(id) == length {
+					break
+				}
+			}
+		}
+
+		return string(id), nil
+	}, nil
+}
+
+// alphabetIdSpace returns the amount of possible IDs for alphabetIdGenerator.
+func alphabetIdSpace(alphabet string, length int) float64 {
+	return math.Pow(float64(len([]rune(alphabet))), float64(length))
+}
+
+// ulidAlphabet is Crockford's base32 alphabet used by ULIDs. Its characters
+// are in ascending order, keeping the encoding's lexicographical order.
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidTimeLength is the amount of characters encoding a ULID's milliseconds.
+const ulidTimeLength = 10
+
+// ulidEncoding encodes a ULID's random part.
+var ulidEncoding = base32.NewEncoding(ulidAlphabet).WithPadding(base32.NoPadding)
+
+// ulidIdGenerator returns an ID generator for the "ulid" type, creating
+// ULID-like IDs of the current Unix time in milliseconds followed by length
+// random bytes. For ten bytes, these are regular ULIDs. A nil clock uses the
+// system's clock.
+//
+// The IDs sort lexicographically by their creation time, but IDs of the same
+// millisecond are ordered randomly. Unlike other IDs, they reveal when an Item
+// was created and, with less time to guess, need more random bytes.
+func ulidIdGenerator(length int, clock Clock) (func() (string, error), error) {
+	if length < 1 {
+		return nil, fmt.Errorf("ULID random part must have at least one byte, not %d", length)
+	}
+
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return func() (string, error) {
+		randomBuff := make([]byte, length)
+		_, err := rand.Read(randomBuff)
+		if err != nil {
+			return "", err
+		}
+
+		var id [ulidTimeLength]byte
+		ms := uint64(clock.Now().UnixMilli())
+		for i := ulidTimeLength - 1; i >= 0; i-- {
+			id[i] = ulidAlphabet[ms&0x1f]
+			ms >>= 5
+		}
+
+		return string(id[:]) + ulidEncoding.EncodeToString(randomBuff), nil
+	}, nil
+}
+
+// ulidTime returns the creation time encoded within an ID of ulidIdGenerator.
+func ulidTime(id string) (time.Time, error) {
+	if len(id) <= ulidTimeLength {
+		return time.Time{}, fmt.Errorf("ULID %q is too short", id)
+	}
+
+	var ms uint64
+	for _, c := range []byte(id[:ulidTimeLength]) {
+		n := strings.IndexByte(ulidAlphabet, c)
+		if n < 0 {
+			return time.Time{}, fmt.Errorf("ULID %q contains invalid character %q", id, c)
+		}
+		ms = ms<<5 | uint64(n)
+	}
+
+	return time.UnixMilli(int64(ms)), nil
+}
+
+// wordlistIdGenerator returns an ID generator for the "wordlist" type together
+// with the amount of possible IDs.
+func wordlistIdGenerator(sourceFile string, length int) (func() (string, error), float64, error) {
 	f, err := os.Open(sourceFile)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer func() { _ = f.Close() }()
 
@@ -79,7 +288,7 @@ func wordlistIdGenerator(sourceFile string, length int) (func() (string, error),
 	}
 	err = scanner.Err()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	return func() (string, error) {
@@ -93,38 +302,271 @@ func wordlistIdGenerator(sourceFile string, length int) (func() (string, error),
 		}
 
 		return strings.Join(parts, "-"), nil
-	}, nil
+	}, math.Pow(float64(len(words)), float64(length)), nil
 }
 
+// ExpiryPolicy defines how Items are treated on access which are expired, but
+// not yet deleted by the cleanup.
+type ExpiryPolicy int
+
+const (
+	// ExpiryDeleteOnAccess deletes an expired Item on access and reports it as
+	// ErrNotFound. This is the default if the cleanup is enabled.
+	ExpiryDeleteOnAccess ExpiryPolicy = iota + 1
+
+	// ExpiryHideOnly reports an expired Item as ErrNotFound, but leaves its
+	// deletion to the cleanup.
+	ExpiryHideOnly
+
+	// ExpiryIgnore serves expired Items as if they were still valid. This is
+	// the default if the cleanup is disabled.
+	ExpiryIgnore
+)
+
+// FileSync defines if and when the files of new Items are fsynced, trading
+// their durability on a crash, e.g., a power loss, for the Puts' throughput.
+type FileSync int
+
+const (
+	// FileSyncNever leaves writing files back to the operating system. After
+	// a crash, the file of a recently acknowledged Item might be missing,
+	// truncated, or empty. This is the default and the fastest, especially
+	// for many small files.
+	FileSyncNever FileSync = iota
+
+	// FileSyncOnClose fsyncs each file once after its content was written,
+	// before it is renamed to its final path. Thus, a file never appears
+	// incomplete. However, the rename might be lost on a crash, leaving an
+	// Item without its file, as reported by Scan.
+	FileSyncOnClose
+
+	// FileSyncAlways additionally fsyncs the storage directory after the
+	// rename, making both the file and its path durable before Put returns.
+	// Together with WithSyncWrites, an acknowledged Item survives a crash.
+	FileSyncAlways
+)
+
 // Store stores an index of all Items as well as the pure files.
 type Store struct {
 	baseDir string
 
 	bh *badgerhold.Store
 
+	closedMutex sync.RWMutex
+	closed      bool
+
+	// draining is set by Shutdown, refusing new operations tracked by
+	// inFlight, e.g., uploads and downloads. Both are guarded by closedMutex.
+	draining bool
+	inFlight sync.WaitGroup
+
 	idGenerator func() (string, error)
 
-	cleanup bool
-	stopSyn chan struct{}
-	stopAck chan struct{}
+	// random is the source of randomness for the default idGenerator and the
+	// cleanup's jitter, crypto/rand unless replaced by WithRandomSource.
+	random io.Reader
+
+	// idSpace is the amount of possible IDs, idSpaceFill the usable fraction.
+	// Both are only checked if idSpace is positive.
+	idSpace     float64
+	idSpaceFill float64
+
+	// itemCount tracks the amount of Items for the idSpace and maxItems check
+	// as well as for Info. Items being inserted are counted as reservedItems
+	// until they are part of the itemCount, see reserveItems.
+	itemCount     atomic.Int64
+	reservedItems atomic.Int64
+
+	// opened is reported by Info, as is dbSchemaVersion, the database's schema
+	// version being upgraded by Migrate.
+	opened          time.Time
+	dbSchemaVersion atomic.Int64
+	migrateMutex    sync.Mutex
+
+	// maxItems limits the amount of Items, if positive.
+	maxItems int64
+
+	// maxFilenameLength truncates Filenames, if positive.
+	maxFilenameLength int
+
+	// defaultTTL is the lifetime of new Items without Expires, if positive.
+	defaultTTL time.Duration
+
+	// blobNaming names new Items' files. For BlobByChecksum, blobPending
+	// counts the files' references by Items not yet stored, guarded by
+	// blobMutex together with removing shared files.
+	blobNaming  BlobNaming
+	blobMutex   sync.Mutex
+	blobPending map[string]int
+
+	// contentTypeTolerance enables WithStrictContentType, if not nil.
+	contentTypeTolerance map[string][]string
+
+	// contentCache holds small Items' contents, if enabled by
+	// WithContentCache.
+	contentCache *contentCache
+
+	// zipSkipMissing skips unavailable Items for StreamZip instead of failing.
+	zipSkipMissing bool
+
+	// verifyOnDedup compares the content of files being shared by
+	// BlobByChecksum, see WithVerifyOnDedup.
+	verifyOnDedup bool
+
+	inlineSize int64
+
+	// writeSem limits concurrent file writes if not nil, readSem reads.
+	writeSem chan struct{}
+	readSem  chan struct{}
+
+	subs subscribers
+
+	// slugMutex serializes checking and inserting Items with a Slug.
+	slugMutex sync.Mutex
+
+	// updateMutexes serialize updates of the same Item, selected by its ID's
+	// hash, to avoid conflicting transactions, see updateItem.
+	updateMutexes [64]sync.Mutex
+
+	// writing holds the IDs of Items whose files are currently being written,
+	// to be ignored by Scan.
+	writing sync.Map
+
+	// reserved holds the IDs returned by createID until their Items were
+	// inserted, preventing concurrent Puts from using the same ID.
+	reserved sync.Map
+
+	// idsGenerated and idCollisions count the IDs tried by createID and those
+	// being already taken, see IDCollisionRate.
+	idsGenerated atomic.Uint64
+	idCollisions atomic.Uint64
+
+	syncWrites bool
+	fileSync   FileSync
+
+	// unsynced holds the paths of files written without fsync, see Sync. After
+	// more than maxUnsynced files, unsyncedOverflow is set instead.
+	unsynced         map[string]struct{}
+	unsyncedOverflow bool
+	unsyncedMutex    sync.Mutex
+
+	// blockCacheSize is badger's block cache in bytes, see WithBlockCacheSize.
+	blockCacheSize int64
+
+	// latencyObserver receives the operations' durations, if set.
+	latencyObserver LatencyObserver
+
+	// compress and encryptionKey configure the transformations of files, see
+	// newContentWriter.
+	compress      bool
+	encryptionKey []byte
+
+	// tempDir holds temporary files while being written, if set. Otherwise,
+	// they are created within the storageDir.
+	tempDir string
+
+	// uploadTimeout aborts Puts without progress for this duration, if set.
+	uploadTimeout time.Duration
+
+	// uploadExpiry is the time after which an inactive PartialUpload is
+	// abandoned, activeUploads holds the IDs of those being appended to.
+	uploadExpiry  time.Duration
+	activeUploads sync.Map
+
+	// thumbnailWidth and thumbnailHeight bound the size of Thumbnails, which
+	// are only created if both are set.
+	thumbnailWidth  int
+	thumbnailHeight int
+
+	// lastAccess enables updating an Item's LastAccess on each access.
+	lastAccess bool
+
+	// removeFile is os.Remove and openFile is os.OpenFile, both replaceable
+	// for testing.
+	removeFile func(name string) error
+	openFile   func(name string, flag int, perm fs.FileMode) (*os.File, error)
+
+	logger *slog.Logger
+
+	clock Clock
+
+	cleanup         bool
+	cleanupInterval time.Duration
+	cleanupJitter   float64
+
+	// expiryGrace delays the deletion of expired Items, see WithExpiryGrace.
+	expiryGrace time.Duration
+
+	// notifyClient delivers notifications to Items' NotifyURLs, retried after
+	// notifyRetryDelay, being doubled for each further attempt.
+	notifyClient     *http.Client
+	notifyRetryDelay time.Duration
+
+	// notifyDone is closed by Shutdown or Close to abort pending
+	// notifications, whose goroutines are tracked by inFlight.
+	notifyDone chan struct{}
+	notifyStop sync.Once
+
+	// expiryPolicy is derived from cleanup, unless set by WithExpiryPolicy.
+	expiryPolicy ExpiryPolicy
+
+	checksumAlgorithm string
+	checksumHash      func() hash.Hash
+
+	// cleanupMutex guards stopSyn and stopAck, both being nil if no cleanup
+	// goroutine is running.
+	cleanupMutex sync.Mutex
+	stopSyn      chan struct{}
+	stopAck      chan struct{}
 }
 
 // NewStore opens or initializes a Store in the given directory.
 //
-// autoCleanup specifies if both a background cleanup job will be launched as
-// well as deleting expired Items after being retrieved.
-func NewStore(
-	baseDir string,
-	idGenerator func() (string, error),
-	autoCleanup bool,
-) (s *Store, err error) {
+// The Store can be configured by Options, e.g., WithCleanup. Without any
+// Options, a Store with random IDs and an automatic cleanup is created.
+func NewStore(baseDir string, opts ...Option) (s *Store, err error) {
 	s = &Store{
-		baseDir:     baseDir,
-		idGenerator: idGenerator,
-		cleanup:     autoCleanup,
+		baseDir:           baseDir,
+		random:            rand.Reader,
+		removeFile:        os.Remove,
+		maxFilenameLength: defaultMaxFilenameLength,
+		openFile:          os.OpenFile,
+		logger:            slog.Default(),
+		clock:             systemClock{},
+		cleanup:           true,
+		cleanupInterval:   time.Minute,
+		uploadExpiry:      defaultUploadExpiry,
+		notifyClient:      &http.Client{Timeout: notifyTimeout},
+		notifyRetryDelay:  notifyRetryDelay,
+		notifyDone:        make(chan struct{}),
+		blockCacheSize:    badgerhold.DefaultOptions.BlockCacheSize,
+
+		checksumAlgorithm: defaultChecksumAlgorithm,
+		checksumHash:      checksumAlgorithms[defaultChecksumAlgorithm],
+	}
+
+	for _, opt := range opts {
+		err = opt(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.idGenerator == nil {
+		s.idGenerator = randomIdGeneratorFrom(8, s.random)
+	}
+
+	if s.blobNaming == BlobByChecksum && (s.compress || s.encryptionKey != nil) {
+		return nil, errors.New("files named by their checksum cannot be compressed or encrypted")
+	}
+
+	if s.expiryPolicy == 0 && s.cleanup {
+		s.expiryPolicy = ExpiryDeleteOnAccess
+	} else if s.expiryPolicy == 0 {
+		s.expiryPolicy = ExpiryIgnore
 	}
 
-	slog.Info("Opening Store", slog.String("directory", baseDir))
+	s.logger.Info("Opening Store", slog.String("directory", baseDir))
 
 	for _, dir := range []string{baseDir, s.databaseDir(), s.storageDir()} {
 		_, stat := os.Stat(dir)
@@ -134,226 +576,2217 @@ func NewStore(
 
 		err = os.Mkdir(dir, 0700)
 		if err != nil {
-			slog.Error("Cannot create directory", slog.String("directory", dir), slog.Any("error", err))
+			s.logger.Error("Cannot create directory", slog.String("directory", dir), slog.Any("error", err))
 			return
 		}
 	}
 
-	opts := badgerhold.DefaultOptions
-	opts.Dir = s.databaseDir()
-	opts.ValueDir = opts.Dir
-	opts.Logger = &BadgerLogWapper{slog.Default()}
-	opts.Options.BaseLevelSize = 1 << 21    // 2MiB
-	opts.Options.ValueLogFileSize = 1 << 24 // 16MiB
-	opts.Options.BaseTableSize = 1 << 20    // 1MiB
+	err = s.checkTempDir()
+	if err != nil {
+		s.logger.Error("Invalid temp directory", slog.Any("error", err))
+		return nil, err
+	}
+
+	bhOpts := badgerhold.DefaultOptions
+	bhOpts.Dir = s.databaseDir()
+	bhOpts.ValueDir = bhOpts.Dir
+	bhOpts.Logger = &BadgerLogWapper{s.logger}
+	bhOpts.Options.BaseLevelSize = 1 << 21    // 2MiB
+	bhOpts.Options.ValueLogFileSize = 1 << 24 // 16MiB
+	bhOpts.Options.BaseTableSize = 1 << 20    // 1MiB
+	bhOpts.Options.SyncWrites = s.syncWrites
+	bhOpts.Options.BlockCacheSize = s.blockCacheSize
+
+	s.bh, err = openBadgerHold(bhOpts)
+	if err != nil && isBadgerLockError(err) {
+		err = fmt.Errorf("%w: directory %q: %v", ErrAlreadyLocked, s.databaseDir(), err)
+		s.logger.Error("Database is locked", slog.Any("error", err))
+		return
+	} else if err != nil && isBadgerVersionError(err) {
+		err = fmt.Errorf("%w: directory %q: %v", ErrIncompatibleDatabaseVersion, s.databaseDir(), err)
+		s.logger.Error("Database has an incompatible version", slog.Any("error", err))
+		return
+	} else if err != nil {
+		s.logger.Error("Cannot open database", slog.Any("error", err))
+		return
+	}
 
-	s.bh, err = badgerhold.Open(opts)
+	var n uint64
+	n, err = s.bh.Count(&Item{}, nil)
 	if err != nil {
+		s.logger.Error("Failed to count Items", slog.Any("error", err))
 		return
 	}
+	s.itemCount.Store(int64(n))
 
-	if s.cleanup {
-		s.stopSyn = make(chan struct{})
-		s.stopAck = make(chan struct{})
+	err = s.loadSchemaVersion()
+	if err != nil {
+		s.logger.Error("Failed to load database schema version", slog.Any("error", err))
+		_ = s.bh.Close()
+		return nil, err
+	}
+	s.opened = s.clock.Now()
 
-		go s.cleanupExired()
+	if s.cleanup {
+		s.StartCleanup()
 	}
 
 	return
 }
 
+// openBadgerHold is badgerhold.Open, replaceable for testing.
+var openBadgerHold = badgerhold.Open
+
+// isBadgerVersionError checks if badger refused to open a database of another
+// format version, again only possible by its message.
+func isBadgerVersionError(err error) bool {
+	return strings.Contains(err.Error(), "manifest has unsupported version") ||
+		strings.Contains(err.Error(), "external magic number doesn't match")
+}
+
+// isBadgerLockError checks if badger failed to acquire its directory lock.
+//
+// Unfortunately, badger does not wrap the underlying error, leaving only a
+// check against its message.
+func isBadgerLockError(err error) bool {
+	return strings.Contains(err.Error(), "Cannot acquire directory lock")
+}
+
 // databaseDir returns the database subdirectory.
-func (s Store) databaseDir() string {
+func (s *Store) databaseDir() string {
 	return filepath.Join(s.baseDir, DirDatabase)
 }
 
 // storageDir returns the file storage subdirectory.
-func (s Store) storageDir() string {
+func (s *Store) storageDir() string {
 	return filepath.Join(s.baseDir, DirStorage)
 }
 
-// cleanupExired runs in a background goroutine to clean up expired Items.
-func (s *Store) cleanupExired() {
-	var ticker = time.NewTicker(time.Minute)
-	defer ticker.Stop()
+// tmpSuffix is appended to the ID of a file being written.
+const tmpSuffix = ".tmp"
+
+// tempPath returns the path of the temporary file for an Item being written,
+// either within the tempDir or the storageDir.
+func (s *Store) tempPath(id string) string {
+	dir := s.tempDir
+	if dir == "" {
+		dir = s.storageDir()
+	}
+	return filepath.Join(dir, id+tmpSuffix)
+}
+
+// checkTempDir verifies that the tempDir is on the same file system as the
+// storageDir, allowing to rename files between them.
+func (s *Store) checkTempDir() error {
+	if s.tempDir == "" {
+		return nil
+	}
+
+	var tmpStat, storageStat unix.Stat_t
+	if err := unix.Stat(s.tempDir, &tmpStat); err != nil {
+		return fmt.Errorf("temp directory %q: %w", s.tempDir, err)
+	} else if err := unix.Stat(s.storageDir(), &storageStat); err != nil {
+		return fmt.Errorf("storage directory %q: %w", s.storageDir(), err)
+	}
+
+	if tmpStat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return fmt.Errorf("temp directory %q is no directory", s.tempDir)
+	} else if tmpStat.Dev != storageStat.Dev {
+		return fmt.Errorf("temp directory %q is not on the same file system as %q", s.tempDir, s.storageDir())
+	}
+	return nil
+}
+
+// StartCleanup launches the background cleanup job, if not already running.
+//
+// This allows enabling the cleanup at runtime, e.g., after creating the Store
+// with WithCleanup(false). Deleting expired Items on access stays as
+// configured by WithCleanup.
+func (s *Store) StartCleanup() {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		s.logger.Warn("Cannot start cleanup of a closed Store")
+		return
+	}
+
+	s.cleanupMutex.Lock()
+	defer s.cleanupMutex.Unlock()
+
+	if s.stopSyn != nil {
+		return
+	}
+
+	s.logger.Debug("Starting background cleanup", slog.Duration("interval", s.cleanupInterval))
+
+	s.stopSyn = make(chan struct{})
+	s.stopAck = make(chan struct{})
+
+	// The Ticker is created here to start counting immediately.
+	go s.cleanupExired(s.clock.NewTicker(s.cleanupTick()), s.stopSyn, s.stopAck)
+}
+
+// StopCleanup stops the background cleanup job, if running. It returns after
+// the job has finished, i.e., no further cleanup will happen.
+func (s *Store) StopCleanup() {
+	s.cleanupMutex.Lock()
+	defer s.cleanupMutex.Unlock()
 
+	if s.stopSyn == nil {
+		return
+	}
+
+	s.logger.Debug("Stopping background cleanup")
+
+	close(s.stopSyn)
+	<-s.stopAck
+
+	s.stopSyn = nil
+	s.stopAck = nil
+}
+
+// cleanupExired runs in a background goroutine to clean up expired Items.
+func (s *Store) cleanupExired(ticker Ticker, stopSyn <-chan struct{}, stopAck chan<- struct{}) {
 	for {
 		select {
-		case <-s.stopSyn:
-			close(s.stopAck)
+		case <-stopSyn:
+			ticker.Stop()
+			close(stopAck)
 			return
 
-		case <-ticker.C:
-			if err := s.deleteExpired(); err != nil {
-				slog.Error("Deletion of expired Items failed", slog.Any("error", err))
+		case <-ticker.C():
+			if _, err := s.deleteExpired(); err != nil {
+				s.logger.Error("Deletion of expired Items failed", slog.Any("error", err))
+			}
+			if _, err := s.deleteAbandonedUploads(); err != nil {
+				s.logger.Error("Deletion of abandoned partial uploads failed", slog.Any("error", err))
+			}
+
+			if s.cleanupJitter > 0 {
+				ticker.Reset(s.cleanupTick())
 			}
 		}
 	}
 }
 
+// cleanupTick returns the duration until the next cleanup, being the cleanup
+// interval randomized by up to the cleanup jitter in both directions.
+func (s *Store) cleanupTick() time.Duration {
+	if s.cleanupJitter <= 0 {
+		return s.cleanupInterval
+	}
+
+	maxJitter := int64(float64(s.cleanupInterval) * s.cleanupJitter)
+	n, err := rand.Int(s.random, big.NewInt(2*maxJitter+1))
+	if err != nil {
+		s.logger.Warn("Failed to randomize cleanup interval", slog.Any("error", err))
+		return s.cleanupInterval
+	}
+	return s.cleanupInterval + time.Duration(n.Int64()-maxJitter)
+}
+
 // createID creates an ID for a new Item based on the Store.idGenerator.
+//
+// The ID is reserved until the caller releases it by releaseID, after the Item
+// was inserted or its insertion failed. Thus, even an idGenerator returning
+// duplicates cannot result in concurrent Puts with the same ID.
 func (s *Store) createID() (string, error) {
 	for i := 0; i < 32; i++ {
 		id, err := s.idGenerator()
 		if err != nil {
 			return "", err
 		}
+		s.idsGenerated.Add(1)
 
-		err = s.bh.Get(id, Item{})
-		switch err {
-		case nil:
-			// Continue if this ID is already in use
+		// Continue if this ID is already in use, also as a Slug
+		taken, err := s.idTaken(id)
+		if err != nil {
+			return "", err
+		} else if taken {
+			s.idCollisions.Add(1)
 			continue
+		}
 
-		case badgerhold.ErrNotFound:
-			// Use this ID if there is no such entry
-			return id, nil
-
-		default:
-			// Otherwise, pass error along
-			return "", err
+		if _, dup := s.reserved.LoadOrStore(id, struct{}{}); dup {
+			s.logger.Warn("ID generator returned an ID already being inserted", slog.String("id", id))
+			s.idCollisions.Add(1)
+			continue
 		}
+		return id, nil
 	}
 
 	return "", errors.New("failed to calculate a free ID")
 }
 
+// reserveItems reserves n slots for new Items against both the idSpace and
+// maxItems limits, refusing them by ErrIDSpaceNearlyFull or
+// ErrItemLimitReached. Thus, concurrent insertions cannot exceed the limits.
+//
+// The caller must release the reservation by decrementing reservedItems,
+// after the inserted Items were added to the itemCount.
+func (s *Store) reserveItems(n int64) error {
+	for {
+		reserved := s.reservedItems.Load()
+		count := s.itemCount.Load() + reserved
+
+		// Each new Item is refused if the count already met the limit.
+		if s.idSpace > 0 && float64(count+n-1) >= s.idSpace*s.idSpaceFill {
+			return ErrIDSpaceNearlyFull
+		} else if s.maxItems > 0 && count+n > s.maxItems {
+			return ErrItemLimitReached
+		}
+
+		if s.reservedItems.CompareAndSwap(reserved, reserved+n) {
+			return nil
+		}
+	}
+}
+
+// releaseID releases an ID reserved by createID.
+func (s *Store) releaseID(id string) {
+	s.reserved.Delete(id)
+}
+
+// IDCollisions returns how often a generated ID was already taken and had to
+// be generated again since the Store was opened.
+func (s *Store) IDCollisions() uint64 {
+	return s.idCollisions.Load()
+}
+
+// IDCollisionRate returns the IDCollisions per generated ID since the Store
+// was opened, or zero before the first ID. A rising rate is an early warning
+// of a crowded ID space, asking for longer IDs.
+func (s *Store) IDCollisionRate() float64 {
+	generated := s.idsGenerated.Load()
+	if generated == 0 {
+		return 0
+	}
+	return float64(s.idCollisions.Load()) / float64(generated)
+}
+
 // Close the Store and its database.
+//
+// After the Store was closed, each subsequent Close call returns
+// ErrStoreClosed. References obtained by BadgerHoldSafe must not be used
+// anymore, which can be verified by calling BadgerHoldSafe again.
 func (s *Store) Close() error {
-	slog.Info("Closing Store")
+	s.closedMutex.Lock()
+	defer s.closedMutex.Unlock()
 
-	if s.cleanup {
-		close(s.stopSyn)
-		<-s.stopAck
+	if s.closed {
+		return ErrStoreClosed
 	}
+	s.closed = true
+
+	s.logger.Info("Closing Store")
+
+	s.StopCleanup()
+	s.stopNotifications()
+	s.closeSubscribers()
 
 	return s.bh.Close()
 }
 
 // Get an Item by its ID. The Item's file can be accessed with GetFile.
 func (s *Store) Get(id string) (i Item, err error) {
-	slog.Debug("Requested Item from Store", slog.String("id", id))
+	defer s.observeLatency(LatencyGet, s.clock.Now())
+
+	i, err = s.get(id)
+	if err != nil {
+		return
+	}
+
+	s.touch(&i)
+	return
+}
+
+// get implements Get without updating the Item's LastAccess.
+func (s *Store) get(id string) (i Item, err error) {
+	s.logger.Debug("Requested Item from Store", slog.String("id", id))
 
 	err = s.bh.Get(id, &i)
 	if err == badgerhold.ErrNotFound {
-		slog.Debug("Requested Item was not found", slog.String("id", id))
+		s.logger.Debug("Requested Item was not found", slog.String("id", id))
 		err = ErrNotFound
 		return
 	} else if err != nil {
-		slog.Error("Requesting Item failed", slog.String("id", id))
+		s.logger.Error("Requesting Item failed", slog.String("id", id))
 		return
 	}
 
-	if s.cleanup && i.Expires.Before(time.Now()) {
-		slog.Info("Requested Item is expired, will be deleted",
-			slog.String("id", id), slog.Any("expires", i.Expires))
+	err = s.available(i)
+	return
+}
 
-		err = s.Delete(i.ID)
-		if err != nil {
-			slog.Error("Failed to delete expired Item", slog.String("id", id), slog.Any("error", err))
-			return
+// available checks if an already fetched Item may be served. Otherwise,
+// ErrNotFound is returned for hidden Items as well as for expired Items,
+// depending on the ExpiryPolicy. Expired Items might be deleted.
+func (s *Store) available(i Item) error {
+	now := s.clock.Now()
+
+	if s.expiryPolicy != ExpiryIgnore && i.Expires.Before(now) {
+		if s.expiryPolicy == ExpiryHideOnly || i.Locked(now) || !i.Expires.Before(now.Add(-s.expiryGrace)) {
+			s.logger.Debug("Requested Item is expired, deletion is left to the cleanup",
+				slog.String("id", i.ID), slog.Any("expires", i.Expires))
+			return ErrNotFound
 		}
 
-		err = ErrNotFound
+		s.logger.Info("Requested Item is expired, will be deleted",
+			slog.String("id", i.ID), slog.Any("expires", i.Expires))
+
+		err := s.Delete(i.ID)
+		if err != nil {
+			s.logger.Error("Failed to delete expired Item", slog.String("id", i.ID), slog.Any("error", err))
+			return err
+		}
+		return ErrNotFound
 	}
 
-	return
-}
+	if i.Hidden(now) {
+		s.logger.Debug("Requested Item is hidden",
+			slog.String("id", i.ID), slog.Any("hide-after", i.HideAfter))
+		return ErrNotFound
+	}
 
-// GetFile creates a ReadCloser for a stored Item file by this ID.
-func (s *Store) GetFile(id string) (*os.File, error) {
-	return os.Open(filepath.Join(s.storageDir(), id))
+	return nil
 }
 
-// Put a new Item inside the Store.
-//
-// Both a database entry and a file will be created. The given file will be
-// read into the storage and closed afterwards.
-func (s *Store) Put(i Item, file io.ReadCloser) (id string, err error) {
-	slog.Debug("Requested insertion of Item into the Store")
-
-	id, err = s.createID()
-	if err != nil {
-		slog.Error("Failed to create an ID for a new Item", slog.Any("error", err))
+// touch sets the Item's LastAccess to now, both for i and in the database, if
+// enabled by WithLastAccess. Failures are only logged.
+func (s *Store) touch(i *Item) {
+	if !s.lastAccess {
 		return
 	}
 
-	i.ID = id
-	slog.Debug("Insert Item with assigned ID", slog.String("id", i.ID))
-
-	err = s.bh.Insert(i.ID, i)
+	now := s.clock.Now().UTC()
+	err := s.updateAccess(i.ID, func(i *Item) error {
+		i.LastAccess = now
+		return nil
+	})
 	if err != nil {
-		slog.Error("Failed to insert Item into database",
-			slog.String("id", i.ID), slog.Any("error", err))
+		s.logger.Warn("Failed to update Item's last access", slog.String("id", i.ID), slog.Any("error", err))
 		return
 	}
+	i.LastAccess = now
+}
 
-	f, err := os.Create(filepath.Join(s.storageDir(), i.ID))
-	if err != nil {
-		slog.Error("Failed to create file",
-			slog.String("id", i.ID), slog.Any("error", err))
-		return
+// GetFile creates a ReadCloser for a stored Item file by this ID.
+//
+// For Items stored inline, the content is served from memory. Otherwise, the
+// file is opened. Closing the ReadCloser after reading it completely counts
+// as a download, incrementing the Item's Downloads and updating its
+// LastAccess. Partial reads have no such effect.
+//
+// Password protected Items result in ErrUnauthorized, see GetFileWithPassword.
+func (s *Store) GetFile(id string) (io.ReadCloser, error) {
+	return s.getFile(id, "")
+}
+
+// getFile implements both GetFile and GetFileWithPassword.
+func (s *Store) getFile(id, password string) (io.ReadCloser, error) {
+	defer s.observeLatency(LatencyGet, s.clock.Now())
+
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = s.available(i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.authorize(i, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.openDownload(i, s.openCached)
+}
+
+// authorize checks the password of a password protected Item before its
+// content is opened, returning ErrUnauthorized for a mismatch. Items without
+// a PasswordHash accept any password.
+func (s *Store) authorize(i Item, password string) error {
+	if len(i.PasswordHash) > 0 && !i.CheckPassword(password) {
+		s.logger.Warn("Denied access to Item for a wrong password", slog.String("id", i.ID))
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// open a file like os.OpenFile, but wraps running out of file descriptors as
+// ErrTooManyOpenFiles.
+func (s *Store) open(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	f, err := s.openFile(name, flag, perm)
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+		s.logger.Error("Ran out of file descriptors", slog.String("file", name), slog.Any("error", err))
+		return nil, fmt.Errorf("%w: %w", ErrTooManyOpenFiles, err)
+	}
+	return f, err
+}
+
+// openContent returns the content of an already fetched Item, either from
+// its Inline field or its file.
+func (s *Store) openContent(i Item) (io.ReadCloser, error) {
+	if len(i.Inline) > 0 {
+		return io.NopCloser(bytes.NewReader(i.Inline)), nil
+	}
+
+	f, err := s.open(s.itemPath(i), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.newContentReader(f, i)
+}
+
+// openCompressedContent works like openContent, but keeps a compressed Item's
+// content gzip compressed. Thus, it only differs for compressed files.
+func (s *Store) openCompressedContent(i Item) (io.ReadCloser, error) {
+	if len(i.Inline) > 0 || !i.Compressed {
+		return s.openContent(i)
+	}
+
+	f, err := s.open(s.itemPath(i), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.newDecryptingReader(f, i)
+}
+
+// GetFileRange works like GetFile, but returns only length bytes of the
+// Item's content, starting at offset. A range exceeding the Item's Size
+// results in ErrInvalidRange. Partial reads are not counted as downloads.
+//
+// Plain files are seeked to the offset. Otherwise, e.g., for compressed
+// files, the content before the offset must be read and discarded.
+//
+// Like for GetFile, password protected Items result in ErrUnauthorized.
+func (s *Store) GetFileRange(id string, offset, length int64) (io.ReadCloser, error) {
+	return s.getFileRange(id, "", offset, length)
+}
+
+// getFileRange implements GetFileRange, checking the password by authorize.
+func (s *Store) getFileRange(id, password string, offset, length int64) (io.ReadCloser, error) {
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = s.available(i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.authorize(i, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || length < 0 || offset+length > i.Size {
+		return nil, ErrInvalidRange
+	}
+
+	f, err := s.openTracked(i, s.openContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if fsFile, ok := f.(*inFlightReader).ReadCloser.(*os.File); ok {
+		_, err = fsFile.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, f, offset)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// openDownload opens an Item's content by open like openTracked, recording a
+// download on closing after it was read completely.
+func (s *Store) openDownload(i Item, open func(Item) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	f, err := s.openTracked(i, open)
+	if err != nil {
+		return nil, err
+	}
+	return &downloadReader{ReadCloser: f, store: s, id: i.ID}, nil
+}
+
+// downloadReader wraps an Item's content to call recordDownload on Close, if
+// it was read until io.EOF.
+type downloadReader struct {
+	io.ReadCloser
+
+	store *Store
+	id    string
+	eof   bool
+	once  sync.Once
+}
+
+func (r *downloadReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return
+}
+
+func (r *downloadReader) Close() error {
+	// The download is recorded first, as closing the underlying openTracked
+	// reader might allow a Shutdown to close the database.
+	r.once.Do(func() {
+		if r.eof {
+			r.store.recordDownload(r.id)
+		}
+	})
+	return r.ReadCloser.Close()
+}
+
+// recordDownload increments an Item's Downloads and, if enabled by
+// WithLastAccess, updates its LastAccess. The first download is notified to
+// the Item's NotifyURL, if set. Failures are only logged.
+func (s *Store) recordDownload(id string) {
+	now := s.clock.Now().UTC()
+
+	var first bool
+	var notifyURL string
+	err := s.updateAccess(id, func(i *Item) error {
+		first, notifyURL = i.Downloads == 0, i.NotifyURL
+		i.Downloads++
+		if s.lastAccess {
+			i.LastAccess = now
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		s.logger.Debug("Downloaded Item was deleted in the meantime", slog.String("id", id))
+	} else if err != nil {
+		s.logger.Warn("Failed to record Item's download", slog.String("id", id), slog.Any("error", err))
+	} else if first && notifyURL != "" {
+		s.notifyFirstDownload(id, notifyURL, now)
+	}
+}
+
+// StreamTo writes an Item's content to w, returning the amount of bytes.
+func (s *Store) StreamTo(id string, w io.Writer) (int64, error) {
+	f, err := s.GetFile(id)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(w, f)
+}
+
+// GetWithFile combines Get and GetFile, returning both the Item and its
+// content. Compared to two separate calls, an Item cannot be deleted in
+// between, e.g., by the cleanup. As the file is already opened, it stays
+// readable even if the Item is deleted afterwards.
+//
+// If either the Item or its file is missing, ErrNotFound is returned. Like
+// for Get, expired Items might be deleted and BurnAfterReading is left to the
+// caller. Password protected Items result in ErrUnauthorized, see
+// GetWithFileAndPassword.
+func (s *Store) GetWithFile(id string) (Item, io.ReadCloser, error) {
+	return s.getWithFile(id, "", s.openCached)
+}
+
+// GetWithFileAndPassword works like GetWithFile, but checks the password first
+// for password protected Items. For a mismatch, ErrUnauthorized is returned.
+func (s *Store) GetWithFileAndPassword(id, password string) (Item, io.ReadCloser, error) {
+	return s.getWithFile(id, password, s.openCached)
+}
+
+// GetWithCompressedFile works like GetWithFile, but returns the content of a
+// compressed Item as its stored gzip stream, e.g., to be served with a gzip
+// Content-Encoding without decompressing it first. The returned flag reports
+// if the content is compressed, being false for other Items.
+//
+// Like for GetWithFile, reading the stream until io.EOF counts as a download
+// and password protected Items result in ErrUnauthorized.
+func (s *Store) GetWithCompressedFile(id string) (Item, io.ReadCloser, bool, error) {
+	return s.getWithCompressedFile(id, "")
+}
+
+// getWithCompressedFile implements GetWithCompressedFile, checking the
+// password by authorize.
+func (s *Store) getWithCompressedFile(id, password string) (Item, io.ReadCloser, bool, error) {
+	i, f, err := s.getWithFile(id, password, s.openCompressedContent)
+	if err != nil {
+		return Item{}, nil, false, err
+	}
+	return i, f, i.Compressed && len(i.Inline) == 0, nil
+}
+
+// getWithFile implements GetWithFile, checking the password by authorize and
+// opening the content by open.
+func (s *Store) getWithFile(id, password string, open func(Item) (io.ReadCloser, error)) (Item, io.ReadCloser, error) {
+	defer s.observeLatency(LatencyGet, s.clock.Now())
+
+	i, err := s.get(id)
+	if err != nil {
+		return Item{}, nil, err
+	}
+
+	err = s.authorize(i, password)
+	if err != nil {
+		return Item{}, nil, err
+	}
+
+	f, err := s.openDownload(i, open)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Item{}, nil, ErrNotFound
+	} else if err != nil {
+		return Item{}, nil, err
+	}
+
+	// The Item might have been deleted after being fetched, but before its
+	// file was opened. Afterwards, the opened file is not affected anymore.
+	err = s.bh.Get(id, &Item{})
+	if err != nil {
+		_ = f.Close()
+		if err == badgerhold.ErrNotFound {
+			err = ErrNotFound
+		}
+		return Item{}, nil, err
+	}
+
+	return i, f, nil
+}
+
+// GetForced returns an Item and its content like GetWithFile, but ignores both
+// its HideAfter and Expires. It is meant as an administrative access to Items
+// which are hidden, but not yet deleted.
+func (s *Store) GetForced(id string) (Item, io.ReadCloser, error) {
+	s.logger.Debug("Requested forced access to Item", slog.String("id", id))
+
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return Item{}, nil, ErrNotFound
+	} else if err != nil {
+		return Item{}, nil, err
+	}
+
+	f, err := s.openTracked(i, s.openContent)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Item{}, nil, ErrNotFound
+	} else if err != nil {
+		return Item{}, nil, err
+	}
+	return i, f, nil
+}
+
+// Peek returns an Item's metadata without any side effects, e.g., for admin
+// tooling. Unlike Get, expired and hidden Items are returned as well and are
+// not deleted, which might be checked by Item.Expired and Item.Hidden.
+// Neither the Item's Downloads nor its LastAccess are updated.
+func (s *Store) Peek(id string) (Item, error) {
+	s.logger.Debug("Requested peek at Item", slog.String("id", id))
+
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return Item{}, ErrNotFound
+	} else if err != nil {
+		return Item{}, err
+	}
+	return i, nil
+}
+
+// GetFileWithPassword works like GetFile, but checks the password first for
+// password protected Items. For a mismatch, ErrUnauthorized is returned.
+func (s *Store) GetFileWithPassword(id, password string) (io.ReadCloser, error) {
+	return s.getFile(id, password)
+}
+
+// readInline tries to read the whole file if it fits into the Store's
+// inlineSize. Otherwise, the already consumed prefix is returned together with
+// inline being false and must be prepended to the rest of the file.
+func (s *Store) readInline(file io.Reader) (data []byte, inline bool, err error) {
+	if s.inlineSize <= 0 {
+		return nil, false, nil
+	}
+
+	data = make([]byte, s.inlineSize+1)
+	n, err := io.ReadFull(file, data)
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		// Empty files are stored as files as an empty Inline cannot be
+		// distinguished from none after being decoded.
+		return data[:n], n > 0, nil
+
+	case nil:
+		return data, false, nil
+
+	default:
+		return nil, false, err
+	}
+}
+
+// sameFilesystem checks if both files are regular files on the same device.
+func sameFilesystem(a, b *os.File) bool {
+	var aStat, bStat unix.Stat_t
+	if unix.Fstat(int(a.Fd()), &aStat) != nil || unix.Fstat(int(b.Fd()), &bStat) != nil {
+		return false
+	}
+
+	aReg := aStat.Mode&unix.S_IFMT == unix.S_IFREG
+	bReg := bStat.Mode&unix.S_IFMT == unix.S_IFREG
+	return aReg && bReg && aStat.Dev == bStat.Dev
+}
+
+// copyItemFile copies the src into the dst storage file and writes the copied
+// data to h, e.g., to calculate a checksum.
+//
+// If src is a regular file on the same file system, e.g., a moved temporary
+// upload, copy_file_range(2) is tried first to skip userspace buffers. The
+// copied data is then read back from dst for h. Otherwise, or if this fails,
+// io.Copy is used.
+func (s *Store) copyItemFile(dst *os.File, src io.Reader, h io.Writer) (int64, error) {
+	if srcFile, ok := src.(*os.File); ok && sameFilesystem(dst, srcFile) {
+		offset, err := dst.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+
+		written, ok, err := copyFileRange(dst, srcFile)
+		if ok {
+			if err != nil {
+				return written, err
+			}
+
+			_, err = io.Copy(h, io.NewSectionReader(dst, offset, written))
+			return written, err
+		}
+		s.logger.Debug("copy_file_range is not applicable, falling back to io.Copy")
+	}
+
+	return io.Copy(io.MultiWriter(dst, h), src)
+}
+
+// Put a new Item inside the Store.
+//
+// Both a database entry and a file will be created. The given file will be
+// read into the storage and closed afterwards. If the file is an *os.File on
+// the same file system as the Store, it will be copied within the kernel.
+//
+// Files not exceeding the Store's inlineSize are stored inline in the
+// database as the Item's Inline field and no file will be created.
+//
+// The file is read only once, determining the Item's Size and Checksum as well
+// as its ContentType, if empty, on the way.
+//
+// The Item's Filename is stored sanitized, see WithMaxFilenameLength. A
+// Filename with a null byte is refused by ErrInvalidFilename.
+func (s *Store) Put(i Item, file io.ReadCloser) (id string, err error) {
+	return s.PutContext(context.Background(), i, file)
+}
+
+// PutWithPassword works like Put, but protects the Item by a password. Its
+// content can only be retrieved by GetFileWithPassword or
+// GetWithFileAndPassword afterwards, while GetFile and the like result in
+// ErrUnauthorized.
+func (s *Store) PutWithPassword(i Item, file io.ReadCloser, password string) (id string, err error) {
+	err = i.SetPassword(password)
+	if err != nil {
+		s.logger.Error("Failed to hash Item's password", slog.Any("error", err))
+		_ = file.Close()
+		return
+	}
+
+	return s.Put(i, file)
+}
+
+// insert a new Item into the database, after checking its optional Slug.
+func (s *Store) insert(i Item) error {
+	if i.Slug == "" {
+		return s.bh.Insert(i.ID, i)
+	} else if !slugPattern.MatchString(i.Slug) {
+		return ErrInvalidSlug
+	}
+
+	s.slugMutex.Lock()
+	defer s.slugMutex.Unlock()
+
+	if taken, err := s.idTaken(i.Slug); err != nil {
+		return err
+	} else if taken {
+		return ErrSlugTaken
+	}
+
+	return s.bh.Insert(i.ID, i)
+}
+
+// idTaken checks if an ID or a Slug is already used as either an ID or a Slug.
+func (s *Store) idTaken(key string) (bool, error) {
+	err := s.bh.Get(key, &Item{})
+	if err == nil {
+		return true, nil
+	} else if err != badgerhold.ErrNotFound {
+		return false, err
+	}
+
+	items, err := s.findByIndex("Slug", key)
+	if err != nil {
+		return false, err
+	}
+	return len(items) > 0, nil
+}
+
+// findByIndex returns all Items whose indexed field equals the value, being
+// looked up directly within the index, see Item.Indexes. As empty values are
+// not indexed, there are no Items for an empty value.
+func (s *Store) findByIndex(index, value string) ([]Item, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var items []Item
+	err := s.bh.Find(&items, badgerhold.Where(index).Eq(value).Index(index))
+	return items, err
+}
+
+// GetBySlug returns the Item with this Slug, just like Get. Without any match,
+// ErrNotFound is returned.
+func (s *Store) GetBySlug(slug string) (Item, error) {
+	s.logger.Debug("Requested Item by slug", slog.String("slug", slug))
+
+	items, err := s.findByIndex("Slug", slug)
+	if err != nil {
+		s.logger.Error("Requesting Item by slug failed", slog.String("slug", slug), slog.Any("error", err))
+		return Item{}, err
+	} else if len(items) == 0 {
+		return Item{}, ErrNotFound
+	}
+
+	return s.Get(items[0].ID)
+}
+
+// resolveSlug returns the ID of the Item with this Slug. Otherwise, e.g., for
+// an ID, the key is returned unchanged.
+func (s *Store) resolveSlug(key string) string {
+	if !slugPattern.MatchString(key) {
+		return key
+	} else if err := s.bh.Get(key, &Item{}); err == nil {
+		return key
+	}
+
+	items, err := s.findByIndex("Slug", key)
+	if err != nil || len(items) == 0 {
+		return key
+	}
+	return items[0].ID
+}
+
+// replaceSlug moves a Slug from the Item oldId to the Item newId, deleting the
+// former. On failure, the Item newId is deleted instead.
+func (s *Store) replaceSlug(oldId, newId, slug string) (err error) {
+	defer func() {
+		if err != nil {
+			if delErr := s.ForceDelete(newId); delErr != nil {
+				s.logger.Error("Failed to delete Item after failed slug replacement",
+					slog.String("id", newId), slog.Any("error", delErr))
+			}
+		}
+	}()
+
+	s.slugMutex.Lock()
+	defer s.slugMutex.Unlock()
+
+	err = s.Delete(oldId)
+	if err != nil {
+		return
+	}
+
+	if taken, takenErr := s.idTaken(slug); takenErr != nil {
+		return takenErr
+	} else if taken {
+		return ErrSlugTaken
+	}
+
+	err = s.update(newId, func(i *Item) error {
+		i.Slug = slug
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	s.logger.Info("Replaced Item at slug", slog.String("slug", slug), slog.String("old", oldId), slog.String("new", newId))
+	return
+}
+
+// idleTimeoutReader wraps an io.ReadCloser and closes it after a timeout
+// without any progress, aborting blocked reads with ErrUploadTimeout.
+type idleTimeoutReader struct {
+	r       io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+
+	expired   atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newIdleTimeoutReader wraps r, unless it is a regular file which cannot
+// stall anyway and should be kept for copy_file_range(2).
+func newIdleTimeoutReader(r io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	if f, ok := r.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode().IsRegular() {
+			return r
+		}
+	}
+
+	ir := &idleTimeoutReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.expired.Store(true)
+		_ = ir.close()
+	})
+	return ir
+}
+
+func (ir *idleTimeoutReader) Read(p []byte) (n int, err error) {
+	n, err = ir.r.Read(p)
+	if ir.expired.Load() {
+		return n, ErrUploadTimeout
+	}
+
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return
+}
+
+func (ir *idleTimeoutReader) Close() error {
+	ir.timer.Stop()
+	if ir.expired.Load() {
+		return ErrUploadTimeout
+	}
+	return ir.close()
+}
+
+func (ir *idleTimeoutReader) close() error {
+	ir.closeOnce.Do(func() {
+		ir.closeErr = ir.r.Close()
+	})
+	return ir.closeErr
+}
+
+// acquireWrite waits for a free slot to write a file, limited by writeSem.
+func (s *Store) acquireWrite(ctx context.Context) error {
+	if s.writeSem == nil {
+		return nil
+	}
+
+	select {
+	case s.writeSem <- struct{}{}:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyDefaultTTL sets the Expires of a new Item without one to the default
+// TTL from now, if configured by WithDefaultTTL.
+func (s *Store) applyDefaultTTL(i *Item) {
+	if i.Expires.IsZero() && s.defaultTTL > 0 {
+		i.Expires = s.clock.Now().UTC().Add(s.defaultTTL)
+	}
+}
+
+// releaseWrite frees a slot previously acquired by acquireWrite.
+func (s *Store) releaseWrite() {
+	if s.writeSem != nil {
+		<-s.writeSem
+	}
+}
+
+// PutContext works like Put, but the context aborts waiting for a free write
+// slot if the amount of concurrent writes is limited.
+func (s *Store) PutContext(ctx context.Context, i Item, file io.ReadCloser) (id string, err error) {
+	return s.putContext(ctx, i, file, nil)
+}
+
+// putContext implements PutContext. If set, reserved is called with the new
+// Item's ID after it was inserted into the database, but before its content
+// is written. Its error rolls back the insertion.
+func (s *Store) putContext(ctx context.Context, i Item, file io.ReadCloser, reserved func(id string) error) (id string, err error) {
+	s.logger.Debug("Requested insertion of Item into the Store")
+	defer s.observeLatency(LatencyPut, s.clock.Now())
+
+	// The file must be closed in any case; on success, this happens below.
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+		}
+	}()
+
+	err = s.beginInFlight()
+	if err != nil {
+		return
+	}
+	defer s.inFlight.Done()
+
+	if s.uploadTimeout > 0 {
+		file = newIdleTimeoutReader(file, s.uploadTimeout)
+	}
+
+	err = s.reserveItems(1)
+	if err == ErrIDSpaceNearlyFull {
+		s.logger.Error("Refusing to insert Item", slog.Int64("items", s.itemCount.Load()), slog.Any("error", err))
+		return
+	} else if err != nil {
+		s.logger.Warn("Refusing to insert Item", slog.Int64("items", s.itemCount.Load()), slog.Any("error", err))
+		return
+	}
+	defer s.reservedItems.Add(-1)
+
+	i.Filename, err = s.sanitizeFilename(i.Filename)
+	if err != nil {
+		s.logger.Warn("Refusing to insert Item", slog.Any("error", err))
+		return
+	}
+
+	id, err = s.createID()
+	if err != nil {
+		s.logger.Error("Failed to create an ID for a new Item", slog.Any("error", err))
+		return
+	}
+	defer s.releaseID(id)
+
+	i.ID = id
+	s.logger.Debug("Insert Item with assigned ID", slog.String("id", i.ID))
+	s.applyDefaultTTL(&i)
+
+	defer func() {
+		if err == nil {
+			s.createThumbnail(id)
+			s.emit(OpCreate, id)
+		}
+	}()
+
+	if s.lastAccess && i.LastAccess.IsZero() {
+		i.LastAccess = s.clock.Now().UTC()
+	}
+
+	prefix, inline, err := s.readInline(file)
+	if err != nil {
+		s.logger.Error("Failed to read Item's data",
+			slog.String("id", i.ID), slog.Any("error", err))
+		return
+	}
+	if inline {
+		s.logger.Debug("Item will be stored inline", slog.String("id", i.ID), slog.Int("size", len(prefix)))
+		i.Inline = prefix
+		i.Size = int64(len(prefix))
+		i.Checksum = checksumWith(s.checksumHash, prefix)
+		i.ChecksumAlgorithm = s.checksumAlgorithm
+		err = s.sniffContentType(&i, prefix)
+		if err != nil {
+			return
+		}
+	} else {
+		i.Inline = nil
+
+		err = s.acquireWrite(ctx)
+		if err != nil {
+			s.logger.Warn("Failed to wait for a free write slot",
+				slog.String("id", i.ID), slog.Any("error", err))
+			return
+		}
+		defer s.releaseWrite()
+
+		s.writing.Store(i.ID, struct{}{})
+		defer s.writing.Delete(i.ID)
+	}
+
+	err = s.insert(i)
+	if err != nil {
+		s.logger.Error("Failed to insert Item into database",
+			slog.String("id", i.ID), slog.Any("error", err))
+		return
+	}
+	s.itemCount.Add(1)
+
+	if reserved != nil {
+		err = reserved(i.ID)
+		if err != nil {
+			s.rollbackPut(i.ID)
+			return
+		}
+	}
+
+	if inline {
+		err = file.Close()
+		if err != nil {
+			s.rollbackPut(i.ID)
+		}
+		return
+	}
+
+	err = s.writeItemFile(&i, prefix, file)
+	if err != nil {
+		s.logger.Error("Failed to write Item's file",
+			slog.String("id", i.ID), slog.Any("error", err))
+		s.rollbackPut(i.ID)
+		return
+	}
+
+	// The Item was inserted before to reserve its ID. Now, both its size and
+	// checksum are known.
+	err = s.bh.Update(i.ID, i)
+	s.releaseBlob(i.Blob, err != nil)
+	if err != nil {
+		s.logger.Error("Failed to update Item's size and checksum",
+			slog.String("id", i.ID), slog.Any("error", err))
+		s.rollbackPut(i.ID)
+		return
+	}
+
+	return
+}
+
+// writeItemFile creates the file for a new Item from its already read prefix
+// and the remaining file, and sets the Item's size and checksum.
+//
+// The file is written as a temporary file first and renamed afterwards, so
+// that a file within the storage directory is always complete.
+func (s *Store) writeItemFile(i *Item, prefix []byte, file io.ReadCloser) (err error) {
+	defer s.observeLatency(LatencyPutCopy, s.clock.Now())
+
+	tmpPath := s.tempPath(i.ID)
+	f, err := s.open(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	// Size, checksum, and content type are all determined while writing.
+	h := s.checksumHash()
+	sniffer := &contentSniffer{}
+	written, err := s.writeContent(f, i, prefix, file, io.MultiWriter(h, sniffer))
+	if err != nil {
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	if s.fileSync != FileSyncNever {
+		err = f.Sync()
+		if err != nil {
+			return fmt.Errorf("syncing file failed: %w", err)
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	i.Size = written
+	i.Checksum = hex.EncodeToString(h.Sum(nil))
+	i.ChecksumAlgorithm = s.checksumAlgorithm
+	err = s.sniffContentType(i, sniffer.head)
+	if err != nil {
+		return err
+	}
+
+	err = s.commitBlob(tmpPath, i)
+	if err != nil {
+		return err
+	} else if s.fileSync == FileSyncNever {
+		s.trackUnsynced(s.itemPath(*i))
+	}
+	if s.fileSync != FileSyncAlways {
+		return nil
+	}
+
+	err = s.syncDir(s.storageDir())
+	if err != nil {
+		if i.Blob != "" {
+			s.releaseBlob(i.Blob, true)
+			i.Blob = ""
+		} else {
+			_ = s.removeFile(s.itemPath(*i))
+		}
+		return fmt.Errorf("syncing storage directory failed: %w", err)
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory, making the renames of its entries durable.
+func (s *Store) syncDir(dir string) error {
+	d, err := s.open(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
+
+// sniffSize is the maximum amount of bytes considered by
+// http.DetectContentType.
+const sniffSize = 512
+
+// contentSniffer is an io.Writer keeping only the first sniffSize bytes of
+// the written data for sniffContentType.
+type contentSniffer struct {
+	head []byte
+}
+
+// Write keeps the first bytes up to sniffSize and discards the others.
+func (sniffer *contentSniffer) Write(p []byte) (int, error) {
+	if n := sniffSize - len(sniffer.head); n > 0 {
+		sniffer.head = append(sniffer.head, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// rollbackPut removes both the database entry and the file of a failed Put.
+func (s *Store) rollbackPut(id string) {
+	err := s.removeFile(filepath.Join(s.storageDir(), id))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Error("Failed to remove file of failed insertion",
+			slog.String("id", id), slog.Any("error", err))
+	}
+
+	err = s.bh.Delete(id, Item{})
+	if err != nil {
+		s.logger.Error("Failed to remove database entry of failed insertion",
+			slog.String("id", id), slog.Any("error", err))
+		return
+	}
+	s.itemCount.Add(-1)
+}
+
+// deleteExpired checks the Store for expired Items and deletes them.
+//
+// Items failing to be deleted are skipped and will be retried next time. Their
+// errors are joined together.
+func (s *Store) deleteExpired() (deleted int, err error) {
+	var items []Item
+	err = s.bh.Find(&items, badgerhold.Where("Expires").Lt(s.clock.Now().Add(-s.expiryGrace)))
+	if err != nil {
+		return
+	}
+
+	var errs []error
+	for _, i := range items {
+		if i.Locked(s.clock.Now()) {
+			s.logger.Debug("Skip expired but still locked Item",
+				slog.String("id", i.ID), slog.Any("locked-until", i.LockedUntil))
+			continue
+		}
+
+		s.logger.Debug("Delete expired Item", slog.String("id", i.ID))
+		if delErr := s.Delete(i.ID); delErr != nil {
+			s.logger.Warn("Failed to delete expired Item, will be retried",
+				slog.String("id", i.ID), slog.Any("error", delErr))
+			errs = append(errs, fmt.Errorf("deleting %q failed: %w", i.ID, delErr))
+			continue
+		}
+		deleted++
+	}
+
+	err = errors.Join(errs...)
+	return
+}
+
+// CleanupNow deletes all expired Items and returns their amount. Abandoned
+// PartialUploads are deleted as well.
+//
+// This can be used independently of the Store's automatic cleanup, e.g., for
+// Stores without a background cleanup job.
+func (s *Store) CleanupNow() (deleted int, err error) {
+	s.logger.Debug("Requested cleanup of expired Items")
+
+	if _, uploadsErr := s.deleteAbandonedUploads(); uploadsErr != nil {
+		s.logger.Error("Deletion of abandoned partial uploads failed", slog.Any("error", uploadsErr))
+	}
+
+	deleted, err = s.deleteExpired()
+	if err != nil {
+		s.logger.Error("Deletion of expired Items failed",
+			slog.Int("deleted", deleted), slog.Any("error", err))
+		return
+	}
+
+	s.logger.Info("Deleted expired Items", slog.Int("deleted", deleted))
+	return
+}
+
+// EvictLRU deletes the least recently accessed Items until the total size of
+// all Items does not exceed targetBytes, returning the amount of deleted Items.
+//
+// An Item's LastAccess is only set if enabled by WithLastAccess. Items without
+// a LastAccess are considered by their Created time instead.
+func (s *Store) EvictLRU(targetBytes int64) (deleted int, err error) {
+	s.logger.Debug("Requested eviction of least recently accessed Items", slog.Int64("target", targetBytes))
+
+	type lruEntry struct {
+		id         string
+		size       int64
+		lastAccess time.Time
+	}
+
+	var entries []lruEntry
+	var total int64
+	err = s.bh.ForEach(nil, func(i *Item) error {
+		if i.Locked(s.clock.Now()) {
+			total += i.Size
+			return nil
+		}
+
+		lastAccess := i.LastAccess
+		if lastAccess.IsZero() {
+			lastAccess = i.Created
+		}
+
+		entries = append(entries, lruEntry{i.ID, i.Size, lastAccess})
+		total += i.Size
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Failed to iterate Items for eviction", slog.Any("error", err))
+		return
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].lastAccess.Before(entries[b].lastAccess)
+	})
+
+	for _, entry := range entries {
+		if total <= targetBytes {
+			break
+		}
+
+		err = s.Delete(entry.id)
+		if err != nil {
+			s.logger.Error("Failed to evict Item", slog.String("id", entry.id), slog.Any("error", err))
+			return
+		}
+
+		deleted++
+		total -= entry.size
+	}
+
+	s.logger.Info("Evicted least recently accessed Items",
+		slog.Int("deleted", deleted), slog.Int64("bytes", total))
+	return
+}
+
+// DeleteUndownloadedBefore deletes all Items created before t which were never
+// downloaded, e.g., abandoned uploads, and returns their amount. Items without
+// a Created time and locked Items are skipped.
+//
+// The Items are deleted from the database in chunks, each within its own
+// transaction, and their files are removed afterwards. Thus, a failure might
+// leave earlier chunks deleted, being reflected by the returned amount. As the
+// Items are checked again within each transaction, an Item being downloaded
+// concurrently is either counted as downloaded and kept, or deleted. A file
+// failing to be removed is only logged, as it can be found by Scan and removed
+// by Repair.
+func (s *Store) DeleteUndownloadedBefore(t time.Time) (int, error) {
+	s.logger.Debug("Requested deletion of undownloaded Items", slog.Any("before", t))
+
+	var candidates []Item
+	err := s.bh.Find(&candidates, badgerhold.Where("Downloads").Eq(int64(0)).And("Created").Lt(t))
+	if err != nil {
+		s.logger.Error("Failed to find undownloaded Items", slog.Any("error", err))
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for _, i := range candidates {
+		ids = append(ids, i.ID)
+	}
+
+	deleted, err := s.deleteChunked(ids, func(i Item) bool {
+		return i.Downloads > 0 || i.Created.IsZero() || !i.Created.Before(t) || i.Locked(s.clock.Now())
+	})
+	if err != nil {
+		s.logger.Error("Failed to delete undownloaded Items", slog.Int("deleted", deleted), slog.Any("error", err))
+		return deleted, err
+	}
+
+	s.logger.Info("Deleted undownloaded Items", slog.Int("deleted", deleted), slog.Any("before", t))
+	return deleted, nil
+}
+
+// deleteChunkSize bounds the amount of Items deleted within one transaction by
+// deleteChunked.
+const deleteChunkSize = 256
+
+// deleteChunked deletes the Items of these IDs in transactions of up to
+// deleteChunkSize Items, halving chunks being too big for a transaction. Within
+// each transaction, the Items are fetched again and kept if keep reports so.
+// After each transaction, the deleted Items' files are removed. The amount of
+// deleted Items is returned, also together with an error of a later chunk.
+func (s *Store) deleteChunked(ids []string, keep func(Item) bool) (deleted int, err error) {
+	for len(ids) > 0 {
+		n := deleteChunkSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+
+		var items []Item
+		for {
+			items, err = s.deleteChunk(ids[:n], keep)
+			if errors.Is(err, badger.ErrTxnTooBig) && n > 1 {
+				n /= 2
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return
+		}
+
+		for _, i := range items {
+			s.itemCount.Add(-1)
+			s.removeDeletedFile(i)
+
+			err = s.deleteThumbnail(i.ID)
+			if err != nil {
+				s.logger.Warn("Failed to delete Item's thumbnail", slog.String("id", i.ID), slog.Any("error", err))
+			}
+
+			s.emit(OpDelete, i.ID)
+		}
+
+		deleted += len(items)
+		ids = ids[n:]
 	}
+	return deleted, nil
+}
 
-	_, err = io.Copy(f, file)
+// deleteChunk deletes the Items of these IDs within one transaction, except
+// for missing ones or those to keep, and returns the deleted Items. Like for
+// updateItem, a conflicting transaction is retried.
+func (s *Store) deleteChunk(ids []string, keep func(Item) bool) (items []Item, err error) {
+	for attempt := 1; attempt <= updateAttempts; attempt++ {
+		items = nil
+		err = s.bh.Badger().Update(func(tx *badger.Txn) error {
+			for _, id := range ids {
+				var i Item
+				err := s.bh.TxGet(tx, id, &i)
+				if err == badgerhold.ErrNotFound {
+					continue
+				} else if err != nil {
+					return err
+				} else if keep(i) {
+					continue
+				}
+
+				err = s.bh.TxDelete(tx, id, Item{})
+				if err != nil {
+					return err
+				}
+				items = append(items, i)
+			}
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
 	if err != nil {
-		return
+		return nil, err
 	}
+	return items, nil
+}
 
-	err = file.Close()
+// removeDeletedFile removes the file of an Item already deleted from the
+// database, only logging failures.
+func (s *Store) removeDeletedFile(i Item) {
+	s.contentCache.remove(i.ID)
+
+	if i.Blob != "" {
+		s.blobMutex.Lock()
+		defer s.blobMutex.Unlock()
+	}
+
+	err := s.removeItemFile(i)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Error("Failed to delete file of deleted Item", slog.String("id", i.ID), slog.Any("error", err))
+	}
+}
+
+// DeleteByIDRange deletes all Items whose IDs are lexicographically between
+// minID and maxID, both inclusive, and returns their amount. Locked Items are
+// skipped. For time-sortable IDs of the "ulid" type, this deletes all Items
+// created within a time range, e.g., before a cutoff.
+//
+// As badgerhold's encoded keys are only sorted like the IDs for IDs of the
+// same length, both bounds must be of the same length and only IDs of this
+// length are deleted, e.g., for fixed-length IDs as ULIDs. Then, only the
+// database keys within the range are iterated, without fetching and decoding
+// Items outside. Like DeleteUndownloadedBefore, the Items are deleted from the
+// database in chunks of transactions before their files are removed.
+func (s *Store) DeleteByIDRange(minID, maxID string) (int, error) {
+	if minID > maxID {
+		return 0, fmt.Errorf("invalid ID range: %q is after %q", minID, maxID)
+	} else if len(minID) != len(maxID) {
+		return 0, fmt.Errorf("invalid ID range: %q and %q differ in length", minID, maxID)
+	}
+
+	s.logger.Debug("Requested deletion of Items by ID range", slog.String("min", minID), slog.String("max", maxID))
+
+	start, err := badgerhold.DefaultEncode(minID)
 	if err != nil {
-		return
+		return 0, err
 	}
 
-	err = f.Close()
+	var ids []string
+	err = s.bh.Badger().View(func(tx *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.PrefetchValues = false
+		iterOpts.Prefix = itemKeyPrefix
+
+		it := tx.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Seek(append(append([]byte{}, itemKeyPrefix...), start...)); it.Valid(); it.Next() {
+			var id string
+			err := badgerhold.DefaultDecode(it.Item().Key()[len(itemKeyPrefix):], &id)
+			if err != nil {
+				return err
+			} else if len(id) != len(minID) || id > maxID {
+				break
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
 	if err != nil {
-		return
+		s.logger.Error("Failed to find Items by ID range", slog.Any("error", err))
+		return 0, err
 	}
 
-	return
+	deleted, err := s.deleteChunked(ids, func(i Item) bool {
+		return i.Locked(s.clock.Now())
+	})
+	if err != nil {
+		s.logger.Error("Failed to delete Items by ID range", slog.Int("deleted", deleted), slog.Any("error", err))
+		return deleted, err
+	}
+
+	s.logger.Info("Deleted Items by ID range",
+		slog.Int("deleted", deleted), slog.String("min", minID), slog.String("max", maxID))
+	return deleted, nil
 }
 
-// deleteExpired checks the Store for expired Items and deletes them.
-func (s *Store) deleteExpired() error {
-	var items []Item
-	err := s.bh.Find(&items, badgerhold.Where("Expires").Lt(time.Now()))
+// Warm reads all Items from the database, e.g., directly after NewStore, to
+// populate badger's block cache. Afterwards, the first reads are served from
+// memory instead of the disk, as far as the WithBlockCacheSize allows.
+//
+// Only the database is read, not the Items' files.
+func (s *Store) Warm() error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	s.logger.Debug("Requested warming of the block cache")
+
+	var n int
+	err := s.bh.Badger().View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = itemKeyPrefix
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			err := it.Item().Value(func([]byte) error { return nil })
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
 	if err != nil {
+		s.logger.Error("Failed to warm the block cache", slog.Any("error", err))
 		return err
 	}
 
-	for _, i := range items {
-		slog.Debug("Delete expired Item", slog.String("id", i.ID))
-		err := s.Delete(i.ID)
-		if err != nil {
+	s.logger.Info("Warmed the block cache", slog.Int("items", n))
+	return nil
+}
+
+// Compact the database on demand, e.g., during a maintenance window after
+// deleting or importing lots of Items.
+//
+// This flattens the LSM tree, dropping tombstones and outdated versions, and
+// runs the value log garbage collection until nothing is left to rewrite.
+func (s *Store) Compact() error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	s.logger.Debug("Requested compaction of the database")
+
+	sizeBefore, err := s.databaseSize()
+	if err != nil {
+		return err
+	}
+
+	db := s.bh.Badger()
+	err = db.Flatten(runtime.NumCPU())
+	if err != nil {
+		s.logger.Error("Failed to flatten the database", slog.Any("error", err))
+		return err
+	}
+
+	for {
+		err = db.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			break
+		} else if err != nil {
+			s.logger.Error("Failed to run value log garbage collection", slog.Any("error", err))
 			return err
 		}
 	}
 
+	sizeAfter, err := s.databaseSize()
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Compacted the database",
+		slog.Int64("size", sizeAfter), slog.Int64("reclaimed", sizeBefore-sizeAfter))
 	return nil
 }
 
+// databaseSize sums up the size of the database's table and value log files,
+// as being done by badger.
+func (s *Store) databaseSize() (size int64, err error) {
+	err = filepath.WalkDir(s.databaseDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch filepath.Ext(path) {
+		case ".sst", ".vlog":
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// List Items in a stable order, skipping the first offset Items and returning
+// at most limit Items. A limit of zero returns all remaining Items.
+//
+// Undecodable Items are skipped as described for WalkItems. Then, the other
+// Items are returned together with an error.
+func (s *Store) List(offset, limit int) (items []Item, err error) {
+	err = s.WalkItems(func(i Item) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+
+		items = append(items, i)
+		if limit > 0 && len(items) >= limit {
+			return errStopWalk
+		}
+		return nil
+	})
+	return
+}
+
+// errStopWalk can be returned from a WalkItems function to stop early without
+// an error.
+var errStopWalk = errors.New("stop walking Items")
+
+// WalkItems calls fn for each Item in the same order as List, without holding
+// all Items in memory together.
+//
+// Items which cannot be decoded, e.g., due to a corrupted database entry, are
+// logged and skipped. After all other Items were walked, an error joining all
+// skipped Items is returned. An error returned by fn aborts the walk.
+func (s *Store) WalkItems(fn func(i Item) error) error {
+	bh, err := s.BadgerHoldSafe()
+	if err != nil {
+		return err
+	}
+
+	var decodeErrs []error
+	err = bh.Badger().View(func(tx *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = itemKeyPrefix
+
+		it := tx.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()[len(itemKeyPrefix):]
+
+			var i Item
+			err := it.Item().Value(func(val []byte) error {
+				return badgerhold.DefaultDecode(val, &i)
+			})
+			if err != nil {
+				var id string
+				if idErr := badgerhold.DefaultDecode(key, &id); idErr != nil {
+					id = string(key)
+				}
+
+				s.logger.Warn("Skipping undecodable Item", slog.String("id", id), slog.Any("error", err))
+				decodeErrs = append(decodeErrs, fmt.Errorf("decoding %q failed: %w", id, err))
+				continue
+			}
+
+			err = fn(i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return err
+	}
+	return errors.Join(decodeErrs...)
+}
+
+// itemKeyPrefix is the prefix of all Item keys within badger, as being set by
+// badgerhold for the Item type.
+var itemKeyPrefix = []byte("bh_Item:")
+
+// ListIDs works like List, but only returns the IDs in the same order.
+//
+// Only the database keys are iterated, without fetching and decoding the
+// Items. Thus, this is much cheaper than List.
+func (s *Store) ListIDs(offset, limit int) ([]string, error) {
+	bh, err := s.BadgerHoldSafe()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	err = bh.Badger().View(func(tx *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.PrefetchValues = false
+		iterOpts.Prefix = itemKeyPrefix
+
+		it := tx.NewIterator(iterOpts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+
+			var id string
+			err := badgerhold.DefaultDecode(it.Item().Key()[len(itemKeyPrefix):], &id)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+
+			if limit > 0 && len(ids) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// TypeStat holds statistics for all Items of one ContentType.
+type TypeStat struct {
+	Count int
+	Bytes int64
+}
+
+// StatsByType returns the amount and total size of all Items grouped by their
+// ContentType. All Items are iterated without being held in memory together.
+func (s *Store) StatsByType() (map[string]TypeStat, error) {
+	stats := make(map[string]TypeStat)
+	err := s.bh.ForEach(nil, func(i *Item) error {
+		stat := stats[i.ContentType]
+		stat.Count++
+		stat.Bytes += i.Size
+		stats[i.ContentType] = stat
+		return nil
+	})
+	return stats, err
+}
+
+// Count returns the amount of Items within the Store, including expired but
+// not yet deleted ones.
+func (s *Store) Count() (int, error) {
+	n, err := s.bh.Count(&Item{}, nil)
+	return int(n), err
+}
+
 // Delte an Item. Both the database entry and the file will be removed.
-func (s *Store) Delete(id string) (err error) {
-	slog.Debug("Requested deletion of Item", slog.String("id", id))
+//
+// A locked Item cannot be deleted before its LockedUntil has passed and
+// ErrLocked is returned. ForceDelete allows this.
+func (s *Store) Delete(id string) error {
+	return s.delete(id, false)
+}
 
-	err = s.bh.Delete(&id, Item{})
+// DeleteWithToken works like Delete, but only if the token matches the Item's
+// DeletionKey. Otherwise, ErrUnauthorized is returned.
+func (s *Store) DeleteWithToken(id, token string) error {
+	i, err := s.get(id)
+	if err != nil {
+		return err
+	}
+
+	if !i.CheckDeletionKey(token) {
+		s.logger.Warn("Refused deletion of Item with an invalid token", slog.String("id", id))
+		return ErrUnauthorized
+	}
+
+	return s.Delete(id)
+}
+
+// ForceDelete works like Delete, but also deletes locked Items. It is meant as
+// an administrative override.
+func (s *Store) ForceDelete(id string) error {
+	return s.delete(id, true)
+}
+
+// delete implements both Delete and ForceDelete.
+func (s *Store) delete(id string, force bool) (err error) {
+	s.logger.Debug("Requested deletion of Item", slog.String("id", id), slog.Bool("force", force))
+	defer s.observeLatency(LatencyDelete, s.clock.Now())
+
+	var i Item
+	err = s.bh.Get(id, &i)
 	if err != nil {
-		slog.Error("Failed to delete Item from database",
+		s.logger.Error("Failed to fetch Item for deletion",
+			slog.String("id", id), slog.Any("error", err))
+		return
+	}
+
+	if !force && i.Locked(s.clock.Now()) {
+		s.logger.Warn("Refused deletion of locked Item",
+			slog.String("id", id), slog.Any("locked-until", i.LockedUntil))
+		return ErrLocked
+	}
+
+	// A shared file must not become unreferenced by a concurrent deletion.
+	if i.Blob != "" {
+		s.blobMutex.Lock()
+		defer s.blobMutex.Unlock()
+	}
+
+	// The file is removed first. If this fails, the database entry is kept,
+	// allowing a retry instead of leaving an orphaned file. A missing file is
+	// fine, e.g., for inline Items or after a partial deletion.
+	err = s.removeItemFile(i)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	} else if err != nil {
+		s.logger.Error("Failed to delete Item's file",
 			slog.String("id", id), slog.Any("error", err))
 		return
 	}
 
-	err = os.Remove(filepath.Join(s.storageDir(), id))
+	err = s.bh.Delete(&id, Item{})
 	if err != nil {
-		slog.Error("Failed to delete Item's file",
+		s.logger.Error("Failed to delete Item from database",
 			slog.String("id", id), slog.Any("error", err))
 		return
 	}
+	s.itemCount.Add(-1)
+	s.contentCache.remove(id)
+
+	err = s.deleteThumbnail(id)
+	if err != nil {
+		s.logger.Warn("Failed to delete Item's thumbnail",
+			slog.String("id", id), slog.Any("error", err))
+		err = nil
+	}
+
+	s.emit(OpDelete, id)
+
+	return
+}
+
+// update an Item within a single transaction by the mutate function and
+// increment its Version. If the Item does not exist, ErrNotFound is returned.
+// An error returned by mutate aborts the update. A transaction conflicting
+// with a concurrent one is retried, running mutate again on the fresh Item.
+func (s *Store) update(id string, mutate func(*Item) error) error {
+	return s.updateItem(id, true, mutate)
+}
 
+// updateAccess works like update for an Item's access statistics, e.g., its
+// Downloads, without incrementing its Version.
+func (s *Store) updateAccess(id string, mutate func(*Item) error) error {
+	return s.updateItem(id, false, mutate)
+}
+
+// updateAttempts bounds the transactions of an update being retried after
+// conflicts, e.g., with concurrent writes to a shared index entry.
+const updateAttempts = 16
+
+// updateItem implements both update and updateAccess. Updates of the same Item
+// are serialized, e.g., for parallel downloads, while conflicts with other
+// writes are retried.
+func (s *Store) updateItem(id string, versioned bool, mutate func(*Item) error) (err error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	mutex := &s.updateMutexes[h.Sum32()%uint32(len(s.updateMutexes))]
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for attempt := 1; attempt <= updateAttempts; attempt++ {
+		err = s.updateItemTxn(id, versioned, mutate)
+		if !errors.Is(err, badger.ErrConflict) {
+			return
+		}
+		s.logger.Debug("Update of Item conflicted, will be retried", slog.String("id", id), slog.Int("attempt", attempt))
+	}
 	return
 }
 
+// updateItemTxn is a single attempt of updateItem.
+func (s *Store) updateItemTxn(id string, versioned bool, mutate func(*Item) error) error {
+	return s.bh.Badger().Update(func(tx *badger.Txn) error {
+		var i Item
+		err := s.bh.TxGet(tx, id, &i)
+		if err == badgerhold.ErrNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		err = mutate(&i)
+		if err != nil {
+			return err
+		}
+
+		if versioned {
+			i.Version++
+		}
+		return s.bh.TxUpdate(tx, id, i)
+	})
+}
+
+// UpdateCAS changes an Item's metadata by the mutate function, but only if its
+// Version still equals the expectedVersion, e.g., as previously returned by
+// Get. Otherwise, also for a concurrent update, ErrVersionConflict is returned
+// and nothing is changed. On success, the Version is incremented.
+//
+// The Item's ID and Slug as well as its fields describing the stored content,
+// e.g., its Blob, Checksum, or PasswordHash, are restored after mutate, as they
+// cannot be changed this way. Like for Extend, a locked Item's expiry cannot be
+// shortened, resulting in ErrLocked. Neither can its LockedUntil be lowered.
+func (s *Store) UpdateCAS(id string, expectedVersion uint64, mutate func(*Item)) error {
+	s.logger.Debug("Requested compare-and-swap update of Item",
+		slog.String("id", id), slog.Uint64("version", expectedVersion))
+
+	err := s.update(id, func(i *Item) error {
+		if i.Version != expectedVersion {
+			return ErrVersionConflict
+		}
+
+		old := *i
+		old.Inline, old.PasswordHash = bytes.Clone(i.Inline), bytes.Clone(i.PasswordHash)
+		old.KeyCheck, old.KeySalt = bytes.Clone(i.KeyCheck), bytes.Clone(i.KeySalt)
+
+		mutate(i)
+		i.ID, i.Slug, i.Version = old.ID, old.Slug, expectedVersion
+		i.Blob, i.Size, i.Checksum, i.ChecksumAlgorithm = old.Blob, old.Size, old.Checksum, old.ChecksumAlgorithm
+		i.Inline, i.Compressed, i.Encrypted = old.Inline, old.Compressed, old.Encrypted
+		i.PasswordHash, i.KeyCheck, i.KeySalt = old.PasswordHash, old.KeyCheck, old.KeySalt
+
+		if old.Locked(s.clock.Now()) && (i.Expires.Before(old.Expires) || i.LockedUntil.Before(old.LockedUntil)) {
+			return ErrLocked
+		}
+		return nil
+	})
+
+	if err == ErrNotFound || err == ErrVersionConflict || err == ErrLocked {
+		return err
+	} else if err != nil {
+		s.logger.Error("Failed to update Item", slog.String("id", id), slog.Any("error", err))
+		return err
+	}
+
+	s.logger.Info("Updated Item", slog.String("id", id), slog.Uint64("version", expectedVersion+1))
+	return nil
+}
+
+// Transfer an Item to a new owner, replacing all of its current owners.
+func (s *Store) Transfer(id string, newOwner map[OwnerType]net.IP) error {
+	s.logger.Debug("Requested transfer of Item", slog.String("id", id), slog.Any("owner", newOwner))
+
+	err := s.update(id, func(i *Item) error {
+		i.Owner = newOwner
+		return nil
+	})
+	if err == ErrNotFound {
+		return err
+	} else if err != nil {
+		s.logger.Error("Failed to transfer Item", slog.String("id", id), slog.Any("error", err))
+		return err
+	}
+
+	s.logger.Info("Transferred Item to new owner", slog.String("id", id), slog.Any("owner", newOwner))
+	return nil
+}
+
+// Extend sets a new expiry date for an Item, which might also shorten it.
+//
+// If the automatic cleanup is enabled, already expired Items cannot be
+// extended and ErrNotFound is returned, as for Get. ForceExtend allows this.
+//
+// Locked Items cannot get an earlier expiry date and ErrLocked is returned.
+func (s *Store) Extend(id string, expires time.Time) error {
+	return s.extend(id, expires, false)
+}
+
+// ForceExtend works like Extend, but also revives already expired Items as
+// long as they were not deleted yet.
+func (s *Store) ForceExtend(id string, expires time.Time) error {
+	return s.extend(id, expires, true)
+}
+
+// extend implements both Extend and ForceExtend.
+func (s *Store) extend(id string, expires time.Time, force bool) error {
+	s.logger.Debug("Requested new expiry date for Item",
+		slog.String("id", id), slog.Any("expires", expires), slog.Bool("force", force))
+
+	err := s.update(id, func(i *Item) error {
+		if !force && s.expiryPolicy != ExpiryIgnore && i.Expires.Before(s.clock.Now()) {
+			return ErrNotFound
+		}
+		if i.Locked(s.clock.Now()) && expires.Before(i.Expires) {
+			return ErrLocked
+		}
+
+		i.Expires = expires
+		return nil
+	})
+	if err == ErrNotFound || err == ErrLocked {
+		return err
+	} else if err != nil {
+		s.logger.Error("Failed to set new expiry date for Item", slog.String("id", id), slog.Any("error", err))
+		return err
+	}
+
+	s.logger.Info("Set new expiry date for Item", slog.String("id", id), slog.Any("expires", expires))
+	return nil
+}
+
+// ListByOwner returns all Items where any OwnerType matches the owner's IP.
+func (s *Store) ListByOwner(owner net.IP) ([]Item, error) {
+	return s.Find(badgerhold.Where("Owner").MatchFunc(func(ra *badgerhold.RecordAccess) (bool, error) {
+		for _, ip := range ra.Field().(map[OwnerType]net.IP) {
+			if ip.Equal(owner) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}))
+}
+
+// ListOwners returns the distinct IP addresses of all Items' owners, across
+// all OwnerTypes, in ascending order. Items without an owner are skipped.
+//
+// There is no owner index, so this is a full scan decoding every Item, even
+// though they are not held in memory together, as in StatsByType.
+func (s *Store) ListOwners() ([]string, error) {
+	bh, err := s.BadgerHoldSafe()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	err = bh.ForEach(nil, func(i *Item) error {
+		for _, ip := range i.Owner {
+			if len(ip) > 0 {
+				seen[ip.String()] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make([]string, 0, len(seen))
+	for owner := range seen {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners, nil
+}
+
+// Find all Items matching the query, e.g., badgerhold.Where("Expires").Lt(t).
+// A nil query matches all Items.
+//
+// This should be preferred over querying the BadgerHold reference directly,
+// as it is bound to the Item type and respects a closed Store.
+func (s *Store) Find(query *badgerhold.Query) ([]Item, error) {
+	bh, err := s.BadgerHoldSafe()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	err = bh.Find(&items, query)
+	return items, err
+}
+
 // BadgerHold returns a reference to the underlying BadgerHold instance.
+//
+// The returned reference is unusable after the Store was closed. Prefer
+// BadgerHoldSafe, which reports a closed Store with ErrStoreClosed. For
+// querying Items, Find should be used instead.
 func (s *Store) BadgerHold() *badgerhold.Store {
 	return s.bh
 }
+
+// BadgerHoldSafe returns a reference to the underlying BadgerHold instance or
+// ErrStoreClosed if the Store was already closed.
+func (s *Store) BadgerHoldSafe() (*badgerhold.Store, error) {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	return s.bh, nil
+}
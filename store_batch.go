@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ErrBatchTooBig is returned by Store.PutBatch if the batch does not fit into a
+// single database transaction. Nothing is inserted and the batch should be
+// split into smaller ones.
+var ErrBatchTooBig = errors.New("Batch is too big for a single transaction")
+
+// ItemWithReader is an Item together with its content for Store.PutBatch.
+type ItemWithReader struct {
+	Item Item
+	File io.ReadCloser
+}
+
+// PutBatch works like Put for multiple Items, but inserts all database entries
+// within one transaction. The assigned IDs are returned in the same order.
+//
+// Either all or none of the Items are inserted. If any Item fails, all files
+// already written are removed and the transaction is discarded. Each given
+// file is closed in any case.
+//
+// As the transaction's size is limited by the database, too big batches fail
+// with ErrBatchTooBig. Batches of thousands of Items fit, unless they share
+// indexed values, e.g., the same Expires, whose index entry is rewritten for
+// each Item. Such batches are limited to several hundred Items.
+func (s *Store) PutBatch(items []ItemWithReader) (ids []string, err error) {
+	s.logger.Debug("Requested batch insertion of Items", slog.Int("items", len(items)))
+
+	next := 0
+	defer func() {
+		for _, item := range items[next:] {
+			_ = item.File.Close()
+		}
+	}()
+
+	err = s.beginInFlight()
+	if err != nil {
+		return
+	}
+	defer s.inFlight.Done()
+
+	err = s.reserveItems(int64(len(items)))
+	if err == ErrIDSpaceNearlyFull {
+		s.logger.Error("Refusing to insert batch", slog.Int64("items", s.itemCount.Load()), slog.Any("error", err))
+		return
+	} else if err != nil {
+		s.logger.Warn("Refusing to insert batch", slog.Int64("items", s.itemCount.Load()), slog.Any("error", err))
+		return
+	}
+	defer s.reservedItems.Add(-int64(len(items)))
+
+	// Slugs and IDs are checked against the database and within this batch.
+	s.slugMutex.Lock()
+	defer s.slugMutex.Unlock()
+
+	tx := s.bh.Badger().NewTransaction(true)
+	defer tx.Discard()
+
+	// All IDs are marked as being written until the transaction is committed
+	// to hide the files from Scan. Shared files are referenced until then.
+	var written, blobs []string
+	defer func() {
+		for _, id := range written {
+			if err != nil {
+				_ = s.removeFile(filepath.Join(s.storageDir(), id))
+			}
+			s.writing.Delete(id)
+			s.releaseID(id)
+		}
+		for _, blob := range blobs {
+			s.releaseBlob(blob, err != nil)
+		}
+		if err != nil {
+			ids = nil
+		}
+	}()
+
+	keys := make(map[string]struct{}, len(items))
+	for n := range items {
+		i, file := items[n].Item, items[n].File
+
+		var id string
+		id, err = s.createBatchID(keys)
+		if err != nil {
+			s.logger.Error("Failed to create an ID for a new Item", slog.Any("error", err))
+			return
+		}
+		i.ID = id
+		s.applyDefaultTTL(&i)
+		keys[id] = struct{}{}
+		s.writing.Store(id, struct{}{})
+		written = append(written, id)
+
+		i.Filename, err = s.sanitizeFilename(i.Filename)
+		if err != nil {
+			err = fmt.Errorf("item %d: %w", n, err)
+			return
+		}
+
+		if i.Slug != "" {
+			_, dup := keys[i.Slug]
+			if !slugPattern.MatchString(i.Slug) {
+				err = ErrInvalidSlug
+			} else if taken, takenErr := s.idTaken(i.Slug); takenErr != nil {
+				err = takenErr
+			} else if taken || dup {
+				err = ErrSlugTaken
+			}
+			if err != nil {
+				err = fmt.Errorf("item %d: %w", n, err)
+				return
+			}
+			keys[i.Slug] = struct{}{}
+		}
+
+		if s.lastAccess && i.LastAccess.IsZero() {
+			i.LastAccess = s.clock.Now().UTC()
+		}
+
+		// From now on, putBatchItem is responsible for closing the file.
+		next = n + 1
+		err = s.putBatchItem(&i, file)
+		if i.Blob != "" {
+			blobs = append(blobs, i.Blob)
+		}
+		if err != nil {
+			err = fmt.Errorf("writing item %d failed: %w", n, err)
+			s.logger.Error("Failed to write Item of batch", slog.String("id", i.ID), slog.Any("error", err))
+			return
+		}
+
+		err = s.bh.TxInsert(tx, i.ID, i)
+		if errors.Is(err, badger.ErrTxnTooBig) {
+			err = fmt.Errorf("%w: exceeded at item %d", ErrBatchTooBig, n)
+			s.logger.Warn("Refusing to insert batch", slog.Int("items", len(items)), slog.Any("error", err))
+			return
+		} else if err != nil {
+			s.logger.Error("Failed to insert Item of batch", slog.String("id", i.ID), slog.Any("error", err))
+			return
+		}
+
+		ids = append(ids, i.ID)
+	}
+
+	err = tx.Commit()
+	if errors.Is(err, badger.ErrTxnTooBig) {
+		err = ErrBatchTooBig
+		s.logger.Warn("Refusing to insert batch", slog.Int("items", len(items)), slog.Any("error", err))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to commit batch", slog.Any("error", err))
+		return
+	}
+
+	s.itemCount.Add(int64(len(ids)))
+	for _, id := range ids {
+		s.emit(OpCreate, id)
+	}
+
+	s.logger.Info("Inserted batch of Items", slog.Int("items", len(ids)))
+	return
+}
+
+// createBatchID works like createID, but also avoids the keys of this batch.
+func (s *Store) createBatchID(keys map[string]struct{}) (string, error) {
+	for i := 0; i < 32; i++ {
+		id, err := s.createID()
+		if err != nil {
+			return "", err
+		} else if _, dup := keys[id]; !dup {
+			return id, nil
+		}
+		s.releaseID(id)
+		s.idCollisions.Add(1)
+	}
+
+	return "", errors.New("failed to calculate a free ID")
+}
+
+// putBatchItem reads the Item's content, either inline or into its file. The
+// file is closed in any case.
+func (s *Store) putBatchItem(i *Item, file io.ReadCloser) error {
+	prefix, inline, err := s.readInline(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if inline {
+		i.Inline = prefix
+		i.Size = int64(len(prefix))
+		i.Checksum = checksumWith(s.checksumHash, prefix)
+		i.ChecksumAlgorithm = s.checksumAlgorithm
+		err = s.sniffContentType(i, prefix)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	i.Inline = nil
+
+	err = s.acquireWrite(context.Background())
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	defer s.releaseWrite()
+
+	err = s.writeItemFile(i, prefix, file)
+	if err != nil {
+		_ = file.Close()
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// failingReadCloser fails after its data was read and records being closed.
+type failingReadCloser struct {
+	data   *bytes.Buffer
+	closed bool
+}
+
+func (frc *failingReadCloser) Read(p []byte) (int, error) {
+	if frc.data.Len() == 0 {
+		return 0, errors.New("failing on purpose")
+	}
+	return frc.data.Read(p)
+}
+
+func (frc *failingReadCloser) Close() error {
+	frc.closed = true
+	return nil
+}
+
+func TestStorePutBatch(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Both inline Items and files are mixed within this batch.
+	var batch []ItemWithReader
+	var data []string
+	for i := 0; i < 16; i++ {
+		content := fmt.Sprintf("item %d", i) + string(bytes.Repeat([]byte("!"), i))
+		data = append(data, content)
+		batch = append(batch, ItemWithReader{
+			Item: Item{Expires: time.Now().Add(time.Minute).UTC()},
+			File: newDummyReadCloser(bytes.NewBufferString(content)),
+		})
+	}
+
+	ids, err := store.PutBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != len(batch) {
+		t.Fatalf("PutBatch returned %d IDs for %d Items", len(ids), len(batch))
+	}
+
+	for i, id := range ids {
+		f, err := store.GetFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buff, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(buff) != data[i] {
+			t.Fatalf("Item %d returned %q, expected %q", i, buff, data[i])
+		}
+	}
+
+	// A failure in the middle of a batch must not leave anything behind.
+	var failingBatch []ItemWithReader
+	var readers []*failingReadCloser
+	for i := 0; i < 8; i++ {
+		frc := &failingReadCloser{data: bytes.NewBufferString("hello world")}
+		readers = append(readers, frc)
+
+		var file io.ReadCloser = frc
+		if i != 4 {
+			file = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(frc, 11), frc}
+		}
+		failingBatch = append(failingBatch, ItemWithReader{
+			Item: Item{Expires: time.Now().Add(time.Minute).UTC()},
+			File: file,
+		})
+	}
+
+	if ids, err := store.PutBatch(failingBatch); err == nil {
+		t.Fatalf("PutBatch with a failing Item did not fail")
+	} else if ids != nil {
+		t.Fatalf("Failed PutBatch returned IDs %v", ids)
+	}
+
+	for i, frc := range readers {
+		if !frc.closed {
+			t.Fatalf("File %d of failed PutBatch was not closed", i)
+		}
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != len(batch) {
+		t.Fatalf("Store has %d Items after a failed PutBatch, expected %d", n, len(batch))
+	}
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) != 0 || len(report.MissingFiles) != 0 {
+		t.Fatalf("Failed PutBatch left inconsistencies: %v", report)
+	}
+}
+
+// blockingReader signals its first Read and blocks until being released.
+type blockingReader struct {
+	data     *bytes.Buffer
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (br *blockingReader) Read(p []byte) (int, error) {
+	if br.started != nil {
+		close(br.started)
+		br.started = nil
+		<-br.released
+	}
+	return br.data.Read(p)
+}
+
+func (br *blockingReader) Close() error {
+	return nil
+}
+
+func TestStorePutBatchDuplicateIdGenerator(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	generator := func() (string, error) { return "dup", nil }
+
+	store, err := NewStore(storageDir, WithIdGenerator(generator), WithCleanup(false), WithInlineSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	batchData := bytes.Repeat([]byte("batch"), 1024)
+	reader := &blockingReader{
+		data:     bytes.NewBuffer(bytes.Clone(batchData)),
+		started:  make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	started := reader.started
+
+	batchErr := make(chan error, 1)
+	go func() {
+		_, err := store.PutBatch([]ItemWithReader{{Item: item, File: reader}})
+		batchErr <- err
+	}()
+
+	// While the batch is writing, its ID must not be used by another Put.
+	<-started
+	if id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("concurrent put"))); err == nil {
+		t.Fatalf("Concurrent Put succeeded with the batch's ID %q", id)
+	}
+	close(reader.released)
+
+	if err := <-batchErr; err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFile("dup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if data, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, batchData) {
+		t.Fatal("Batch's content was overwritten")
+	}
+}
+
+func TestStorePutBatchLarge(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(8)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expires := time.Now().Add(time.Hour).UTC()
+	batch := func(n int, shared bool) []ItemWithReader {
+		var batch []ItemWithReader
+		for i := 0; i < n; i++ {
+			item := Item{Expires: expires}
+			if !shared {
+				item.Expires = expires.Add(time.Duration(i) * time.Millisecond)
+			}
+			batch = append(batch, ItemWithReader{
+				Item: item,
+				File: newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d", i))),
+			})
+		}
+		return batch
+	}
+
+	ids, err := store.PutBatch(batch(2000, false))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2000 {
+		t.Fatalf("PutBatch returned %d IDs for 2000 Items", len(ids))
+	}
+
+	// All Items sharing the same Expires rewrite its index entry, exceeding
+	// the transaction's size.
+	if ids, err := store.PutBatch(batch(5000, true)); !errors.Is(err, ErrBatchTooBig) {
+		t.Fatalf("Too big PutBatch resulted in %v", err)
+	} else if ids != nil {
+		t.Fatalf("Too big PutBatch returned IDs %v", ids)
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 2000 {
+		t.Fatalf("Store has %d Items after a too big PutBatch, expected 2000", n)
+	}
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) != 0 || len(report.MissingFiles) != 0 {
+		t.Fatalf("Too big PutBatch left inconsistencies: %v", report)
+	}
+}
+
+func TestStorePutBatchIdCollidesWithSlug(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// The second ID equals the first Item's Slug and must be skipped.
+	generated := []string{"first", "taken", "second"}
+	store, err := NewStore(storageDir,
+		WithIdGenerator(func() (string, error) {
+			id := generated[0]
+			generated = generated[1:]
+			return id, nil
+		}),
+		WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	batch := []ItemWithReader{
+		{Item{Slug: "taken", Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("first"))},
+		{Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("second"))},
+	}
+	ids, err := store.PutBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 || ids[0] != "first" || ids[1] != "second" {
+		t.Fatalf("PutBatch returned IDs %v", ids)
+	}
+
+	if _, reserved := store.reserved.Load("taken"); reserved {
+		t.Fatal("Skipped ID is still reserved")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobNaming defines how Items' files are named within the storage directory,
+// set by WithBlobNaming.
+type BlobNaming int
+
+const (
+	// BlobByID names each Item's file by its ID. This is the default.
+	BlobByID BlobNaming = iota
+
+	// BlobByChecksum names files by their content's Checksum, e.g., for
+	// content-addressable external systems. Items of the same content share
+	// one file, being removed with the last Item referencing it.
+	//
+	// As the files must contain the plain content, this cannot be combined
+	// with WithCompression or WithEncryptionKey. For weak checksum algorithms,
+	// WithVerifyOnDedup guards against collisions.
+	//
+	// All Items sharing a file also share one index entry of their Checksum,
+	// being rewritten by each change of one of them. Thus, heavily duplicated
+	// content makes changes, e.g., recording downloads, more expensive.
+	BlobByChecksum
+)
+
+// itemPath returns the path of an Item's file, named by its Blob, if set, or
+// by its ID otherwise.
+func (s *Store) itemPath(i Item) string {
+	if i.Blob != "" {
+		return filepath.Join(s.storageDir(), i.Blob)
+	}
+	return filepath.Join(s.storageDir(), i.ID)
+}
+
+// commitBlob moves a new Item's completely written temporary file to its
+// final path. For BlobByChecksum, an already existing file of the same
+// content is reused. Then, the file stays referenced until releaseBlob.
+//
+// If enabled by WithVerifyOnDedup, an existing file is only reused if its
+// content is identical. Otherwise, the checksums collided and the new Item
+// gets its own file, named by its checksum and its ID.
+func (s *Store) commitBlob(tmpPath string, i *Item) error {
+	if s.blobNaming != BlobByChecksum {
+		return os.Rename(tmpPath, s.itemPath(*i))
+	}
+
+	s.blobMutex.Lock()
+	defer s.blobMutex.Unlock()
+
+	blob := i.Checksum
+	path := filepath.Join(s.storageDir(), blob)
+	_, err := os.Stat(path)
+	if err == nil && s.verifyOnDedup {
+		same, cmpErr := sameFileContent(tmpPath, path)
+		if cmpErr != nil {
+			return cmpErr
+		} else if !same {
+			s.logger.Warn("Checksum collided with a different content, storing a separate file",
+				slog.String("id", i.ID), slog.String("checksum", i.Checksum))
+			blob = i.Checksum + "-" + i.ID
+			path = filepath.Join(s.storageDir(), blob)
+			_, err = os.Stat(path)
+		}
+	}
+
+	if err == nil {
+		s.logger.Debug("Reuse existing file of the same content", slog.String("id", i.ID), slog.String("blob", blob))
+		if err := os.Remove(tmpPath); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	} else if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	if s.blobPending == nil {
+		s.blobPending = make(map[string]int)
+	}
+	s.blobPending[blob]++
+	i.Blob = blob
+	return nil
+}
+
+// sameFileContent compares two files byte by byte.
+func sameFileContent(pathA, pathB string) (bool, error) {
+	fA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fA.Close()
+
+	fB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fB.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(fA, bufA)
+		nB, errB := io.ReadFull(fB, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		} else if errB != nil && !endB {
+			return false, errB
+		} else if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
+
+// releaseBlob ends the reference of a new Item to its file by commitBlob,
+// after the Item was stored in the database. Having failed, remove also
+// removes the file, unless being referenced otherwise.
+func (s *Store) releaseBlob(blob string, remove bool) {
+	if blob == "" {
+		return
+	}
+
+	s.blobMutex.Lock()
+	defer s.blobMutex.Unlock()
+
+	if s.blobPending[blob]--; s.blobPending[blob] <= 0 {
+		delete(s.blobPending, blob)
+	}
+	if !remove {
+		return
+	}
+
+	if referenced, err := s.blobReferenced(blob, ""); err != nil || referenced {
+		return
+	}
+	if err := s.removeFile(filepath.Join(s.storageDir(), blob)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Error("Failed to remove file of failed insertion", slog.String("blob", blob), slog.Any("error", err))
+	}
+}
+
+// blobReferenced checks if a file of BlobByChecksum is referenced by any Item
+// except the one of the exceptId, either stored or still being inserted. The
+// blobMutex must be held.
+func (s *Store) blobReferenced(blob, exceptId string) (bool, error) {
+	if s.blobPending[blob] > 0 {
+		return true, nil
+	}
+
+	// A separate file after a collision is named by its checksum and its ID.
+	checksum, _, _ := strings.Cut(blob, "-")
+
+	items, err := s.findByIndex("Checksum", checksum)
+	if err != nil {
+		return false, err
+	}
+	for _, i := range items {
+		if i.Blob == blob && i.ID != exceptId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeItemFile removes the file of an Item to be deleted. A shared file of
+// BlobByChecksum is kept while being referenced by another Item. For shared
+// files, the blobMutex must be held until the Item was deleted.
+func (s *Store) removeItemFile(i Item) error {
+	if i.Blob != "" {
+		referenced, err := s.blobReferenced(i.Blob, i.ID)
+		if err != nil {
+			return err
+		} else if referenced {
+			s.logger.Debug("Keep file referenced by other Items", slog.String("id", i.ID), slog.String("blob", i.Blob))
+			return nil
+		}
+	}
+	return s.removeFile(s.itemPath(i))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreBlobNaming(t *testing.T) {
+	for _, naming := range []BlobNaming{BlobByID, BlobByChecksum} {
+		t.Run(map[BlobNaming]string{BlobByID: "by-id", BlobByChecksum: "by-checksum"}[naming], func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithIdGenerator(randomIdGenerator(4)),
+				WithCleanup(false),
+				WithBlobNaming(naming))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			data := []byte("hello world")
+			var ids []string
+			for n := 0; n < 2; n++ {
+				item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+				id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+
+			// A different content gets its own file in any case.
+			otherId, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("other")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range ids {
+				item, err := store.Get(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expectedName := id
+				if naming == BlobByChecksum {
+					expectedName = item.Checksum
+				}
+				if _, err := os.Stat(filepath.Join(store.storageDir(), expectedName)); err != nil {
+					t.Fatalf("File of Item %q is not named %q: %v", id, expectedName, err)
+				} else if naming == BlobByChecksum && item.Blob != item.Checksum {
+					t.Fatalf("Item %q references blob %q instead of its checksum %q", id, item.Blob, item.Checksum)
+				}
+
+				assertItemContent(t, store, id, data)
+			}
+
+			entries, err := os.ReadDir(store.storageDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedFiles := map[BlobNaming]int{BlobByID: 3, BlobByChecksum: 2}[naming]
+			if len(entries) != expectedFiles {
+				t.Fatalf("Storage directory has %d files, expected %d", len(entries), expectedFiles)
+			}
+
+			if report, err := store.Scan(); err != nil {
+				t.Fatal(err)
+			} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+				t.Fatalf("Scan found inconsistencies: %+v", report)
+			}
+
+			// Deleting one Item must keep a shared file for the other one.
+			first, err := store.Get(ids[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ids[0]); err != nil {
+				t.Fatal(err)
+			}
+			assertItemContent(t, store, ids[1], data)
+
+			if err := store.Delete(ids[1]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(store.itemPath(first)); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("File of deleted Items still exists: %v", err)
+			}
+			assertItemContent(t, store, otherId, []byte("other"))
+
+			if report, err := store.Scan(); err != nil {
+				t.Fatal(err)
+			} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+				t.Fatalf("Scan found inconsistencies after deletion: %+v", report)
+			}
+		})
+	}
+}
+
+// assertItemContent checks an Item's content read by GetFile.
+func assertItemContent(t *testing.T, store *Store, id string, data []byte) {
+	t.Helper()
+
+	f, err := store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(content, data) {
+		t.Fatalf("Item %q has content %q, expected %q", id, content, data)
+	}
+}
+
+func TestStoreBlobNamingOrphan(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithBlobNaming(BlobByChecksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file named like the Item's ID does not belong to it anymore.
+	if err := os.WriteFile(filepath.Join(store.storageDir(), id), []byte("stray"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Repair(false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) != 1 || report.OrphanFiles[0] != id || len(report.MissingFiles) > 0 {
+		t.Fatalf("Repair reported unexpected inconsistencies: %+v", report)
+	}
+	assertItemContent(t, store, id, []byte("hello world"))
+}
+
+func TestStoreBlobNamingTransformed(t *testing.T) {
+	for _, opt := range []Option{WithCompression(true), WithEncryptionKey(make([]byte, 32))} {
+		storageDir, err := os.MkdirTemp("", "db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(storageDir)
+
+		store, err := NewStore(storageDir, WithCleanup(false), WithBlobNaming(BlobByChecksum), opt)
+		if err == nil {
+			_ = store.Close()
+			t.Fatalf("BlobByChecksum was accepted for transformed files")
+		}
+	}
+}
+
+// collidingHash is a checksum algorithm mapping any content to the same sum.
+type collidingHash struct{}
+
+func (collidingHash) Write(p []byte) (int, error) { return len(p), nil }
+func (collidingHash) Sum(b []byte) []byte         { return append(b, 0x42) }
+func (collidingHash) Reset()                      {}
+func (collidingHash) Size() int                   { return 1 }
+func (collidingHash) BlockSize() int              { return 1 }
+
+func TestStoreBlobNamingVerifyOnDedup(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithBlobNaming(BlobByChecksum),
+		WithChecksumHash("colliding", func() hash.Hash { return collidingHash{} }),
+		WithVerifyOnDedup(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	contents := [][]byte{[]byte("hello world"), []byte("other content"), []byte("hello world")}
+	var ids []string
+	for _, data := range contents {
+		id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBuffer(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for n, id := range ids {
+		assertItemContent(t, store, id, contents[n])
+	}
+
+	// The colliding content has its own file, while the duplicate is shared.
+	entries, err := os.ReadDir(store.storageDir())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Fatalf("Storage directory has %d files, expected 2", len(entries))
+	}
+
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found inconsistencies: %+v", report)
+	}
+
+	if err := store.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertItemContent(t, store, ids[1], contents[1])
+	assertItemContent(t, store, ids[2], contents[2])
+
+	if err := store.Delete(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	assertItemContent(t, store, ids[2], contents[2])
+
+	if entries, err := os.ReadDir(store.storageDir()); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Fatalf("Storage directory has %d files after deletion, expected 1", len(entries))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+)
+
+// contentCache is an in-memory LRU cache of small Items' plain contents,
+// bounded by their total size, set by WithContentCache.
+//
+// A cache entry is bound to its Item's Checksum. Thus, if an ID is reused for
+// another Item after a deletion raced with its caching, the stale entry is
+// never served.
+type contentCache struct {
+	maxItemSize int64
+	maxBytes    int64
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// contentCacheEntry is an element of the contentCache's lru list, the most
+// recently used one being in front.
+type contentCacheEntry struct {
+	id       string
+	checksum string
+	data     []byte
+}
+
+func newContentCache(maxItemSize, maxBytes int64) *contentCache {
+	return &contentCache{
+		maxItemSize: maxItemSize,
+		maxBytes:    maxBytes,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+	}
+}
+
+// cacheable checks if an Item's content may be cached. Inline Items are
+// already held in memory. Items to be burned are never cached, as they must
+// not be served again after being read.
+func (c *contentCache) cacheable(i Item) bool {
+	return c != nil && len(i.Inline) == 0 && !i.BurnAfterReading && i.Size <= c.maxItemSize
+}
+
+// get returns a cached content, marking it as recently used.
+func (c *contentCache) get(i Item) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[i.ID]
+	if !ok || elem.Value.(*contentCacheEntry).checksum != i.Checksum {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*contentCacheEntry).data, true
+}
+
+// add caches an Item's content, evicting the least recently used entries
+// exceeding the maxBytes.
+func (c *contentCache) add(i Item, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[i.ID]; ok {
+		c.removeElement(elem)
+	}
+
+	c.entries[i.ID] = c.lru.PushFront(&contentCacheEntry{id: i.ID, checksum: i.Checksum, data: data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove invalidates an Item's cached content, if any. A nil contentCache is
+// fine, allowing to call this for Stores without a cache.
+func (c *contentCache) remove(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeElement removes an entry. The mutex must be held.
+func (c *contentCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*contentCacheEntry)
+	delete(c.entries, entry.id)
+	c.size -= int64(len(entry.data))
+}
+
+// openCached works like openContent, but serves small Items from the
+// contentCache, if enabled. A cache miss reads the whole content into the
+// cache first.
+func (s *Store) openCached(i Item) (io.ReadCloser, error) {
+	if !s.contentCache.cacheable(i) {
+		return s.openContent(i)
+	}
+
+	if data, ok := s.contentCache.get(i); ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	f, err := s.openContent(i)
+	if err != nil {
+		return nil, err
+	}
+
+	// A content exceeding its Size is served, but not cached.
+	data, err := io.ReadAll(io.LimitReader(f, s.contentCache.maxItemSize+1))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	} else if int64(len(data)) > s.contentCache.maxItemSize {
+		return pipelineReader{io.MultiReader(bytes.NewReader(data), f), []io.Closer{f}}, nil
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	s.contentCache.add(i, data)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStoreContentCache(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithInlineSize(0),
+		WithContentCache(16, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var opens atomic.Int64
+	store.openFile = func(name string, flag int, perm fs.FileMode) (*os.File, error) {
+		if flag == os.O_RDONLY && strings.HasPrefix(name, store.storageDir()) {
+			opens.Add(1)
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+
+	put := func(item Item, data string) string {
+		item.Expires = time.Now().Add(time.Minute).UTC()
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	download := func(id, data string) {
+		t.Helper()
+		assertItemContent(t, store, id, []byte(data))
+	}
+
+	small := put(Item{}, "hello world")
+	download(small, "hello world")
+	if n := opens.Load(); n != 1 {
+		t.Fatalf("First download opened %d files, expected 1", n)
+	}
+
+	download(small, "hello world")
+	if n := opens.Load(); n != 1 {
+		t.Fatalf("Cached download opened the file again")
+	} else if item, err := store.Peek(small); err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 2 {
+		t.Fatalf("Item has %d downloads, expected 2", item.Downloads)
+	}
+
+	// Large Items and those to be burned are never cached.
+	large := put(Item{}, strings.Repeat("x", 17))
+	burn := put(Item{BurnAfterReading: true}, "burn me")
+	for n := 0; n < 2; n++ {
+		download(large, strings.Repeat("x", 17))
+		download(burn, "burn me")
+	}
+	if n := opens.Load(); n != 5 {
+		t.Fatalf("Uncachable downloads opened %d files, expected 5", n)
+	}
+
+	// Exceeding the total size evicts the least recently used Item.
+	other := put(Item{}, "another item")
+	download(other, "another item")
+	if _, ok := store.contentCache.get(Item{ID: small, Checksum: checksum([]byte("hello world"))}); ok {
+		t.Fatalf("Least recently used Item was not evicted")
+	}
+
+	if err := store.Delete(other); err != nil {
+		t.Fatal(err)
+	} else if len(store.contentCache.entries) != 0 || store.contentCache.size != 0 {
+		t.Fatalf("Deletion did not invalidate the cache, having %d entries", len(store.contentCache.entries))
+	} else if _, err := store.GetFile(other); err != ErrNotFound {
+		t.Fatalf("Deleted Item resulted in %v", err)
+	}
+}
+
+func TestStoreContentCacheChecksum(t *testing.T) {
+	cache := newContentCache(16, 16)
+	cache.add(Item{ID: "id", Checksum: "old"}, []byte("old content"))
+
+	// A reused ID of another content never gets the stale entry.
+	if _, ok := cache.get(Item{ID: "id", Checksum: "new"}); ok {
+		t.Fatalf("Stale cache entry was served")
+	} else if data, ok := cache.get(Item{ID: "id", Checksum: "old"}); !ok || string(data) != "old content" {
+		t.Fatalf("Cache entry was not served")
+	}
+
+	var nilCache *contentCache
+	nilCache.remove("id")
+	if nilCache.cacheable(Item{}) {
+		t.Fatalf("Disabled cache accepts Items")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// ErrContentTypeMismatch is returned by Put for an Item whose ContentType
+// differs from its sniffed one, if enabled by WithStrictContentType.
+var ErrContentTypeMismatch = errors.New("Content type mismatches the content")
+
+// DefaultContentTypeTolerance lists the claimed media types being tolerated
+// for a sniffed one by WithStrictContentType, as the sniffing only knows a
+// few generic types. A claimed "text/csv" is fine for a sniffed "text/plain",
+// while a claimed image being sniffed as "text/html" is not.
+var DefaultContentTypeTolerance = map[string][]string{
+	"text/plain":         {"text/*", "application/json", "application/xml", "application/yaml", "application/*+json"},
+	"text/xml":           {"application/xml", "application/*+xml", "image/svg+xml"},
+	"application/zip":    {"application/java-archive", "application/epub+zip", "application/vnd.*"},
+	"application/x-gzip": {"application/gzip", "application/x-tar"},
+}
+
+// sniffContentType sets an Item's ContentType from the head of its content if
+// no ContentType was given. Otherwise, the given ContentType is checked if
+// enabled by WithStrictContentType.
+func (s *Store) sniffContentType(i *Item, head []byte) error {
+	sniffed := http.DetectContentType(head)
+	if i.ContentType == "" {
+		i.ContentType = sniffed
+		return nil
+	} else if s.contentTypeTolerance == nil || contentTypeMatches(i.ContentType, sniffed, s.contentTypeTolerance) {
+		return nil
+	}
+
+	s.logger.Warn("Refusing Item with a mismatching content type", slog.String("id", i.ID),
+		slog.String("content_type", i.ContentType), slog.String("sniffed", sniffed))
+	return ErrContentTypeMismatch
+}
+
+// contentTypeMatches checks if the claimed content type is acceptable for the
+// sniffed one. Parameters, e.g., a charset, are ignored. Unrecognized content,
+// sniffed as "application/octet-stream", accepts any claim.
+func contentTypeMatches(claimed, sniffed string, tolerance map[string][]string) bool {
+	claimedType, _, err := mime.ParseMediaType(claimed)
+	if err != nil {
+		return false
+	}
+	sniffedType, _, err := mime.ParseMediaType(sniffed)
+	if err != nil {
+		return false
+	}
+
+	if claimedType == sniffedType || sniffedType == "application/octet-stream" {
+		return true
+	}
+	for _, pattern := range tolerance[sniffedType] {
+		if ok, _ := path.Match(pattern, claimedType); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreStrictContentType(t *testing.T) {
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+	html := []byte("<!DOCTYPE html><html><script>alert(1)</script></html>")
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		err         error
+	}{
+		{"matching", "image/png", png, nil},
+		{"matching-with-parameter", "text/html; charset=utf-8", html, nil},
+		{"unclaimed", "", html, nil},
+		{"benign-csv", "text/csv", []byte("a,b\n1,2\n"), nil},
+		{"benign-json", "application/json", []byte(`{"hello": "world"}`), nil},
+		{"unrecognized", "application/x-custom", []byte{0x00, 0x01, 0x02}, nil},
+		{"html-as-image", "image/png", html, ErrContentTypeMismatch},
+		{"html-as-text", "text/plain", html, ErrContentTypeMismatch},
+		{"image-as-pdf", "application/pdf", png, ErrContentTypeMismatch},
+	}
+
+	// Both inline Items and those stored as files are checked.
+	for _, inlineSize := range []int64{0, 1024} {
+		storageDir, err := os.MkdirTemp("", "db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(storageDir)
+
+		store, err := NewStore(storageDir,
+			WithIdGenerator(randomIdGenerator(4)),
+			WithCleanup(false),
+			WithInlineSize(inlineSize),
+			WithStrictContentType(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		for _, test := range tests {
+			item := Item{ContentType: test.contentType, Expires: time.Now().Add(time.Minute).UTC()}
+			_, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("%s with inline size %d resulted in %v, expected %v", test.name, inlineSize, err, test.err)
+			}
+		}
+
+		expected := 0
+		for _, test := range tests {
+			if test.err == nil {
+				expected++
+			}
+		}
+		if n, err := store.Count(); err != nil {
+			t.Fatal(err)
+		} else if n != expected {
+			t.Fatalf("Store has %d Items, expected %d", n, expected)
+		}
+		if entries, err := os.ReadDir(store.storageDir()); err != nil {
+			t.Fatal(err)
+		} else if inlineSize == 0 && len(entries) != expected {
+			t.Fatalf("Storage directory has %d files, expected %d", len(entries), expected)
+		}
+	}
+}
+
+func TestContentTypeMatchesTolerance(t *testing.T) {
+	tolerance := map[string][]string{"text/plain": {"text/csv"}}
+
+	if !contentTypeMatches("text/csv", "text/plain; charset=utf-8", tolerance) {
+		t.Fatalf("Tolerated content type was refused")
+	} else if contentTypeMatches("text/markdown", "text/plain; charset=utf-8", tolerance) {
+		t.Fatalf("Content type outside the tolerance was accepted")
+	} else if contentTypeMatches("in/valid/type", "text/plain", tolerance) {
+		t.Fatalf("Invalid content type was accepted")
+	}
+
+	// Stores without WithStrictContentType accept everything.
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{ContentType: "image/png", Expires: time.Now().Add(time.Minute).UTC()}
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(strings.Repeat("<html>", 10)))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// CopyTo copies an Item into the dst Store, e.g., for replication, and returns
+// its new ID within dst. The content is streamed from this Store's file into
+// dst's Put without an intermediate file.
+//
+// The Item's metadata is preserved, including its Slug, Expires, secrets, and
+// counters, while dst determines its storage, e.g., inlining or encryption.
+// Like GetForced, hidden and expired Items are copied as well, without
+// counting as a download. If both Stores use the same checksum algorithm, a
+// mismatching checksum removes the copy and returns ErrChecksumMismatch.
+//
+// As the content is never returned, password protected Items are copied as
+// well, staying protected within dst by their preserved PasswordHash.
+func (s *Store) CopyTo(dst *Store, id string) (newID string, err error) {
+	s.logger.Debug("Requested copy of Item", slog.String("id", id))
+
+	var i Item
+	err = s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	f, err := s.openTracked(i, s.openContent)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	copied := i
+	copied.ID, copied.Blob, copied.Inline = "", "", nil
+	copied.Compressed, copied.Encrypted = false, false
+	copied.Version = 0
+
+	newID, err = dst.Put(copied, f)
+	if err != nil {
+		s.logger.Error("Failed to copy Item", slog.String("id", id), slog.Any("error", err))
+		return "", err
+	}
+
+	if i.Checksum != "" && i.ChecksumAlgorithm == dst.checksumAlgorithm {
+		var stored Item
+		stored, err = dst.Peek(newID)
+		if err == nil && stored.Checksum != i.Checksum {
+			err = fmt.Errorf("%w: copy of %q has checksum %s instead of %s",
+				ErrChecksumMismatch, id, stored.Checksum, i.Checksum)
+			_ = dst.ForceDelete(newID)
+		}
+		if err != nil {
+			s.logger.Error("Failed to verify copy of Item", slog.String("id", id), slog.Any("error", err))
+			return "", err
+		}
+	}
+
+	s.logger.Info("Copied Item", slog.String("id", id), slog.String("new-id", newID))
+	return newID, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreCopyTo(t *testing.T) {
+	newStore := func(opts ...Option) *Store {
+		storageDir, err := os.MkdirTemp("", "db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(storageDir) })
+
+		store, err := NewStore(storageDir, append([]Option{WithIdGenerator(randomIdGenerator(4)), WithCleanup(false)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}
+
+	src, dst := newStore(WithCompression(true)), newStore()
+
+	data := bytes.Repeat([]byte("hello world "), 1024)
+	item := Item{
+		DeletionKey: "secret",
+		Slug:        "greeting",
+		Filename:    "hello.txt",
+		ContentType: "text/plain",
+		Created:     time.Now().Add(-time.Hour).UTC().Truncate(time.Second),
+		Expires:     time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+		Owner:       map[OwnerType]net.IP{RemoteAddr: net.ParseIP("192.0.2.1")},
+	}
+	srcId, err := src.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dstId, err := src.CopyTo(dst, srcId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcItem, err := src.Get(srcId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstItem, err := dst.Get(dstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dstItem.DeletionKey != srcItem.DeletionKey || dstItem.Slug != srcItem.Slug ||
+		dstItem.Filename != srcItem.Filename || dstItem.ContentType != srcItem.ContentType ||
+		!dstItem.Created.Equal(srcItem.Created) || !dstItem.Expires.Equal(srcItem.Expires) ||
+		!dstItem.Owner[RemoteAddr].Equal(srcItem.Owner[RemoteAddr]) ||
+		dstItem.Size != srcItem.Size || dstItem.Checksum != srcItem.Checksum {
+		t.Fatalf("Copied Item %+v differs from %+v", dstItem, srcItem)
+	} else if !srcItem.Compressed || dstItem.Compressed {
+		t.Fatalf("Copy kept the source's compression: %t, %t", srcItem.Compressed, dstItem.Compressed)
+	}
+
+	f, err := dst.GetFile(dstId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(content, data) {
+		t.Fatal("Copied content differs")
+	}
+
+	if srcItem.Downloads != 0 {
+		t.Fatalf("Copying counted %d downloads", srcItem.Downloads)
+	} else if _, err := src.CopyTo(dst, "missing"); err != ErrNotFound {
+		t.Fatalf("Copying a missing Item resulted in %v", err)
+	}
+
+	// A password protected Item stays protected within dst.
+	protectedId, err := src.PutWithPassword(Item{Expires: item.Expires}, newDummyReadCloser(bytes.NewBufferString("secret")), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protectedCopyId, err := src.CopyTo(dst, protectedId)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := dst.GetFile(protectedCopyId); err != ErrUnauthorized {
+		t.Fatalf("GetFile of the protected copy returned %v", err)
+	}
+	if f, err := dst.GetFileWithPassword(protectedCopyId, "hunter2"); err != nil {
+		t.Fatal(err)
+	} else {
+		_ = f.Close()
+	}
+}
+
+func TestStoreCopyToChecksumMismatch(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	dstDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	src, err := NewStore(srcDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := NewStore(dstDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+	id, err := src.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcItem, err := src.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src.itemPath(srcItem), []byte("hello w0rld"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := src.CopyTo(dst, id); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Copying a corrupted Item resulted in %v", err)
+	} else if n, err := dst.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Destination has %d Items after a failed copy", n)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"syscall"
+)
+
+// ErrorCode classifies errors, e.g., to be bucketed by monitoring or mapped to
+// HTTP status codes. It is derived from an error by ClassifyError.
+type ErrorCode int
+
+const (
+	CodeOK ErrorCode = iota
+	CodeUnknown
+	CodeNotFound
+	CodeStoreClosed
+	CodeAlreadyLocked
+	CodeIncompatibleVersion
+	CodeIDSpaceFull
+	CodeSlugTaken
+	CodeInvalidSlug
+	CodeUploadTimeout
+	CodeUnauthorized
+	CodeChecksumMismatch
+	CodeNoEncryptionKey
+	CodeLifetimeTooLong
+	CodeFileTooBig
+	CodeDiskFull
+	CodeCanceled
+	CodeLocked
+	CodeUnknownChecksumAlgorithm
+	CodeInvalidRange
+	CodeLifetimeTooShort
+	CodeShutdownTimeout
+	CodeRateLimited
+	CodeUploadOffsetMismatch
+	CodeVersionConflict
+	CodeItemLimitReached
+	CodeTooManyOpenFiles
+	CodeInvalidFilename
+	CodeContentTypeMismatch
+	CodeBatchTooBig
+)
+
+// errorCodes maps known errors to their ErrorCode, checked by errors.Is.
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrNotFound, CodeNotFound},
+	{ErrStoreClosed, CodeStoreClosed},
+	{ErrAlreadyLocked, CodeAlreadyLocked},
+	{ErrIncompatibleDatabaseVersion, CodeIncompatibleVersion},
+	{ErrIDSpaceNearlyFull, CodeIDSpaceFull},
+	{ErrSlugTaken, CodeSlugTaken},
+	{ErrInvalidSlug, CodeInvalidSlug},
+	{ErrUploadTimeout, CodeUploadTimeout},
+	{ErrUnauthorized, CodeUnauthorized},
+	{ErrChecksumMismatch, CodeChecksumMismatch},
+	{ErrNoEncryptionKey, CodeNoEncryptionKey},
+	{ErrLifetimeTooLong, CodeLifetimeTooLong},
+	{ErrFileTooBig, CodeFileTooBig},
+	{syscall.ENOSPC, CodeDiskFull},
+	{context.Canceled, CodeCanceled},
+	{context.DeadlineExceeded, CodeCanceled},
+	{ErrLocked, CodeLocked},
+	{ErrUnknownChecksumAlgorithm, CodeUnknownChecksumAlgorithm},
+	{ErrInvalidRange, CodeInvalidRange},
+	{ErrLifetimeTooShort, CodeLifetimeTooShort},
+	{ErrShutdownTimeout, CodeShutdownTimeout},
+	{ErrRateLimited, CodeRateLimited},
+	{ErrUploadOffsetMismatch, CodeUploadOffsetMismatch},
+	{ErrVersionConflict, CodeVersionConflict},
+	{ErrItemLimitReached, CodeItemLimitReached},
+	{ErrTooManyOpenFiles, CodeTooManyOpenFiles},
+	{ErrInvalidFilename, CodeInvalidFilename},
+	{ErrContentTypeMismatch, CodeContentTypeMismatch},
+	{ErrBatchTooBig, CodeBatchTooBig},
+}
+
+// ClassifyError returns the ErrorCode for an error, also if being wrapped. A
+// nil error results in CodeOK and unknown errors in CodeUnknown.
+func ClassifyError(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+
+	for _, errorCode := range errorCodes {
+		if errors.Is(err, errorCode.err) {
+			return errorCode.code
+		}
+	}
+	return CodeUnknown
+}
+
+// HTTPStatus returns the HTTP status code for responding to a request failed
+// with an error of this ErrorCode.
+func (code ErrorCode) HTTPStatus() int {
+	switch code {
+	case CodeOK:
+		return http.StatusOK
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeStoreClosed, CodeIDSpaceFull, CodeShutdownTimeout, CodeTooManyOpenFiles:
+		return http.StatusServiceUnavailable
+	case CodeSlugTaken, CodeUploadOffsetMismatch, CodeVersionConflict:
+		return http.StatusConflict
+	case CodeInvalidSlug, CodeInvalidFilename:
+		return http.StatusBadRequest
+	case CodeUploadTimeout, CodeCanceled:
+		return http.StatusRequestTimeout
+	case CodeUnauthorized:
+		return http.StatusForbidden
+	case CodeLocked:
+		return http.StatusLocked
+	case CodeLifetimeTooLong, CodeLifetimeTooShort:
+		return http.StatusNotAcceptable
+	case CodeFileTooBig, CodeBatchTooBig:
+		return http.StatusRequestEntityTooLarge
+	case CodeDiskFull, CodeItemLimitReached:
+		return http.StatusInsufficientStorage
+	case CodeInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeContentTypeMismatch:
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// String returns a short name of the ErrorCode, e.g., as a metrics label.
+func (code ErrorCode) String() string {
+	switch code {
+	case CodeOK:
+		return "ok"
+	case CodeNotFound:
+		return "not_found"
+	case CodeStoreClosed:
+		return "store_closed"
+	case CodeAlreadyLocked:
+		return "already_locked"
+	case CodeIncompatibleVersion:
+		return "incompatible_version"
+	case CodeIDSpaceFull:
+		return "id_space_full"
+	case CodeSlugTaken:
+		return "slug_taken"
+	case CodeInvalidSlug:
+		return "invalid_slug"
+	case CodeUploadTimeout:
+		return "upload_timeout"
+	case CodeUnauthorized:
+		return "unauthorized"
+	case CodeChecksumMismatch:
+		return "checksum_mismatch"
+	case CodeNoEncryptionKey:
+		return "no_encryption_key"
+	case CodeLifetimeTooLong:
+		return "lifetime_too_long"
+	case CodeFileTooBig:
+		return "file_too_big"
+	case CodeDiskFull:
+		return "disk_full"
+	case CodeCanceled:
+		return "canceled"
+	case CodeLocked:
+		return "locked"
+	case CodeUnknownChecksumAlgorithm:
+		return "unknown_checksum_algorithm"
+	case CodeInvalidRange:
+		return "invalid_range"
+	case CodeLifetimeTooShort:
+		return "lifetime_too_short"
+	case CodeShutdownTimeout:
+		return "shutdown_timeout"
+	case CodeRateLimited:
+		return "rate_limited"
+	case CodeUploadOffsetMismatch:
+		return "upload_offset_mismatch"
+	case CodeVersionConflict:
+		return "version_conflict"
+	case CodeItemLimitReached:
+		return "item_limit_reached"
+	case CodeTooManyOpenFiles:
+		return "too_many_open_files"
+	case CodeInvalidFilename:
+		return "invalid_filename"
+	case CodeContentTypeMismatch:
+		return "content_type_mismatch"
+	case CodeBatchTooBig:
+		return "batch_too_big"
+	default:
+		return "unknown"
+	}
+}
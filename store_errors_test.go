@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{nil, CodeOK},
+		{errors.New("something else"), CodeUnknown},
+		{ErrNotFound, CodeNotFound},
+		{ErrStoreClosed, CodeStoreClosed},
+		{ErrAlreadyLocked, CodeAlreadyLocked},
+		{ErrIncompatibleDatabaseVersion, CodeIncompatibleVersion},
+		{ErrIDSpaceNearlyFull, CodeIDSpaceFull},
+		{ErrSlugTaken, CodeSlugTaken},
+		{ErrInvalidSlug, CodeInvalidSlug},
+		{ErrUploadTimeout, CodeUploadTimeout},
+		{ErrUnauthorized, CodeUnauthorized},
+		{ErrChecksumMismatch, CodeChecksumMismatch},
+		{ErrNoEncryptionKey, CodeNoEncryptionKey},
+		{ErrLifetimeTooLong, CodeLifetimeTooLong},
+		{ErrFileTooBig, CodeFileTooBig},
+		{syscall.ENOSPC, CodeDiskFull},
+		{&fs.PathError{Op: "write", Path: "/data/foo", Err: syscall.ENOSPC}, CodeDiskFull},
+		{context.Canceled, CodeCanceled},
+		{context.DeadlineExceeded, CodeCanceled},
+		{ErrLocked, CodeLocked},
+		{ErrUnknownChecksumAlgorithm, CodeUnknownChecksumAlgorithm},
+		{ErrInvalidRange, CodeInvalidRange},
+		{ErrLifetimeTooShort, CodeLifetimeTooShort},
+		{ErrShutdownTimeout, CodeShutdownTimeout},
+		{ErrRateLimited, CodeRateLimited},
+		{ErrUploadOffsetMismatch, CodeUploadOffsetMismatch},
+		{ErrVersionConflict, CodeVersionConflict},
+		{ErrItemLimitReached, CodeItemLimitReached},
+		{ErrTooManyOpenFiles, CodeTooManyOpenFiles},
+		{ErrInvalidFilename, CodeInvalidFilename},
+		{ErrContentTypeMismatch, CodeContentTypeMismatch},
+		{ErrBatchTooBig, CodeBatchTooBig},
+		{fmt.Errorf("%w: directory %q", ErrAlreadyLocked, "/db"), CodeAlreadyLocked},
+		{fmt.Errorf("item 3: %w", ErrSlugTaken), CodeSlugTaken},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.err), func(t *testing.T) {
+			if code := ClassifyError(test.err); code != test.code {
+				t.Fatalf("ClassifyError returned %v, expected %v", code, test.code)
+			}
+		})
+	}
+
+	// Each known error must map to its own named ErrorCode, except for both
+	// context errors.
+	seen := make(map[ErrorCode]error)
+	for _, errorCode := range errorCodes {
+		if other, exists := seen[errorCode.code]; exists && errorCode.code != CodeCanceled {
+			t.Fatalf("Errors %v and %v share ErrorCode %v", other, errorCode.err, errorCode.code)
+		} else if errorCode.code.String() == CodeUnknown.String() {
+			t.Fatalf("ErrorCode %d has no name", errorCode.code)
+		} else if status := errorCode.code.HTTPStatus(); status < 400 || status > 599 {
+			t.Fatalf("ErrorCode %v maps to HTTP status %d", errorCode.code, status)
+		}
+		seen[errorCode.code] = errorCode.err
+	}
+}
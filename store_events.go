@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StoreOp is the kind of change of a StoreEvent.
+type StoreOp string
+
+const (
+	// OpCreate is emitted after an Item was inserted by Put.
+	OpCreate StoreOp = "create"
+
+	// OpDelete is emitted after an Item was deleted, including by the cleanup.
+	OpDelete StoreOp = "delete"
+)
+
+// StoreEvent describes a change of the Store, received by Subscribe.
+type StoreEvent struct {
+	Op   StoreOp
+	ID   string
+	Time time.Time
+}
+
+// subscriberBuffer is the amount of StoreEvents buffered for each subscriber.
+const subscriberBuffer = 64
+
+// subscribers manages the channels returned by Store.Subscribe.
+type subscribers struct {
+	mutex sync.Mutex
+	chans map[chan StoreEvent]struct{}
+}
+
+// Subscribe to StoreEvents for all following changes.
+//
+// The returned function unsubscribes and closes the channel. Closing the Store
+// closes all channels as well. The channel is buffered, but events are dropped
+// for subscribers not keeping up to never block the Store.
+func (s *Store) Subscribe() (<-chan StoreEvent, func()) {
+	c := make(chan StoreEvent, subscriberBuffer)
+
+	s.subs.mutex.Lock()
+	if s.subs.chans == nil {
+		s.subs.chans = make(map[chan StoreEvent]struct{})
+	}
+	s.subs.chans[c] = struct{}{}
+	s.subs.mutex.Unlock()
+
+	return c, func() {
+		s.subs.mutex.Lock()
+		defer s.subs.mutex.Unlock()
+
+		if _, ok := s.subs.chans[c]; ok {
+			delete(s.subs.chans, c)
+			close(c)
+		}
+	}
+}
+
+// emit a StoreEvent to all subscribers.
+func (s *Store) emit(op StoreOp, id string) {
+	s.subs.mutex.Lock()
+	defer s.subs.mutex.Unlock()
+
+	if len(s.subs.chans) == 0 {
+		return
+	}
+
+	event := StoreEvent{Op: op, ID: id, Time: s.clock.Now()}
+	for c := range s.subs.chans {
+		select {
+		case c <- event:
+		default:
+			s.logger.Warn("Dropping event for slow subscriber",
+				slog.String("op", string(op)), slog.String("id", id))
+		}
+	}
+}
+
+// closeSubscribers closes all subscribed channels.
+func (s *Store) closeSubscribers() {
+	s.subs.mutex.Lock()
+	defer s.subs.mutex.Unlock()
+
+	for c := range s.subs.chans {
+		delete(s.subs.chans, c)
+		close(c)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreSubscribe(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	events, unsubscribe := store.Subscribe()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(itemId); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []StoreOp{OpCreate, OpDelete} {
+		select {
+		case event := <-events:
+			if event.Op != op || event.ID != itemId || event.Time.IsZero() {
+				t.Fatalf("Received event %v, expected %q for %q", event, op, itemId)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No event received for %q", op)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatalf("Channel is still open after unsubscribing")
+	}
+
+	// A slow subscriber must neither block the Store nor get more events than
+	// being buffered.
+	slowEvents, _ := store.Subscribe()
+	for i := 0; i < 2*subscriberBuffer; i++ {
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(slowEvents) != subscriberBuffer {
+		t.Fatalf("Slow subscriber has %d buffered events, expected %d", len(slowEvents), subscriberBuffer)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range slowEvents {
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidFilename is returned by Store.Put for a Filename containing a null
+// byte, which is refused instead of being sanitized.
+var ErrInvalidFilename = errors.New("Filename contains a null byte")
+
+// defaultMaxFilenameLength is the maximum length of Filenames in bytes, unless
+// configured by WithMaxFilenameLength. It matches common file systems.
+const defaultMaxFilenameLength = 255
+
+// sanitizeFilename makes an Item's Filename safe for headers and logs. Path
+// components are removed, control characters and invalid UTF-8 are stripped,
+// and overlong names are truncated to the maxFilenameLength, keeping their
+// extension if possible. Null bytes are refused by ErrInvalidFilename.
+func (s *Store) sanitizeFilename(filename string) (string, error) {
+	if strings.ContainsRune(filename, 0) {
+		return "", ErrInvalidFilename
+	}
+
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(filename, ""))
+
+	filename = path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	filename = strings.TrimSpace(filename)
+	if filename == "." || filename == ".." || filename == "/" {
+		return "", nil
+	}
+
+	if s.maxFilenameLength <= 0 || len(filename) <= s.maxFilenameLength {
+		return filename, nil
+	}
+
+	ext := path.Ext(filename)
+	if len(ext) > s.maxFilenameLength/2 {
+		ext = ""
+	}
+	return truncateUTF8(filename[:len(filename)-len(ext)], s.maxFilenameLength-len(ext)) + ext, nil
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
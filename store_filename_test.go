@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSanitizeFilename(t *testing.T) {
+	store := &Store{maxFilenameLength: 16}
+
+	tests := []struct {
+		name     string
+		filename string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"plain", "hello.txt", "hello.txt"},
+		{"newline-injection", "evil.txt\r\nSet-Cookie: a=b", "evil.txtSet-Cook"},
+		{"control-characters", "a\tb\x1bc\x7f.txt", "abc.txt"},
+		{"path-traversal", "../../etc/passwd", "passwd"},
+		{"windows-path", `..\..\boot.ini`, "boot.ini"},
+		{"only-dots", "..", ""},
+		{"trailing-slash", "dir/", "dir"},
+		{"overlong", strings.Repeat("a", 32) + ".tar.gz", "aaaaaaaaaaaaa.gz"},
+		{"overlong-multibyte", strings.Repeat("ä", 10) + ".txt", "ääääää.txt"},
+		{"overlong-extension", "a." + strings.Repeat("b", 20), "a.bbbbbbbbbbbbbb"},
+		{"invalid-utf8", "a\xffb.txt", "ab.txt"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, err := store.sanitizeFilename(test.filename)
+			if err != nil {
+				t.Fatal(err)
+			} else if filename != test.expected {
+				t.Fatalf("Filename %q was sanitized to %q, expected %q", test.filename, filename, test.expected)
+			} else if len(filename) > store.maxFilenameLength {
+				t.Fatalf("Filename %q exceeds the maximum length", filename)
+			}
+		})
+	}
+
+	if _, err := store.sanitizeFilename("a\x00.txt"); !errors.Is(err, ErrInvalidFilename) {
+		t.Fatalf("Filename with a null byte resulted in %v", err)
+	}
+}
+
+func TestStorePutSanitizesFilename(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Filename: "../secret\n.txt", Expires: time.Now().Add(time.Minute).UTC()}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if i.Filename != "secret.txt" {
+		t.Fatalf("Item has Filename %q", i.Filename)
+	}
+
+	item.Filename = strings.Repeat("x", 300) + ".pdf"
+	id, err = store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if len(i.Filename) != defaultMaxFilenameLength || !strings.HasSuffix(i.Filename, ".pdf") {
+		t.Fatalf("Overlong Filename was truncated to %d bytes: %q", len(i.Filename), i.Filename)
+	}
+
+	item.Filename = "null\x00byte"
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != ErrInvalidFilename {
+		t.Fatalf("Put of a Filename with a null byte resulted in %v", err)
+	}
+
+	batch := []ItemWithReader{
+		{Item{Expires: item.Expires}, newDummyReadCloser(bytes.NewBufferString("first"))},
+		{Item{Filename: "null\x00byte", Expires: item.Expires}, newDummyReadCloser(bytes.NewBufferString("second"))},
+	}
+	if _, err := store.PutBatch(batch); !errors.Is(err, ErrInvalidFilename) {
+		t.Fatalf("PutBatch of a Filename with a null byte resulted in %v", err)
+	}
+}
@@ -0,0 +1,1237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handlerDefaultLifetime is used for uploads by the Store's Handler without a
+// requested lifetime.
+const handlerDefaultLifetime = 24 * time.Hour
+
+// handlerMaxFieldSize limits the size of each multipart form field before the
+// file part of an upload to the Store's Handler.
+const handlerMaxFieldSize = 1024
+
+// storeHandler is the http.Handler returned by Store.Handler.
+type storeHandler struct {
+	store *Store
+
+	rateLimiter    RateLimiter
+	trustProxy     bool
+	trustedProxies []net.IPNet
+	maxUploadBytes int64
+
+	defaultLifetime time.Duration
+	minLifetime     time.Duration
+	maxLifetime     time.Duration
+
+	baseURL string
+
+	adminPath  string
+	adminToken string
+
+	uploadKeyValidator UploadKeyValidator
+
+	livenessPath  string
+	readinessPath string
+
+	contentSecurityPolicy string
+	sandboxDomain         bool
+
+	middleware []Middleware
+}
+
+// HandlerOption configures the http.Handler created by Store.Handler.
+type HandlerOption func(*storeHandler)
+
+// WithRateLimiter limits uploads per client IP address by the RateLimiter,
+// e.g., a TokenBucketLimiter. Exceeding requests are responded with 429 Too
+// Many Requests and a Retry-After header.
+func WithRateLimiter(limiter RateLimiter) HandlerOption {
+	return func(h *storeHandler) {
+		h.rateLimiter = limiter
+	}
+}
+
+// WithTrustedProxy identifies clients by the last address of the
+// X-Forwarded-For header, as set by a trusted reverse proxy. Otherwise, the
+// header could be forged by clients to bypass the WithRateLimiter.
+//
+// This trusts every peer to be the proxy. WithTrustedProxies restricts this
+// to the proxies' addresses.
+func WithTrustedProxy(trustProxy bool) HandlerOption {
+	return func(h *storeHandler) {
+		h.trustProxy = trustProxy
+	}
+}
+
+// WithTrustedProxies identifies clients by the Forwarded or X-Forwarded-For
+// header only for requests from peers within these networks. The addresses
+// are walked from the nearest hop, skipping all trusted proxies. Thus, hops
+// added by clients in front of the first trusted proxy are ignored.
+func WithTrustedProxies(proxies []net.IPNet) HandlerOption {
+	return func(h *storeHandler) {
+		h.trustedProxies = proxies
+	}
+}
+
+// WithMaxUploadBytes rejects uploads exceeding this size in bytes with 413
+// Request Entity Too Large. Oversized requests are aborted while reading,
+// without storing partial Items. Zero means unlimited.
+func WithMaxUploadBytes(maxUploadBytes int64) HandlerOption {
+	return func(h *storeHandler) {
+		h.maxUploadBytes = max(0, maxUploadBytes)
+	}
+}
+
+// WithDefaultLifetime sets the lifetime of uploads without a requested
+// expiry, instead of handlerDefaultLifetime.
+func WithDefaultLifetime(lifetime time.Duration) HandlerOption {
+	return func(h *storeHandler) {
+		if lifetime > 0 {
+			h.defaultLifetime = lifetime
+		}
+	}
+}
+
+// WithLifetimeBounds restricts the requested lifetime of uploads. Lifetimes
+// out of bounds are refused by ErrLifetimeTooShort or ErrLifetimeTooLong. A
+// zero bound is ignored.
+func WithLifetimeBounds(minLifetime, maxLifetime time.Duration) HandlerOption {
+	return func(h *storeHandler) {
+		h.minLifetime = max(0, minLifetime)
+		h.maxLifetime = max(0, maxLifetime)
+	}
+}
+
+// WithContentSecurityPolicy replaces the defaultContentSecurityPolicy sent for
+// downloads. An empty policy disables the header.
+func WithContentSecurityPolicy(policy string) HandlerOption {
+	return func(h *storeHandler) {
+		h.contentSecurityPolicy = policy
+	}
+}
+
+// WithSandboxDomain declares that the Handler is served from a separate domain
+// only for uploaded content, e.g., "usercontent.example.org". Then, Items of
+// dangerous content types, such as HTML, might be displayed inline by their
+// Disposition. Otherwise, they are always served as attachments.
+func WithSandboxDomain(sandboxDomain bool) HandlerOption {
+	return func(h *storeHandler) {
+		h.sandboxDomain = sandboxDomain
+	}
+}
+
+// WithBaseURL sets the URL under which the Handler is reachable, e.g.,
+// "https://example.org/gosh/", to create the absolute URLs of new uploads.
+// Otherwise, the URL is derived from the request's Host and the path prefix
+// removed by http.StripPrefix, if mounted under a subpath.
+func WithBaseURL(baseURL string) HandlerOption {
+	return func(h *storeHandler) {
+		h.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// Handler returns an http.Handler serving the Store's Items directly, e.g.,
+// for embedding a Store without the Server and its RPC.
+//
+//   - POST / uploads the request body as a new Item and responds with its URL.
+//     The Item's DeletionKey is returned in the X-Deletion-Key header. By the
+//     Accept header, the response might also be JSON or an HTML page with the
+//     ID, URL, DeletionKey, and expiry. The
+//     optional query parameters "time", "burn", and "filename" set the
+//     lifetime, BurnAfterReading, and Filename. The expiry might also be
+//     requested by the X-Expires-In header or the "expires" parameter, as a
+//     duration or an RFC 3339 timestamp. For a multipart/form-data
+//     body, e.g., from a browser, its first file part is uploaded with its
+//     filename and content type, while preceding form fields might also set
+//     "time", "expires", and "burn".
+//   - PUT /{slug} uploads the request body like POST / as a new Item with
+//     this Slug. An Item already using the Slug is replaced if authorized by
+//     its DeletionKey as a bearer token, which is kept. Otherwise, 409
+//     Conflict is responded.
+//   - GET /{id} downloads an Item with headers from its metadata. Its
+//     Disposition might be overridden by "download=1" to force an attachment.
+//     Dangerous content types, e.g., HTML, are always attachments, unless
+//     WithSandboxDomain. Scripts are also prevented by a restrictive
+//     Content-Security-Policy, WithContentSecurityPolicy.
+//     A single byte range might be requested by the Range header, optionally
+//     conditional by If-Range for the Item's Checksum. The Checksum is also
+//     sent as the ETag, resulting in 304 Not Modified for If-None-Match.
+//   - HEAD /{id} responds with the headers of GET /{id}, e.g., its
+//     Content-Length and an Expires header of the Item's expiry, but neither
+//     serves its content nor counts as a download.
+//   - GET /{id}/thumb serves an image Item's Thumbnail, if created by
+//     WithThumbnails. Otherwise, e.g., for non-images, 404 is responded.
+//   - DELETE /{id} deletes an Item, authorized by its DeletionKey as a bearer
+//     token in the Authorization header.
+//   - GET /{id}/{token} responds with an HTML page to confirm the deletion,
+//     which is actually performed by POST /{id}/delete with the DeletionKey
+//     as the "token" form field. Thus, following the link alone, e.g., by a
+//     link preview, does not delete the Item.
+//   - /uploads serves resumable uploads in chunks, see handleResumable.
+//   - The optional admin API is served WithAdmin, see handleAdmin.
+//   - Optional liveness and readiness checks are served WithHealth.
+//
+// Instead of an ID, each {id} might also be an Item's Slug.
+//
+// Uploads might be restricted to known clients WithUploadKeys.
+//
+// Errors are responded by ErrorCode.HTTPStatus of ClassifyError, as plain text
+// or, for clients accepting JSON, as an ErrorResponse.
+//
+// All routes might be wrapped WithMiddleware, e.g., by CORSMiddleware.
+func (s *Store) Handler(opts ...HandlerOption) http.Handler {
+	h := &storeHandler{
+		store:                 s,
+		defaultLifetime:       handlerDefaultLifetime,
+		contentSecurityPolicy: defaultContentSecurityPolicy,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	var handler http.Handler = h
+	for n := len(h.middleware) - 1; n >= 0; n-- {
+		handler = h.middleware[n](handler)
+	}
+	return handler
+}
+
+func (h *storeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, action, nested := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch {
+	case h.adminToken != "" && id == h.adminPath:
+		h.handleAdmin(w, r, action)
+
+	case h.livenessPath != "" && id == h.livenessPath && !nested:
+		h.handleHealth(w, r, h.store.Health)
+
+	case h.readinessPath != "" && id == h.readinessPath && !nested:
+		h.handleHealth(w, r, h.store.Ready)
+
+	case id == uploadsPath:
+		h.handleResumable(w, r, action, nested)
+
+	case id == "" && !nested && r.Method == http.MethodPost:
+		h.handleUpload(w, r, "")
+
+	case id != "" && !nested && r.Method == http.MethodPut:
+		h.handleUpload(w, r, id)
+
+	case id != "" && !nested && r.Method == http.MethodGet:
+		start := h.store.clock.Now()
+		cw := &countingResponseWriter{ResponseWriter: w}
+		h.handleDownload(cw, r, id)
+		h.logDownload(cw, id, h.store.clock.Now().Sub(start))
+
+	case id != "" && !nested && r.Method == http.MethodHead:
+		h.handleHead(w, r, id)
+
+	case id != "" && !nested && r.Method == http.MethodDelete:
+		h.handleDelete(w, r, id, bearerToken(r))
+
+	case id != "" && action == "delete" && r.Method == http.MethodPost:
+		token := bearerToken(r)
+		if token == "" {
+			token = r.PostFormValue("token")
+		}
+		h.handleDelete(w, r, id, token)
+
+	case id != "" && action == thumbnailPath && r.Method == http.MethodGet:
+		h.handleThumbnail(w, r, id)
+
+	case id != "" && action != "" && !strings.Contains(action, "/") && r.Method == http.MethodGet:
+		h.handleDeleteConfirmation(w, r, id, action)
+
+	case !nested || (id != "" && action != "" && !strings.Contains(action, "/")):
+		h.httpError(w, r, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+
+	default:
+		h.httpError(w, r, msgNotExists, http.StatusNotFound)
+	}
+}
+
+// countingResponseWriter records the status code and the amount of bytes
+// actually written, e.g., for aborted downloads.
+type countingResponseWriter struct {
+	http.ResponseWriter
+
+	status  int
+	written int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap allows an http.ResponseController to access the ResponseWriter.
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logDownload logs an access log line for a download. It is complete if the
+// whole content was served, partial for range requests or aborted downloads.
+func (h *storeHandler) logDownload(w *countingResponseWriter, id string, duration time.Duration) {
+	download := "none"
+	if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && w.written > 0 {
+		if w.status == http.StatusOK && w.written == length {
+			download = "complete"
+		} else {
+			download = "partial"
+		}
+	}
+
+	h.store.logger.Info("Served Item",
+		slog.String("id", id),
+		slog.Int("status", w.status),
+		slog.Int64("bytes", w.written),
+		slog.String("download", download),
+		slog.Duration("duration", duration))
+}
+
+// errorCodeHeader names the ErrorCode of a failed request, allowing a Client
+// to restore the error.
+const errorCodeHeader = "X-Gosh-Error"
+
+// handleError responds with the HTTP status code for err.
+func (h *storeHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	code := ClassifyError(err)
+	status := code.HTTPStatus()
+	if status >= http.StatusInternalServerError {
+		h.store.logger.Error("Failed to handle request", slog.Any("error", err))
+	}
+
+	w.Header().Set(errorCodeHeader, code.String())
+	writeError(w, r, http.StatusText(status), code.String(), status)
+}
+
+// ErrorResponse is the body of a failed request for clients preferring JSON by
+// their Accept header. Its Code is the ErrorCode's String for errors of the
+// Store and otherwise derived from the HTTP status, e.g., "bad_request".
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// errorResponseTypes are the media types of error responses, plain text being
+// the fallback, e.g., for CLI clients.
+var errorResponseTypes = []string{"text/plain", "application/json"}
+
+// httpError works like http.Error, but responds with an ErrorResponse to
+// clients preferring JSON, having a Code derived from the HTTP status.
+func (h *storeHandler) httpError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	writeError(w, r, message, strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"), status)
+}
+
+// writeError implements both handleError and httpError.
+func writeError(w http.ResponseWriter, r *http.Request, message, code string, status int) {
+	w.Header().Add("Vary", "Accept")
+	if negotiateContentType(r.Header.Get("Accept"), errorResponseTypes) != "application/json" {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
+// clientIP identifies the client of a request by its IP address, taken from
+// the X-Forwarded-For header for a trusted proxy. For WithTrustedProxies, the
+// nearest untrusted hop of either the Forwarded or X-Forwarded-For header is
+// used, if the peer is a trusted proxy.
+func (h *storeHandler) clientIP(r *http.Request) (string, error) {
+	if xff := r.Header.Get("X-Forwarded-For"); h.trustProxy && xff != "" {
+		addrs := strings.Split(xff, ",")
+		addr := strings.TrimSpace(addrs[len(addrs)-1])
+		if ip := net.ParseIP(addr); ip != nil {
+			return ip.String(), nil
+		}
+		return "", fmt.Errorf("cannot parse remote IP %q from header X-Forwarded-For", addr)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("cannot parse remote IP %q", host)
+	}
+
+	if !h.trustedProxy(ip) {
+		return ip.String(), nil
+	}
+
+	header, hops := "Forwarded", forwardedHops(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		header, hops = "X-Forwarded-For", forwardedForHops(r.Header.Values("X-Forwarded-For"))
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = net.ParseIP(hops[i])
+		if ip == nil {
+			return "", fmt.Errorf("cannot parse remote IP %q from header %s", hops[i], header)
+		} else if !h.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String(), nil
+}
+
+// trustedProxy checks if an IP address belongs to WithTrustedProxies.
+func (h *storeHandler) trustedProxy(ip net.IP) bool {
+	for _, proxy := range h.trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedForHops returns the addresses of X-Forwarded-For header values,
+// ordered from the most distant hop.
+func forwardedForHops(values []string) (hops []string) {
+	for _, value := range values {
+		for _, addr := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(addr))
+		}
+	}
+	return
+}
+
+// forwardedHops returns the "for" addresses of RFC 7239 Forwarded header
+// values, ordered from the most distant hop. Ports and brackets are removed.
+func forwardedHops(values []string) (hops []string) {
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, addr, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if !strings.EqualFold(key, "for") {
+					continue
+				}
+
+				addr = strings.Trim(addr, `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				hops = append(hops, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	return
+}
+
+// allowUpload consults the RateLimiter, if any, and otherwise responds with
+// either 429 Too Many Requests or 400 Bad Request for an unknown client.
+func (h *storeHandler) allowUpload(w http.ResponseWriter, r *http.Request) bool {
+	if h.rateLimiter == nil {
+		return true
+	}
+
+	ip, err := h.clientIP(r)
+	if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	ok, retryAfter := h.rateLimiter.Allow(ip)
+	if !ok {
+		seconds := max(1, int64(math.Ceil(retryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		h.handleError(w, r, ErrRateLimited)
+		return false
+	}
+	return true
+}
+
+// handleUploadError responds to a failed upload. Exceeding WithMaxUploadBytes
+// results in ErrFileTooBig. Other errors are responded by the given status
+// code or, if zero, by their ErrorCode.
+func (h *storeHandler) handleUploadError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+		h.handleError(w, r, ErrFileTooBig)
+	} else if status != 0 {
+		h.httpError(w, r, err.Error(), status)
+	} else {
+		h.handleError(w, r, err)
+	}
+}
+
+// uploadLifetime returns the requested lifetime of an upload, taken from the
+// X-Expires-In header, the "expires" parameter, or the "time" parameter, in
+// this order. Otherwise, the default lifetime is used.
+func (h *storeHandler) uploadLifetime(r *http.Request, query url.Values, now time.Time) (time.Duration, error) {
+	lifetime := h.defaultLifetime
+	if expires := r.Header.Get("X-Expires-In"); expires != "" {
+		var err error
+		if lifetime, err = parseExpiry(expires, now); err != nil {
+			return 0, err
+		}
+	} else if expires := query.Get("expires"); expires != "" {
+		var err error
+		if lifetime, err = parseExpiry(expires, now); err != nil {
+			return 0, err
+		}
+	} else if queryLifetime := query.Get(formLifetime); queryLifetime != "" {
+		var err error
+		if lifetime, err = ParseDuration(queryLifetime); err != nil {
+			return 0, err
+		}
+	}
+
+	if lifetime <= 0 || (h.minLifetime > 0 && lifetime < h.minLifetime) {
+		return 0, ErrLifetimeTooShort
+	} else if h.maxLifetime > 0 && lifetime > h.maxLifetime {
+		return 0, ErrLifetimeTooLong
+	}
+	return lifetime, nil
+}
+
+// parseExpiry parses an expiry as either a duration, supported by
+// ParseDuration, or an RFC 3339 timestamp, resulting in a lifetime from now.
+func parseExpiry(expiry string, now time.Time) (time.Duration, error) {
+	if lifetime, err := ParseDuration(expiry); err == nil {
+		return lifetime, nil
+	}
+
+	expires, err := time.Parse(time.RFC3339, expiry)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse expiry %q as a duration or an RFC 3339 timestamp", expiry)
+	}
+	return expires.Sub(now), nil
+}
+
+// firstFilePart reads a multipart/form-data request up to its first file part,
+// which is returned together with the preceding form fields. Without any file
+// part, io.EOF is returned.
+func firstFilePart(r *http.Request) (*multipart.Part, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make(url.Values)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FileName() != "" {
+			return part, fields, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, handlerMaxFieldSize+1))
+		if err != nil {
+			return nil, nil, err
+		} else if len(value) > handlerMaxFieldSize {
+			return nil, nil, fmt.Errorf("multipart form field %q is too large", part.FormName())
+		}
+		fields.Add(part.FormName(), string(value))
+	}
+}
+
+// handleUpload stores a new Item, optionally at a client-chosen slug. An Item
+// already using this Slug is replaced, if authorized by its DeletionKey.
+func (h *storeHandler) handleUpload(w http.ResponseWriter, r *http.Request, slug string) {
+	if !h.allowUpload(w, r) {
+		return
+	}
+	ownerKey, ok := h.uploadKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if h.maxUploadBytes > 0 {
+		if r.ContentLength > h.maxUploadBytes {
+			h.handleError(w, r, ErrFileTooBig)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
+	}
+
+	var replaced Item
+	if slug != "" {
+		var err error
+		replaced, err = h.slugReplacement(r, slug, ownerKey)
+		if err != nil {
+			h.handleError(w, r, err)
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	body := io.ReadCloser(r.Body)
+	contentType := r.Header.Get("Content-Type")
+	filename := query.Get("filename")
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "multipart/form-data" {
+		part, fields, err := firstFilePart(r)
+		if err == io.EOF {
+			h.httpError(w, r, "multipart form has no file", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.handleUploadError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		body = part
+		contentType = part.Header.Get("Content-Type")
+		if filename == "" {
+			filename = part.FileName()
+		}
+		for key, values := range fields {
+			if !query.Has(key) {
+				query[key] = values
+			}
+		}
+	}
+
+	item := Item{
+		BurnAfterReading: query.Get(formBurnAfterReading) == "1",
+		Filename:         filenamePattern.ReplaceAllString(filename, "_"),
+		ContentType:      contentType,
+		Created:          h.store.clock.Now().UTC(),
+	}
+
+	lifetime, err := h.uploadLifetime(r, query, item.Created)
+	if err != nil && ClassifyError(err) != CodeUnknown {
+		h.handleError(w, r, err)
+		return
+	} else if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.Expires = item.Created.Add(lifetime)
+
+	// A replacing Item keeps the DeletionKey, allowing to repeat the request.
+	if replaced.ID != "" {
+		item.DeletionKey = replaced.DeletionKey
+	} else {
+		item.Slug = slug
+		item.DeletionKey, err = newDeletionKey()
+		if err != nil {
+			h.handleError(w, r, err)
+			return
+		}
+	}
+
+	item.Owner, err = NewOwnerTypes(r)
+	if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.OwnerKey = ownerKey
+
+	id, err := h.store.PutContext(r.Context(), item, body)
+	if err != nil {
+		h.handleUploadError(w, r, err, 0)
+		return
+	}
+
+	status := http.StatusCreated
+	if replaced.ID != "" {
+		err = h.store.replaceSlug(replaced.ID, id, slug)
+		if err != nil {
+			h.handleError(w, r, err)
+			return
+		}
+		status = http.StatusOK
+	}
+
+	urlPath := id
+	if slug != "" {
+		urlPath = slug
+	}
+	result := UploadResult{
+		ID:          id,
+		URL:         h.itemURL(r, urlPath),
+		DeletionKey: item.DeletionKey,
+		Expires:     item.Expires,
+	}
+
+	w.Header().Set("X-Deletion-Key", item.DeletionKey)
+	w.Header().Add("Vary", "Accept")
+
+	switch negotiateContentType(r.Header.Get("Accept"), uploadResultTypes) {
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err = json.NewEncoder(w).Encode(result)
+
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		err = uploadResultTpl.Execute(w, result)
+
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, err = fmt.Fprintln(w, result.URL)
+	}
+	if err != nil {
+		h.store.logger.Warn("Failed to respond to upload", slog.String("id", id), slog.Any("error", err))
+	}
+}
+
+// itemURL returns the absolute URL of an Item, either below WithBaseURL or
+// derived from the request.
+func (h *storeHandler) itemURL(r *http.Request, id string) string {
+	if h.baseURL != "" {
+		return h.baseURL + "/" + id
+	}
+
+	// The request's path might be shortened by http.StripPrefix, while its
+	// RequestURI is left untouched.
+	prefix := ""
+	if requestURL, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		prefix = strings.TrimSuffix(requestURL.Path, r.URL.Path)
+	}
+	return fmt.Sprintf("%s://%s%s/%s", WebProtocol(r), r.Host, strings.TrimRight(prefix, "/"), id)
+}
+
+// UploadResult describes a new Item in the response to an upload, e.g., as
+// JSON for a Client.
+type UploadResult struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	DeletionKey string    `json:"deletion_key"`
+	Expires     time.Time `json:"expires"`
+}
+
+// uploadResultTypes are the supported media types of an UploadResult, the
+// first being the default.
+var uploadResultTypes = []string{"text/plain", "application/json", "text/html"}
+
+// uploadResultTpl renders an UploadResult as a minimal HTML page.
+var uploadResultTpl = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.ID}}</title></head>
+<body>
+<dl>
+<dt>URL</dt><dd><a href="{{.URL}}">{{.URL}}</a></dd>
+<dt>Deletion key</dt><dd><code>{{.DeletionKey}}</code></dd>
+<dt>Expires</dt><dd><time datetime="{{.Expires.Format "2006-01-02T15:04:05Z07:00"}}">{{.Expires}}</time></dd>
+</dl>
+</body>
+</html>
+`))
+
+// negotiateContentType picks the supported media type with the highest
+// quality from an Accept header value. Ties are resolved by the order of the
+// supported types, the first also being the fallback.
+func negotiateContentType(accept string, supported []string) string {
+	best, bestQuality := supported[0], 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		for _, candidate := range supported {
+			mainType, _, _ := strings.Cut(candidate, "/")
+			matches := mediaType == candidate || mediaType == "*/*" || mediaType == mainType+"/*"
+			if matches && quality > bestQuality {
+				best, bestQuality = candidate, quality
+			}
+		}
+	}
+	return best
+}
+
+// acceptsGzip checks if an Accept-Encoding header value allows a gzip encoded
+// response, either explicitly or by a wildcard, with a non-zero quality.
+func acceptsGzip(acceptEncoding string) bool {
+	accepted := false
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "x-gzip" && name != "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		// An explicit gzip coding overrides the wildcard.
+		if name != "*" {
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
+
+// writeItemHeaders writes the headers describing an Item's content for both
+// downloads and HEAD requests. If nothing else should be written, e.g., for a
+// missing password or an unchanged ETag, false is returned after responding.
+func (h *storeHandler) writeItemHeaders(w http.ResponseWriter, r *http.Request, item Item) bool {
+	if len(item.PasswordHash) > 0 {
+		if _, password, _ := r.BasicAuth(); !item.CheckPassword(password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gosh"`)
+			h.httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return false
+		}
+	}
+
+	if etag := itemETag(item); etag != "" {
+		w.Header().Set("ETag", etag)
+
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+	}
+
+	contentType := item.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	filename := item.Filename
+	if filename == "" {
+		filename = item.ID
+	}
+
+	download := r.URL.Query().Get("download") == "1" || (!h.sandboxDomain && dangerousContentType(contentType))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(item, filename, download))
+	h.setContentSecurity(w)
+
+	if !item.Expires.IsZero() && !item.NeverExpires() {
+		w.Header().Set("Expires", item.Expires.UTC().Format(http.TimeFormat))
+	}
+	if !item.BurnAfterReading {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	return true
+}
+
+// handleHead responds to HEAD /{id} with the headers of a download, but
+// without its content. Thus, it neither counts as a download nor burns the
+// Item.
+func (h *storeHandler) handleHead(w http.ResponseWriter, r *http.Request, id string) {
+	item, err := h.store.get(h.store.resolveSlug(id))
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	if !h.writeItemHeaders(w, r, item) {
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(item.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *storeHandler) handleDownload(w http.ResponseWriter, r *http.Request, id string) {
+	id = h.store.resolveSlug(id)
+
+	// A compressed Item's gzip stream is passed through to clients accepting
+	// it, except for ranges, which would refer to the encoded content.
+	var (
+		item    Item
+		f       io.ReadCloser
+		gzipped bool
+		err     error
+	)
+	_, password, _ := r.BasicAuth()
+	if r.Header.Get("Range") == "" && acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		item, f, gzipped, err = h.store.getWithCompressedFile(id, password)
+	} else {
+		item, f, err = h.store.GetWithFileAndPassword(id, password)
+	}
+	if err == ErrUnauthorized {
+		// A missing or wrong password asks for HTTP Basic authentication,
+		// unlike ErrUnauthorized's 403 Forbidden.
+		w.Header().Set("WWW-Authenticate", `Basic realm="gosh"`)
+		h.httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !h.writeItemHeaders(w, r, item) {
+		return
+	}
+
+	// Items to be burned are always served completely.
+	status, length := http.StatusOK, item.Size
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && !item.BurnAfterReading && ifRangeMatches(r, item) {
+		start, rangeLength, err := parseRange(rangeHeader, item.Size)
+		if err == errRangeUnsatisfiable {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", item.Size))
+			h.handleError(w, r, ErrInvalidRange)
+			return
+		} else if err == nil {
+			rangeFile, err := h.store.getFileRange(id, password, start, rangeLength)
+			if err != nil {
+				h.handleError(w, r, err)
+				return
+			}
+			_ = f.Close()
+			f = rangeFile
+
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+rangeLength-1, item.Size))
+			status, length = http.StatusPartialContent, rangeLength
+		}
+		// Otherwise, e.g., for multiple ranges, the whole content is served.
+	}
+
+	// The size of the gzip stream is unknown in advance.
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(status)
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		h.store.logger.Warn("Failed to serve Item", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+
+	if item.BurnAfterReading {
+		err = h.store.Delete(item.ID)
+		if err != nil {
+			h.store.logger.Error("Failed to burn Item after reading", slog.String("id", id), slog.Any("error", err))
+		}
+	}
+}
+
+// thumbnailPath is the suffix of an Item's path to serve its Thumbnail.
+const thumbnailPath = "thumb"
+
+func (h *storeHandler) handleThumbnail(w http.ResponseWriter, r *http.Request, id string) {
+	thumb, err := h.store.GetThumbnail(h.store.resolveSlug(id))
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", thumb.ContentType)
+	h.setContentSecurity(w)
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumb.Data)))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(thumb.Data)
+	if err != nil {
+		h.store.logger.Warn("Failed to serve thumbnail", slog.String("id", id), slog.Any("error", err))
+	}
+}
+
+// defaultContentSecurityPolicy is sent for downloads, unless replaced by
+// WithContentSecurityPolicy. It allows displaying media, but neither scripts
+// nor any requests to other resources, sandboxing the content.
+const defaultContentSecurityPolicy = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+// dangerousContentTypes might execute scripts within the Handler's origin when
+// being displayed inline.
+var dangerousContentTypes = map[string]bool{
+	"application/javascript": true,
+	"application/xhtml+xml":  true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/xml":               true,
+}
+
+// dangerousContentType checks if a content type is one of the
+// dangerousContentTypes. Unparsable types are considered dangerous as well.
+func dangerousContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err != nil || dangerousContentTypes[mediaType]
+}
+
+// setContentSecurity sets the headers restricting the browser for uploaded
+// content, preventing both MIME sniffing and scripts.
+func (h *storeHandler) setContentSecurity(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if h.contentSecurityPolicy != "" {
+		w.Header().Set("Content-Security-Policy", h.contentSecurityPolicy)
+	}
+}
+
+// inlineContentTypes are displayed inline for DispositionAuto. Other types,
+// e.g., HTML or SVG images possibly containing scripts, are attachments.
+var inlineContentTypes = map[string]bool{
+	"image/avif": true,
+	"image/bmp":  true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"text/plain": true,
+}
+
+// contentDisposition creates an Item's Content-Disposition header with the
+// filename. Non-ASCII filenames are encoded by RFC 5987 with an ASCII fallback.
+// A forced download always results in an attachment.
+func contentDisposition(item Item, filename string, download bool) string {
+	disposition := item.Disposition
+	if download {
+		disposition = DispositionAttachment
+	} else if disposition != DispositionInline && disposition != DispositionAttachment {
+		disposition = DispositionAttachment
+		if mediaType, _, err := mime.ParseMediaType(item.ContentType); err == nil && inlineContentTypes[mediaType] {
+			disposition = DispositionInline
+		}
+	}
+
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	header := fmt.Sprintf("%s; filename=%q", disposition, fallback)
+	if fallback != filename {
+		header += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return header
+}
+
+// encodeExtValue percent-encodes a value except for RFC 5987's attr-chars.
+func encodeExtValue(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+var (
+	// errRangeUnsatisfiable is returned by parseRange for a range outside the
+	// content, to be responded with HTTP status code 416.
+	errRangeUnsatisfiable = errors.New("range is not satisfiable")
+
+	// errRangeUnsupported is returned by parseRange for malformed or multiple
+	// ranges, which are ignored by serving the whole content.
+	errRangeUnsupported = errors.New("range is not supported")
+)
+
+// parseRange parses a Range header value with a single byte range for some
+// content of the given size. A satisfiable range results in its start and
+// length.
+func parseRange(header string, size int64) (start, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeUnsupported
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeUnsupported
+	}
+
+	// A suffix range, e.g., "-500" for the last 500 bytes.
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeUnsupported
+		} else if n == 0 || size == 0 {
+			return 0, 0, errRangeUnsatisfiable
+		}
+
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeUnsupported
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errRangeUnsupported
+		}
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return 0, 0, errRangeUnsatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// itemETag returns the Item's Checksum as a quoted entity tag or an empty
+// string for Items without a Checksum.
+func itemETag(item Item) string {
+	if item.Checksum == "" {
+		return ""
+	}
+	return strconv.Quote(item.Checksum)
+}
+
+// etagMatches checks if an If-None-Match header value, being either "*" or a
+// list of entity tags, matches the etag by a weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches checks if a Range header should be considered, being the
+// case without an If-Range header or if it matches the Item's Checksum as an,
+// also weak, entity tag.
+func ifRangeMatches(r *http.Request, item Item) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(ifRange, "W/"), `"`)
+	return item.Checksum != "" && tag == item.Checksum
+}
+
+// bearerToken returns the bearer token of the Authorization header, if any.
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// slugReplacement checks if an upload might use a Slug and returns the Item to
+// be replaced, if any. A Slug used by another Item results in ErrSlugTaken,
+// unless authorized by its DeletionKey as a bearer token or, WithUploadKeys,
+// by the upload key's owner of the Item.
+func (h *storeHandler) slugReplacement(r *http.Request, slug, ownerKey string) (Item, error) {
+	if !slugPattern.MatchString(slug) || h.reservedSlug(slug) {
+		return Item{}, ErrInvalidSlug
+	}
+
+	existing, err := h.store.GetBySlug(slug)
+	if err == ErrNotFound {
+		return Item{}, nil
+	} else if err != nil {
+		return Item{}, err
+	} else if h.uploadKeyValidator != nil && (ownerKey == "" || existing.OwnerKey != ownerKey) {
+		return Item{}, ErrSlugTaken
+	} else if h.uploadKeyValidator == nil && !existing.CheckDeletionKey(bearerToken(r)) {
+		return Item{}, ErrSlugTaken
+	}
+	return existing, nil
+}
+
+// reservedSlug checks if a Slug equals a path segment routed by ServeHTTP
+// before any Item, making such an Item inaccessible. The admin path is also
+// reserved while the admin API is disabled by an empty token.
+func (h *storeHandler) reservedSlug(slug string) bool {
+	for _, path := range []string{uploadsPath, h.adminPath, h.livenessPath, h.readinessPath} {
+		if path != "" && slug == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *storeHandler) handleDelete(w http.ResponseWriter, r *http.Request, id, token string) {
+	err := h.store.DeleteWithToken(h.store.resolveSlug(id), token)
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteConfirmationTpl renders the page to confirm an Item's deletion. The
+// relative form action resolves to POST /{id}/delete.
+var deleteConfirmationTpl = template.Must(template.New("delete").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Delete {{.ID}}</title></head>
+<body>
+<form method="post" action="delete">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Delete <code>{{.ID}}</code>{{with .Filename}} (<code>{{.}}</code>){{end}}?</p>
+<button type="submit">Delete</button>
+</form>
+</body>
+</html>
+`))
+
+func (h *storeHandler) handleDeleteConfirmation(w http.ResponseWriter, r *http.Request, id, token string) {
+	item, err := h.store.Get(h.store.resolveSlug(id))
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	} else if !item.CheckDeletionKey(token) {
+		h.handleError(w, r, ErrUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = deleteConfirmationTpl.Execute(w, struct {
+		ID       string
+		Filename string
+		Token    string
+	}{item.ID, item.Filename, token})
+	if err != nil {
+		h.store.logger.Warn("Failed to respond with deletion confirmation", slog.String("id", id), slog.Any("error", err))
+	}
+}
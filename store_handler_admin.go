@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+const (
+	// adminDefaultLimit and adminMaxLimit bound the Items per page listed by
+	// the admin API.
+	adminDefaultLimit = 100
+	adminMaxLimit     = 1000
+
+	// adminStatsInterval and adminHeartbeatInterval are the periods of stats
+	// snapshots and of heartbeats to keep proxies from closing idle streams
+	// of the admin API's events.
+	adminStatsInterval     = 30 * time.Second
+	adminHeartbeatInterval = 15 * time.Second
+)
+
+// WithAdmin enables the admin API under a single path segment, e.g., "admin",
+// authorized by the token as a bearer token in the Authorization header. An
+// Item with an ID equal to the path becomes inaccessible. An empty path
+// defaults to "admin", while an empty token disables the API.
+func WithAdmin(path, token string) HandlerOption {
+	return func(h *storeHandler) {
+		h.adminPath = strings.Trim(path, "/")
+		if h.adminPath == "" {
+			h.adminPath = "admin"
+		}
+		h.adminToken = token
+	}
+}
+
+// AdminItem is an Item's metadata as listed by the admin API, omitting its
+// secrets and Inline content.
+type AdminItem struct {
+	ID               string    `json:"id"`
+	Slug             string    `json:"slug,omitempty"`
+	Filename         string    `json:"filename,omitempty"`
+	ContentType      string    `json:"content_type,omitempty"`
+	Size             int64     `json:"size"`
+	Checksum         string    `json:"checksum,omitempty"`
+	Created          time.Time `json:"created"`
+	Expires          time.Time `json:"expires"`
+	HideAfter        time.Time `json:"hide_after"`
+	LockedUntil      time.Time `json:"locked_until"`
+	Downloads        int64     `json:"downloads"`
+	BurnAfterReading bool      `json:"burn_after_reading"`
+	Password         bool      `json:"password"`
+	OwnerKey         string    `json:"owner_key,omitempty"`
+}
+
+// newAdminItem creates an AdminItem from an Item.
+func newAdminItem(i Item) AdminItem {
+	return AdminItem{
+		ID:               i.ID,
+		Slug:             i.Slug,
+		Filename:         i.Filename,
+		ContentType:      i.ContentType,
+		Size:             i.Size,
+		Checksum:         i.Checksum,
+		Created:          i.Created,
+		Expires:          i.Expires,
+		HideAfter:        i.HideAfter,
+		LockedUntil:      i.LockedUntil,
+		Downloads:        i.Downloads,
+		BurnAfterReading: i.BurnAfterReading,
+		Password:         len(i.PasswordHash) > 0,
+		OwnerKey:         i.OwnerKey,
+	}
+}
+
+// AdminStats are the Store's statistics reported by the admin API.
+type AdminStats struct {
+	Items int                 `json:"items"`
+	Bytes int64               `json:"bytes"`
+	Types map[string]TypeStat `json:"types"`
+
+	// IDCollisions and IDCollisionRate are as reported by the Store.
+	IDCollisions    uint64  `json:"id_collisions"`
+	IDCollisionRate float64 `json:"id_collision_rate"`
+}
+
+// handleAdmin routes the Handler's admin API, WithAdmin:
+//
+//   - GET /admin/items lists Items as AdminItems, paginated by the "offset"
+//     and "limit" query parameters.
+//   - GET /admin/items/{id} returns an AdminItem, even if hidden or expired.
+//   - DELETE /admin/items/{id} deletes an Item, even if locked.
+//   - GET /admin/stats returns the AdminStats.
+//   - POST /admin/cleanup deletes all expired Items and returns their amount.
+//   - GET /admin/events streams Server-Sent Events of "stats" with periodic
+//     AdminStats and of "create" and "delete" with each StoreEvent.
+//
+// Requests without the valid token are responded with 401 Unauthorized.
+func (h *storeHandler) handleAdmin(w http.ResponseWriter, r *http.Request, subpath string) {
+	token := bearerToken(r)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gosh"`)
+		h.httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	resource, id, nested := strings.Cut(subpath, "/")
+
+	switch {
+	case resource == "items" && !nested && r.Method == http.MethodGet:
+		h.handleAdminList(w, r)
+
+	case resource == "items" && id != "" && !strings.Contains(id, "/") && r.Method == http.MethodGet:
+		h.handleAdminItem(w, r, id)
+
+	case resource == "items" && id != "" && !strings.Contains(id, "/") && r.Method == http.MethodDelete:
+		err := h.store.ForceDelete(id)
+		if err == badgerhold.ErrNotFound {
+			err = ErrNotFound
+		}
+		if err != nil {
+			h.handleError(w, r, err)
+			return
+		}
+		h.store.logger.Info("Deleted Item by admin API", slog.String("id", id))
+		w.WriteHeader(http.StatusNoContent)
+
+	case resource == "stats" && !nested && r.Method == http.MethodGet:
+		h.handleAdminStats(w, r)
+
+	case resource == "events" && !nested && r.Method == http.MethodGet:
+		h.handleAdminEvents(w, r)
+
+	case resource == "cleanup" && !nested && r.Method == http.MethodPost:
+		deleted, err := h.store.CleanupNow()
+		if err != nil {
+			h.handleError(w, r, err)
+			return
+		}
+		h.writeAdminJSON(w, map[string]int{"deleted": deleted})
+
+	case (resource == "items" && (!nested || !strings.Contains(id, "/"))) ||
+		(!nested && (resource == "stats" || resource == "cleanup" || resource == "events")):
+		h.httpError(w, r, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+
+	default:
+		h.httpError(w, r, msgNotExists, http.StatusNotFound)
+	}
+}
+
+// writeAdminJSON responds with v encoded as JSON.
+func (h *storeHandler) writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		h.store.logger.Warn("Failed to write admin API response", slog.Any("error", err))
+	}
+}
+
+func (h *storeHandler) handleAdminList(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, adminDefaultLimit
+	query := r.URL.Query()
+
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.httpError(w, r, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.httpError(w, r, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, adminMaxLimit)
+	}
+
+	items, err := h.store.List(offset, limit)
+	if err != nil && len(items) == 0 {
+		h.handleError(w, r, err)
+		return
+	} else if err != nil {
+		h.store.logger.Warn("Listing Items skipped undecodable Items", slog.Any("error", err))
+	}
+
+	adminItems := make([]AdminItem, 0, len(items))
+	for _, i := range items {
+		adminItems = append(adminItems, newAdminItem(i))
+	}
+
+	h.writeAdminJSON(w, struct {
+		Items  []AdminItem `json:"items"`
+		Offset int         `json:"offset"`
+		Limit  int         `json:"limit"`
+	}{adminItems, offset, limit})
+}
+
+func (h *storeHandler) handleAdminItem(w http.ResponseWriter, r *http.Request, id string) {
+	i, err := h.store.Peek(id)
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	h.writeAdminJSON(w, newAdminItem(i))
+}
+
+// adminStats collects the AdminStats.
+func (h *storeHandler) adminStats() (stats AdminStats, err error) {
+	stats.Types, err = h.store.StatsByType()
+	if err != nil {
+		return
+	}
+
+	for _, stat := range stats.Types {
+		stats.Items += stat.Count
+		stats.Bytes += stat.Bytes
+	}
+	stats.IDCollisions = h.store.IDCollisions()
+	stats.IDCollisionRate = h.store.IDCollisionRate()
+	return
+}
+
+func (h *storeHandler) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.adminStats()
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+	h.writeAdminJSON(w, stats)
+}
+
+// adminEvent is a StoreEvent's data within the admin API's events.
+type adminEvent struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// handleAdminEvents streams Server-Sent Events until either the client
+// disconnects or the Store is closed. A stats snapshot is sent first.
+func (h *storeHandler) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := h.store.Subscribe()
+	defer unsubscribe()
+
+	statsTicker := h.store.clock.NewTicker(adminStatsInterval)
+	defer statsTicker.Stop()
+	heartbeatTicker := h.store.clock.NewTicker(adminHeartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// send writes and flushes one event; a comment for an empty name.
+	send := func(name string, data any) error {
+		if name == "" {
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	sendStats := func() error {
+		stats, err := h.adminStats()
+		if err != nil {
+			h.store.logger.Warn("Failed to collect stats for admin events", slog.Any("error", err))
+			return nil
+		}
+		return send("stats", stats)
+	}
+
+	var err error
+	if err = sendStats(); err != nil {
+		return
+	}
+
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = send(string(event.Op), adminEvent{ID: event.ID, Time: event.Time})
+
+		case <-statsTicker.C():
+			err = sendStats()
+
+		case <-heartbeatTicker.C():
+			err = send("", nil)
+		}
+	}
+	h.store.logger.Debug("Stopped admin events", slog.Any("error", err))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreHandlerAdmin(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	h := store.Handler(WithAdmin("/manage/", "secret"))
+
+	var ids []string
+	for _, lifetime := range []time.Duration{time.Minute, time.Hour, time.Hour} {
+		id, err := store.Put(
+			Item{ContentType: "text/plain", Created: clock.Now(), Expires: clock.Now().Add(lifetime)},
+			newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	adminRequest := func(method, target, token string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serveHandler(h, req)
+	}
+
+	for _, token := range []string{"", "nope", "secre", "secrets"} {
+		resp := adminRequest(http.MethodGet, "/manage/stats", token)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Admin API with token %q responded %d", token, resp.StatusCode)
+		} else if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Fatal("Unauthorized response lacks WWW-Authenticate")
+		}
+	}
+
+	// Without WithAdmin, the path is just an unknown Item.
+	if resp := serveHandler(store.Handler(), httptest.NewRequest(http.MethodGet, "/manage", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Disabled admin API responded %d", resp.StatusCode)
+	}
+
+	resp := adminRequest(http.MethodGet, "/manage/items?offset=1&limit=5", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Admin list responded %d", resp.StatusCode)
+	}
+	var list struct {
+		Items  []AdminItem `json:"items"`
+		Offset int         `json:"offset"`
+		Limit  int         `json:"limit"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	} else if len(list.Items) != 2 || list.Offset != 1 || list.Limit != 5 {
+		t.Fatalf("Admin list returned %v", list)
+	}
+
+	if resp := adminRequest(http.MethodGet, "/manage/items?limit=-1", "secret"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Admin list with an invalid limit responded %d", resp.StatusCode)
+	}
+
+	resp = adminRequest(http.MethodGet, "/manage/items/"+ids[0], "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Admin inspection responded %d", resp.StatusCode)
+	}
+	var item AdminItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	} else if item.ID != ids[0] || item.Size != 11 || item.ContentType != "text/plain" {
+		t.Fatalf("Admin inspection returned %v", item)
+	}
+
+	resp = adminRequest(http.MethodGet, "/manage/stats", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Admin stats responded %d", resp.StatusCode)
+	}
+	var stats AdminStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	} else if stats.Items != 3 || stats.Bytes != 33 || stats.Types["text/plain"].Count != 3 {
+		t.Fatalf("Admin stats returned %v", stats)
+	}
+
+	clock.Advance(10 * time.Minute)
+	resp = adminRequest(http.MethodPost, "/manage/cleanup", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Admin cleanup responded %d", resp.StatusCode)
+	}
+	var cleanup map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&cleanup); err != nil {
+		t.Fatal(err)
+	} else if cleanup["deleted"] != 1 {
+		t.Fatalf("Admin cleanup returned %v", cleanup)
+	}
+
+	// Locked Items are deleted as well.
+	if err := store.update(ids[1], func(i *Item) error {
+		i.LockedUntil = clock.Now().Add(time.Hour)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := adminRequest(http.MethodDelete, "/manage/items/"+ids[1], "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Admin deletion responded %d", resp.StatusCode)
+	}
+	if _, err := store.Get(ids[1]); err != ErrNotFound {
+		t.Fatalf("Deleted Item resulted in %v", err)
+	}
+	if resp := adminRequest(http.MethodDelete, "/manage/items/"+ids[1], "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Admin deletion of a missing Item responded %d", resp.StatusCode)
+	}
+
+	for _, test := range []struct {
+		method, target string
+		expected       int
+	}{
+		{http.MethodPost, "/manage/stats", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/manage/cleanup", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/manage/nope", http.StatusNotFound},
+		{http.MethodGet, "/manage/items/a/b", http.StatusNotFound},
+	} {
+		if resp := adminRequest(test.method, test.target, "secret"); resp.StatusCode != test.expected {
+			t.Fatalf("%s %s responded %d, expected %d", test.method, test.target, resp.StatusCode, test.expected)
+		}
+	}
+}
+
+func TestStoreHandlerAdminEvents(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	srv := httptest.NewServer(store.Handler(WithAdmin("admin", "secret")))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/admin/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Admin events responded %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Content-Type"); v != "text/event-stream" {
+		t.Fatalf("Admin events have content type %q", v)
+	}
+
+	frames := make(chan string)
+	go func() {
+		defer close(frames)
+
+		var frame strings.Builder
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				frame.WriteString(scanner.Text() + "\n")
+				continue
+			}
+			frames <- frame.String()
+			frame.Reset()
+		}
+	}()
+
+	nextFrame := func() string {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatal("Admin events stream was closed")
+			}
+			return frame
+		case <-time.After(5 * time.Second):
+			t.Fatal("No admin event received")
+		}
+		return ""
+	}
+
+	if frame := nextFrame(); !strings.HasPrefix(frame, "event: stats\ndata: {") {
+		t.Fatalf("First frame is %q, expected stats", frame)
+	}
+
+	clock.Advance(adminHeartbeatInterval)
+	if frame := nextFrame(); frame != ": heartbeat\n" {
+		t.Fatalf("Frame is %q, expected a heartbeat", frame)
+	}
+
+	id, err := store.Put(
+		Item{Created: clock.Now(), Expires: clock.Now().Add(time.Hour)},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := nextFrame()
+	data, ok := strings.CutPrefix(frame, "event: create\ndata: ")
+	if !ok {
+		t.Fatalf("Frame is %q, expected a create event", frame)
+	}
+	var event adminEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatal(err)
+	} else if event.ID != id {
+		t.Fatalf("Create event is for %q, expected %q", event.ID, id)
+	}
+
+	clock.Advance(adminStatsInterval - adminHeartbeatInterval)
+	for {
+		frame := nextFrame()
+		if frame == ": heartbeat\n" {
+			continue
+		} else if !strings.HasPrefix(frame, "event: stats\ndata: {\"items\":1,") {
+			t.Fatalf("Frame is %q, expected stats of one Item", frame)
+		}
+		break
+	}
+
+	// Disconnecting the client unsubscribes.
+	resp.Body.Close()
+	for i := 0; ; i++ {
+		store.subs.mutex.Lock()
+		subscribers := len(store.subs.chans)
+		store.subs.mutex.Unlock()
+
+		if subscribers == 0 {
+			break
+		} else if i >= 100 {
+			t.Fatal("Disconnected client is still subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
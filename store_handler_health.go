@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// WithHealth serves health checks for orchestrators under single path
+// segments, e.g., "healthz" for liveness by Store.Health and "readyz" for
+// readiness by Store.Ready. Items with an ID equal to these paths become
+// inaccessible. Empty paths default to "healthz" and "readyz".
+func WithHealth(livenessPath, readinessPath string) HandlerOption {
+	return func(h *storeHandler) {
+		h.livenessPath = strings.Trim(livenessPath, "/")
+		if h.livenessPath == "" {
+			h.livenessPath = "healthz"
+		}
+
+		h.readinessPath = strings.Trim(readinessPath, "/")
+		if h.readinessPath == "" {
+			h.readinessPath = "readyz"
+		}
+	}
+}
+
+// handleHealth responds to a health check by check, either Store.Health or
+// Store.Ready, with 200 OK or with 503 Service Unavailable and a short reason.
+func (h *storeHandler) handleHealth(w http.ResponseWriter, r *http.Request, check func() error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.httpError(w, r, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := check()
+	if err == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+
+	reason := "unavailable"
+	switch {
+	case errors.Is(err, errDraining):
+		reason = "draining"
+	case errors.Is(err, ErrStoreClosed):
+		reason = "closed"
+	default:
+		h.store.logger.Error("Health check failed", slog.String("path", r.URL.Path), slog.Any("error", err))
+	}
+	h.httpError(w, r, reason, http.StatusServiceUnavailable)
+}
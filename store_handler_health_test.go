@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreHandlerHealth(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithHealth("", "/ready/"))
+
+	assertHealth := func(path string, status int, reason string) {
+		t.Helper()
+
+		resp := serveHandler(h, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != status {
+			t.Fatalf("GET %s responded %d, expected %d", path, resp.StatusCode, status)
+		} else if strings.TrimSpace(string(body)) != reason {
+			t.Fatalf("GET %s responded %q, expected %q", path, body, reason)
+		}
+	}
+
+	assertHealth("/healthz", http.StatusOK, "ok")
+	assertHealth("/ready", http.StatusOK, "ok")
+
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodPost, "/healthz", nil)); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /healthz responded %d", resp.StatusCode)
+	}
+	if resp := serveHandler(store.Handler(), httptest.NewRequest(http.MethodGet, "/healthz", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET /healthz without WithHealth responded %d", resp.StatusCode)
+	}
+
+	// An open download keeps the Store draining after a timed out Shutdown.
+	id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := store.Shutdown(ctx); err != ErrShutdownTimeout {
+		t.Fatalf("Shutdown with an open download resulted in %v", err)
+	}
+
+	assertHealth("/healthz", http.StatusOK, "ok")
+	assertHealth("/ready", http.StatusServiceUnavailable, "draining")
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertHealth("/healthz", http.StatusServiceUnavailable, "closed")
+	assertHealth("/ready", http.StatusServiceUnavailable, "closed")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware wraps an http.Handler, e.g., for authentication, CORS by
+// CORSMiddleware, or logging.
+type Middleware func(http.Handler) http.Handler
+
+// WithMiddleware wraps the Handler's routes by the middleware. The first one is
+// the outermost, seeing each request first and each response last. Multiple
+// WithMiddleware options append their middleware in order.
+func WithMiddleware(middleware ...Middleware) HandlerOption {
+	return func(h *storeHandler) {
+		h.middleware = append(h.middleware, middleware...)
+	}
+}
+
+// corsMaxAge is the time in seconds a browser may cache a preflight response.
+const corsMaxAge = "600"
+
+// corsMethods and corsExposedHeaders are all methods and response headers used
+// by the Handler's routes.
+const (
+	corsMethods        = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	corsExposedHeaders = "Content-Disposition, Content-Location, ETag, Location, Retry-After, Upload-Length, Upload-Offset, X-Deletion-Key"
+)
+
+// CORSMiddleware allows cross-origin requests from the allowed origins, e.g.,
+// "https://example.org", or from any origin for "*". Preflight requests of
+// allowed origins are answered directly, while requests of other origins are
+// passed on unchanged, leaving their refusal to the browser.
+//
+// Credentials are not allowed, as the Handler authorizes requests by tokens
+// instead of cookies.
+func CORSMiddleware(allowedOrigins ...string) Middleware {
+	anyOrigin := slices.Contains(allowedOrigins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !anyOrigin {
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin == "" || (!anyOrigin && !slices.Contains(allowedOrigins, origin)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", strings.TrimSpace(headers))
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandlerMiddleware(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	var calls []string
+	tracing := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" before")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" after")
+			})
+		}
+	}
+	refusing := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer embedder" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	h := store.Handler(WithMiddleware(tracing("outer"), tracing("middle")), WithMiddleware(refusing, tracing("inner")))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer embedder")
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload responded %d", resp.StatusCode)
+	}
+
+	expected := []string{"outer before", "middle before", "inner before", "inner after", "middle after", "outer after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Middleware ran as %v, expected %v", calls, expected)
+	}
+
+	calls = nil
+	resp = serveHandler(h, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world")))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Refused upload responded %d", resp.StatusCode)
+	} else if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Store has %d Items after a refused upload, expected 1", n)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithMiddleware(CORSMiddleware("https://allowed.example")))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+	req.Header.Set("Origin", "https://allowed.example")
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload responded %d", resp.StatusCode)
+	} else if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "https://allowed.example" {
+		t.Fatalf("Upload allowed origin %q", origin)
+	} else if exposed := resp.Header.Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-Deletion-Key") {
+		t.Fatalf("Upload exposed headers %q", exposed)
+	}
+	id := uploadedID(resp)
+
+	req = httptest.NewRequest(http.MethodOptions, "/"+id, nil)
+	req.Header.Set("Origin", "https://allowed.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	req.Header.Set("Access-Control-Request-Headers", "authorization")
+	resp = serveHandler(h, req)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Preflight responded %d", resp.StatusCode)
+	} else if methods := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(methods, http.MethodDelete) {
+		t.Fatalf("Preflight allowed methods %q", methods)
+	} else if headers := resp.Header.Get("Access-Control-Allow-Headers"); headers != "authorization" {
+		t.Fatalf("Preflight allowed headers %q", headers)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.Header.Set("Origin", "https://evil.example")
+	resp = serveHandler(h, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download of another origin responded %d", resp.StatusCode)
+	} else if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Fatalf("Download of another origin allowed origin %q", origin)
+	} else if vary := resp.Header.Values("Vary"); !strings.Contains(strings.Join(vary, ","), "Origin") {
+		t.Fatalf("Download does not vary by origin: %v", vary)
+	}
+
+	// Any origin is allowed by a wildcard.
+	h = store.Handler(WithMiddleware(CORSMiddleware("*")))
+	req = httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.Header.Set("Origin", "https://any.example")
+	if origin := serveHandler(h, req).Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Fatalf("Wildcard allowed origin %q", origin)
+	}
+}
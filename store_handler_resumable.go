@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// uploadsPath is the path prefix of the Handler's resumable uploads.
+const uploadsPath = "uploads"
+
+// handleResumable routes the Handler's resumable uploads, loosely following
+// the tus protocol:
+//
+//   - POST /uploads creates a PartialUpload of Upload-Length bytes. Its URL
+//     is returned as Location. The query parameters are used like for regular
+//     uploads, while the Upload-Metadata header might set the "filename" and
+//     "filetype" as base64 encoded values.
+//   - HEAD /uploads/{upload} reports the received bytes as Upload-Offset.
+//   - PATCH /uploads/{upload} appends the body at Upload-Offset. After the
+//     last chunk, the new Item's URL is returned as Content-Location.
+func (h *storeHandler) handleResumable(w http.ResponseWriter, r *http.Request, uploadId string, nested bool) {
+	switch {
+	case !nested && r.Method == http.MethodPost:
+		h.handleCreateUpload(w, r)
+
+	case nested && uploadId != "" && !strings.Contains(uploadId, "/") && r.Method == http.MethodHead:
+		h.handleUploadOffset(w, r, uploadId)
+
+	case nested && uploadId != "" && !strings.Contains(uploadId, "/") && r.Method == http.MethodPatch:
+		h.handleUploadChunk(w, r, uploadId)
+
+	case !nested || (uploadId != "" && !strings.Contains(uploadId, "/")):
+		h.httpError(w, r, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+
+	default:
+		h.httpError(w, r, msgNotExists, http.StatusNotFound)
+	}
+}
+
+// parseUploadMetadata parses an Upload-Metadata header value of comma
+// separated keys, each optionally followed by a base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode Upload-Metadata of %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func (h *storeHandler) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.allowUpload(w, r) {
+		return
+	}
+	ownerKey, ok := h.uploadKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		h.httpError(w, r, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	} else if h.maxUploadBytes > 0 && length > h.maxUploadBytes {
+		h.handleError(w, r, ErrFileTooBig)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filename := query.Get("filename")
+	if filename == "" {
+		filename = metadata["filename"]
+	}
+
+	item := Item{
+		BurnAfterReading: query.Get(formBurnAfterReading) == "1",
+		Filename:         filenamePattern.ReplaceAllString(filename, "_"),
+		ContentType:      metadata["filetype"],
+		Created:          h.store.clock.Now().UTC(),
+	}
+
+	lifetime, err := h.uploadLifetime(r, query, item.Created)
+	if err != nil && ClassifyError(err) != CodeUnknown {
+		h.handleError(w, r, err)
+		return
+	} else if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.Expires = item.Created.Add(lifetime)
+
+	item.DeletionKey, err = newDeletionKey()
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	item.Owner, err = NewOwnerTypes(r)
+	if err != nil {
+		h.httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item.OwnerKey = ownerKey
+
+	upload, err := h.store.CreateUpload(item, length)
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	location := h.itemURL(r, uploadsPath+"/"+upload.ID)
+	w.Header().Set("Location", location)
+	w.Header().Set("X-Deletion-Key", item.DeletionKey)
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, location)
+}
+
+func (h *storeHandler) handleUploadOffset(w http.ResponseWriter, r *http.Request, uploadId string) {
+	upload, err := h.store.GetUpload(uploadId)
+	if err != nil {
+		h.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *storeHandler) handleUploadChunk(w http.ResponseWriter, r *http.Request, uploadId string) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/offset+octet-stream" {
+		h.httpError(w, r, "chunks must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		h.httpError(w, r, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	upload, itemId, err := h.store.AppendUpload(r.Context(), uploadId, offset, r.Body)
+	if upload.ID != "" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		h.handleUploadError(w, r, err, 0)
+		return
+	}
+
+	if itemId != "" {
+		w.Header().Set("Content-Location", h.itemURL(r, itemId))
+		w.Header().Set("X-Deletion-Key", upload.Item.DeletionKey)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStoreHandlerResumable(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithBaseURL("https://example.org"))
+
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads?time=1h", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(data)))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("digits.txt"))+
+		",filetype "+base64.StdEncoding.EncodeToString([]byte("text/plain")))
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Creating upload responded %d", resp.StatusCode)
+	}
+
+	location := resp.Header.Get("Location")
+	uploadPath, ok := strings.CutPrefix(location, "https://example.org")
+	if !ok || !strings.HasPrefix(uploadPath, "/uploads/") {
+		t.Fatalf("Upload has location %q", location)
+	}
+
+	offset := func() string {
+		t.Helper()
+
+		resp := serveHandler(h, httptest.NewRequest(http.MethodHead, uploadPath, nil))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Querying offset responded %d", resp.StatusCode)
+		}
+		return resp.Header.Get("Upload-Offset")
+	}
+
+	patch := func(offset int, body io.Reader) *http.Response {
+		req := httptest.NewRequest(http.MethodPatch, uploadPath, body)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		return serveHandler(h, req)
+	}
+
+	if o := offset(); o != "0" {
+		t.Fatalf("New upload has offset %s", o)
+	}
+
+	// The connection breaks after 300 bytes.
+	if resp := patch(0, io.MultiReader(bytes.NewReader(data[:300]), failingReader{io.ErrUnexpectedEOF})); resp.StatusCode == http.StatusNoContent {
+		t.Fatal("Interrupted chunk succeeded")
+	}
+	if o := offset(); o != "300" {
+		t.Fatalf("Interrupted upload has offset %s, expected 300", o)
+	}
+
+	if resp := patch(0, bytes.NewReader(data)); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Chunk with wrong offset responded %d", resp.StatusCode)
+	}
+
+	resp = patch(300, bytes.NewReader(data[300:]))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Resumed chunk responded %d", resp.StatusCode)
+	} else if o := resp.Header.Get("Upload-Offset"); o != strconv.Itoa(len(data)) {
+		t.Fatalf("Completed upload has offset %s", o)
+	}
+
+	itemId, ok := strings.CutPrefix(resp.Header.Get("Content-Location"), "https://example.org/")
+	if !ok {
+		t.Fatalf("Completed upload has Content-Location %q", resp.Header.Get("Content-Location"))
+	}
+
+	resp = serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+itemId, nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download responded %d", resp.StatusCode)
+	} else if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, data) {
+		t.Fatal("Downloaded data differs")
+	} else if v := resp.Header.Get("Content-Disposition"); v != `inline; filename="digits.txt"` {
+		t.Fatalf("Download has Content-Disposition %q", v)
+	} else if v := resp.Header.Get("Content-Type"); v != "text/plain" {
+		t.Fatalf("Download has Content-Type %q", v)
+	}
+
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodHead, uploadPath, nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Completed upload responded %d", resp.StatusCode)
+	}
+
+	for _, test := range []struct {
+		name     string
+		req      func() *http.Request
+		expected int
+	}{
+		{"missing-length", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/uploads", nil)
+		}, http.StatusBadRequest},
+		{"invalid-metadata", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+			req.Header.Set("Upload-Length", "10")
+			req.Header.Set("Upload-Metadata", "filename !!!")
+			return req
+		}, http.StatusBadRequest},
+		{"unknown-upload", func() *http.Request {
+			return httptest.NewRequest(http.MethodHead, "/uploads/nope", nil)
+		}, http.StatusNotFound},
+		{"wrong-content-type", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPatch, uploadPath, nil)
+			req.Header.Set("Upload-Offset", "0")
+			return req
+		}, http.StatusUnsupportedMediaType},
+		{"unsupported-method", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/uploads", nil)
+		}, http.StatusMethodNotAllowed},
+	} {
+		if resp := serveHandler(h, test.req()); resp.StatusCode != test.expected {
+			t.Fatalf("%s responded %d, expected %d", test.name, resp.StatusCode, test.expected)
+		}
+	}
+}
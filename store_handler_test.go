@@ -0,0 +1,1356 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newHandlerTestStore creates a Store for testing its Handler.
+func newHandlerTestStore(t *testing.T) *Store {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(storageDir) })
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+// serveHandler sends a request to the handler and returns its response.
+func serveHandler(h http.Handler, r *http.Request) *http.Response {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// uploadedID extracts the ID from the URL of an upload's plain text response.
+func uploadedID(resp *http.Response) string {
+	body, _ := io.ReadAll(resp.Body)
+	return path.Base(strings.TrimSpace(string(body)))
+}
+
+// failingReader fails each Read with its err.
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestStoreHandler(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	data := []byte("hello world")
+
+	req := httptest.NewRequest(http.MethodPost, "/?filename=hello.txt&time=1h", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "text/plain")
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload responded %d", resp.StatusCode)
+	}
+
+	id := uploadedID(resp)
+	delKey := resp.Header.Get("X-Deletion-Key")
+	if id == "" || delKey == "" {
+		t.Fatalf("Upload responded ID %q and deletion key %q", id, delKey)
+	}
+
+	resp = serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download responded %d", resp.StatusCode)
+	}
+	if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, data) {
+		t.Fatalf("Download data mismatch: %q != %q", body, data)
+	}
+	for header, value := range map[string]string{
+		"Content-Type":        "text/plain",
+		"Content-Disposition": `inline; filename="hello.txt"`,
+		"Content-Length":      "11",
+	} {
+		if v := resp.Header.Get(header); v != value {
+			t.Fatalf("Download header %s is %q, expected %q", header, v, value)
+		}
+	}
+
+	for _, auth := range []string{"", "Bearer nope", delKey} {
+		req := httptest.NewRequest(http.MethodDelete, "/"+id, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		if resp := serveHandler(h, req); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Deletion with Authorization %q responded %d", auth, resp.StatusCode)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+delKey)
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Deletion responded %d", resp.StatusCode)
+	}
+
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id, nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Download of deleted Item responded %d", resp.StatusCode)
+	}
+}
+
+func TestStoreHandlerStatus(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	protectedId, err := store.PutWithPassword(
+		Item{Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("secret")),
+		"hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diskFull := failingReader{&fs.PathError{Op: "write", Path: "/data/foo", Err: syscall.ENOSPC}}
+
+	tests := []struct {
+		name     string
+		req      func() *http.Request
+		expected int
+	}{
+		{"not-found", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/nope", nil)
+		}, http.StatusNotFound},
+		{"delete-not-found", func() *http.Request {
+			return httptest.NewRequest(http.MethodDelete, "/nope", nil)
+		}, http.StatusNotFound},
+		{"nested-path", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/foo/bar", nil)
+		}, http.StatusNotFound},
+		{"unsupported-method", func() *http.Request {
+			return httptest.NewRequest(http.MethodPut, "/", nil)
+		}, http.StatusMethodNotAllowed},
+		{"invalid-lifetime", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/?time=soon", bytes.NewBufferString("foo"))
+		}, http.StatusBadRequest},
+		{"disk-full", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/", diskFull)
+		}, http.StatusInsufficientStorage},
+		{"password-missing", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/"+protectedId, nil)
+		}, http.StatusUnauthorized},
+		{"password", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/"+protectedId, nil)
+			req.SetBasicAuth("", "hunter2")
+			return req
+		}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if resp := serveHandler(h, test.req()); resp.StatusCode != test.expected {
+				t.Fatalf("Request responded %d, expected %d", resp.StatusCode, test.expected)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerRange(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	id, err := store.Put(
+		Item{Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		ifRange      string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"no-range", "", "", http.StatusOK, "", "hello world"},
+		{"range", "bytes=0-4", "", http.StatusPartialContent, "bytes 0-4/11", "hello"},
+		{"open-range", "bytes=6-", "", http.StatusPartialContent, "bytes 6-10/11", "world"},
+		{"suffix-range", "bytes=-3", "", http.StatusPartialContent, "bytes 8-10/11", "rld"},
+		{"exceeding-range", "bytes=6-100", "", http.StatusPartialContent, "bytes 6-10/11", "world"},
+		{"unsatisfiable-range", "bytes=11-", "", http.StatusRequestedRangeNotSatisfiable, "bytes */11", ""},
+		{"multiple-ranges", "bytes=0-1,3-4", "", http.StatusOK, "", "hello world"},
+		{"if-range", "bytes=0-4", `"` + item.Checksum + `"`, http.StatusPartialContent, "bytes 0-4/11", "hello"},
+		{"if-range-weak", "bytes=0-4", `W/"` + item.Checksum + `"`, http.StatusPartialContent, "bytes 0-4/11", "hello"},
+		{"if-range-mismatch", "bytes=0-4", `"nope"`, http.StatusOK, "", "hello world"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+			if test.rangeHeader != "" {
+				req.Header.Set("Range", test.rangeHeader)
+			}
+			if test.ifRange != "" {
+				req.Header.Set("If-Range", test.ifRange)
+			}
+
+			resp := serveHandler(h, req)
+			if resp.StatusCode != test.status {
+				t.Fatalf("Request responded %d, expected %d", resp.StatusCode, test.status)
+			}
+			if v := resp.Header.Get("Content-Range"); v != test.contentRange {
+				t.Fatalf("Content-Range is %q, expected %q", v, test.contentRange)
+			}
+			if test.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if body, _ := io.ReadAll(resp.Body); string(body) != test.body {
+				t.Fatalf("Body is %q, expected %q", body, test.body)
+			}
+		})
+	}
+
+	item, err = store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 3 {
+		t.Fatalf("Item has %d downloads, expected 3 full ones", item.Downloads)
+	}
+}
+
+func TestStoreHandlerETag(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	id, err := store.Put(
+		Item{Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag := `"` + item.Checksum + `"`
+	resp := serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download responded %d", resp.StatusCode)
+	} else if v := resp.Header.Get("ETag"); v != etag {
+		t.Fatalf("ETag is %q, expected %q", v, etag)
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		expected    int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"nope", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"nope"`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		req.Header.Set("If-None-Match", test.ifNoneMatch)
+
+		resp := serveHandler(h, req)
+		if resp.StatusCode != test.expected {
+			t.Fatalf("If-None-Match %q responded %d, expected %d", test.ifNoneMatch, resp.StatusCode, test.expected)
+		} else if v := resp.Header.Get("ETag"); v != etag {
+			t.Fatalf("If-None-Match %q has ETag %q", test.ifNoneMatch, v)
+		}
+		if body, _ := io.ReadAll(resp.Body); test.expected == http.StatusNotModified && len(body) > 0 {
+			t.Fatalf("If-None-Match %q has a body %q", test.ifNoneMatch, body)
+		}
+	}
+
+	// Items without a Checksum, e.g., from before checksums, have no ETag.
+	err = store.update(id, func(i *Item) error {
+		i.Checksum = ""
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.Header.Set("If-None-Match", "*")
+	resp = serveHandler(h, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download without Checksum responded %d", resp.StatusCode)
+	} else if v := resp.Header.Get("ETag"); v != "" {
+		t.Fatalf("Download without Checksum has ETag %q", v)
+	}
+}
+
+func TestStoreHandlerRateLimit(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	limiter, err := NewTokenBucketLimiter(0.1, 3, store.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(h http.Handler, remoteAddr, xff string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("foo"))
+		req.RemoteAddr = remoteAddr
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		return serveHandler(h, req)
+	}
+
+	h := store.Handler(WithRateLimiter(limiter))
+	for i := 0; i < 3; i++ {
+		if resp := upload(h, "192.0.2.1:1234", ""); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Upload %d within the burst responded %d", i, resp.StatusCode)
+		}
+	}
+
+	resp := upload(h, "192.0.2.1:4321", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Upload after the burst responded %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "10" {
+		t.Fatalf("Retry-After is %q, expected 10", v)
+	}
+
+	// Without a trusted proxy, X-Forwarded-For is ignored.
+	if resp := upload(h, "192.0.2.1:1234", "198.51.100.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Upload with forged X-Forwarded-For responded %d", resp.StatusCode)
+	}
+	if resp := upload(h, "192.0.2.2:1234", ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload from another IP responded %d", resp.StatusCode)
+	}
+
+	h = store.Handler(WithRateLimiter(limiter), WithTrustedProxy(true))
+	for i := 0; i < 3; i++ {
+		if resp := upload(h, "192.0.2.1:1234", "198.51.100.1"); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Upload %d behind the proxy responded %d", i, resp.StatusCode)
+		}
+	}
+	if resp := upload(h, "192.0.2.3:1234", "198.51.100.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Upload behind the proxy after the burst responded %d", resp.StatusCode)
+	}
+	if resp := upload(h, "192.0.2.1:1234", "nope"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Upload with an invalid X-Forwarded-For responded %d", resp.StatusCode)
+	}
+}
+
+func TestStoreHandlerMaxUploadBytes(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithMaxUploadBytes(8))
+
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("12345678"))); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload within the limit responded %d", resp.StatusCode)
+	}
+
+	// The announced Content-Length is rejected before reading.
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("123456789"))); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Oversized upload responded %d", resp.StatusCode)
+	}
+
+	// A streamed body without a Content-Length is aborted while reading.
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, 4096)))
+	req.ContentLength = -1
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Oversized streamed upload responded %d", resp.StatusCode)
+	}
+
+	if ids, err := store.ListIDs(0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 1 {
+		t.Fatalf("Store has %d Items, expected 1", len(ids))
+	}
+	if entries, err := os.ReadDir(store.storageDir()); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Fatalf("Store has %d files, expected 1", len(entries))
+	}
+}
+
+func TestStoreHandlerJSONErrors(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithMaxUploadBytes(8))
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"not-found", httptest.NewRequest(http.MethodGet, "/missing", nil), http.StatusNotFound, "not_found"},
+		{"too-big", httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("123456789")), http.StatusRequestEntityTooLarge, "file_too_big"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.req.Header.Set("Accept", "application/json")
+			resp := serveHandler(h, test.req)
+			if resp.StatusCode != test.status {
+				t.Fatalf("Request responded %d, expected %d", resp.StatusCode, test.status)
+			} else if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+				t.Fatalf("Error responded Content-Type %q", contentType)
+			}
+
+			var body map[string]string
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			expected := map[string]string{"error": http.StatusText(test.status), "code": test.code}
+			if len(body) != len(expected) || body["error"] != expected["error"] || body["code"] != expected["code"] {
+				t.Fatalf("Error responded %v, expected %v", body, expected)
+			}
+		})
+	}
+
+	// CLI clients, e.g., curl sending "*/*", still get plain text.
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("Accept", "*/*")
+	resp := serveHandler(h, req)
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("Error for a CLI client responded Content-Type %q", contentType)
+	} else if resp.Header.Get(errorCodeHeader) != "not_found" {
+		t.Fatalf("Error for a CLI client responded %s %q", errorCodeHeader, resp.Header.Get(errorCodeHeader))
+	}
+
+	// Errors outside the Store's sentinels are coded by their status.
+	req = httptest.NewRequest(http.MethodPatch, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	var body ErrorResponse
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("PATCH responded %d", resp.StatusCode)
+	} else if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	} else if body.Code != "method_not_allowed" {
+		t.Fatalf("PATCH responded code %q", body.Code)
+	}
+}
+
+func TestStoreHandlerMultipart(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	upload := func(fields map[string]string, filename, contentType string, data []byte) *http.Response {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for key, value := range fields {
+			if err := mw.WriteField(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if filename != "" {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+			if contentType != "" {
+				header.Set("Content-Type", contentType)
+			}
+			part, err := mw.CreatePart(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = part.Write(data)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return serveHandler(h, req)
+	}
+
+	getItem := func(resp *http.Response) Item {
+		t.Helper()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Upload responded %d", resp.StatusCode)
+		}
+		item, f, err := store.GetWithFile(uploadedID(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if data, _ := io.ReadAll(f); string(data) != "hello world" {
+			t.Fatalf("Uploaded data is %q", data)
+		}
+		return item
+	}
+
+	item := getItem(upload(map[string]string{"time": "1h", "burn": "1"}, "../hello world.txt", "text/plain", []byte("hello world")))
+	if item.Filename != "hello_world.txt" {
+		t.Fatalf("Filename is %q", item.Filename)
+	} else if item.ContentType != "text/plain" {
+		t.Fatalf("Content type is %q", item.ContentType)
+	} else if !item.BurnAfterReading {
+		t.Fatal("Form field burn was ignored")
+	} else if lifetime := item.Expires.Sub(item.Created); lifetime != time.Hour {
+		t.Fatalf("Lifetime is %v, expected 1h", lifetime)
+	}
+
+	// Without a part's content type, it is sniffed.
+	item = getItem(upload(nil, "hello.txt", "", []byte("hello world")))
+	if item.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("Sniffed content type is %q", item.ContentType)
+	}
+
+	if resp := upload(map[string]string{"time": "1h"}, "", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Upload without file part responded %d", resp.StatusCode)
+	}
+	if resp := upload(map[string]string{"time": strings.Repeat("1", 2*handlerMaxFieldSize)}, "a.txt", "", []byte("a")); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Upload with oversized field responded %d", resp.StatusCode)
+	}
+}
+
+func TestStoreHandlerExpiry(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithDefaultLifetime(2*time.Hour), WithLifetimeBounds(time.Minute, 7*24*time.Hour))
+
+	timestamp := time.Now().Add(48 * time.Hour).Truncate(time.Second).UTC()
+
+	tests := []struct {
+		name     string
+		header   string
+		query    string
+		status   int
+		lifetime time.Duration
+		expires  time.Time
+	}{
+		{"missing", "", "", http.StatusCreated, 2 * time.Hour, time.Time{}},
+		{"header-duration", "3h", "", http.StatusCreated, 3 * time.Hour, time.Time{}},
+		{"header-days", "2d", "", http.StatusCreated, 48 * time.Hour, time.Time{}},
+		{"header-timestamp", timestamp.Format(time.RFC3339), "", http.StatusCreated, 0, timestamp},
+		{"query-duration", "", "?expires=30m", http.StatusCreated, 30 * time.Minute, time.Time{}},
+		{"query-timestamp", "", "?expires=" + url.QueryEscape(timestamp.Format(time.RFC3339)), http.StatusCreated, 0, timestamp},
+		{"header-precedence", "1h", "?expires=30m&time=10m", http.StatusCreated, time.Hour, time.Time{}},
+		{"time", "", "?time=10m", http.StatusCreated, 10 * time.Minute, time.Time{}},
+		{"invalid", "soon", "", http.StatusBadRequest, 0, time.Time{}},
+		{"invalid-query", "", "?expires=tomorrow", http.StatusBadRequest, 0, time.Time{}},
+		{"past", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), "", http.StatusNotAcceptable, 0, time.Time{}},
+		{"too-short", "30s", "", http.StatusNotAcceptable, 0, time.Time{}},
+		{"too-long", "2w", "", http.StatusNotAcceptable, 0, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/"+test.query, bytes.NewBufferString("foo"))
+			if test.header != "" {
+				req.Header.Set("X-Expires-In", test.header)
+			}
+
+			resp := serveHandler(h, req)
+			if resp.StatusCode != test.status {
+				t.Fatalf("Upload responded %d, expected %d", resp.StatusCode, test.status)
+			} else if test.status != http.StatusCreated {
+				return
+			}
+
+			item, err := store.Get(uploadedID(resp))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.expires.IsZero() && !item.Expires.Equal(test.expires) {
+				t.Fatalf("Item expires %v, expected %v", item.Expires, test.expires)
+			} else if test.expires.IsZero() && item.Expires.Sub(item.Created) != test.lifetime {
+				t.Fatalf("Item's lifetime is %v, expected %v", item.Expires.Sub(item.Created), test.lifetime)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerUploadResult(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	upload := func(h http.Handler, accept string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "http://gosh.example/?time=1h", bytes.NewBufferString("foo"))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		resp := serveHandler(h, req)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Upload with Accept %q responded %d", accept, resp.StatusCode)
+		}
+		return resp
+	}
+
+	h := store.Handler(WithBaseURL("https://example.org/gosh/"))
+
+	for _, accept := range []string{"", "*/*", "text/plain", "text/*;q=0.5, application/json;q=0.1"} {
+		resp := upload(h, accept)
+		if v := resp.Header.Get("Content-Type"); v != "text/plain; charset=utf-8" {
+			t.Fatalf("Accept %q resulted in Content-Type %q", accept, v)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		id, ok := strings.CutPrefix(strings.TrimSpace(string(body)), "https://example.org/gosh/")
+		if !ok {
+			t.Fatalf("Accept %q resulted in %q", accept, body)
+		} else if _, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := upload(h, "application/json")
+	if v := resp.Header.Get("Content-Type"); v != "application/json" {
+		t.Fatalf("JSON has Content-Type %q", v)
+	}
+	var result UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.Get(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if result.URL != "https://example.org/gosh/"+item.ID {
+		t.Fatalf("JSON has URL %q", result.URL)
+	} else if result.DeletionKey != item.DeletionKey || result.DeletionKey != resp.Header.Get("X-Deletion-Key") {
+		t.Fatalf("JSON has deletion key %q", result.DeletionKey)
+	} else if !result.Expires.Equal(item.Expires) {
+		t.Fatalf("JSON expires %v, expected %v", result.Expires, item.Expires)
+	}
+
+	resp = upload(h, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	if v := resp.Header.Get("Content-Type"); v != "text/html; charset=utf-8" {
+		t.Fatalf("HTML has Content-Type %q", v)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, part := range []string{"<!DOCTYPE html>", `<a href="https://example.org/gosh/`, resp.Header.Get("X-Deletion-Key")} {
+		if !bytes.Contains(body, []byte(part)) {
+			t.Fatalf("HTML misses %q: %s", part, body)
+		}
+	}
+
+	// Without a base URL, it is derived from the request.
+	resp = upload(store.Handler(), "")
+	if body, _ := io.ReadAll(resp.Body); !strings.HasPrefix(string(body), "http://gosh.example/") {
+		t.Fatalf("Upload without base URL resulted in %q", body)
+	}
+}
+
+func TestStoreHandlerBaseURL(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	tests := []struct {
+		name   string
+		h      http.Handler
+		target string
+		prefix string
+	}{
+		{"base", store.Handler(WithBaseURL("https://example.org")), "/", "https://example.org/"},
+		{"base-trailing-slashes", store.Handler(WithBaseURL("https://example.org/gosh//")), "/", "https://example.org/gosh/"},
+		{"base-subpath", http.StripPrefix("/gosh", store.Handler(WithBaseURL("https://example.org/gosh/"))), "/gosh/", "https://example.org/gosh/"},
+		{"request", store.Handler(), "http://gosh.example/", "http://gosh.example/"},
+		{"request-subpath", http.StripPrefix("/gosh", store.Handler()), "http://gosh.example/gosh/", "http://gosh.example/gosh/"},
+		{"request-nested-subpath", http.StripPrefix("/a/b", store.Handler()), "http://gosh.example/a/b/?time=1h", "http://gosh.example/a/b/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := serveHandler(test.h, httptest.NewRequest(http.MethodPost, test.target, bytes.NewBufferString("foo")))
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("Upload responded %d", resp.StatusCode)
+			}
+
+			body, _ := io.ReadAll(resp.Body)
+			id, ok := strings.CutPrefix(strings.TrimSpace(string(body)), test.prefix)
+			if !ok || strings.Contains(id, "/") {
+				t.Fatalf("Upload URL %q is not below %q", body, test.prefix)
+			}
+
+			downloadTarget := strings.TrimSuffix(strings.SplitN(test.target, "?", 2)[0], "/") + "/" + id
+			if resp := serveHandler(test.h, httptest.NewRequest(http.MethodGet, downloadTarget, nil)); resp.StatusCode != http.StatusOK {
+				t.Fatalf("Download of %q responded %d", downloadTarget, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerDeleteToken(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	id, err := store.Put(
+		Item{Expires: time.Now().Add(time.Minute).UTC(), DeletionKey: "s3cr3t", Filename: "foo.txt"},
+		newDummyReadCloser(bytes.NewBufferString("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postDelete := func(id, token string) *http.Response {
+		form := url.Values{"token": {token}}
+		req := httptest.NewRequest(http.MethodPost, "/"+id+"/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serveHandler(h, req)
+	}
+
+	// The confirmation page does not delete the Item.
+	resp := serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id+"/s3cr3t", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Confirmation responded %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, part := range []string{`action="delete"`, `value="s3cr3t"`, "foo.txt"} {
+		if !bytes.Contains(body, []byte(part)) {
+			t.Fatalf("Confirmation misses %q: %s", part, body)
+		}
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Fatalf("Item is gone after confirmation: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		resp     *http.Response
+		expected int
+	}{
+		{"confirmation-wrong-token", serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id+"/nope", nil)), http.StatusForbidden},
+		{"confirmation-missing", serveHandler(h, httptest.NewRequest(http.MethodGet, "/nope/s3cr3t", nil)), http.StatusNotFound},
+		{"confirmation-nested", serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id+"/s3cr3t/foo", nil)), http.StatusNotFound},
+		{"delete-wrong-token", postDelete(id, "nope"), http.StatusForbidden},
+		{"delete-empty-token", postDelete(id, ""), http.StatusForbidden},
+		{"delete-missing", postDelete("nope", "s3cr3t"), http.StatusNotFound},
+	} {
+		if test.resp.StatusCode != test.expected {
+			t.Fatalf("%s responded %d, expected %d", test.name, test.resp.StatusCode, test.expected)
+		}
+	}
+
+	if resp := postDelete(id, "s3cr3t"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Deletion responded %d", resp.StatusCode)
+	} else if _, err := store.Get(id); err != ErrNotFound {
+		t.Fatalf("Deleted Item resulted in %v", err)
+	}
+}
+
+func TestStoreHandlerAccessLog(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	var logs bytes.Buffer
+	clock := newManualClock(time.Now())
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	h := store.Handler()
+
+	id, err := store.Put(
+		Item{Expires: clock.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastAccessLog := func() map[string]any {
+		t.Helper()
+
+		var entry map[string]any
+		for _, line := range bytes.Split(logs.Bytes(), []byte("\n")) {
+			var e map[string]any
+			if json.Unmarshal(line, &e) == nil && e["msg"] == "Served Item" {
+				entry = e
+			}
+		}
+		if entry == nil {
+			t.Fatal("No access log was written")
+		}
+		logs.Reset()
+		return entry
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		rangeValue string
+		status     float64
+		bytes      float64
+		download   string
+	}{
+		{"complete", id, "", http.StatusOK, 11, "complete"},
+		{"range", id, "bytes=2-5", http.StatusPartialContent, 4, "partial"},
+		{"suffix-range", id, "bytes=-3", http.StatusPartialContent, 3, "partial"},
+		{"not-found", "nope", "", http.StatusNotFound, float64(len("Not Found\n")), "none"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+test.id, nil)
+			if test.rangeValue != "" {
+				req.Header.Set("Range", test.rangeValue)
+			}
+
+			resp := serveHandler(h, req)
+			body, _ := io.ReadAll(resp.Body)
+
+			entry := lastAccessLog()
+			if entry["id"] != test.id || entry["status"] != test.status || entry["download"] != test.download {
+				t.Fatalf("Access log is %v", entry)
+			} else if entry["bytes"] != test.bytes {
+				t.Fatalf("Access log counts %v bytes, expected %v", entry["bytes"], test.bytes)
+			} else if float64(len(body)) != test.bytes {
+				t.Fatalf("Response has %d bytes, logged %v", len(body), entry["bytes"])
+			} else if entry["duration"] != float64(0) {
+				t.Fatalf("Access log has duration %v for a stopped clock", entry["duration"])
+			}
+		})
+	}
+
+	// The duration is measured by the Store's clock, here advanced while
+	// writing the response.
+	w := &advancingResponseWriter{ResponseRecorder: httptest.NewRecorder(), clock: clock, step: 3 * time.Second}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if entry := lastAccessLog(); entry["duration"] != float64(3*time.Second) {
+		t.Fatalf("Access log has duration %v, expected %v", entry["duration"], float64(3*time.Second))
+	}
+}
+
+// advancingResponseWriter advances its clock by step for each Write.
+type advancingResponseWriter struct {
+	*httptest.ResponseRecorder
+	clock *manualClock
+	step  time.Duration
+}
+
+func (w *advancingResponseWriter) Write(p []byte) (int, error) {
+	w.clock.Advance(w.step)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestStoreHandlerThumbnail(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithThumbnails(32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	h := store.Handler()
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(newTestPNG(t, 128, 96)))
+	req.Header.Set("Content-Type", "image/png")
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload responded %d", resp.StatusCode)
+	}
+	imageId := uploadedID(resp)
+
+	resp = serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+imageId+"/thumb", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Thumbnail responded %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Content-Type"); v != "image/png" {
+		t.Fatalf("Thumbnail has content type %q", v)
+	}
+
+	config, _, err := image.DecodeConfig(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	} else if config.Width != 32 || config.Height != 24 {
+		t.Fatalf("Thumbnail is %dx%d, expected 32x24", config.Width, config.Height)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp = serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload responded %d", resp.StatusCode)
+	}
+	textId := uploadedID(resp)
+
+	for _, id := range []string{textId, "nope"} {
+		resp = serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id+"/thumb", nil))
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Thumbnail of %q responded %d, expected 404", id, resp.StatusCode)
+		}
+	}
+}
+
+func TestStoreHandlerDisposition(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	tests := []struct {
+		name     string
+		item     Item
+		query    string
+		expected string
+	}{
+		{"text", Item{Filename: "hello.txt", ContentType: "text/plain; charset=utf-8"}, "", `inline; filename="hello.txt"`},
+		{"image", Item{Filename: "cat.png", ContentType: "image/png"}, "", `inline; filename="cat.png"`},
+		{"html", Item{Filename: "page.html", ContentType: "text/html"}, "", `attachment; filename="page.html"`},
+		{"svg", Item{Filename: "logo.svg", ContentType: "image/svg+xml"}, "", `attachment; filename="logo.svg"`},
+		{"binary", Item{Filename: "blob.bin", ContentType: "application/octet-stream"}, "", `attachment; filename="blob.bin"`},
+		{"forced-download", Item{Filename: "hello.txt", ContentType: "text/plain"}, "?download=1", `attachment; filename="hello.txt"`},
+		{"forced-inline", Item{Filename: "doc.pdf", ContentType: "application/pdf", Disposition: DispositionInline}, "", `inline; filename="doc.pdf"`},
+		{"forced-inline-html", Item{Filename: "page.html", ContentType: "text/html", Disposition: DispositionInline}, "", `attachment; filename="page.html"`},
+		{"forced-attachment", Item{Filename: "cat.png", ContentType: "image/png", Disposition: DispositionAttachment}, "", `attachment; filename="cat.png"`},
+		{"utf-8", Item{Filename: "grüße €.txt", ContentType: "text/plain"}, "",
+			`inline; filename="gr__e _.txt"; filename*=UTF-8''gr%C3%BC%C3%9Fe%20%E2%82%AC.txt`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.item.Expires = time.Now().Add(time.Minute).UTC()
+			id, err := store.Put(test.item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id+test.query, nil))
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Download responded %d", resp.StatusCode)
+			} else if v := resp.Header.Get("Content-Disposition"); v != test.expected {
+				t.Fatalf("Content-Disposition is %q, expected %q", v, test.expected)
+			}
+
+			if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err != nil {
+				t.Fatal(err)
+			} else if params["filename"] != test.item.Filename {
+				t.Fatalf("Content-Disposition's filename is %q, expected %q", params["filename"], test.item.Filename)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerPutSlug(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithBaseURL("https://example.org"))
+
+	putSlug := func(slug, body, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodPut, "/"+slug, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serveHandler(h, req)
+	}
+	download := func(key string) string {
+		resp := serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+key, nil))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Download of %q responded %d", key, resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	resp := putSlug("my-report", "first", "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Creating PUT responded %d", resp.StatusCode)
+	}
+	delKey := resp.Header.Get("X-Deletion-Key")
+	if body, _ := io.ReadAll(resp.Body); string(body) != "https://example.org/my-report\n" {
+		t.Fatalf("Creating PUT responded %q", body)
+	}
+	if body := download("my-report"); body != "first" {
+		t.Fatalf("Download of the slug is %q", body)
+	}
+	firstItem, err := store.GetBySlug("my-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the DeletionKey, the Slug is taken.
+	for _, token := range []string{"", "nope"} {
+		if resp := putSlug("my-report", "stolen", token); resp.StatusCode != http.StatusConflict {
+			t.Fatalf("Conflicting PUT with token %q responded %d", token, resp.StatusCode)
+		}
+	}
+	if body := download("my-report"); body != "first" {
+		t.Fatalf("Download after a conflicting PUT is %q", body)
+	}
+
+	// Repeating the request with the DeletionKey replaces the Item.
+	for _, body := range []string{"second", "second"} {
+		resp := putSlug("my-report", body, delKey)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Replacing PUT responded %d", resp.StatusCode)
+		} else if v := resp.Header.Get("X-Deletion-Key"); v != delKey {
+			t.Fatalf("Replacing PUT changed the deletion key to %q", v)
+		}
+	}
+	if body := download("my-report"); body != "second" {
+		t.Fatalf("Download after replacing PUT is %q", body)
+	}
+	if _, err := store.Get(firstItem.ID); err != ErrNotFound {
+		t.Fatalf("Replaced Item resulted in %v", err)
+	}
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Store has %d Items after replacing, expected 1", n)
+	}
+
+	// Neither existing IDs nor invalid characters can be used.
+	if resp := putSlug(firstItem.ID, "nope", ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT of an unused former ID responded %d", resp.StatusCode)
+	}
+	if resp := putSlug("my%20report", "nope", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("PUT of an invalid slug responded %d", resp.StatusCode)
+	}
+
+	replacedItem, err := store.GetBySlug("my-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := putSlug(replacedItem.ID, "nope", ""); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("PUT of an existing ID responded %d", resp.StatusCode)
+	}
+
+	// The Slug also allows deleting the Item.
+	req := httptest.NewRequest(http.MethodDelete, "/my-report", nil)
+	req.Header.Set("Authorization", "Bearer "+delKey)
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Deletion by slug responded %d", resp.StatusCode)
+	}
+	if _, err := store.GetBySlug("my-report"); err != ErrNotFound {
+		t.Fatalf("Deleted slug resulted in %v", err)
+	}
+}
+
+func TestStoreHandlerPutReservedSlug(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithAdmin("", ""), WithHealth("live", "ready"))
+
+	// The admin path is reserved while the admin API is disabled.
+	req := httptest.NewRequest(http.MethodPut, "/admin", strings.NewReader("nope"))
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("PUT of the admin path responded %d", resp.StatusCode)
+	}
+	if _, err := store.GetBySlug("admin"); err != ErrNotFound {
+		t.Fatalf("Reserved slug resulted in %v", err)
+	}
+
+	// Other reserved paths are routed before uploads.
+	sh := &storeHandler{store: store, adminPath: "admin", livenessPath: "live", readinessPath: "ready"}
+	for _, slug := range []string{uploadsPath, "admin", "live", "ready"} {
+		if _, err := sh.slugReplacement(req, slug, ""); err != ErrInvalidSlug {
+			t.Fatalf("Reserved slug %q resulted in %v", slug, err)
+		}
+	}
+	for _, slug := range []string{"uploads2", "thumb", "healthz"} {
+		if _, err := sh.slugReplacement(req, slug, ""); err != nil {
+			t.Fatalf("Unreserved slug %q resulted in %v", slug, err)
+		}
+	}
+}
+
+func TestStoreHandlerContentSecurity(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	id, err := store.Put(
+		Item{
+			Filename:    "page.html",
+			ContentType: "text/html; charset=utf-8",
+			Disposition: DispositionInline,
+			Expires:     time.Now().Add(time.Minute).UTC(),
+		},
+		newDummyReadCloser(bytes.NewBufferString("<script>alert(1)</script>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		opts        []HandlerOption
+		disposition string
+		policy      string
+	}{
+		{"default", nil, `attachment; filename="page.html"`, defaultContentSecurityPolicy},
+		{"custom-policy", []HandlerOption{WithContentSecurityPolicy("sandbox")}, `attachment; filename="page.html"`, "sandbox"},
+		{"no-policy", []HandlerOption{WithContentSecurityPolicy("")}, `attachment; filename="page.html"`, ""},
+		{"sandbox-domain", []HandlerOption{WithSandboxDomain(true)}, `inline; filename="page.html"`, defaultContentSecurityPolicy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := serveHandler(store.Handler(test.opts...), httptest.NewRequest(http.MethodGet, "/"+id, nil))
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Download responded %d", resp.StatusCode)
+			}
+
+			for header, value := range map[string]string{
+				"Content-Disposition":     test.disposition,
+				"Content-Security-Policy": test.policy,
+				"X-Content-Type-Options":  "nosniff",
+			} {
+				if v := resp.Header.Get(header); v != value {
+					t.Fatalf("Download header %s is %q, expected %q", header, v, value)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreHandlerClientIP(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	_, proxyNet, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, proxyNet6, err := net.ParseCIDR("fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusting := store.Handler(WithTrustedProxies([]net.IPNet{*proxyNet, *proxyNet6})).(*storeHandler)
+	untrusting := store.Handler().(*storeHandler)
+
+	tests := []struct {
+		name       string
+		h          *storeHandler
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{"direct", trusting, "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"without-proxies", untrusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "10.0.0.1"},
+		{"untrusted-peer", trusting, "192.0.2.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+		{"trusted-proxy", trusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "192.0.2.1"},
+		{"proxy-chain", trusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"192.0.2.1, 10.0.0.2"}}, "192.0.2.1"},
+		{"spoofing", trusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 192.0.2.1"}}, "192.0.2.1"},
+		{"spoofing-multiple-headers", trusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"198.51.100.1", "192.0.2.1"}}, "192.0.2.1"},
+		{"only-proxies", trusting, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"no-header", trusting, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"forwarded", trusting, "10.0.0.1:1234",
+			http.Header{"Forwarded": {`for=198.51.100.1, for="[2001:db8::1]:4711";proto=https, For=10.0.0.2`}}, "2001:db8::1"},
+		{"forwarded-precedence", trusting, "[fd00::1]:1234",
+			http.Header{"Forwarded": {"for=192.0.2.1"}, "X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for key, values := range test.header {
+				for _, value := range values {
+					req.Header.Add(key, value)
+				}
+			}
+
+			ip, err := test.h.clientIP(req)
+			if err != nil {
+				t.Fatal(err)
+			} else if ip != test.expected {
+				t.Fatalf("Client IP is %q, expected %q", ip, test.expected)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1, nope")
+	if ip, err := trusting.clientIP(req); err == nil {
+		t.Fatalf("Invalid X-Forwarded-For resulted in %q", ip)
+	}
+}
+
+func TestStoreHandlerGzipPassthrough(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"compressed", []Option{WithCompression(true)}},
+		{"compressed-encrypted", []Option{WithCompression(true), WithEncryptionKey(make([]byte, 32))}},
+		{"plain", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			opts := append([]Option{WithIdGenerator(randomIdGenerator(4)), WithCleanup(false)}, test.opts...)
+			store, err := NewStore(storageDir, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			h := store.Handler()
+
+			data := bytes.Repeat([]byte("hello world\n"), 1024)
+			item := Item{Filename: "hello.txt", ContentType: "text/plain", Expires: time.Now().Add(time.Hour).UTC()}
+			id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed := len(test.opts) > 0
+
+			for _, acceptEncoding := range []string{"gzip, deflate", "br;q=1.0, *;q=0.5", "gzip;q=0, *", "identity", ""} {
+				req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+				if acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", acceptEncoding)
+				}
+				resp := serveHandler(h, req)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("Accept-Encoding %q: download responded %d", acceptEncoding, resp.StatusCode)
+				} else if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+					t.Fatalf("Accept-Encoding %q: Vary is %q", acceptEncoding, vary)
+				}
+
+				passthrough := compressed && acceptsGzip(acceptEncoding)
+				body := io.Reader(resp.Body)
+				if encoding := resp.Header.Get("Content-Encoding"); passthrough && encoding != "gzip" {
+					t.Fatalf("Accept-Encoding %q: Content-Encoding is %q, expected gzip", acceptEncoding, encoding)
+				} else if passthrough {
+					gz, err := gzip.NewReader(resp.Body)
+					if err != nil {
+						t.Fatalf("Accept-Encoding %q: response is no gzip stream: %v", acceptEncoding, err)
+					}
+					body = gz
+				} else if encoding != "" {
+					t.Fatalf("Accept-Encoding %q: unexpected Content-Encoding %q", acceptEncoding, encoding)
+				} else if length := resp.Header.Get("Content-Length"); length != fmt.Sprint(len(data)) {
+					t.Fatalf("Accept-Encoding %q: Content-Length is %q", acceptEncoding, length)
+				}
+
+				content, err := io.ReadAll(body)
+				if err != nil {
+					t.Fatal(err)
+				} else if !bytes.Equal(content, data) {
+					t.Fatalf("Accept-Encoding %q: content differs", acceptEncoding)
+				}
+			}
+
+			// Each complete download is counted, also for passed through streams.
+			if item, err := store.Get(id); err != nil {
+				t.Fatal(err)
+			} else if item.Downloads != 5 {
+				t.Fatalf("Item has %d downloads, expected 5", item.Downloads)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		accepted       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"deflate, gzip;q=0.8", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"br, identity", false},
+		{"gzip;q=nope", false},
+	}
+
+	for _, test := range tests {
+		if accepted := acceptsGzip(test.acceptEncoding); accepted != test.accepted {
+			t.Errorf("acceptsGzip(%q) = %t, expected %t", test.acceptEncoding, accepted, test.accepted)
+		}
+	}
+}
+
+func TestHandlerHead(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler()
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id, err := store.Put(Item{Filename: "hello.txt", ContentType: "text/plain", Expires: expires, BurnAfterReading: true},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.Peek(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := serveHandler(h, httptest.NewRequest(http.MethodHead, "/"+id, nil))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD responded %d", resp.StatusCode)
+	} else if len(body) != 0 {
+		t.Fatalf("HEAD responded with a body of %d bytes", len(body))
+	}
+
+	expectedHeaders := map[string]string{
+		"Content-Length":      "11",
+		"Content-Type":        "text/plain",
+		"Content-Disposition": `inline; filename="hello.txt"`,
+		"ETag":                itemETag(item),
+		"Expires":             expires.Format(http.TimeFormat),
+	}
+	for header, expected := range expectedHeaders {
+		if value := resp.Header.Get(header); value != expected {
+			t.Errorf("HEAD responded %s %q, expected %q", header, value, expected)
+		}
+	}
+
+	// Neither a download was counted nor was the Item burned.
+	if item, err := store.Peek(id); err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 0 {
+		t.Fatalf("HEAD counted %d downloads", item.Downloads)
+	}
+	assertItemContent(t, store, id, []byte("hello world"))
+
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodHead, "/missing", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("HEAD of a missing Item responded %d", resp.StatusCode)
+	}
+}
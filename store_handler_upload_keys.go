@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// UploadKeyValidator checks an upload's API key, set WithUploadKeyValidator.
+// For a valid key, it returns the key's owner, e.g., a client's name, being
+// recorded as the Item's OwnerKey. It must be safe for concurrent use.
+type UploadKeyValidator func(key string) (owner string, ok bool)
+
+// WithUploadKeys restricts uploads to known clients, mapping the names of the
+// keys' owners to their API keys. See WithUploadKeyValidator.
+func WithUploadKeys(keys map[string]string) HandlerOption {
+	if len(keys) == 0 {
+		return WithUploadKeyValidator(nil)
+	}
+
+	keysCopy := make(map[string]string, len(keys))
+	for owner, key := range keys {
+		keysCopy[owner] = key
+	}
+
+	return WithUploadKeyValidator(func(key string) (string, bool) {
+		// All keys are compared to not leak a match by the timing.
+		var match string
+		for owner, known := range keysCopy {
+			if known != "" && subtle.ConstantTimeCompare([]byte(key), []byte(known)) == 1 {
+				match = owner
+			}
+		}
+		return match, match != ""
+	})
+}
+
+// WithUploadKeyValidator requires uploads by POST and PUT, including creating
+// resumable uploads, to be authorized by an API key as a bearer token in the
+// Authorization header. Other requests are responded with 401 Unauthorized.
+// Downloads and the chunks of an already created resumable upload are not
+// affected.
+//
+// As the bearer token is taken by the API key, a Slug used by another Item
+// can only be replaced by an upload of the same key's owner, instead of by
+// the Item's DeletionKey.
+func WithUploadKeyValidator(validator UploadKeyValidator) HandlerOption {
+	return func(h *storeHandler) {
+		h.uploadKeyValidator = validator
+	}
+}
+
+// uploadKeyOwner checks an upload's API key, if required WithUploadKeys, and
+// returns its owner. Otherwise, 401 Unauthorized is responded.
+func (h *storeHandler) uploadKeyOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if h.uploadKeyValidator == nil {
+		return "", true
+	}
+
+	if key := bearerToken(r); key != "" {
+		if owner, ok := h.uploadKeyValidator(key); ok {
+			return owner, true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="gosh"`)
+	h.httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return "", false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStoreHandlerUploadKeys(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithUploadKeys(map[string]string{"alice": "key-a", "bob": "key-b"}))
+
+	upload := func(method, path, key string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader("hello world"))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		return serveHandler(h, req)
+	}
+
+	resp := upload(http.MethodPost, "/", "key-a")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload with a valid key responded %d", resp.StatusCode)
+	}
+	id := uploadedID(resp)
+	if item, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if item.OwnerKey != "alice" {
+		t.Fatalf("Item was uploaded by %q, expected alice", item.OwnerKey)
+	}
+
+	for name, key := range map[string]string{"missing": "", "invalid": "nope", "empty": " "} {
+		for _, method := range []string{http.MethodPost, http.MethodPut} {
+			resp := upload(method, map[string]string{http.MethodPost: "/", http.MethodPut: "/slug"}[method], key)
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("%s with a %s key responded %d", method, name, resp.StatusCode)
+			} else if resp.Header.Get("WWW-Authenticate") == "" {
+				t.Fatalf("%s with a %s key responded no WWW-Authenticate header", method, name)
+			}
+		}
+	}
+	if resp := upload(http.MethodPost, "/"+uploadsPath, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Resumable upload without a key responded %d", resp.StatusCode)
+	}
+
+	// Downloads remain unauthenticated.
+	resp = serveHandler(h, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "hello world" {
+		t.Fatalf("Download without a key responded %d: %q", resp.StatusCode, body)
+	}
+
+	// A Slug can only be replaced by its owner's key.
+	if resp := upload(http.MethodPut, "/slug", "key-a"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload at a new Slug responded %d", resp.StatusCode)
+	}
+	if resp := upload(http.MethodPut, "/slug", "key-b"); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Replacing another owner's Slug responded %d", resp.StatusCode)
+	}
+	if resp := upload(http.MethodPut, "/slug", "key-a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Replacing an own Slug responded %d", resp.StatusCode)
+	}
+
+	if ids, err := store.ListIDs(0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 {
+		t.Fatalf("Store has %d Items, expected 2", len(ids))
+	}
+}
+
+func TestStoreHandlerUploadKeyValidator(t *testing.T) {
+	store := newHandlerTestStore(t)
+	h := store.Handler(WithUploadKeyValidator(func(key string) (string, bool) {
+		owner, ok := strings.CutPrefix(key, "client-")
+		return owner, ok
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer client-ci")
+	resp := serveHandler(h, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload with a valid key responded %d", resp.StatusCode)
+	} else if item, err := store.Get(uploadedID(resp)); err != nil {
+		t.Fatal(err)
+	} else if item.OwnerKey != "ci" {
+		t.Fatalf("Item was uploaded by %q, expected ci", item.OwnerKey)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer other")
+	if resp := serveHandler(h, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Upload with an invalid key responded %d", resp.StatusCode)
+	}
+
+	// Without keys, uploads stay open.
+	h = store.Handler(WithUploadKeys(nil))
+	if resp := serveHandler(h, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Upload without required keys responded %d", resp.StatusCode)
+	}
+}
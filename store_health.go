@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// errDraining is returned by Store.Ready during Shutdown, while in-flight
+// operations are being awaited.
+var errDraining = fmt.Errorf("%w: draining for shutdown", ErrStoreClosed)
+
+// Health checks if the Store is alive, i.e., not closed and both its database
+// and its storage directory are accessible. A Store draining by Shutdown is
+// still alive, as it finishes its in-flight operations.
+func (s *Store) Health() error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	err := s.bh.Badger().View(func(*badger.Txn) error { return nil })
+	if err != nil {
+		return fmt.Errorf("database is inaccessible: %w", err)
+	}
+
+	_, err = os.Stat(s.storageDir())
+	if err != nil {
+		return fmt.Errorf("storage directory is inaccessible: %w", err)
+	}
+	return nil
+}
+
+// Ready checks if the Store accepts new operations. In addition to Health, it
+// fails while draining by Shutdown with an error wrapping ErrStoreClosed.
+func (s *Store) Ready() error {
+	s.closedMutex.RLock()
+	draining := s.draining && !s.closed
+	s.closedMutex.RUnlock()
+
+	if draining {
+		return errDraining
+	}
+	return s.Health()
+}
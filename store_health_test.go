@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStoreHealth(t *testing.T) {
+	store := newHandlerTestStore(t)
+
+	if err := store.Health(); err != nil {
+		t.Fatalf("Health of a new Store failed: %v", err)
+	} else if err := store.Ready(); err != nil {
+		t.Fatalf("Ready of a new Store failed: %v", err)
+	}
+
+	if err := os.RemoveAll(store.storageDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Health(); err == nil || errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("Health without a storage directory resulted in %v", err)
+	} else if err := store.Ready(); err == nil {
+		t.Fatal("Ready without a storage directory succeeded")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// StoreInfo describes an opened Store for debugging, returned by Store.Info.
+type StoreInfo struct {
+	// Opened is when the Store was opened by NewStore.
+	Opened time.Time
+
+	// BadgerVersion is the module version of badger compiled into this
+	// binary, or "unknown" if the build information is unavailable.
+	BadgerVersion string
+
+	// Items is the amount of Items, including expired but not yet deleted
+	// ones, like Count.
+	Items int64
+
+	// SchemaVersion is the version of the database's schema.
+	SchemaVersion int
+}
+
+// Info describes the Store, e.g., for support requests.
+func (s *Store) Info() StoreInfo {
+	return StoreInfo{
+		Opened:        s.opened,
+		BadgerVersion: badgerVersion(),
+		Items:         s.itemCount.Load(),
+		SchemaVersion: int(s.dbSchemaVersion.Load()),
+	}
+}
+
+// badgerVersion returns badger's module version from the build information.
+func badgerVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/dgraph-io/badger/v4" {
+			continue
+		} else if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreInfo(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(newManualClock(t0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := store.Info()
+	if info.SchemaVersion != schemaVersion {
+		t.Fatalf("Fresh Store has schema version %d, expected %d", info.SchemaVersion, schemaVersion)
+	} else if !info.Opened.Equal(t0) {
+		t.Fatalf("Store was opened at %v, expected %v", info.Opened, t0)
+	} else if info.Items != 0 {
+		t.Fatalf("Fresh Store has %d Items", info.Items)
+	} else if info.BadgerVersion == "" {
+		t.Fatal("Badger version is empty")
+	}
+
+	item := Item{Expires: t0.Add(time.Hour)}
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema version and the Items persist after reopening.
+	store, err = NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := store.Info(); info.SchemaVersion != schemaVersion || info.Items != 1 {
+		t.Fatalf("Reopened Store has unexpected info %+v", info)
+	}
+
+	// A database of a newer gosh version is refused.
+	if err := store.bh.Upsert(storeMetaKey, &storeMeta{SchemaVersion: schemaVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err := NewStore(storageDir, WithCleanup(false)); err == nil {
+		_ = store.Close()
+		t.Fatal("Store of a newer schema version was opened")
+	}
+}
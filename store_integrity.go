@@ -0,0 +1,369 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrChecksumMismatch is returned by Store.Verify if an Item's content does
+// not match its stored checksum.
+var ErrChecksumMismatch = errors.New("Item's content mismatches its checksum")
+
+// ErrUnknownChecksumAlgorithm is returned for a checksum algorithm neither
+// being built-in nor set by WithChecksumHash.
+var ErrUnknownChecksumAlgorithm = errors.New("Unknown checksum algorithm")
+
+// defaultChecksumAlgorithm is used for new Items, unless configured otherwise.
+// It is also assumed for Items without a ChecksumAlgorithm from older versions.
+const defaultChecksumAlgorithm = "sha256"
+
+// checksumAlgorithms are the built-in checksum algorithms by their name.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b-256": func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+}
+
+// checksum calculates the hex encoded Checksum for some data by the default
+// checksum algorithm.
+func checksum(data []byte) string {
+	return checksumWith(checksumAlgorithms[defaultChecksumAlgorithm], data)
+}
+
+// checksumWith calculates the hex encoded Checksum for some data.
+func checksumWith(newHash func() hash.Hash, data []byte) string {
+	h := newHash()
+	_, _ = h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checksumHashFor returns the hash.Hash constructor for an Item's
+// ChecksumAlgorithm, being either the Store's one or a built-in one.
+func (s *Store) checksumHashFor(algorithm string) (func() hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = defaultChecksumAlgorithm
+	}
+
+	if algorithm == s.checksumAlgorithm {
+		return s.checksumHash, nil
+	} else if newHash, ok := checksumAlgorithms[algorithm]; ok {
+		return newHash, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownChecksumAlgorithm, algorithm)
+}
+
+// GetByChecksum returns the first Item whose content matches the hex encoded
+// checksum, just like Get. Without any match, ErrNotFound is returned.
+func (s *Store) GetByChecksum(sum string) (Item, error) {
+	s.logger.Debug("Requested Item by checksum", slog.String("checksum", sum))
+
+	items, err := s.findByIndex("Checksum", strings.ToLower(sum))
+	if err != nil {
+		s.logger.Error("Requesting Item by checksum failed", slog.String("checksum", sum), slog.Any("error", err))
+		return Item{}, err
+	} else if len(items) == 0 {
+		return Item{}, ErrNotFound
+	}
+
+	return s.Get(items[0].ID)
+}
+
+// Verify an Item's content against its stored checksum.
+//
+// For a mismatch, ErrChecksumMismatch is returned. A missing file results in
+// an error wrapping fs.ErrNotExist. Items without a checksum, e.g., from older
+// versions, cannot be verified and are considered valid.
+func (s *Store) Verify(id string) error {
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return s.verifyItem(i)
+}
+
+// verifyItem implements Verify for an already fetched Item.
+func (s *Store) verifyItem(i Item) error {
+	if i.Checksum == "" {
+		s.logger.Debug("Item has no checksum to be verified", slog.String("id", i.ID))
+		return nil
+	}
+
+	newHash, err := s.checksumHashFor(i.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.openContent(i)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := newHash()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != i.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// VerifyAll verifies each Item of the Store and returns the IDs of those with
+// either mismatching or missing files.
+//
+// All Items are iterated one after another without being held in memory
+// together. Other errors abort the verification.
+func (s *Store) VerifyAll() (corrupt []string, err error) {
+	return s.VerifyAllConcurrently(1)
+}
+
+// VerifyAllConcurrently works like VerifyAll, but verifies up to concurrency
+// Items at the same time, e.g., for large files on fast storage. The results
+// are the same as for VerifyAll, including the order of the corrupt IDs.
+//
+// At most concurrency Items are held in memory together.
+func (s *Store) VerifyAllConcurrently(concurrency int) (corrupt []string, err error) {
+	s.logger.Debug("Requested verification of all Items", slog.Int("concurrency", concurrency))
+
+	if concurrency < 1 {
+		return nil, errors.New("verification concurrency must be at least one")
+	}
+
+	type verifyJob struct {
+		index int
+		item  Item
+	}
+	type verifyResult struct {
+		index int
+		id    string
+		err   error
+	}
+
+	jobs := make(chan verifyJob, concurrency)
+	results := make(chan verifyResult, concurrency)
+	abort := make(chan struct{})
+
+	var workers sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				results <- verifyResult{job.index, job.item.ID, s.verifyItem(job.item)}
+			}
+		}()
+	}
+
+	var walkErr error
+	go func() {
+		defer close(jobs)
+
+		index := 0
+		walkErr = s.bh.ForEach(nil, func(i *Item) error {
+			select {
+			case jobs <- verifyJob{index, *i}:
+				index++
+				return nil
+			case <-abort:
+				return errAbortVerify
+			}
+		})
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// Corrupt Items are reordered by their index, while only the first of
+	// other errors is returned, like for a sequential verification.
+	var corruptResults []verifyResult
+	var firstErr *verifyResult
+	for result := range results {
+		switch {
+		case result.err == nil:
+		case errors.Is(result.err, ErrChecksumMismatch) || errors.Is(result.err, fs.ErrNotExist):
+			s.logger.Warn("Item failed verification", slog.String("id", result.id), slog.Any("error", result.err))
+			corruptResults = append(corruptResults, result)
+		default:
+			if firstErr == nil {
+				close(abort)
+			}
+			if firstErr == nil || result.index < firstErr.index {
+				firstErr = &result
+			}
+		}
+	}
+
+	sort.Slice(corruptResults, func(a, b int) bool {
+		return corruptResults[a].index < corruptResults[b].index
+	})
+	for _, result := range corruptResults {
+		if firstErr != nil && result.index > firstErr.index {
+			break
+		}
+		corrupt = append(corrupt, result.id)
+	}
+
+	if walkErr != nil && walkErr != errAbortVerify {
+		err = walkErr
+	} else if firstErr != nil {
+		err = fmt.Errorf("verifying %q failed: %w", firstErr.id, firstErr.err)
+	}
+	if err != nil {
+		s.logger.Error("Verification of all Items failed", slog.Any("error", err))
+		return
+	}
+
+	s.logger.Info("Verified all Items", slog.Int("corrupt", len(corrupt)))
+	return
+}
+
+// errAbortVerify stops the iteration of VerifyAllConcurrently after an error.
+var errAbortVerify = errors.New("verification aborted")
+
+// ScanReport lists inconsistencies between the database and the files, found
+// by Store.Scan.
+type ScanReport struct {
+	// OrphanFiles are files without a database entry.
+	OrphanFiles []string
+
+	// MissingFiles are IDs of Items whose file does not exist.
+	MissingFiles []string
+}
+
+// Scan the Store for inconsistencies between the database and the files.
+//
+// Items whose files are currently being written are ignored, e.g., from an
+// uncommitted PutBatch.
+func (s *Store) Scan() (report ScanReport, err error) {
+	s.logger.Debug("Requested scan for inconsistencies")
+
+	entries, err := os.ReadDir(s.storageDir())
+	if err != nil {
+		s.logger.Error("Failed to list storage directory", slog.Any("error", err))
+		return
+	}
+
+	// Each file is checked on its own instead of against a snapshot, as a new
+	// Item's database entry is inserted before its file is being created.
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		} else if _, writing := s.writing.Load(strings.TrimSuffix(entry.Name(), tmpSuffix)); writing {
+			continue
+		}
+
+		var orphan bool
+		orphan, err = s.orphanFile(entry.Name())
+		if err != nil {
+			s.logger.Error("Failed to fetch Item for file", slog.String("file", entry.Name()), slog.Any("error", err))
+			return
+		} else if orphan {
+			report.OrphanFiles = append(report.OrphanFiles, entry.Name())
+		}
+	}
+
+	err = s.bh.ForEach(nil, func(i *Item) error {
+		if len(i.Inline) > 0 {
+			return nil
+		} else if _, writing := s.writing.Load(i.ID); writing {
+			return nil
+		}
+
+		_, statErr := os.Stat(s.itemPath(*i))
+		if errors.Is(statErr, fs.ErrNotExist) {
+			report.MissingFiles = append(report.MissingFiles, i.ID)
+			return nil
+		}
+		return statErr
+	})
+	if err != nil {
+		s.logger.Error("Failed to check Items' files", slog.Any("error", err))
+		return
+	}
+
+	s.logger.Info("Scanned for inconsistencies",
+		slog.Int("orphan_files", len(report.OrphanFiles)), slog.Int("missing_files", len(report.MissingFiles)))
+	return
+}
+
+// orphanFile checks if a file within the storage directory belongs to no Item,
+// neither by its ID nor as a shared file of BlobByChecksum.
+func (s *Store) orphanFile(name string) (bool, error) {
+	var i Item
+	err := s.bh.Get(name, &i)
+	if err == nil && i.Blob == "" {
+		return false, nil
+	} else if err != nil && err != badgerhold.ErrNotFound {
+		return false, err
+	}
+
+	s.blobMutex.Lock()
+	defer s.blobMutex.Unlock()
+
+	referenced, err := s.blobReferenced(name, "")
+	return !referenced, err
+}
+
+// Repair the inconsistencies found by Scan by removing both orphaned files and
+// Items with missing files. The returned ScanReport lists what was removed.
+//
+// With dryRun, nothing is removed and the ScanReport lists what would be
+// removed, allowing to preview a Repair.
+func (s *Store) Repair(dryRun bool) (report ScanReport, err error) {
+	report, err = s.Scan()
+	if err != nil || dryRun {
+		return
+	}
+
+	var errs []error
+	for _, name := range report.OrphanFiles {
+		if rmErr := s.removeFile(filepath.Join(s.storageDir(), name)); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+			s.logger.Warn("Failed to remove orphaned file", slog.String("file", name), slog.Any("error", rmErr))
+			errs = append(errs, fmt.Errorf("removing file %q failed: %w", name, rmErr))
+		}
+	}
+	for _, id := range report.MissingFiles {
+		if delErr := s.Delete(id); delErr != nil {
+			errs = append(errs, fmt.Errorf("deleting %q failed: %w", id, delErr))
+		}
+	}
+
+	err = errors.Join(errs...)
+	if err != nil {
+		s.logger.Error("Repair failed partially", slog.Any("error", err))
+		return
+	}
+
+	s.logger.Info("Repaired inconsistencies",
+		slog.Int("orphan_files", len(report.OrphanFiles)), slog.Int("missing_files", len(report.MissingFiles)))
+	return
+}
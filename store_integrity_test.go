@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestStoreVerify(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+
+	inlineId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("tiny")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{inlineId, fileId} {
+		if err := store.Verify(id); err != nil {
+			t.Fatalf("Verify %q: %v", id, err)
+		}
+	}
+
+	if err := store.Verify("nope"); err != ErrNotFound {
+		t.Fatalf("Verify of unknown Item returned %v", err)
+	}
+
+	filePath := filepath.Join(store.storageDir(), fileId)
+	if err := os.WriteFile(filePath, []byte("hello w0rld"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify(fileId); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Verify of corrupted Item returned %v", err)
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify(fileId); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Verify of missing file returned %v", err)
+	}
+}
+
+func TestStoreVerifyAll(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := make([]string, 20)
+	for i := range ids {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		ids[i], err = store.Put(item, newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d", i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if corrupt, err := store.VerifyAll(); err != nil {
+		t.Fatal(err)
+	} else if len(corrupt) != 0 {
+		t.Fatalf("VerifyAll reported corrupt Items %v for an intact Store", corrupt)
+	}
+
+	if err := os.WriteFile(filepath.Join(store.storageDir(), ids[3]), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(store.storageDir(), ids[11])); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt, err := store.VerifyAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{ids[3], ids[11]}
+	sort.Strings(expected)
+	sort.Strings(corrupt)
+	if !reflect.DeepEqual(corrupt, expected) {
+		t.Fatalf("VerifyAll reported %v, expected %v", corrupt, expected)
+	}
+}
+
+func TestStoreVerifyAllConcurrently(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := make([]string, 50)
+	for i := range ids {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		ids[i], err = store.Put(item, newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d", i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, i := range []int{2, 17, 23, 41} {
+		if err := os.WriteFile(filepath.Join(store.storageDir(), ids[i]), []byte("garbage"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(store.storageDir(), ids[30])); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := store.VerifyAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(expected) != 5 {
+		t.Fatalf("VerifyAll reported %v, expected five corrupt Items", expected)
+	}
+
+	for _, concurrency := range []int{1, 4, 16, 100} {
+		corrupt, err := store.VerifyAllConcurrently(concurrency)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(corrupt, expected) {
+			t.Fatalf("VerifyAllConcurrently(%d) reported %v, expected %v", concurrency, corrupt, expected)
+		}
+	}
+
+	if _, err := store.VerifyAllConcurrently(0); err == nil {
+		t.Fatal("VerifyAllConcurrently accepted a concurrency of zero")
+	}
+}
+
+func TestStoreRepairDryRun(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := make([]string, 4)
+	for i := range ids {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		ids[i], err = store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	inlineId, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("tiny")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphanPath := filepath.Join(store.storageDir(), "orphan")
+	if err := os.WriteFile(orphanPath, []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(store.storageDir(), ids[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ScanReport{OrphanFiles: []string{"orphan"}, MissingFiles: []string{ids[1]}}
+
+	dryReport, err := store.Repair(true)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(dryReport, expected) {
+		t.Fatalf("Dry-run Repair reported %v, expected %v", dryReport, expected)
+	}
+
+	if _, err := os.Stat(orphanPath); err != nil {
+		t.Fatalf("Dry-run Repair removed orphaned file: %v", err)
+	}
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != len(ids)+1 {
+		t.Fatalf("Dry-run Repair changed the Store to %d Items", n)
+	}
+
+	report, err := store.Repair(false)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(report, dryReport) {
+		t.Fatalf("Repair reported %v, dry-run reported %v", report, dryReport)
+	}
+
+	if _, err := os.Stat(orphanPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Repair kept orphaned file: %v", err)
+	}
+	if _, err := store.Get(ids[1]); err != ErrNotFound {
+		t.Fatalf("Repair kept Item with missing file: %v", err)
+	}
+	for _, id := range []string{ids[0], ids[2], ids[3], inlineId} {
+		if _, err := store.Get(id); err != nil {
+			t.Fatalf("Repair removed intact Item %q: %v", id, err)
+		}
+	}
+
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) != 0 || len(report.MissingFiles) != 0 {
+		t.Fatalf("Scan after Repair reported %v", report)
+	}
+}
+
+func TestStoreGetByChecksum(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 4; i++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d", i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("hello world"))
+	for _, digest := range []string{hex.EncodeToString(sum[:]), strings.ToUpper(hex.EncodeToString(sum[:]))} {
+		if itemX, err := store.GetByChecksum(digest); err != nil {
+			t.Fatal(err)
+		} else if itemX.ID != itemId {
+			t.Fatalf("GetByChecksum returned Item %q, expected %q", itemX.ID, itemId)
+		}
+	}
+
+	if _, err := store.GetByChecksum(checksum([]byte("nope"))); err != ErrNotFound {
+		t.Fatalf("GetByChecksum for unknown checksum returned %v", err)
+	}
+
+	// Items without a checksum, e.g., from older gosh versions, are neither
+	// indexed nor found.
+	err = store.updateAccess(itemId, func(i *Item) error {
+		i.Checksum = ""
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetByChecksum(""); err != ErrNotFound {
+		t.Fatalf("GetByChecksum for an empty checksum returned %v", err)
+	}
+	empty, err := badgerhold.DefaultEncode("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.bh.Badger().View(func(tx *badger.Txn) error {
+		_, err := tx.Get(itemIndexKey("Checksum", empty))
+		return err
+	})
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("Empty checksum was indexed: %v", err)
+	}
+}
+
+func TestStoreChecksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       Option
+		algorithm string
+		sum       func([]byte) string
+	}{
+		{"sha1", WithChecksumAlgorithm("sha1"), "sha1", func(data []byte) string {
+			sum := sha1.Sum(data)
+			return hex.EncodeToString(sum[:])
+		}},
+		{"blake2b-256", WithChecksumAlgorithm("blake2b-256"), "blake2b-256", func(data []byte) string {
+			sum := blake2b.Sum256(data)
+			return hex.EncodeToString(sum[:])
+		}},
+		{"custom", WithChecksumHash("custom-sha224", sha256.New224), "custom-sha224", func(data []byte) string {
+			sum := sha256.Sum224(data)
+			return hex.EncodeToString(sum[:])
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithIdGenerator(randomIdGenerator(4)),
+				WithCleanup(false),
+				WithInlineSize(8),
+				test.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			for _, data := range [][]byte{[]byte("tiny"), []byte("hello world")} {
+				id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if i, err := store.Get(id); err != nil {
+					t.Fatal(err)
+				} else if i.ChecksumAlgorithm != test.algorithm {
+					t.Fatalf("Item's checksum algorithm is %q, expected %q", i.ChecksumAlgorithm, test.algorithm)
+				} else if i.Checksum != test.sum(data) {
+					t.Fatalf("Item's checksum is %q, expected %q", i.Checksum, test.sum(data))
+				} else if err := store.Verify(id); err != nil {
+					t.Fatalf("Verify %q: %v", id, err)
+				}
+			}
+
+			// An Item from another Store's custom algorithm cannot be verified.
+			unknown := Item{ID: "unknown", Checksum: "00", ChecksumAlgorithm: "unknown", Inline: []byte("data")}
+			if err := store.verifyItem(unknown); !errors.Is(err, ErrUnknownChecksumAlgorithm) {
+				t.Fatalf("Verify of unknown checksum algorithm returned %v", err)
+			}
+		})
+	}
+}
+
+func BenchmarkStoreVerifyAll(b *testing.B) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+
+	data := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		data[0] = byte(i)
+		item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(bytes.Clone(data)))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := store.VerifyAllConcurrently(concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
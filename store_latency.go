@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyOp is the kind of Store operation measured for a LatencyObserver.
+type LatencyOp string
+
+const (
+	// LatencyPut is a whole Put, including LatencyPutCopy.
+	LatencyPut LatencyOp = "put"
+
+	// LatencyPutCopy is the part of a Put writing the Item's file, being
+	// dominated by the disk and the client. Inline Items are not measured.
+	LatencyPutCopy LatencyOp = "put_copy"
+
+	// LatencyGet is fetching an Item and opening its content by Get, GetFile,
+	// or GetWithFile, without reading the content.
+	LatencyGet LatencyOp = "get"
+
+	// LatencyDelete is the deletion of an Item, including by the cleanup.
+	LatencyDelete LatencyOp = "delete"
+)
+
+// LatencyObserver receives the duration of each Store operation, both
+// successful and failed ones, set by WithLatencyObserver. It must be safe for
+// concurrent use and should return quickly, as it is called inline.
+//
+// LatencyHistograms is an in-memory implementation. Others might, e.g., pass
+// the durations to Prometheus histograms of a client library.
+type LatencyObserver interface {
+	ObserveLatency(op LatencyOp, d time.Duration)
+}
+
+// observeLatency passes the duration since start to the LatencyObserver.
+func (s *Store) observeLatency(op LatencyOp, start time.Time) {
+	if s.latencyObserver != nil {
+		s.latencyObserver.ObserveLatency(op, s.clock.Now().Sub(start))
+	}
+}
+
+// DefaultLatencyBuckets are the upper bounds of LatencyHistograms' buckets,
+// ranging from fast database lookups to slow uploads.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// LatencyHistograms is an in-memory LatencyObserver, keeping one histogram of
+// the durations per LatencyOp.
+type LatencyHistograms struct {
+	buckets []time.Duration
+
+	mutex sync.Mutex
+	ops   map[LatencyOp]*LatencySnapshot
+}
+
+// NewLatencyHistograms creates LatencyHistograms with buckets of the given
+// ascending upper bounds. Slower durations are counted in an additional
+// overflow bucket. Nil buckets use DefaultLatencyBuckets.
+func NewLatencyHistograms(buckets []time.Duration) (*LatencyHistograms, error) {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	} else if len(buckets) == 0 {
+		return nil, errors.New("latency histograms need at least one bucket")
+	}
+
+	for i, bucket := range buckets {
+		if bucket <= 0 || (i > 0 && bucket <= buckets[i-1]) {
+			return nil, errors.New("latency buckets must be positive and ascending")
+		}
+	}
+
+	return &LatencyHistograms{
+		buckets: append([]time.Duration(nil), buckets...),
+		ops:     make(map[LatencyOp]*LatencySnapshot),
+	}, nil
+}
+
+// ObserveLatency counts the duration in the LatencyOp's histogram.
+func (l *LatencyHistograms) ObserveLatency(op LatencyOp, d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snap, ok := l.ops[op]
+	if !ok {
+		snap = &LatencySnapshot{Buckets: l.buckets, Counts: make([]uint64, len(l.buckets)+1)}
+		l.ops[op] = snap
+	}
+
+	snap.Counts[sort.Search(len(l.buckets), func(i int) bool { return d <= l.buckets[i] })]++
+	snap.Count++
+	snap.Sum += d
+}
+
+// LatencySnapshot is the state of a LatencyOp's histogram.
+type LatencySnapshot struct {
+	// Buckets are the upper bounds, while Counts are the amount of durations
+	// per bucket, not being cumulative. The last Count is the overflow bucket.
+	Buckets []time.Duration
+	Counts  []uint64
+
+	Count uint64
+	Sum   time.Duration
+}
+
+// Snapshot returns a copy of the LatencyOp's histogram, being empty for
+// operations without any observations.
+func (l *LatencyHistograms) Snapshot(op LatencyOp) LatencySnapshot {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snap, ok := l.ops[op]
+	if !ok {
+		return LatencySnapshot{Buckets: l.buckets, Counts: make([]uint64, len(l.buckets)+1)}
+	}
+
+	snapCopy := *snap
+	snapCopy.Counts = append([]uint64(nil), snap.Counts...)
+	return snapCopy
+}
+
+// Quantile estimates the q-quantile, e.g., 0.99 for the p99, by interpolating
+// linearly within the bucket, like Prometheus' histogram_quantile. Durations
+// within the overflow bucket are estimated as the highest bound.
+func (snap LatencySnapshot) Quantile(q float64) time.Duration {
+	if snap.Count == 0 || len(snap.Buckets) == 0 {
+		return 0
+	}
+
+	rank := q * float64(snap.Count)
+	var cumulative uint64
+	for i, count := range snap.Counts {
+		if count == 0 || float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		} else if i == len(snap.Buckets) {
+			break
+		}
+
+		var lower time.Duration
+		if i > 0 {
+			lower = snap.Buckets[i-1]
+		}
+		fraction := max(0, rank-float64(cumulative)) / float64(count)
+		return lower + time.Duration(fraction*float64(snap.Buckets[i]-lower))
+	}
+	return snap.Buckets[len(snap.Buckets)-1]
+}
+
+// WritePrometheus writes all histograms in Prometheus' text exposition format
+// as gosh_store_operation_duration_seconds, labeled by their LatencyOp.
+func (l *LatencyHistograms) WritePrometheus(w io.Writer) error {
+	l.mutex.Lock()
+	ops := make([]LatencyOp, 0, len(l.ops))
+	for op := range l.ops {
+		ops = append(ops, op)
+	}
+	l.mutex.Unlock()
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+
+	const name = "gosh_store_operation_duration_seconds"
+	_, err := fmt.Fprintf(w, "# HELP %s Duration of Store operations.\n# TYPE %s histogram\n", name, name)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		snap := l.Snapshot(op)
+
+		var cumulative uint64
+		for i, count := range snap.Counts {
+			cumulative += count
+
+			le := "+Inf"
+			if i < len(snap.Buckets) {
+				le = strconv.FormatFloat(snap.Buckets[i].Seconds(), 'g', -1, 64)
+			}
+			_, err = fmt.Fprintf(w, "%s_bucket{op=%q,le=%q} %d\n", name, op, le, cumulative)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = fmt.Fprintf(w, "%s_sum{op=%q} %s\n%s_count{op=%q} %d\n",
+			name, op, strconv.FormatFloat(snap.Sum.Seconds(), 'g', -1, 64), name, op, snap.Count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreLatencyObserver(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	histograms, err := NewLatencyHistograms(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithLatencyObserver(histograms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []LatencyOp{LatencyPut, LatencyPutCopy} {
+		if snap := histograms.Snapshot(op); snap.Count != 1 {
+			t.Fatalf("Histogram of %q has %d samples after Put, expected 1", op, snap.Count)
+		}
+	}
+	if snap := histograms.Snapshot(LatencyGet); snap.Count != 0 {
+		t.Fatalf("Histogram of %q has %d samples before Get", LatencyGet, snap.Count)
+	}
+
+	if _, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []LatencyOp{LatencyGet, LatencyDelete} {
+		snap := histograms.Snapshot(op)
+		if snap.Count != 1 {
+			t.Fatalf("Histogram of %q has %d samples, expected 1", op, snap.Count)
+		}
+
+		var bucketed uint64
+		for _, count := range snap.Counts {
+			bucketed += count
+		}
+		if bucketed != snap.Count {
+			t.Fatalf("Histogram of %q has %d bucketed samples, expected %d", op, bucketed, snap.Count)
+		}
+	}
+}
+
+func TestLatencyHistogramsQuantile(t *testing.T) {
+	histograms, err := NewLatencyHistograms([]time.Duration{time.Millisecond, 10 * time.Millisecond, time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q := histograms.Snapshot(LatencyGet).Quantile(0.5); q != 0 {
+		t.Fatalf("Quantile of empty histogram is %v", q)
+	}
+
+	// 50 fast samples, 49 within the second bucket, and a single slow one.
+	for i := 0; i < 50; i++ {
+		histograms.ObserveLatency(LatencyGet, 500*time.Microsecond)
+	}
+	for i := 0; i < 49; i++ {
+		histograms.ObserveLatency(LatencyGet, 5*time.Millisecond)
+	}
+	histograms.ObserveLatency(LatencyGet, time.Minute)
+
+	snap := histograms.Snapshot(LatencyGet)
+	if snap.Count != 100 {
+		t.Fatalf("Histogram has %d samples, expected 100", snap.Count)
+	} else if counts := snap.Counts; counts[0] != 50 || counts[1] != 49 || counts[2] != 0 || counts[3] != 1 {
+		t.Fatalf("Histogram has unexpected bucket counts %v", counts)
+	}
+
+	tests := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0.25, 500 * time.Microsecond},
+		{0.5, time.Millisecond},
+		{0.99, 10 * time.Millisecond},
+		{1, time.Second},
+	}
+	for _, test := range tests {
+		if q := snap.Quantile(test.q); q != test.expected {
+			t.Errorf("Quantile %v is %v, expected %v", test.q, q, test.expected)
+		}
+	}
+
+	for _, invalid := range [][]time.Duration{{}, {0}, {time.Second, time.Millisecond}, {time.Second, time.Second}} {
+		if _, err := NewLatencyHistograms(invalid); err == nil {
+			t.Fatalf("Invalid buckets %v were accepted", invalid)
+		}
+	}
+}
+
+func TestLatencyHistogramsWritePrometheus(t *testing.T) {
+	histograms, err := NewLatencyHistograms([]time.Duration{time.Millisecond, time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	histograms.ObserveLatency(LatencyPut, 250*time.Millisecond)
+	histograms.ObserveLatency(LatencyPut, 2*time.Second)
+	histograms.ObserveLatency(LatencyGet, 500*time.Microsecond)
+
+	var buf strings.Builder
+	if err := histograms.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP gosh_store_operation_duration_seconds Duration of Store operations.
+# TYPE gosh_store_operation_duration_seconds histogram
+gosh_store_operation_duration_seconds_bucket{op="get",le="0.001"} 1
+gosh_store_operation_duration_seconds_bucket{op="get",le="1"} 1
+gosh_store_operation_duration_seconds_bucket{op="get",le="+Inf"} 1
+gosh_store_operation_duration_seconds_sum{op="get"} 0.0005
+gosh_store_operation_duration_seconds_count{op="get"} 1
+gosh_store_operation_duration_seconds_bucket{op="put",le="0.001"} 0
+gosh_store_operation_duration_seconds_bucket{op="put",le="1"} 1
+gosh_store_operation_duration_seconds_bucket{op="put",le="+Inf"} 2
+gosh_store_operation_duration_seconds_sum{op="put"} 2.25
+gosh_store_operation_duration_seconds_count{op="put"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected Prometheus output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// schemaVersion is the current version of the database's schema, being the
+// version of the last migration.
+const schemaVersion = 4
+
+// storeMetaKey is the key of the single storeMeta record.
+const storeMetaKey = "store"
+
+// storeMeta is the Store's own metadata, persisted within the database next
+// to the Items.
+type storeMeta struct {
+	SchemaVersion int
+}
+
+// migration upgrades the database's schema to its version.
+type migration struct {
+	version int
+	name    string
+	migrate func(*Store) error
+}
+
+// migrations are all upgrades of the database's schema, ordered by their
+// version. As an interrupted Migrate runs the last migration again, each one
+// must be idempotent.
+var migrations = []migration{
+	{2, "backfill size", (*Store).migrateSize},
+	{3, "backfill creation time", (*Store).migrateCreated},
+	{4, "drop empty index entries", (*Store).migrateEmptyIndexes},
+}
+
+// loadSchemaVersion reads the database's schema version while opening the
+// Store. Databases of a newer version are refused, e.g., after a downgrade.
+//
+// A database without a storeMeta is either new, starting at the current
+// schemaVersion, or was created before the schema version was tracked, being
+// the first version.
+func (s *Store) loadSchemaVersion() error {
+	var meta storeMeta
+	err := s.bh.Get(storeMetaKey, &meta)
+	if err == badgerhold.ErrNotFound && s.itemCount.Load() == 0 {
+		meta.SchemaVersion = schemaVersion
+		err = s.bh.Upsert(storeMetaKey, &meta)
+	} else if err == badgerhold.ErrNotFound {
+		meta.SchemaVersion = 1
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if meta.SchemaVersion > schemaVersion {
+		return fmt.Errorf("database has the schema version %d, newer than the supported %d, use a newer gosh version",
+			meta.SchemaVersion, schemaVersion)
+	} else if meta.SchemaVersion < schemaVersion {
+		s.logger.Warn("Database schema is outdated and should be migrated",
+			slog.Int("version", meta.SchemaVersion), slog.Int("current", schemaVersion))
+	}
+
+	s.dbSchemaVersion.Store(int64(meta.SchemaVersion))
+	return nil
+}
+
+// Migrate upgrades the database to the current schema version by running all
+// pending migrations in order, e.g., backfilling fields of Items created by
+// older gosh versions. The version is recorded after each migration. Thus,
+// each migration runs once and an interrupted Migrate resumes when called
+// again. An up-to-date database is left unchanged.
+func (s *Store) Migrate() error {
+	_, err := s.BadgerHoldSafe()
+	if err != nil {
+		return err
+	}
+
+	s.migrateMutex.Lock()
+	defer s.migrateMutex.Unlock()
+
+	for _, m := range migrations {
+		if int64(m.version) <= s.dbSchemaVersion.Load() {
+			continue
+		}
+
+		s.logger.Info("Migrating database schema", slog.Int("version", m.version), slog.String("migration", m.name))
+
+		err = m.migrate(s)
+		if err != nil {
+			s.logger.Error("Failed to migrate database schema",
+				slog.Int("version", m.version), slog.String("migration", m.name), slog.Any("error", err))
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+
+		err = s.bh.Upsert(storeMetaKey, &storeMeta{SchemaVersion: m.version})
+		if err != nil {
+			return err
+		}
+		s.dbSchemaVersion.Store(int64(m.version))
+	}
+	return nil
+}
+
+// migrateSize backfills the Size of Items stored without, taken from their
+// Inline content or their file. Compressed or encrypted files are skipped, as
+// their size differs from the content's.
+func (s *Store) migrateSize() error {
+	sizes := make(map[string]int64)
+	err := s.bh.ForEach(nil, func(i *Item) error {
+		if i.Size != 0 {
+			return nil
+		} else if len(i.Inline) > 0 {
+			sizes[i.ID] = int64(len(i.Inline))
+			return nil
+		} else if i.Compressed || i.Encrypted {
+			return nil
+		}
+
+		info, err := os.Stat(s.itemPath(*i))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sizes[i.ID] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, size := range sizes {
+		err = s.updateAccess(id, func(i *Item) error {
+			if i.Size == 0 {
+				i.Size = size
+			}
+			return nil
+		})
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCreated backfills the Created time of Items stored without by their
+// file's modification time. Inline Items are skipped.
+func (s *Store) migrateCreated() error {
+	created := make(map[string]time.Time)
+	err := s.bh.ForEach(nil, func(i *Item) error {
+		if !i.Created.IsZero() || len(i.Inline) > 0 {
+			return nil
+		}
+
+		info, err := os.Stat(s.itemPath(*i))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		created[i.ID] = info.ModTime().UTC()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, t := range created {
+		err = s.updateAccess(id, func(i *Item) error {
+			if i.Created.IsZero() {
+				i.Created = t
+			}
+			return nil
+		})
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// emptyIndexes are the Item's indexes not indexing empty values anymore, see
+// Item.Indexes.
+var emptyIndexes = []string{"Slug", "Checksum"}
+
+// itemIndexKey is the badger key of an Item's index entry for the encoded
+// value, as being named by badgerhold.
+func itemIndexKey(index string, value []byte) []byte {
+	return append([]byte("_bhIndex:Item:"+index+":"), value...)
+}
+
+// migrateEmptyIndexes drops the index entries of empty values, which were
+// shared by all Items without a value. As they are not updated anymore, they
+// would otherwise keep referencing deleted Items.
+func (s *Store) migrateEmptyIndexes() error {
+	empty, err := badgerhold.DefaultEncode("")
+	if err != nil {
+		return err
+	}
+
+	return s.bh.Badger().Update(func(tx *badger.Txn) error {
+		for _, index := range emptyIndexes {
+			err := tx.Delete(itemIndexKey(index, empty))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+func TestStoreMigrate(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithInlineSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+	fileId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inlineId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mimic a database of an older gosh version, storing neither sizes nor
+	// creation times and without a schema version.
+	for _, id := range []string{fileId, inlineId} {
+		err := store.updateAccess(id, func(i *Item) error {
+			i.Size = 0
+			i.Created = time.Time{}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.bh.Delete(storeMetaKey, storeMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if v := store.Info().SchemaVersion; v != 1 {
+		t.Fatalf("Old Store has schema version %d, expected 1", v)
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	} else if v := store.Info().SchemaVersion; v != schemaVersion {
+		t.Fatalf("Migrated Store has schema version %d, expected %d", v, schemaVersion)
+	}
+
+	for id, size := range map[string]int64{fileId: 11, inlineId: 5} {
+		if i, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if i.Size != size {
+			t.Fatalf("Item %q has the size %d after Migrate, expected %d", id, i.Size, size)
+		}
+	}
+	if i, err := store.Get(fileId); err != nil {
+		t.Fatal(err)
+	} else if i.Created.IsZero() {
+		t.Fatal("Creation time of the file Item was not backfilled")
+	}
+
+	// Migrations are recorded and run only once, also when being resumed.
+	err = store.updateAccess(fileId, func(i *Item) error {
+		i.Size = 0
+		i.Created = time.Time{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.bh.Upsert(storeMetaKey, &storeMeta{SchemaVersion: 2}); err != nil {
+		t.Fatal(err)
+	}
+	store.dbSchemaVersion.Store(2)
+
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := store.Get(fileId); err != nil {
+		t.Fatal(err)
+	} else if i.Size != 0 || i.Created.IsZero() {
+		t.Fatalf("Resumed Migrate ran unexpected migrations: size %d, created %v", i.Size, i.Created)
+	}
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for n, m := range migrations {
+		if n > 0 && m.version <= migrations[n-1].version {
+			t.Fatalf("Migration %q is not ordered by its version %d", m.name, m.version)
+		}
+	}
+	if last := migrations[len(migrations)-1].version; last != schemaVersion {
+		t.Fatalf("Last migration has version %d, but the schema version is %d", last, schemaVersion)
+	}
+}
+
+func TestStoreMigrateEmptyIndexes(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	id, err := store.Put(Item{Expires: time.Now().Add(time.Hour).UTC()}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mimic a database of an older gosh version, sharing one index entry for
+	// all Items without a Slug, which was not updated after the deletion.
+	if err := store.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := badgerhold.DefaultEncode("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := badgerhold.DefaultEncode(badgerhold.KeyList{[]byte(id)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range emptyIndexes {
+		err := store.bh.Badger().Update(func(tx *badger.Txn) error {
+			return tx.Set(itemIndexKey(index, empty), keys)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.bh.Upsert(storeMetaKey, &storeMeta{SchemaVersion: 3}); err != nil {
+		t.Fatal(err)
+	}
+	store.dbSchemaVersion.Store(3)
+
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range emptyIndexes {
+		err := store.bh.Badger().View(func(tx *badger.Txn) error {
+			_, err := tx.Get(itemIndexKey(index, empty))
+			return err
+		})
+		if err != badger.ErrKeyNotFound {
+			t.Fatalf("Index entry of empty %s was not dropped: %v", index, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// renameDir is os.Rename, replaceable for testing.
+var renameDir = os.Rename
+
+// MoveStore relocates a closed Store from oldBase to newBase, e.g., to
+// migrate to another volume.
+//
+// If both directories are on the same file system, the database and storage
+// directories are renamed. Otherwise, they are copied, validated, and removed
+// afterwards. In both cases, the moved Store is validated by opening it. If
+// this fails, renamed directories are renamed back and copied ones removed,
+// leaving the Store at oldBase. A Store still in use results in
+// ErrAlreadyLocked.
+func MoveStore(oldBase, newBase string) error {
+	logger := slog.Default().With(slog.String("from", oldBase), slog.String("to", newBase))
+
+	for _, dir := range []string{DirDatabase, DirStorage} {
+		if _, err := os.Stat(filepath.Join(newBase, dir)); err == nil {
+			return fmt.Errorf("destination %q already contains %q", newBase, dir)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(oldBase, DirDatabase)); err != nil {
+		return fmt.Errorf("source is no Store: %w", err)
+	}
+
+	// Opening the old Store ensures that it is both valid and not in use.
+	expected, err := countStoreItems(oldBase)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(newBase, 0700)
+	if err != nil {
+		return err
+	}
+
+	copied, err := moveStoreDirs(oldBase, newBase)
+	if err != nil {
+		return err
+	}
+
+	n, err := countStoreItems(newBase)
+	if err == nil && n != expected {
+		err = fmt.Errorf("moved Store has %d Items, expected %d", n, expected)
+	}
+	if err != nil {
+		logger.Error("Validating moved Store failed", slog.Any("error", err))
+		if copied {
+			_ = os.RemoveAll(filepath.Join(newBase, DirDatabase))
+			_ = os.RemoveAll(filepath.Join(newBase, DirStorage))
+		} else if restoreErr := restoreStoreDirs(oldBase, newBase, DirDatabase, DirStorage); restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+		return fmt.Errorf("validating moved Store failed: %w", err)
+	}
+
+	if copied {
+		for _, dir := range []string{DirDatabase, DirStorage} {
+			err = os.RemoveAll(filepath.Join(oldBase, dir))
+			if err != nil {
+				return fmt.Errorf("removing old directory failed: %w", err)
+			}
+		}
+	}
+
+	logger.Info("Moved Store", slog.Int("items", n), slog.Bool("copied", copied))
+	return nil
+}
+
+// countStoreItems opens the Store to count its Items.
+func countStoreItems(baseDir string) (int, error) {
+	store, err := NewStore(baseDir, WithCleanup(false))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := store.Count()
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// moveStoreDirs renames or, across file systems, copies both the database and
+// the storage directory. It reports if the directories were copied.
+func moveStoreDirs(oldBase, newBase string) (copied bool, err error) {
+	err = renameDir(filepath.Join(oldBase, DirDatabase), filepath.Join(newBase, DirDatabase))
+	if errors.Is(err, syscall.EXDEV) {
+		for _, dir := range []string{DirDatabase, DirStorage} {
+			err = copyDir(filepath.Join(oldBase, dir), filepath.Join(newBase, dir))
+			if err != nil {
+				_ = os.RemoveAll(filepath.Join(newBase, DirDatabase))
+				_ = os.RemoveAll(filepath.Join(newBase, DirStorage))
+				return false, err
+			}
+		}
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = renameDir(filepath.Join(oldBase, DirStorage), filepath.Join(newBase, DirStorage))
+	if err != nil {
+		// Restore the database directory for a consistent old Store.
+		if restoreErr := restoreStoreDirs(oldBase, newBase, DirDatabase); restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// restoreStoreDirs renames the already moved directories back from newBase to
+// oldBase, joining the errors of all failed renames.
+func restoreStoreDirs(oldBase, newBase string, dirs ...string) (err error) {
+	for _, dir := range dirs {
+		err = errors.Join(err, renameDir(filepath.Join(newBase, dir), filepath.Join(oldBase, dir)))
+	}
+	return
+}
+
+// copyDir recursively copies src to dst, keeping permissions and, if running
+// as root, ownership. All files are synced to disk.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			err = os.Mkdir(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			err = copyRegularFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot copy %q of type %v", path, info.Mode().Type())
+		}
+		if err != nil {
+			return err
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+			return os.Lchown(target, int(stat.Uid), int(stat.Gid))
+		}
+		return nil
+	})
+}
+
+// copyRegularFile copies a regular file, syncing it to disk.
+func copyRegularFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMoveStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		rename func(string, string) error
+	}{
+		{"rename", os.Rename},
+		{"copy", func(oldpath, newpath string) error {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			renameDir = test.rename
+			defer func() { renameDir = os.Rename }()
+
+			tmpDir, err := os.MkdirTemp("", "move")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			oldBase := filepath.Join(tmpDir, "old")
+			newBase := filepath.Join(tmpDir, "new")
+
+			store, err := NewStore(oldBase, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(8))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := make(map[string]string)
+			for i := 0; i < 16; i++ {
+				content := fmt.Sprintf("content of item %d", i)
+				if i%4 == 0 {
+					content = "tiny"
+				}
+
+				item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+				id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(content)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[id] = content
+			}
+
+			// The Store must be closed before being moved.
+			if err := MoveStore(oldBase, newBase); !errors.Is(err, ErrAlreadyLocked) {
+				t.Fatalf("MoveStore of an open Store returned %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := MoveStore(oldBase, newBase); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, dir := range []string{DirDatabase, DirStorage} {
+				if _, err := os.Stat(filepath.Join(oldBase, dir)); !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("Old directory %q still exists: %v", dir, err)
+				}
+			}
+
+			store, err = NewStore(newBase, WithCleanup(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			for id, content := range data {
+				f, err := store.GetFile(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				buff, err := io.ReadAll(f)
+				_ = f.Close()
+				if err != nil {
+					t.Fatal(err)
+				} else if string(buff) != content {
+					t.Fatalf("Moved Item %q has content %q, expected %q", id, buff, content)
+				}
+			}
+
+			if corrupt, err := store.VerifyAll(); err != nil {
+				t.Fatal(err)
+			} else if len(corrupt) != 0 {
+				t.Fatalf("Moved Store has corrupt Items %v", corrupt)
+			}
+		})
+	}
+}
+
+func TestMoveStoreInvalid(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := MoveStore(filepath.Join(tmpDir, "nope"), filepath.Join(tmpDir, "new")); err == nil {
+		t.Fatalf("MoveStore of a missing Store did not fail")
+	}
+
+	// An existing Store at the destination must not be overwritten.
+	for _, base := range []string{"a", "b"} {
+		store, err := NewStore(filepath.Join(tmpDir, base), WithCleanup(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MoveStore(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b")); err == nil {
+		t.Fatalf("MoveStore into an existing Store did not fail")
+	}
+}
+
+func TestMoveStoreRestoresRename(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldBase := filepath.Join(tmpDir, "old")
+	newBase := filepath.Join(tmpDir, "new")
+
+	store, err := NewStore(oldBase, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After renaming both directories, another Item sneaks into the moved
+	// Store, letting its validation fail.
+	renameDir = func(oldpath, newpath string) error {
+		err := os.Rename(oldpath, newpath)
+		if err != nil || newpath != filepath.Join(newBase, DirStorage) {
+			return err
+		}
+
+		store, err := NewStore(newBase, WithCleanup(false))
+		if err != nil {
+			return err
+		}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("sneaky"))); err != nil {
+			return err
+		}
+		return store.Close()
+	}
+	defer func() { renameDir = os.Rename }()
+
+	if err := MoveStore(oldBase, newBase); err == nil {
+		t.Fatal("MoveStore with a failing validation succeeded")
+	}
+
+	for _, dir := range []string{DirDatabase, DirStorage} {
+		if _, err := os.Stat(filepath.Join(newBase, dir)); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("Moved directory %q was not renamed back: %v", dir, err)
+		}
+	}
+
+	store, err = NewStore(oldBase, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	f, err := store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if buff, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != "hello world" {
+		t.Fatalf("Restored Item has content %q", buff)
+	}
+}
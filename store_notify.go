@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// notifyTimeout bounds each attempt to notify an Item's NotifyURL, unless
+	// replaced by WithNotifyClient.
+	notifyTimeout = 10 * time.Second
+
+	// notifyAttempts is the amount of tries to deliver a notification, waiting
+	// twice as long after each failure, starting at notifyRetryDelay.
+	notifyAttempts   = 4
+	notifyRetryDelay = time.Second
+)
+
+// FirstDownloadNotification is POSTed as JSON to an Item's NotifyURL after
+// its first complete download.
+type FirstDownloadNotification struct {
+	ID         string    `json:"id"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
+// notifyFirstDownload delivers a FirstDownloadNotification in the background,
+// retrying failed attempts until the Store is shut down. Failures are only
+// logged, as the download itself has already succeeded.
+func (s *Store) notifyFirstDownload(id, notifyURL string, downloaded time.Time) {
+	u, err := url.Parse(notifyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		s.logger.Warn("Skip notification of first download to an invalid URL",
+			slog.String("id", id), slog.String("url", notifyURL))
+		return
+	}
+
+	body, err := json.Marshal(FirstDownloadNotification{ID: id, Downloaded: downloaded})
+	if err != nil {
+		s.logger.Error("Failed to encode notification of first download", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+
+	if err := s.beginInFlight(); err != nil {
+		s.logger.Warn("Skip notification of first download for a closed Store", slog.String("id", id))
+		return
+	}
+
+	go func() {
+		defer s.inFlight.Done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.notifyDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		delay := s.notifyRetryDelay
+		for attempt := 1; ; attempt++ {
+			err := s.postNotification(ctx, notifyURL, body)
+			if err == nil {
+				s.logger.Debug("Notified first download", slog.String("id", id), slog.Int("attempt", attempt))
+				return
+			} else if attempt >= notifyAttempts {
+				s.logger.Warn("Failed to notify first download, giving up",
+					slog.String("id", id), slog.Int("attempts", attempt), slog.Any("error", err))
+				return
+			}
+
+			s.logger.Debug("Failed to notify first download, will be retried",
+				slog.String("id", id), slog.Duration("delay", delay), slog.Any("error", err))
+			ticker := s.clock.NewTicker(delay)
+			select {
+			case <-ticker.C():
+				ticker.Stop()
+			case <-ctx.Done():
+				ticker.Stop()
+				s.logger.Warn("Failed to notify first download, aborted by shutdown",
+					slog.String("id", id), slog.Int("attempts", attempt))
+				return
+			}
+			delay *= 2
+		}
+	}()
+}
+
+// postNotification sends a single notification attempt, failing for both
+// transport errors and non-2xx responses. The request is aborted with ctx.
+func (s *Store) postNotification(ctx context.Context, notifyURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gosh")
+
+	resp, err := s.notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification was answered by %s", resp.Status)
+	}
+	return nil
+}
+
+// stopNotifications aborts all pending notifications, including their retries.
+func (s *Store) stopNotifications() {
+	s.notifyStop.Do(func() { close(s.notifyDone) })
+}
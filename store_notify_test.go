@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreNotifyFirstDownload(t *testing.T) {
+	var mutex sync.Mutex
+	var attempts int
+	var notifications []FirstDownloadNotification
+	delivered := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// The first attempt fails to exercise the retry.
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var notification FirstDownloadNotification
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected notification request %s with %q", r.Method, r.Header.Get("Content-Type"))
+		} else if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Error(err)
+		}
+		notifications = append(notifications, notification)
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithNotifyClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.notifyRetryDelay = 10 * time.Millisecond
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC(), NotifyURL: server.URL}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	download := func() {
+		f, err := store.GetFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	download()
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First download was not notified")
+	}
+
+	download()
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 2 || len(notifications) != 1 {
+		t.Fatalf("Received %d attempts with %d notifications, expected 2 and 1", attempts, len(notifications))
+	} else if notifications[0].ID != id || notifications[0].Downloaded.IsZero() {
+		t.Fatalf("Unexpected notification %+v for Item %q", notifications[0], id)
+	}
+}
+
+func TestStoreNotifyShutdown(t *testing.T) {
+	attempted := make(chan struct{}, notifyAttempts)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempted <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Now())
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithNotifyClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC(), NotifyURL: server.URL}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-attempted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First download was not notified")
+	}
+
+	// Wait for the retry's ticker, which is never fired before Shutdown.
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		clock.mutex.Lock()
+		tickers := len(clock.tickers)
+		clock.mutex.Unlock()
+
+		if tickers > 0 {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal("Failed notification was not retried")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := store.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown with a pending notification failed: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(attempted); n != 0 {
+		t.Fatalf("Notification was retried %d times after Shutdown", n)
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Option configures a Store on its creation by NewStore.
+type Option func(*Store) error
+
+// WithIdGenerator sets the generator for new IDs, e.g., randomIdGenerator.
+func WithIdGenerator(idGenerator func() (string, error)) Option {
+	return func(s *Store) error {
+		if idGenerator == nil {
+			return errors.New("ID generator must not be nil")
+		}
+
+		s.idGenerator = idGenerator
+		return nil
+	}
+}
+
+// WithIdSpace enables failing fast with ErrIDSpaceNearlyFull for new Items if
+// more than the fraction fill of all size possible IDs are in use, instead of
+// slowing down by ID collisions. The size is reported by randomIdSpace or
+// wordlistIdGenerator.
+func WithIdSpace(size float64, fill float64) Option {
+	return func(s *Store) error {
+		if size <= 0 {
+			return errors.New("ID space size must be positive")
+		} else if fill <= 0 || fill > 1 {
+			return errors.New("ID space fill must be within (0, 1]")
+		}
+
+		s.idSpace = size
+		s.idSpaceFill = fill
+		return nil
+	}
+}
+
+// WithDefaultTTL sets the lifetime of new Items without an Expires, e.g.,
+// seven days. Items might still opt out by NoExpiry. Zero disables this,
+// leaving Items without an Expires as immediately expired.
+//
+// Only Put applies the default. The Handler always sets an Expires within its
+// WithLifetimeBounds, being neither affected by the default TTL nor able to
+// request NoExpiry.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(s *Store) error {
+		if ttl < 0 {
+			return errors.New("default TTL must not be negative")
+		}
+
+		s.defaultTTL = ttl
+		return nil
+	}
+}
+
+// WithBlobNaming defines how new Items' files are named, see BlobByChecksum.
+// Existing Items keep their files' names.
+func WithBlobNaming(naming BlobNaming) Option {
+	return func(s *Store) error {
+		if naming != BlobByID && naming != BlobByChecksum {
+			return fmt.Errorf("unknown blob naming %d", naming)
+		}
+
+		s.blobNaming = naming
+		return nil
+	}
+}
+
+// WithVerifyOnDedup compares a new Item's content byte by byte with an
+// existing file of the same checksum before sharing it for BlobByChecksum.
+// On a mismatch, the new Item gets its own file. This guards against
+// collisions of weak algorithms, e.g., set by WithChecksumHash, at the cost of
+// reading the existing file for each duplicate.
+func WithVerifyOnDedup(verify bool) Option {
+	return func(s *Store) error {
+		s.verifyOnDedup = verify
+		return nil
+	}
+}
+
+// WithStrictContentType makes Put refuse Items with a ContentType mismatching
+// their sniffed one by ErrContentTypeMismatch, e.g., HTML claimed to be an
+// image. The tolerance maps sniffed media types to claimed ones being
+// accepted anyway, allowing wildcards like "text/*". A nil tolerance uses
+// DefaultContentTypeTolerance.
+func WithStrictContentType(tolerance map[string][]string) Option {
+	return func(s *Store) error {
+		if tolerance == nil {
+			tolerance = DefaultContentTypeTolerance
+		}
+
+		for sniffed, claims := range tolerance {
+			for _, claim := range claims {
+				if _, err := path.Match(claim, ""); err != nil {
+					return fmt.Errorf("invalid content type tolerance %q for %q: %w", claim, sniffed, err)
+				}
+			}
+		}
+
+		s.contentTypeTolerance = tolerance
+		return nil
+	}
+}
+
+// WithContentCache enables an in-memory LRU cache for the contents of Items
+// up to maxItemSize bytes, e.g., for tiny but frequently downloaded Items.
+// The cache is populated by GetFile and GetWithFile, bounded by maxBytes in
+// total, and invalidated on deletion. Inline Items and Items to be burned are
+// never cached.
+func WithContentCache(maxItemSize, maxBytes int64) Option {
+	return func(s *Store) error {
+		if maxItemSize <= 0 || maxBytes < maxItemSize {
+			return errors.New("content cache requires a positive item size not exceeding its total size")
+		}
+
+		s.contentCache = newContentCache(maxItemSize, maxBytes)
+		return nil
+	}
+}
+
+// WithZipSkipMissing makes StreamZip skip unavailable and password protected
+// Items instead of failing, e.g., for Items expired in the meantime.
+func WithZipSkipMissing(skip bool) Option {
+	return func(s *Store) error {
+		s.zipSkipMissing = skip
+		return nil
+	}
+}
+
+// WithMaxItems limits the amount of Items, failing new ones with
+// ErrItemLimitReached, e.g., against lots of tiny files exhausting inodes.
+// Zero means unlimited.
+//
+// Each insertion reserves its slot beforehand, thus concurrent Puts cannot
+// exceed the limit.
+func WithMaxItems(n int64) Option {
+	return func(s *Store) error {
+		if n < 0 {
+			return errors.New("maximum amount of Items must not be negative")
+		}
+
+		s.maxItems = n
+		return nil
+	}
+}
+
+// WithCompression enables gzip compression for new Items' files.
+func WithCompression(compress bool) Option {
+	return func(s *Store) error {
+		s.compress = compress
+		return nil
+	}
+}
+
+// WithEncryptionKey enables AES-256 encryption for new Items' files. The key
+// must be 32 bytes long and is also required to read encrypted files.
+func WithEncryptionKey(key []byte) Option {
+	return func(s *Store) error {
+		if len(key) != 32 {
+			return fmt.Errorf("encryption key must be 32 bytes, not %d", len(key))
+		}
+
+		s.encryptionKey = key
+		return nil
+	}
+}
+
+// WithChecksumAlgorithm selects a built-in algorithm for new Items' checksums:
+// "sha1", "sha256" (default), "sha512", or "blake2b-256".
+func WithChecksumAlgorithm(algorithm string) Option {
+	return func(s *Store) error {
+		newHash, ok := checksumAlgorithms[algorithm]
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownChecksumAlgorithm, algorithm)
+		}
+
+		s.checksumAlgorithm = algorithm
+		s.checksumHash = newHash
+		return nil
+	}
+}
+
+// WithChecksumHash sets a custom algorithm for new Items' checksums, e.g.,
+// BLAKE3 from an external package. Its name is stored with each Item to
+// verify it later, requiring the same Option.
+func WithChecksumHash(algorithm string, newHash func() hash.Hash) Option {
+	return func(s *Store) error {
+		if algorithm == "" || newHash == nil {
+			return errors.New("checksum algorithm requires both a name and a hash")
+		}
+
+		s.checksumAlgorithm = algorithm
+		s.checksumHash = newHash
+		return nil
+	}
+}
+
+// WithTempDir sets the directory for temporary files while writing Items,
+// instead of the storage directory. It must be on the same file system to
+// allow renaming files, which is verified by NewStore.
+func WithTempDir(dir string) Option {
+	return func(s *Store) error {
+		if dir == "" {
+			return errors.New("temp directory must not be empty")
+		}
+
+		s.tempDir = dir
+		return nil
+	}
+}
+
+// WithUploadTimeout aborts Puts with ErrUploadTimeout if no data was read for
+// this idle timeout. Large uploads still succeed as long as they progress.
+func WithUploadTimeout(timeout time.Duration) Option {
+	return func(s *Store) error {
+		if timeout < 0 {
+			return errors.New("upload timeout must not be negative")
+		}
+
+		s.uploadTimeout = timeout
+		return nil
+	}
+}
+
+// WithUploadExpiry sets the time after which a PartialUpload without a new
+// chunk is abandoned and deleted by the cleanup, by default 24 hours.
+func WithUploadExpiry(expiry time.Duration) Option {
+	return func(s *Store) error {
+		if expiry <= 0 {
+			return errors.New("upload expiry must be positive")
+		}
+
+		s.uploadExpiry = expiry
+		return nil
+	}
+}
+
+// WithThumbnails enables the creation of Thumbnails for new image Items,
+// downscaled to fit within maxWidth and maxHeight pixels. Thumbnails are
+// neither created for password protected or burned Items nor for encrypted
+// Stores.
+func WithThumbnails(maxWidth, maxHeight int) Option {
+	return func(s *Store) error {
+		if maxWidth <= 0 || maxHeight <= 0 {
+			return errors.New("thumbnail dimensions must be positive")
+		}
+
+		s.thumbnailWidth, s.thumbnailHeight = maxWidth, maxHeight
+		return nil
+	}
+}
+
+// WithLastAccess enables updating an Item's LastAccess on each Get and
+// completely read GetFile, e.g., for EvictLRU. As this results in a database
+// write for each access, it is disabled by default.
+func WithLastAccess(lastAccess bool) Option {
+	return func(s *Store) error {
+		s.lastAccess = lastAccess
+		return nil
+	}
+}
+
+// WithCleanup specifies if both a background cleanup job will be launched as
+// well as deleting expired Items after being retrieved, unless another
+// WithExpiryPolicy is set.
+func WithCleanup(autoCleanup bool) Option {
+	return func(s *Store) error {
+		s.cleanup = autoCleanup
+		return nil
+	}
+}
+
+// WithExpiryPolicy sets how expired, but not yet deleted Items are treated on
+// access, independently of the cleanup. By default, expired Items are deleted
+// on access if the cleanup is enabled and ignored otherwise.
+func WithExpiryPolicy(policy ExpiryPolicy) Option {
+	return func(s *Store) error {
+		switch policy {
+		case ExpiryDeleteOnAccess, ExpiryHideOnly, ExpiryIgnore:
+			s.expiryPolicy = policy
+			return nil
+
+		default:
+			return fmt.Errorf("unknown expiry policy %d", policy)
+		}
+	}
+}
+
+// WithCleanupInterval sets the interval of the background cleanup job.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(s *Store) error {
+		if interval <= 0 {
+			return errors.New("cleanup interval must be positive")
+		}
+
+		s.cleanupInterval = interval
+		return nil
+	}
+}
+
+// WithCleanupJitter randomizes each interval of the background cleanup job by
+// up to this fraction in both directions, e.g., 0.1 for ±10%. This avoids
+// simultaneous cleanups of multiple Stores.
+func WithCleanupJitter(jitter float64) Option {
+	return func(s *Store) error {
+		if jitter < 0 || jitter >= 1 {
+			return errors.New("cleanup jitter must be within [0, 1)")
+		}
+
+		s.cleanupJitter = jitter
+		return nil
+	}
+}
+
+// WithExpiryGrace delays the deletion of expired Items by this grace period,
+// e.g., for downloads still being in progress. Within the grace period, an
+// expired Item is already hidden from Get unless using ExpiryIgnore, but is
+// neither deleted by the cleanup nor on access.
+func WithExpiryGrace(grace time.Duration) Option {
+	return func(s *Store) error {
+		if grace < 0 {
+			return errors.New("expiry grace period must not be negative")
+		}
+
+		s.expiryGrace = grace
+		return nil
+	}
+}
+
+// WithNotifyClient replaces the http.Client delivering notifications to
+// Items' NotifyURLs, e.g., for a proxy. The default has a timeout of
+// notifyTimeout for each attempt.
+func WithNotifyClient(client *http.Client) Option {
+	return func(s *Store) error {
+		if client == nil {
+			return errors.New("notify client must not be nil")
+		}
+
+		s.notifyClient = client
+		return nil
+	}
+}
+
+// WithLogger sets the logger for both the Store and its database.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Store) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithLatencyObserver passes the duration of each Put, Get, and Delete to the
+// LatencyObserver, e.g., LatencyHistograms, to spot slow disks.
+func WithLatencyObserver(observer LatencyObserver) Option {
+	return func(s *Store) error {
+		if observer == nil {
+			return errors.New("latency observer must not be nil")
+		}
+
+		s.latencyObserver = observer
+		return nil
+	}
+}
+
+// WithClock replaces the system's clock, used for both expiry checks and the
+// background cleanup job.
+func WithClock(clock Clock) Option {
+	return func(s *Store) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+
+		s.clock = clock
+		return nil
+	}
+}
+
+// WithInlineSize sets the maximum size in bytes for an Item's content to be
+// stored inline within the database instead of as a file. Zero disables this.
+func WithInlineSize(inlineSize int64) Option {
+	return func(s *Store) error {
+		if inlineSize < 0 {
+			return errors.New("inline size must not be negative")
+		}
+
+		s.inlineSize = inlineSize
+		return nil
+	}
+}
+
+// WithMaxConcurrentWrites limits the amount of files being written at the same
+// time, e.g., to protect the disk's throughput. Zero means unlimited.
+func WithMaxConcurrentWrites(n int) Option {
+	return func(s *Store) error {
+		if n < 0 {
+			return errors.New("maximum concurrent writes must not be negative")
+		} else if n == 0 {
+			s.writeSem = nil
+		} else {
+			s.writeSem = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// WithMaxConcurrentReads limits the amount of Items' files being open for
+// reading at the same time, e.g., to stay below the process' file descriptor
+// limit. Further reads fail fast with ErrTooManyOpenFiles instead of waiting,
+// as a slow reader might hold its file for long. Zero means unlimited.
+func WithMaxConcurrentReads(n int) Option {
+	return func(s *Store) error {
+		if n < 0 {
+			return errors.New("maximum concurrent reads must not be negative")
+		} else if n == 0 {
+			s.readSem = nil
+		} else {
+			s.readSem = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// WithMaxFilenameLength truncates Items' Filenames to this length in bytes,
+// keeping their extension, instead of defaultMaxFilenameLength. Zero disables
+// the truncation, while Filenames are sanitized in any case.
+func WithMaxFilenameLength(n int) Option {
+	return func(s *Store) error {
+		if n < 0 {
+			return errors.New("maximum filename length must not be negative")
+		}
+		s.maxFilenameLength = n
+		return nil
+	}
+}
+
+// WithSyncWrites makes each database write durable before returning.
+//
+// By default, badger writes asynchronously, risking the loss of the last
+// writes on a crash for a higher throughput. For files, see WithFileSync.
+func WithSyncWrites(syncWrites bool) Option {
+	return func(s *Store) error {
+		s.syncWrites = syncWrites
+		return nil
+	}
+}
+
+// WithFileSync fsyncs each new file before Put returns, being a shorthand for
+// WithFileSyncPolicy of FileSyncOnClose or FileSyncNever.
+//
+// Without, a file might be incomplete after a crash while its Item still
+// exists. Skipping the fsync is faster, especially for many small files.
+func WithFileSync(fileSync bool) Option {
+	if fileSync {
+		return WithFileSyncPolicy(FileSyncOnClose)
+	}
+	return WithFileSyncPolicy(FileSyncNever)
+}
+
+// WithFileSyncPolicy sets when new files are fsynced, FileSyncNever by
+// default. See FileSync for the durability of each policy.
+func WithFileSyncPolicy(policy FileSync) Option {
+	return func(s *Store) error {
+		switch policy {
+		case FileSyncNever, FileSyncOnClose, FileSyncAlways:
+			s.fileSync = policy
+			return nil
+
+		default:
+			return fmt.Errorf("unknown file sync policy %d", policy)
+		}
+	}
+}
+
+// WithBlockCacheSize sets the size in bytes of badger's block cache, holding
+// recently read blocks of the database in memory. It defaults to badger's
+// 256MiB, while zero disables the cache.
+//
+// The cache is allocated on demand up to its size, so a larger cache trades
+// memory for fewer disk reads, especially after Warm. For small instances, a
+// few MiB are sufficient.
+func WithBlockCacheSize(size int64) Option {
+	return func(s *Store) error {
+		if size < 0 {
+			return errors.New("block cache size must not be negative")
+		}
+
+		s.blockCacheSize = size
+		return nil
+	}
+}
+
+// WithRandomSource replaces crypto/rand as the source of randomness for the
+// default ID generator and the cleanup's jitter, e.g., for deterministic IDs
+// in tests. ID generators passed by WithIdGenerator are not affected.
+//
+// The source must be cryptographically secure for production, as IDs should
+// not be guessable.
+func WithRandomSource(random io.Reader) Option {
+	return func(s *Store) error {
+		if random == nil {
+			return errors.New("random source must not be nil")
+		}
+
+		s.random = random
+		return nil
+	}
+}
+
+// NewStoreLegacy opens or initializes a Store with the former NewStore
+// signature.
+//
+// Deprecated: Use NewStore with WithIdGenerator and WithCleanup instead.
+func NewStoreLegacy(baseDir string, idGenerator func() (string, error), autoCleanup bool) (*Store, error) {
+	return NewStore(baseDir, WithIdGenerator(idGenerator), WithCleanup(autoCleanup))
+}
+
+// dsnSchemePattern matches the scheme of a store URL. It must have at least
+// two characters, as a single letter is rather a Windows drive, e.g., "C:".
+var dsnSchemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]+:`)
+
+// errUnsupportedBackend is returned by OpenStore for URLs of storage backends
+// other than a local directory, e.g., "mem://" or "s3://".
+var errUnsupportedBackend = errors.New("store backend is unsupported until storage backends other than a local directory exist")
+
+// OpenStore opens or initializes a Store like NewStore, but located by a URL,
+// e.g., from a configuration. Currently, only the "file" scheme is supported
+// with an absolute path, e.g., "file:///var/lib/gosh". A plain path without a
+// scheme is passed to NewStore unchanged, e.g., keeping a "#" or "?".
+//
+// The "mem" and "s3" schemes are reserved for future storage backends and
+// result in an error for now.
+func OpenStore(dsn string, opts ...Option) (*Store, error) {
+	if dsn == "" {
+		return nil, errors.New("store URL has no path")
+	} else if !dsnSchemePattern.MatchString(dsn) {
+		return NewStore(dsn, opts...)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse store URL %q: %w", dsn, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("store URL %q has a remote host", dsn)
+		} else if !filepath.IsAbs(u.Path) {
+			return nil, fmt.Errorf("store URL %q has no absolute path", dsn)
+		}
+		return NewStore(filepath.Clean(u.Path), opts...)
+
+	case "mem", "s3":
+		return nil, fmt.Errorf("store URL %q: %w", dsn, errUnsupportedBackend)
+
+	default:
+		return nil, fmt.Errorf("store URL %q has the unsupported scheme %q", dsn, u.Scheme)
+	}
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStoreOptions(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	var logBuff bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuff, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(func() (string, error) { return "static", nil }),
+		WithLogger(logger),
+		WithCleanup(true),
+		WithCleanupInterval(50*time.Millisecond),
+		WithInlineSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if !strings.Contains(logBuff.String(), "Opening Store") {
+		t.Fatalf("Logger was not used: %q", logBuff.String())
+	}
+
+	item := Item{Expires: time.Now().Add(100 * time.Millisecond).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	} else if itemId != "static" {
+		t.Fatalf("ID generator was not used, got ID %q", itemId)
+	}
+
+	if itemX, err := store.Get(itemId); err != nil {
+		t.Fatal(err)
+	} else if string(itemX.Inline) != "hello world" {
+		t.Fatalf("Item was not stored inline: %v", itemX)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, err := store.Count(); err != nil {
+			t.Fatal(err)
+		} else if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expired Item was not cleaned up in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"nil-id-generator", WithIdGenerator(nil)},
+		{"nil-logger", WithLogger(nil)},
+		{"zero-interval", WithCleanupInterval(0)},
+		{"negative-inline-size", WithInlineSize(-1)},
+		{"zero-id-space", WithIdSpace(0, 0.5)},
+		{"overfull-id-space", WithIdSpace(256, 1.5)},
+		{"short-encryption-key", WithEncryptionKey(make([]byte, 16))},
+		{"unknown-expiry-policy", WithExpiryPolicy(ExpiryPolicy(42))},
+		{"negative-cleanup-jitter", WithCleanupJitter(-0.1)},
+		{"full-cleanup-jitter", WithCleanupJitter(1)},
+		{"unknown-checksum-algorithm", WithChecksumAlgorithm("md4")},
+		{"nil-checksum-hash", WithChecksumHash("custom", nil)},
+		{"zero-upload-expiry", WithUploadExpiry(0)},
+		{"zero-thumbnail-width", WithThumbnails(0, 64)},
+		{"negative-block-cache-size", WithBlockCacheSize(-1)},
+		{"negative-max-items", WithMaxItems(-1)},
+		{"nil-latency-observer", WithLatencyObserver(nil)},
+		{"negative-max-concurrent-reads", WithMaxConcurrentReads(-1)},
+		{"negative-default-ttl", WithDefaultTTL(-time.Hour)},
+		{"unknown-blob-naming", WithBlobNaming(BlobNaming(42))},
+		{"unknown-file-sync", WithFileSyncPolicy(FileSync(42))},
+		{"nil-random-source", WithRandomSource(nil)},
+		{"negative-max-filename-length", WithMaxFilenameLength(-1)},
+		{"negative-expiry-grace", WithExpiryGrace(-time.Minute)},
+		{"nil-notify-client", WithNotifyClient(nil)},
+		{"invalid-content-type-tolerance", WithStrictContentType(map[string][]string{"text/plain": {"["}})},
+		{"zero-content-cache", WithContentCache(0, 1024)},
+		{"too-small-content-cache", WithContentCache(1024, 512)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			if store, err := NewStore(storageDir, test.opt); err == nil {
+				_ = store.Close()
+				t.Fatalf("NewStore did not fail")
+			}
+		})
+	}
+}
+
+func TestNewStoreLegacy(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStoreLegacy(storageDir, randomIdGenerator(4), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if store.cleanup {
+		t.Fatalf("NewStoreLegacy ignored autoCleanup")
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	for _, dsn := range []string{"file://" + storageDir, "file://localhost" + storageDir + "/", storageDir} {
+		t.Run(dsn, func(t *testing.T) {
+			store, err := OpenStore(dsn, WithCleanup(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			if store.baseDir != storageDir {
+				t.Fatalf("Store was opened in %q, expected %q", store.baseDir, storageDir)
+			}
+		})
+	}
+
+	// Plain paths are used unchanged, even if they would be URL encoded.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.Chdir(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	for _, dsn := range []string{storageDir + "/gosh#1", storageDir + "/a?b", storageDir + "/a%20b", `C:\data`, "relative"} {
+		t.Run(dsn, func(t *testing.T) {
+			store, err := OpenStore(dsn, WithCleanup(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			if store.baseDir != dsn {
+				t.Fatalf("Store was opened in %q, expected %q", store.baseDir, dsn)
+			} else if _, err := os.Stat(dsn); err != nil {
+				t.Fatalf("Store directory %q was not created: %v", dsn, err)
+			}
+		})
+	}
+
+	for _, dsn := range []string{"", "file:relative", "file://example.org/data", "ftp://example.org/data", "file://%zz"} {
+		t.Run(dsn, func(t *testing.T) {
+			if store, err := OpenStore(dsn, WithCleanup(false)); err == nil {
+				store.Close()
+				t.Fatalf("Store URL %q was accepted", dsn)
+			}
+		})
+	}
+
+	for _, dsn := range []string{"mem://", "s3://bucket/prefix"} {
+		t.Run(dsn, func(t *testing.T) {
+			if store, err := OpenStore(dsn, WithCleanup(false)); err == nil {
+				store.Close()
+				t.Fatalf("Store URL %q was accepted", dsn)
+			} else if !errors.Is(err, errUnsupportedBackend) {
+				t.Fatalf("Store URL %q resulted in %v, expected errUnsupportedBackend", dsn, err)
+			}
+		})
+	}
+}
+
+// trackingReadCloser counts concurrently active readers, reading slowly.
+type trackingReadCloser struct {
+	active, peak *atomic.Int32
+
+	data    *bytes.Buffer
+	started bool
+}
+
+func (trc *trackingReadCloser) Read(p []byte) (int, error) {
+	if !trc.started {
+		trc.started = true
+		n := trc.active.Add(1)
+		for {
+			peak := trc.peak.Load()
+			if n <= peak || trc.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+	return trc.data.Read(p)
+}
+
+func (trc *trackingReadCloser) Close() error {
+	if trc.started {
+		trc.active.Add(-1)
+	}
+	return nil
+}
+
+func TestStoreMaxConcurrentWrites(t *testing.T) {
+	const (
+		limit = 2
+		puts  = 16
+	)
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(8)),
+		WithCleanup(false),
+		WithMaxConcurrentWrites(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var (
+		active, peak atomic.Int32
+		wg           sync.WaitGroup
+	)
+
+	for i := 0; i < puts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			file := &trackingReadCloser{
+				active: &active,
+				peak:   &peak,
+				data:   bytes.NewBuffer(make([]byte, 32*1024)),
+			}
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			if _, err := store.Put(item, file); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := peak.Load(); n > limit {
+		t.Fatalf("Peak of concurrent writes was %d, limit is %d", n, limit)
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != puts {
+		t.Fatalf("Count returned %d, expected %d", n, puts)
+	}
+}
+
+func TestStoreMaxConcurrentWritesContext(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(8)),
+		WithCleanup(false),
+		WithMaxConcurrentWrites(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Occupy the only write slot.
+	if err := store.acquireWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer store.releaseWrite()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	_, err = store.PutContext(ctx, item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PutContext returned %v", err)
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Count returned %d, expected 0", n)
+	}
+}
+
+func TestStoreSyncWrites(t *testing.T) {
+	for _, syncWrites := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sync-%t", syncWrites), func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithCleanup(false),
+				WithSyncWrites(syncWrites),
+				WithFileSync(syncWrites))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			if opts := store.bh.Badger().Opts(); opts.SyncWrites != syncWrites {
+				t.Fatalf("Badger's SyncWrites is %t", opts.SyncWrites)
+			}
+
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if itemX, err := store.Get(itemId); err != nil {
+				t.Fatal(err)
+			} else if itemX.Size != 11 {
+				t.Fatalf("Item has a size of %d", itemX.Size)
+			}
+		})
+	}
+}
+
+func BenchmarkStorePutSync(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, syncWrites := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sync-%t", syncWrites), func(b *testing.B) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithCleanup(false),
+				WithSyncWrites(syncWrites),
+				WithFileSync(syncWrites))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+
+			data := make([]byte, 4096)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+				if _, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// fileSyncPolicies names all FileSync policies for subtests and benchmarks.
+var fileSyncPolicies = []struct {
+	name   string
+	policy FileSync
+}{
+	{"never", FileSyncNever},
+	{"on-close", FileSyncOnClose},
+	{"always", FileSyncAlways},
+}
+
+func TestStoreFileSyncPolicy(t *testing.T) {
+	for _, test := range fileSyncPolicies {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithIdGenerator(randomIdGenerator(4)),
+				WithCleanup(false),
+				WithFileSyncPolicy(test.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			data := []byte("hello world")
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if content, err := os.ReadFile(filepath.Join(store.storageDir(), itemId)); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(content, data) {
+				t.Fatalf("File has content %q", content)
+			}
+
+			if entries, err := os.ReadDir(store.storageDir()); err != nil {
+				t.Fatal(err)
+			} else if len(entries) != 1 {
+				t.Fatalf("Storage directory has %d entries, expected only the file", len(entries))
+			}
+		})
+	}
+}
+
+func BenchmarkStorePutFileSync(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, test := range fileSyncPolicies {
+		b.Run(test.name, func(b *testing.B) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithCleanup(false),
+				WithFileSyncPolicy(test.policy))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+
+			data := make([]byte, 4096)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+				if _, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// stallReader returns its data slowly. Afterwards, it either returns io.EOF
+// or, for stall, blocks until closed.
+type stallReader struct {
+	data     []byte
+	delay    time.Duration
+	stall    bool
+	unblock  chan struct{}
+	closeMtx sync.Mutex
+	closed   bool
+}
+
+func newStallReader(data []byte, delay time.Duration, stall bool) *stallReader {
+	return &stallReader{data: data, delay: delay, stall: stall, unblock: make(chan struct{})}
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	if len(sr.data) == 0 && !sr.stall {
+		return 0, io.EOF
+	} else if len(sr.data) == 0 {
+		<-sr.unblock
+		return 0, os.ErrClosed
+	}
+
+	time.Sleep(sr.delay)
+	n := copy(p[:1], sr.data)
+	sr.data = sr.data[n:]
+	return n, nil
+}
+
+func (sr *stallReader) Close() error {
+	sr.closeMtx.Lock()
+	defer sr.closeMtx.Unlock()
+
+	if !sr.closed {
+		sr.closed = true
+		close(sr.unblock)
+	}
+	return nil
+}
+
+func TestStoreUploadTimeout(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithUploadTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+
+	// A stalling upload must be aborted without leaving anything behind.
+	stalling := newStallReader([]byte("hello"), 0, true)
+	if _, err := store.Put(item, stalling); !errors.Is(err, ErrUploadTimeout) {
+		t.Fatalf("Put of a stalling upload returned %v", err)
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Store has %d Items after a timed out upload", n)
+	}
+	if entries, err := os.ReadDir(store.storageDir()); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatalf("Storage directory has %d files after a timed out upload", len(entries))
+	}
+
+	// A slow, but progressing upload takes longer than the timeout in total.
+	slow := newStallReader([]byte("hello world"), 20*time.Millisecond, false)
+	itemId, err := store.Put(item, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if itemX, err := store.Get(itemId); err != nil {
+		t.Fatal(err)
+	} else if itemX.Size != 11 {
+		t.Fatalf("Slow upload resulted in %d bytes", itemX.Size)
+	}
+}
+
+func TestStoreTempDir(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	tempDir := filepath.Join(storageDir, "tmp")
+	if err := os.Mkdir(tempDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithTempDir(tempDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.storageDir(), itemId)); err != nil {
+		t.Fatalf("Item's file was not moved into the storage directory: %v", err)
+	} else if entries, err := os.ReadDir(tempDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatalf("Temp directory still contains %d files", len(entries))
+	}
+}
+
+func TestStoreTempDirOtherFilesystem(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// /dev/shm is commonly a tmpfs, but might be on the same file system.
+	tempDir, err := os.MkdirTemp("/dev/shm", "tmp")
+	if err != nil {
+		t.Skipf("Cannot create temp directory in /dev/shm: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var tempStat, storageStat unix.Stat_t
+	if err := unix.Stat(tempDir, &tempStat); err != nil {
+		t.Fatal(err)
+	} else if err := unix.Stat(storageDir, &storageStat); err != nil {
+		t.Fatal(err)
+	} else if tempStat.Dev == storageStat.Dev {
+		t.Skip("/dev/shm is on the same file system as the storage")
+	}
+
+	store, err := NewStore(storageDir, WithCleanup(false), WithTempDir(tempDir))
+	if err == nil {
+		_ = store.Close()
+		t.Fatalf("NewStore accepted a temp directory on another file system")
+	} else if !strings.Contains(err.Error(), "same file system") {
+		t.Fatalf("NewStore returned %v", err)
+	}
+}
+
+func TestStoreExpiryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ExpiryPolicy
+		found   bool
+		deleted bool
+	}{
+		{"delete-on-access", ExpiryDeleteOnAccess, false, true},
+		{"hide-only", ExpiryHideOnly, false, false},
+		{"ignore", ExpiryIgnore, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+			store, err := NewStore(storageDir,
+				WithIdGenerator(randomIdGenerator(4)),
+				WithCleanup(false),
+				WithClock(clock),
+				WithExpiryPolicy(test.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			item := Item{Expires: clock.Now().Add(time.Minute)}
+			itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clock.Advance(time.Hour)
+
+			if _, err := store.Get(itemId); test.found && err != nil {
+				t.Fatalf("Get of expired Item failed: %v", err)
+			} else if !test.found && err != ErrNotFound {
+				t.Fatalf("Get of expired Item returned %v", err)
+			}
+
+			if f, err := store.GetFile(itemId); test.found && err != nil {
+				t.Fatalf("GetFile of expired Item failed: %v", err)
+			} else if !test.found && err != ErrNotFound {
+				t.Fatalf("GetFile of expired Item returned %v", err)
+			} else if f != nil {
+				f.Close()
+			}
+
+			if n, err := store.Count(); err != nil {
+				t.Fatal(err)
+			} else if deleted := n == 0; deleted != test.deleted {
+				t.Fatalf("Expired Item deleted: %t, expected %t", deleted, test.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/crypto/argon2"
+)
+
+// ItemKeySize is the size of a client supplied key for Store.PutWithKey,
+// selecting AES-256.
+const ItemKeySize = 32
+
+// The Argon2id parameters to derive an Item's key from a passphrase, following
+// the recommendation of RFC 9106. As they are not stored, changing them makes
+// existing Items unrecoverable.
+const (
+	passphraseSaltSize = 16
+	argon2Time         = 1
+	argon2Memory       = 64 * 1024
+	argon2Threads      = 4
+)
+
+// keyCheckLabel is authenticated by an Item's key to be stored as its
+// KeyCheck.
+var keyCheckLabel = []byte("gosh item key check")
+
+// derivePassphraseKey derives an Item's key from a passphrase by Argon2id.
+func derivePassphraseKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, ItemKeySize)
+}
+
+// itemKeyCheck calculates an Item's KeyCheck as an HMAC, not allowing to
+// recover the key itself.
+func itemKeyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(keyCheckLabel)
+	return mac.Sum(nil)
+}
+
+// PutWithKey works like Put, but encrypts the content by a client supplied key
+// of ItemKeySize bytes with AES-256-CTR before it reaches the Store. Only the
+// encrypted content and the Item's KeyCheck are stored, not the key. Thus,
+// the content is unrecoverable without the key, e.g., for zero-knowledge
+// sharing. The Item's metadata, e.g., its Filename, stays unencrypted.
+//
+// As the file is encrypted before being stored, the Item's Size and Checksum
+// belong to the encrypted content and its ContentType is not sniffed.
+//
+// The content can be retrieved by GetFileWithKey. GetFile and the like return
+// the encrypted content, allowing clients to decrypt it on their own.
+func (s *Store) PutWithKey(i Item, file io.ReadCloser, key []byte) (id string, err error) {
+	if len(key) != ItemKeySize {
+		_ = file.Close()
+		return "", fmt.Errorf("item key must have %d bytes, not %d", ItemKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		_ = file.Close()
+		return "", err
+	}
+
+	iv := make([]byte, block.BlockSize())
+	_, err = io.ReadFull(s.random, iv)
+	if err != nil {
+		_ = file.Close()
+		return "", err
+	}
+
+	i.KeyCheck = itemKeyCheck(key)
+	if i.ContentType == "" {
+		i.ContentType = "application/octet-stream"
+	}
+
+	encrypted := io.MultiReader(bytes.NewReader(iv), cipher.StreamReader{S: cipher.NewCTR(block, iv), R: file})
+	return s.Put(i, pipelineReader{encrypted, []io.Closer{file}})
+}
+
+// PutWithPassphrase works like PutWithKey, but derives the key from a
+// passphrase by Argon2id with a random salt, being stored as the Item's
+// KeySalt. The content can be retrieved by GetFileWithPassphrase.
+func (s *Store) PutWithPassphrase(i Item, file io.ReadCloser, passphrase string) (id string, err error) {
+	i.KeySalt = make([]byte, passphraseSaltSize)
+	_, err = io.ReadFull(s.random, i.KeySalt)
+	if err != nil {
+		_ = file.Close()
+		return "", err
+	}
+
+	return s.PutWithKey(i, file, derivePassphraseKey(passphrase, i.KeySalt))
+}
+
+// GetFileWithKey works like GetFile, but decrypts the content of an Item
+// stored by PutWithKey. For a wrong key or an Item not being encrypted by a
+// client supplied key, ErrUnauthorized is returned.
+func (s *Store) GetFileWithKey(id string, key []byte) (io.ReadCloser, error) {
+	i, err := s.getClientEncrypted(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.openWithKey(i, key)
+}
+
+// GetFileWithPassphrase works like GetFileWithKey for an Item stored by
+// PutWithPassphrase.
+func (s *Store) GetFileWithPassphrase(id, passphrase string) (io.ReadCloser, error) {
+	i, err := s.getClientEncrypted(id)
+	if err != nil {
+		return nil, err
+	} else if len(i.KeySalt) == 0 {
+		s.logger.Warn("Denied access to Item without a passphrase", slog.String("id", id))
+		return nil, ErrUnauthorized
+	}
+	return s.openWithKey(i, derivePassphraseKey(passphrase, i.KeySalt))
+}
+
+// getClientEncrypted fetches an available Item encrypted by a client supplied
+// key.
+func (s *Store) getClientEncrypted(id string) (Item, error) {
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return Item{}, ErrNotFound
+	} else if err != nil {
+		return Item{}, err
+	}
+
+	err = s.available(i)
+	if err != nil {
+		return Item{}, err
+	} else if !i.ClientEncrypted() {
+		s.logger.Warn("Denied access by key to an Item not being encrypted by a key", slog.String("id", id))
+		return Item{}, ErrUnauthorized
+	}
+	return i, nil
+}
+
+// openWithKey opens an Item's content for download after verifying the key
+// against its KeyCheck, and decrypts it.
+func (s *Store) openWithKey(i Item, key []byte) (io.ReadCloser, error) {
+	if len(key) != ItemKeySize || !hmac.Equal(itemKeyCheck(key), i.KeyCheck) {
+		s.logger.Warn("Denied access to Item for a wrong key", slog.String("id", i.ID))
+		return nil, ErrUnauthorized
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.openDownload(i, s.openContent)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, block.BlockSize())
+	_, err = io.ReadFull(f, iv)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return pipelineReader{cipher.StreamReader{S: cipher.NewCTR(block, iv), R: f}, []io.Closer{f}}, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestStorePassphrase(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const passphrase = "correct horse battery staple"
+	data := bytes.Repeat([]byte("confidential "), 1024)
+
+	id, err := store.PutWithPassphrase(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBuffer(data)), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFileWithPassphrase(id, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(content, data) {
+		t.Fatalf("Decrypted content differs from the original one")
+	}
+
+	if _, err := store.GetFileWithPassphrase(id, "wrong"); err != ErrUnauthorized {
+		t.Fatalf("Wrong passphrase resulted in %v", err)
+	} else if _, err := store.GetFileWithKey(id, make([]byte, ItemKeySize)); err != ErrUnauthorized {
+		t.Fatalf("Wrong key resulted in %v", err)
+	}
+
+	item, err := store.Peek(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if !item.ClientEncrypted() || len(item.KeySalt) != passphraseSaltSize {
+		t.Fatalf("Item lacks its key metadata: %+v", item)
+	}
+	key := derivePassphraseKey(passphrase, item.KeySalt)
+
+	// Neither the database nor any file contains the key, the passphrase, or
+	// the plain content.
+	secrets := [][]byte{key, []byte(passphrase), data[:64]}
+	err = store.BadgerHold().Badger().View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			for _, secret := range secrets {
+				if bytes.Contains(val, secret) {
+					t.Errorf("Database entry %q contains a secret", it.Item().Key())
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.WalkDir(storageDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, secret := range secrets {
+			if bytes.Contains(content, secret) {
+				t.Errorf("File %q contains a secret", path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorePutWithKey(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.PutWithKey(Item{}, newDummyReadCloser(bytes.NewBufferString("hello world")), []byte("short")); err == nil {
+		t.Fatalf("Too short key was accepted")
+	}
+
+	key := bytes.Repeat([]byte{0x23}, ItemKeySize)
+	id, err := store.PutWithKey(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("hello world")), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFileWithKey(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "hello world" {
+		t.Fatalf("Item has content %q", content)
+	}
+
+	// Without the key, only the encrypted content is accessible.
+	f, err = store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(content, []byte("hello world")) {
+		t.Fatalf("Item's content is stored in plain")
+	}
+
+	if _, err := store.GetFileWithPassphrase(id, ""); err != ErrUnauthorized {
+		t.Fatalf("Passphrase for a raw key's Item resulted in %v", err)
+	}
+}
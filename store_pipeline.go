@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNoEncryptionKey is returned when reading an encrypted Item from a Store
+// without an encryption key.
+var ErrNoEncryptionKey = errors.New("Item is encrypted, but no key is configured")
+
+// The content of an Item's file passes a pipeline of transformations, all
+// being defined here to apply them in the same order for each access:
+//
+//	plain content -> gzip compression -> AES-256-CTR encryption -> file
+//
+// Reading reverses this order. Which transformations were applied is recorded
+// in the Item's flags, allowing to change the configuration later on.
+
+// writeContent writes an Item's content, consisting of the already read prefix
+// and the remaining src, into dst while applying the configured
+// transformations. The plain content is also written into h. The returned
+// size is the one of the plain content.
+func (s *Store) writeContent(dst *os.File, i *Item, prefix []byte, src io.Reader, h io.Writer) (int64, error) {
+	if !s.compress && s.encryptionKey == nil {
+		_, err := io.MultiWriter(dst, h).Write(prefix)
+		if err != nil {
+			return 0, err
+		}
+
+		written, err := s.copyItemFile(dst, src, h)
+		return int64(len(prefix)) + written, err
+	}
+
+	w, err := s.newContentWriter(dst, i)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(io.MultiWriter(w, h), io.MultiReader(bytes.NewReader(prefix), src))
+	if err != nil {
+		_ = w.Close()
+		return written, err
+	}
+	return written, w.Close()
+}
+
+// newContentWriter returns a writer applying the configured transformations
+// before writing into dst and sets the Item's flags accordingly. Closing the
+// writer flushes all transformations, but does not close dst.
+func (s *Store) newContentWriter(dst io.Writer, i *Item) (io.WriteCloser, error) {
+	var w io.Writer = dst
+	var closers []io.Closer
+
+	if s.encryptionKey != nil {
+		block, err := aes.NewCipher(s.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		iv := make([]byte, block.BlockSize())
+		_, err = rand.Read(iv)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(iv)
+		if err != nil {
+			return nil, err
+		}
+
+		w = cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: w}
+		i.Encrypted = true
+	}
+
+	if s.compress {
+		gz := gzip.NewWriter(w)
+		w = gz
+		closers = append(closers, gz)
+		i.Compressed = true
+	}
+
+	return pipelineWriter{w, closers}, nil
+}
+
+// newContentReader returns a reader reversing the transformations recorded
+// in the Item's flags while reading from src. Closing it closes src.
+func (s *Store) newContentReader(src io.ReadCloser, i Item) (io.ReadCloser, error) {
+	r, err := s.newDecryptingReader(src, i)
+	if err != nil || !i.Compressed {
+		return r, err
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return pipelineReader{gz, []io.Closer{gz, r}}, nil
+}
+
+// newDecryptingReader works like newContentReader, but only reverses the
+// encryption. Thus, a compressed Item's content is returned as a gzip stream.
+func (s *Store) newDecryptingReader(src io.ReadCloser, i Item) (io.ReadCloser, error) {
+	if !i.Encrypted {
+		return src, nil
+	}
+
+	if s.encryptionKey == nil {
+		_ = src.Close()
+		return nil, ErrNoEncryptionKey
+	}
+
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+
+	iv := make([]byte, block.BlockSize())
+	_, err = io.ReadFull(src, iv)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+
+	return pipelineReader{cipher.StreamReader{S: cipher.NewCTR(block, iv), R: src}, []io.Closer{src}}, nil
+}
+
+// pipelineWriter is an io.WriteCloser closing all its closers in order.
+type pipelineWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (w pipelineWriter) Close() error {
+	return closeAll(w.closers)
+}
+
+// pipelineReader is an io.ReadCloser closing all its closers in order.
+type pipelineReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r pipelineReader) Close() error {
+	return closeAll(r.closers)
+}
+
+// closeAll closes each Closer, even after an error, returning all errors.
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorePipeline(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	opts := []Option{
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithCompression(true),
+		WithEncryptionKey(key),
+	}
+
+	store, err := NewStore(storageDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("hello world, "), 1024)
+	itemId, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBuffer(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := store.Get(itemId)
+	if err != nil {
+		t.Fatal(err)
+	} else if !item.Compressed || !item.Encrypted {
+		t.Fatalf("Item is not flagged as compressed and encrypted: %v", item)
+	} else if item.Size != int64(len(data)) || item.Checksum != checksum(data) {
+		t.Fatalf("Item's size and checksum do not describe the plain content")
+	}
+
+	// The file must be compressed first and encrypted afterwards.
+	raw, err := os.ReadFile(filepath.Join(store.storageDir(), itemId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := make([]byte, len(raw)-aes.BlockSize)
+	cipher.NewCTR(block, raw[:aes.BlockSize]).XORKeyStream(compressed, raw[aes.BlockSize:])
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Decrypted file is not gzip compressed: %v", err)
+	}
+	if plain, err := io.ReadAll(gz); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plain, data) {
+		t.Fatalf("Decrypted and decompressed file mismatches")
+	}
+
+	f, err := store.GetFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buff, data) {
+		t.Fatalf("GetFile returned mismatching data")
+	}
+
+	var streamed bytes.Buffer
+	if n, err := store.StreamTo(itemId, &streamed); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(data)) || !bytes.Equal(streamed.Bytes(), data) {
+		t.Fatalf("StreamTo returned mismatching data of %d bytes", n)
+	}
+
+	if err := store.Verify(itemId); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the key, the Item cannot be read anymore.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.GetFile(itemId); err != ErrNoEncryptionKey {
+		t.Fatalf("GetFile without a key returned %v", err)
+	}
+}
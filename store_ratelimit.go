@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for a request refused by a RateLimiter, e.g., by
+// a Client for an upload.
+var ErrRateLimited = errors.New("Too many requests, rate limit exceeded")
+
+// RateLimiter limits requests per client, identified by a key such as its IP
+// address. It is used by the Store's Handler for uploads, WithRateLimiter.
+//
+// TokenBucketLimiter is an in-memory implementation. Others, e.g., shared
+// between multiple instances by Redis, might be used instead.
+type RateLimiter interface {
+	// Allow reports if the client might perform another request. Otherwise,
+	// retryAfter estimates when the next request will be allowed.
+	Allow(key string) (ok bool, retryAfter time.Duration)
+}
+
+// TokenBucketLimiter is an in-memory RateLimiter with one token bucket per
+// key. Each bucket holds up to burst tokens and is refilled by rate tokens per
+// second, while each request takes one token.
+type TokenBucketLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the state of one key within a TokenBucketLimiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter creates a TokenBucketLimiter for rate requests per
+// second with bursts up to burst requests. A nil clock uses the system's clock.
+func NewTokenBucketLimiter(rate float64, burst int, clock Clock) (*TokenBucketLimiter, error) {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil, errors.New("rate limit must be positive")
+	} else if burst < 1 {
+		return nil, errors.New("rate limit burst must be at least one")
+	}
+
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &TokenBucketLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		clock:     clock,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: clock.Now(),
+	}, nil
+}
+
+// refillDuration is the time for an empty bucket to become full again.
+func (l *TokenBucketLimiter) refillDuration() time.Duration {
+	return time.Duration(l.burst / l.rate * float64(time.Second))
+}
+
+// Allow takes a token from the key's bucket, if available.
+func (l *TokenBucketLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = min(l.burst, bucket.tokens+max(0, elapsed)*l.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		missing := (1 - bucket.tokens) / l.rate
+		return false, time.Duration(math.Ceil(missing * float64(time.Second)))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep removes buckets which would have been refilled completely, being
+// indistinguishable from new ones. This happens at most once per refill
+// duration to keep the amount of buckets bounded by the active clients.
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	refill := l.refillDuration()
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
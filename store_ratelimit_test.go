@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	limiter, err := NewTokenBucketLimiter(0.5, 3, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("Request %d within the burst was denied", i)
+		}
+	}
+
+	ok, retryAfter := limiter.Allow("a")
+	if ok {
+		t.Fatal("Request after the burst was allowed")
+	} else if retryAfter != 2*time.Second {
+		t.Fatalf("Retry after %v, expected 2s", retryAfter)
+	}
+
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Fatal("Request of another key was denied")
+	}
+
+	clock.Advance(2 * time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Fatal("Request after refill was denied")
+	} else if ok, _ := limiter.Allow("a"); ok {
+		t.Fatal("Second request after refilling one token was allowed")
+	}
+
+	// Completely refilled buckets are swept.
+	clock.Advance(time.Minute)
+	if ok, _ := limiter.Allow("c"); !ok {
+		t.Fatal("Request of new key was denied")
+	} else if len(limiter.buckets) != 1 {
+		t.Fatalf("Limiter has %d buckets, expected 1", len(limiter.buckets))
+	}
+}
+
+func TestTokenBucketLimiterInvalid(t *testing.T) {
+	for _, test := range []struct {
+		rate  float64
+		burst int
+	}{
+		{0, 1},
+		{-1, 1},
+		{1, 0},
+	} {
+		if _, err := NewTokenBucketLimiter(test.rate, test.burst, nil); err == nil {
+			t.Fatalf("Rate %v and burst %d were accepted", test.rate, test.burst)
+		}
+	}
+}
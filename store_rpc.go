@@ -72,6 +72,12 @@ type StoreRpcServer struct {
 
 	store     *Store
 	rpcServer *rpc.Server
+
+	// downloads are the IDs of Items whose files were sent, awaiting the
+	// client's DownloadAck, keyed by their token.
+	downloadsMutex sync.Mutex
+	downloads      map[uint64]string
+	downloadsNext  uint64
 }
 
 // NewStoreRpcServer creates a StoreRpcServer which directly starts listening
@@ -83,6 +89,8 @@ func NewStoreRpcServer(store *Store, rpcConn, fdConn *net.UnixConn) *StoreRpcSer
 
 		store:     store,
 		rpcServer: rpc.NewServer(),
+
+		downloads: make(map[uint64]string),
 	}
 
 	_ = server.rpcServer.Register(server)
@@ -132,6 +140,21 @@ func (client *StoreRpcClient) call(method string, args interface{}, reply interf
 	}
 }
 
+// rpcError restores known error values from the Store as the original error
+// type gets lost over net/rpc.
+func rpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, knownErr := range []error{ErrNotFound, ErrIDSpaceNearlyFull, ErrUnauthorized, ErrSlugTaken, ErrInvalidSlug, ErrUploadTimeout, ErrLocked} {
+		if err.Error() == knownErr.Error() {
+			return knownErr
+		}
+	}
+	return err
+}
+
 // Close this StoreRpcClient and all its connections.
 func (client *StoreRpcClient) Close() error {
 	_ = client.rpcClient.Close()
@@ -154,39 +177,199 @@ func (server *StoreRpcServer) Get(id string, item *Item) error {
 func (client *StoreRpcClient) Get(id string, ctx context.Context) (Item, error) {
 	var item Item
 	err := client.call("Get", id, &item, ctx)
+	return item, rpcError(err)
+}
 
-	// The original error type gets lost..
-	if err != nil && err.Error() == "No Item found for this ID" {
-		err = ErrNotFound
+// GetFile wraps Store.GetFile and sends a FD for the file back. The reply is
+// the token for the client's DownloadAck.
+//
+// A plain file is sent directly. Otherwise, e.g., for inline or compressed
+// Items, the data will be written into a pipe2(2) and its reading end will be
+// sent instead. In both cases, the download is only recorded after the client
+// acknowledged reading the file completely.
+func (server *StoreRpcServer) GetFile(id string, token *uint64) error {
+	f, err := server.store.GetFile(id)
+	if err != nil {
+		return err
 	}
 
-	return item, err
+	return server.sendFile(f, token)
 }
 
-// GetFile wraps Store.GetFile and sends a FD for the file back.
-func (server *StoreRpcServer) GetFile(id string, _ *int) error {
-	f, err := server.store.GetFile(id)
+// sendFile sends a FD for the file back, directly or through a pipe2(2), and
+// returns the token of its pending download.
+//
+// The Store's downloadReader is bypassed, as the file being read completely
+// by this server, e.g., into the pipe, does not mean that the client did.
+func (server *StoreRpcServer) sendFile(f io.ReadCloser, token *uint64) error {
+	download, ok := f.(*downloadReader)
+	if !ok {
+		_ = f.Close()
+		return fmt.Errorf("cannot send %T as a download", f)
+	}
+	content := download.ReadCloser
+
+	if tracked, ok := content.(*inFlightReader); ok {
+		if fsFile, ok := tracked.ReadCloser.(*os.File); ok {
+			err := sendFd(fsFile, server.fdConn)
+			_ = content.Close()
+			if err != nil {
+				return err
+			}
+			*token = server.addDownload(download.id)
+			return nil
+		}
+	}
+
+	dataReader, dataWriter, err := pipe2()
 	if err != nil {
+		_ = content.Close()
 		return err
 	}
-	defer f.Close()
+	defer dataReader.Close()
 
-	err = sendFd(f, server.fdConn)
+	go func() {
+		_, _ = io.Copy(dataWriter, content)
+		_ = dataWriter.Close()
+		_ = content.Close()
+	}()
+
+	err = sendFd(dataReader, server.fdConn)
 	if err != nil {
 		return err
 	}
+	*token = server.addDownload(download.id)
+	return nil
+}
 
+// addDownload registers a sent file of an Item, returning its token.
+func (server *StoreRpcServer) addDownload(id string) uint64 {
+	server.downloadsMutex.Lock()
+	defer server.downloadsMutex.Unlock()
+
+	server.downloadsNext++
+	server.downloads[server.downloadsNext] = id
+	return server.downloadsNext
+}
+
+// DownloadAck is sent by the client after closing a file received by
+// GetFile or GetWithFile. Only a Complete read counts as a download.
+type DownloadAck struct {
+	Token    uint64
+	Complete bool
+}
+
+// AckDownload ends a pending download, recording it if the client read the
+// file completely. Unknown tokens are ignored.
+func (server *StoreRpcServer) AckDownload(ack DownloadAck, _ *int) error {
+	server.downloadsMutex.Lock()
+	id, ok := server.downloads[ack.Token]
+	delete(server.downloads, ack.Token)
+	server.downloadsMutex.Unlock()
+
+	if ok && ack.Complete {
+		server.store.recordDownload(id)
+	}
 	return nil
 }
 
-// GetFile returns an *os.File for the requested ID from the server.
-func (client *StoreRpcClient) GetFile(id string, ctx context.Context) (*os.File, error) {
-	err := client.call("GetFile", id, nil, ctx)
+// rpcDownload is a file received from the StoreRpcServer. On Close, the
+// download is acknowledged, being complete if the file was read until io.EOF.
+//
+// The *os.File is not embedded, as its WriteTo would bypass Read.
+type rpcDownload struct {
+	f      *os.File
+	client *StoreRpcClient
+	token  uint64
+	eof    bool
+	once   sync.Once
+}
+
+func (d *rpcDownload) Read(p []byte) (n int, err error) {
+	n, err = d.f.Read(p)
+	if err == io.EOF {
+		d.eof = true
+	}
+	return
+}
+
+func (d *rpcDownload) Close() error {
+	err := d.f.Close()
+	d.once.Do(func() {
+		ack := DownloadAck{Token: d.token, Complete: d.eof}
+		if ackErr := d.client.call("AckDownload", ack, nil, context.Background()); err == nil {
+			err = ackErr
+		}
+	})
+	return err
+}
+
+// GetFile returns the content for the requested ID from the server. It must
+// be closed, counting as a download if it was read completely.
+func (client *StoreRpcClient) GetFile(id string, ctx context.Context) (io.ReadCloser, error) {
+	var token uint64
+	err := client.call("GetFile", id, &token, ctx)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	f, err := recvFd(client.fdConn)
 	if err != nil {
 		return nil, err
 	}
+	return &rpcDownload{f: f, client: client, token: token}, nil
+}
+
+// GetWithFileRequest are the arguments of StoreRpcServer.GetWithFile. The
+// Password is only required for password protected Items.
+type GetWithFileRequest struct {
+	ID       string
+	Password string
+}
+
+// GetWithFileReply is the reply of StoreRpcServer.GetWithFile, being the Item
+// and the token for the client's DownloadAck.
+type GetWithFileReply struct {
+	Item  Item
+	Token uint64
+}
+
+// GetWithFile wraps Store.GetWithFileAndPassword, returning the Item as the
+// reply and sending a FD for the file back, similar to GetFile.
+func (server *StoreRpcServer) GetWithFile(req GetWithFileRequest, reply *GetWithFileReply) error {
+	i, f, err := server.store.GetWithFileAndPassword(req.ID, req.Password)
+	if err != nil {
+		return err
+	}
 
-	return recvFd(client.fdConn)
+	err = server.sendFile(f, &reply.Token)
+	if err != nil {
+		return err
+	}
+	reply.Item = i
+	return nil
+}
+
+// GetWithFile returns both an Item and its content for the requested ID from
+// the server, similar to GetFile.
+func (client *StoreRpcClient) GetWithFile(id string, ctx context.Context) (Item, io.ReadCloser, error) {
+	return client.GetWithFileAndPassword(id, "", ctx)
+}
+
+// GetWithFileAndPassword works like GetWithFile, but passes the password for
+// password protected Items. A missing or wrong one results in ErrUnauthorized.
+func (client *StoreRpcClient) GetWithFileAndPassword(id, password string, ctx context.Context) (Item, io.ReadCloser, error) {
+	var reply GetWithFileReply
+	err := client.call("GetWithFile", GetWithFileRequest{ID: id, Password: password}, &reply, ctx)
+	if err != nil {
+		return Item{}, nil, rpcError(err)
+	}
+
+	f, err := recvFd(client.fdConn)
+	if err != nil {
+		return Item{}, nil, err
+	}
+	return reply.Item, &rpcDownload{f: f, client: client, token: reply.Token}, nil
 }
 
 // Put wraps Store.Put but reads the input data from a pipe2(2).
@@ -287,5 +470,5 @@ func (server *StoreRpcServer) Delete(id string, _ *int) error {
 
 // Delete both an Item as well as its file from the server.
 func (client *StoreRpcClient) Delete(id string, ctx context.Context) error {
-	return client.call("Delete", id, nil, ctx)
+	return rpcError(client.call("Delete", id, nil, ctx))
 }
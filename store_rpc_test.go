@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"io"
+	"net/http"
 	"os"
 	"reflect"
 	"testing"
@@ -24,6 +25,10 @@ func testStoreRpcSessionGet(t *testing.T, server *StoreRpcServer, client *StoreR
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
@@ -47,6 +52,10 @@ func testStoreRpcSessionGetFile(t *testing.T, server *StoreRpcServer, client *St
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
@@ -73,9 +82,159 @@ func testStoreRpcSessionGetFile(t *testing.T, server *StoreRpcServer, client *St
 	}
 }
 
+// testStoreRpcSessionGetFileInline tests GetFile for an inline Item, which
+// will be passed through a pipe instead of an opened file.
+func testStoreRpcSessionGetFileInline(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	server.store.inlineSize = 64
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemDataRaw := []byte("hello world")
+	itemData := newDummyReadCloser(bytes.NewBuffer(itemDataRaw))
+
+	itemId, err := client.Put(item, itemData, context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if f, err := client.GetFile(itemId, context.Background()); err != nil {
+		t.Error(err)
+	} else {
+		buff, err := io.ReadAll(f)
+		if err != nil {
+			t.Error(err)
+		}
+		f.Close()
+
+		if !bytes.Equal(itemDataRaw, buff) {
+			t.Errorf("Store data mismatch: %v != %v", itemDataRaw, buff)
+		}
+	}
+
+	if _, err := client.GetFile("whatever", context.Background()); err != ErrNotFound {
+		t.Error(err)
+	}
+}
+
 // testStoreRpcSessionPut tests Put'ing a new Item of the given size to the Store.
 //
 // It builds on top of testStoreRpcSessionGetFile - duplicate code ahoy!
+func testStoreRpcSessionGetWithFile(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemDataRaw := []byte("hello world")
+	itemData := newDummyReadCloser(bytes.NewBuffer(itemDataRaw))
+
+	itemId, err := server.store.Put(item, itemData)
+	if err != nil {
+		t.Error(err)
+	}
+	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
+
+	itemX, f, err := client.GetWithFile(itemId, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if !reflect.DeepEqual(item, itemX) {
+		t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
+	}
+
+	buff, err := io.ReadAll(f)
+	if err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(itemDataRaw, buff) {
+		t.Errorf("Store data mismatch: %v != %v", itemDataRaw, buff)
+	}
+
+	if _, _, err := client.GetWithFile("nope", context.Background()); err != ErrNotFound {
+		t.Errorf("GetWithFile of unknown ID returned %v", err)
+	}
+}
+
+func testStoreRpcSessionGetWithFilePassword(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := server.store.PutWithPassword(item, newDummyReadCloser(bytes.NewBufferString("secret")), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := client.GetWithFile(itemId, context.Background()); err != ErrUnauthorized {
+		t.Fatalf("GetWithFile of a protected Item returned %v", err)
+	} else if _, _, err := client.GetWithFileAndPassword(itemId, "hunter3", context.Background()); err != ErrUnauthorized {
+		t.Fatalf("GetWithFileAndPassword with a wrong password returned %v", err)
+	} else if _, err := client.GetFile(itemId, context.Background()); err != ErrUnauthorized {
+		t.Fatalf("GetFile of a protected Item returned %v", err)
+	}
+
+	_, f, err := client.GetWithFileAndPassword(itemId, "hunter2", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if buff, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != "secret" {
+		t.Fatalf("GetWithFileAndPassword returned %q", buff)
+	}
+}
+
+// testStoreRpcSessionGetFileAborted checks that only files read completely by
+// the client are counted as downloads, both for plain files sent directly and
+// for inline Items sent through a pipe.
+func testStoreRpcSessionGetFileAborted(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	server.store.inlineSize = 64
+
+	for name, size := range map[string]int{"inline": 16, "file": 4096} {
+		itemId, err := server.store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()},
+			newDummyReadCloser(bytes.NewBuffer(make([]byte, size))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertDownloads := func(expected int64) {
+			t.Helper()
+			if item, err := server.store.Peek(itemId); err != nil {
+				t.Fatal(err)
+			} else if item.Downloads != expected {
+				t.Fatalf("%s Item has %d downloads, expected %d", name, item.Downloads, expected)
+			}
+		}
+
+		f, err := client.GetFile(itemId, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertDownloads(0)
+
+		_, f, err = client.GetWithFile(itemId, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertDownloads(0)
+
+		f, err = client.GetFile(itemId, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := io.Copy(io.Discard, f); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertDownloads(1)
+	}
+}
+
 func testStoreRpcSessionPut(size int) func(*testing.T, *StoreRpcServer, *StoreRpcClient) {
 	return func(t *testing.T, _ *StoreRpcServer, client *StoreRpcClient) {
 		itemDataRaw := make([]byte, size)
@@ -93,6 +252,10 @@ func testStoreRpcSessionPut(size int) func(*testing.T, *StoreRpcServer, *StoreRp
 			t.Error(err)
 		}
 		item.ID = itemId
+		item.Size = int64(len(itemDataRaw))
+		item.Checksum = checksum(itemDataRaw)
+		item.ChecksumAlgorithm = defaultChecksumAlgorithm
+		item.ContentType = http.DetectContentType(itemDataRaw)
 
 		itemX, err := client.Get(itemId, context.Background())
 		if err != nil {
@@ -133,6 +296,10 @@ func testStoreRpcSessionDelete(t *testing.T, server *StoreRpcServer, client *Sto
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
@@ -167,6 +334,10 @@ func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *St
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
 
 	if itemX, err := client.Get(itemId, context.Background()); err != nil {
 		t.Error(err)
@@ -201,7 +372,7 @@ func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *St
 		t.Error(err)
 	}
 
-	if err := server.store.deleteExpired(); err != nil {
+	if _, err := server.store.deleteExpired(); err != nil {
 		t.Error(err)
 	} else if _, err := client.Get(item.ID, context.Background()); err != ErrNotFound {
 		t.Error(err)
@@ -215,6 +386,10 @@ func TestStoreRpcSession(t *testing.T) {
 	}{
 		{"Get", testStoreRpcSessionGet},
 		{"GetFile", testStoreRpcSessionGetFile},
+		{"GetFile-inline", testStoreRpcSessionGetFileInline},
+		{"GetFile-aborted", testStoreRpcSessionGetFileAborted},
+		{"GetWithFile", testStoreRpcSessionGetWithFile},
+		{"GetWithFile-password", testStoreRpcSessionGetWithFilePassword},
 		{"Put-0", testStoreRpcSessionPut(0)},
 		{"Put-128", testStoreRpcSessionPut(128)},
 		{"Put-1k", testStoreRpcSessionPut(1024)},
@@ -257,7 +432,7 @@ func TestStoreRpcSession(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			store, err := NewStore(storageDir, randomIdGenerator(4), false)
+			store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
 			if err != nil {
 				t.Fatal(err)
 			}
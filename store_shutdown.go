@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// ErrShutdownTimeout is returned by Store.Shutdown if in-flight operations
+// did not finish before its context was done.
+var ErrShutdownTimeout = errors.New("Store shutdown timed out before in-flight operations finished")
+
+// Shutdown gracefully closes the Store. First, new uploads and downloads are
+// refused with ErrStoreClosed, the background cleanup is stopped and pending
+// notifications are aborted. Then,
+// in-flight uploads and opened downloads are awaited before the Store is
+// closed like by Close.
+//
+// If the ctx is done before, ErrShutdownTimeout is returned and the Store is
+// left open, still refusing new operations. It might be closed forcefully by
+// Close afterwards.
+//
+// When the Store is served by an http.Server, e.g., by its Handler, the
+// server's Shutdown should be called first to stop accepting connections and
+// to finish active requests. Afterwards, the Store's Shutdown awaits the
+// remaining operations, e.g., downloads of hijacked or timed out requests.
+func (s *Store) Shutdown(ctx context.Context) error {
+	s.closedMutex.Lock()
+	if s.closed {
+		s.closedMutex.Unlock()
+		return ErrStoreClosed
+	}
+	s.draining = true
+	s.closedMutex.Unlock()
+
+	s.logger.Info("Shutting down Store, waiting for in-flight operations")
+	s.StopCleanup()
+	s.stopNotifications()
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return s.Close()
+
+	case <-ctx.Done():
+		s.logger.Warn("Store shutdown timed out", slog.Any("error", ctx.Err()))
+		return ErrShutdownTimeout
+	}
+}
+
+// beginInFlight registers an operation to be awaited by Shutdown, which must
+// be finished by inFlight.Done. After Shutdown or Close, ErrStoreClosed is
+// returned instead.
+func (s *Store) beginInFlight() error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed || s.draining {
+		return ErrStoreClosed
+	}
+	s.inFlight.Add(1)
+	return nil
+}
+
+// openTracked opens an Item's content by open, e.g., openContent, but
+// registers the opened content as an in-flight operation until being closed.
+func (s *Store) openTracked(i Item, open func(Item) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	err := s.beginInFlight()
+	if err != nil {
+		return nil, err
+	}
+
+	done := s.inFlight.Done
+	if s.readSem != nil && len(i.Inline) == 0 {
+		select {
+		case s.readSem <- struct{}{}:
+			done = func() {
+				<-s.readSem
+				s.inFlight.Done()
+			}
+		default:
+			s.inFlight.Done()
+			return nil, ErrTooManyOpenFiles
+		}
+	}
+
+	f, err := open(i)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return &inFlightReader{ReadCloser: f, done: done}, nil
+}
+
+// inFlightReader calls done once after its ReadCloser was closed.
+type inFlightReader struct {
+	io.ReadCloser
+
+	done func()
+	once sync.Once
+}
+
+func (r *inFlightReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.done)
+	return err
+}
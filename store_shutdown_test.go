@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreShutdown(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itemId, err := store.Put(
+		Item{Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow download, still being open while shutting down.
+	f, err := store.GetFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := store.Shutdown(ctx); err != ErrShutdownTimeout {
+		t.Fatalf("Shutdown with an open download resulted in %v", err)
+	} else if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Shutdown returned after %v, before its timeout", elapsed)
+	}
+
+	// New operations are refused, while the open download continues.
+	if _, err := store.GetFile(itemId); err != ErrStoreClosed {
+		t.Fatalf("Download while shutting down resulted in %v", err)
+	}
+	if _, err := store.Put(Item{}, newDummyReadCloser(bytes.NewBufferString("foo"))); err != ErrStoreClosed {
+		t.Fatalf("Upload while shutting down resulted in %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- store.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the download finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if data, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "hello world" {
+		t.Fatalf("Download data is %q", data)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the download finished")
+	}
+
+	if _, err := store.BadgerHoldSafe(); err != ErrStoreClosed {
+		t.Fatalf("Store is not closed after Shutdown: %v", err)
+	} else if err := store.Shutdown(context.Background()); err != ErrStoreClosed {
+		t.Fatalf("Shutdown of a closed Store resulted in %v", err)
+	}
+}
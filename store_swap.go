@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// SwapIDs swaps the IDs of two Items, e.g., to promote a new version to an
+// existing link. Afterwards, each ID serves the other Item. Both Items'
+// Versions are incremented, while their other metadata, e.g., their Slugs,
+// stay with their contents.
+//
+// If either Item is missing, ErrNotFound is returned, and ErrLocked if either
+// is locked. The files named by the IDs are renamed while the database entries
+// are swapped within one transaction. If anything fails, all renames are
+// rolled back and nothing is changed.
+func (s *Store) SwapIDs(idA, idB string) error {
+	s.logger.Debug("Requested swap of Items' IDs", slog.String("a", idA), slog.String("b", idB))
+
+	if idA == idB {
+		return fmt.Errorf("cannot swap Item %q with itself", idA)
+	}
+
+	var renames [][2]string
+	rollback := func() {
+		for n := len(renames) - 1; n >= 0; n-- {
+			err := os.Rename(renames[n][1], renames[n][0])
+			if err != nil {
+				s.logger.Error("Failed to roll back renaming of swapped file",
+					slog.String("from", renames[n][1]), slog.String("to", renames[n][0]), slog.Any("error", err))
+			}
+		}
+		renames = nil
+	}
+	rename := func(from, to string) error {
+		err := os.Rename(from, to)
+		if err != nil {
+			return err
+		}
+		renames = append(renames, [2]string{from, to})
+		return nil
+	}
+
+	err := s.bh.Badger().Update(func(tx *badger.Txn) error {
+		var a, b Item
+		for _, fetch := range []struct {
+			id string
+			i  *Item
+		}{{idA, &a}, {idB, &b}} {
+			err := s.bh.TxGet(tx, fetch.id, fetch.i)
+			if err == badgerhold.ErrNotFound {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			} else if fetch.i.Locked(s.clock.Now()) {
+				return ErrLocked
+			}
+		}
+
+		// Files named by their IDs are moved aside first, as both might exist.
+		var moved []Item
+		for _, i := range []Item{a, b} {
+			if len(i.Inline) > 0 || i.Blob != "" {
+				continue
+			}
+
+			err := rename(s.itemPath(i), s.tempPath(i.ID+".swap"))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			moved = append(moved, i)
+		}
+
+		a.ID, b.ID = idB, idA
+		a.Version++
+		b.Version++
+
+		for _, i := range moved {
+			newPath := s.itemPath(a)
+			if i.ID == idB {
+				newPath = s.itemPath(b)
+			}
+
+			err := rename(s.tempPath(i.ID+".swap"), newPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.bh.TxUpdate(tx, idB, a)
+		if err != nil {
+			return err
+		}
+		return s.bh.TxUpdate(tx, idA, b)
+	})
+	if err != nil {
+		rollback()
+		s.logger.Error("Failed to swap Items' IDs", slog.String("a", idA), slog.String("b", idB), slog.Any("error", err))
+		return err
+	}
+
+	// Neither cached contents nor Thumbnails belong to their IDs anymore.
+	for _, id := range []string{idA, idB} {
+		s.contentCache.remove(id)
+
+		err = s.deleteThumbnail(id)
+		if err != nil {
+			s.logger.Warn("Failed to delete Item's thumbnail", slog.String("id", id), slog.Any("error", err))
+		}
+		s.createThumbnail(id)
+	}
+
+	s.logger.Info("Swapped Items' IDs", slog.String("a", idA), slog.String("b", idB))
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSwapIDs(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Two Items stored as files and an inline one.
+	contents := map[string][]byte{
+		"a": []byte(strings.Repeat("a", 32)),
+		"b": []byte(strings.Repeat("b", 32)),
+		"c": []byte("inline"),
+	}
+	ids := make(map[string]string)
+	for name, data := range contents {
+		item := Item{Filename: name, Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+
+	assertSwapped := func(id, name string) {
+		t.Helper()
+
+		assertItemContent(t, store, id, contents[name])
+		if item, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if item.ID != id || item.Filename != name || item.Version != 1 {
+			t.Fatalf("Item %q has unexpected metadata %+v", id, item)
+		}
+	}
+
+	if err := store.SwapIDs(ids["a"], ids["b"]); err != nil {
+		t.Fatal(err)
+	}
+	assertSwapped(ids["a"], "b")
+	assertSwapped(ids["b"], "a")
+
+	if err := store.SwapIDs(ids["a"], ids["c"]); err != nil {
+		t.Fatal(err)
+	}
+	assertItemContent(t, store, ids["a"], contents["c"])
+	assertItemContent(t, store, ids["c"], contents["b"])
+	assertItemContent(t, store, ids["b"], contents["a"])
+
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found inconsistencies: %+v", report)
+	}
+
+	if err := store.SwapIDs(ids["a"], "missing"); err != ErrNotFound {
+		t.Fatalf("Swap with a missing Item resulted in %v", err)
+	} else if err := store.SwapIDs(ids["a"], ids["a"]); err == nil {
+		t.Fatalf("Swap of an Item with itself was accepted")
+	}
+}
+
+func TestStoreSwapIDsRollback(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var ids []string
+	for _, data := range []string{"first", "second"} {
+		id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// A non-empty directory in the way fails moving the second file aside,
+	// after the first one was already moved.
+	blocker := store.tempPath(ids[1] + ".swap")
+	if err := os.MkdirAll(filepath.Join(blocker, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SwapIDs(ids[0], ids[1]); err == nil {
+		t.Fatalf("Swap succeeded despite a failing rename")
+	}
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	assertItemContent(t, store, ids[0], []byte("first"))
+	assertItemContent(t, store, ids[1], []byte("second"))
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found inconsistencies after the rollback: %+v", report)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// maxUnsynced bounds the amount of paths remembered for the next Sync, as
+// Sync might never be called. Beyond, Sync syncs all files.
+const maxUnsynced = 1 << 14
+
+// trackUnsynced remembers the path of a file written without fsync for the
+// next Sync.
+func (s *Store) trackUnsynced(path string) {
+	s.unsyncedMutex.Lock()
+	defer s.unsyncedMutex.Unlock()
+
+	if s.unsyncedOverflow {
+		return
+	} else if len(s.unsynced) >= maxUnsynced {
+		s.unsynced, s.unsyncedOverflow = nil, true
+		return
+	}
+
+	if s.unsynced == nil {
+		s.unsynced = make(map[string]struct{})
+	}
+	s.unsynced[path] = struct{}{}
+}
+
+// Sync forces a durability point, e.g., before acknowledging a critical upload
+// while running without WithSyncWrites or with a FileSync other than
+// FileSyncAlways. All files written without fsync since the last Sync, the
+// storage directory, and badger's pending writes are synced to disk.
+//
+// Files of Items deleted in the meantime are skipped. On a failure, the files
+// not yet synced are kept for the next Sync.
+func (s *Store) Sync() error {
+	s.closedMutex.RLock()
+	defer s.closedMutex.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	s.unsyncedMutex.Lock()
+	paths, overflow := s.unsynced, s.unsyncedOverflow
+	s.unsynced, s.unsyncedOverflow = nil, false
+	s.unsyncedMutex.Unlock()
+
+	if overflow {
+		entries, err := os.ReadDir(s.storageDir())
+		if err != nil {
+			s.unsyncedMutex.Lock()
+			s.unsyncedOverflow = true
+			s.unsyncedMutex.Unlock()
+			return err
+		}
+
+		paths = make(map[string]struct{}, len(entries))
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				paths[filepath.Join(s.storageDir(), entry.Name())] = struct{}{}
+			}
+		}
+	}
+
+	s.logger.Debug("Requested sync", slog.Int("files", len(paths)))
+
+	for path := range paths {
+		err := s.syncFile(path)
+		if err != nil {
+			for path := range paths {
+				s.trackUnsynced(path)
+			}
+			return fmt.Errorf("syncing file failed: %w", err)
+		}
+		delete(paths, path)
+	}
+
+	err := s.syncDir(s.storageDir())
+	if err != nil {
+		return fmt.Errorf("syncing storage directory failed: %w", err)
+	}
+
+	return s.bh.Badger().Sync()
+}
+
+// syncFile fsyncs a file, ignoring already removed files.
+func (s *Store) syncFile(path string) error {
+	f, err := s.open(path, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
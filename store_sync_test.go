@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreSync(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithSyncWrites(false),
+		WithFileSyncPolicy(FileSyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("hello world")
+	id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBuffer(data)))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(store.unsynced) != 1 {
+		t.Fatalf("Store tracks %d unsynced files, expected 1", len(store.unsynced))
+	}
+
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	} else if len(store.unsynced) != 0 {
+		t.Fatalf("Store tracks %d unsynced files after Sync", len(store.unsynced))
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := store.Sync(); err != ErrStoreClosed {
+		t.Fatalf("Sync of a closed Store returned %v", err)
+	}
+
+	store, err = NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	assertItemContent(t, store, id, data)
+}
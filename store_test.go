@@ -2,12 +2,30 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/akamensky/base58"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+	"golang.org/x/sys/unix"
 )
 
 // dummyReadCloser wraps around a bytes.Buffer and implements a ReadCloser.
@@ -43,7 +61,7 @@ func TestStore(t *testing.T) {
 	}
 	defer os.RemoveAll(storageDir)
 
-	store, err := NewStore(storageDir, randomIdGenerator(4), false)
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,6 +75,10 @@ func TestStore(t *testing.T) {
 		t.Fatal(err)
 	}
 	item.ID = itemId
+	item.Size = int64(len(itemDataRaw))
+	item.Checksum = checksum(itemDataRaw)
+	item.ChecksumAlgorithm = defaultChecksumAlgorithm
+	item.ContentType = http.DetectContentType(itemDataRaw)
 
 	if itemX, err := store.Get(itemId); err != nil {
 		t.Fatal(err)
@@ -91,7 +113,7 @@ func TestStore(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := store.deleteExpired(); err != nil {
+	if _, err := store.deleteExpired(); err != nil {
 		t.Fatal(err)
 	} else if _, err := store.Get(item.ID); err != ErrNotFound {
 		t.Fatal(err)
@@ -111,7 +133,7 @@ func TestStoreCreateId(t *testing.T) {
 	}
 	defer os.RemoveAll(storageDir)
 
-	store, err := NewStore(storageDir, randomIdGenerator(4), false)
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -134,3 +156,3118 @@ func TestStoreCreateId(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestStoreDuplicateIdGenerator(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// This broken generator cycles through only three IDs.
+	var counter atomic.Int32
+	generator := func() (string, error) {
+		return fmt.Sprintf("dup%d", counter.Add(1)%3), nil
+	}
+
+	store, err := NewStore(storageDir, WithIdGenerator(generator), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const puts = 16
+
+	var wg sync.WaitGroup
+	ids := make([]string, puts)
+	errs := make([]error, puts)
+	for n := 0; n < puts; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+
+			data := bytes.Repeat([]byte{byte(n)}, 64*1024)
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			ids[n], errs[n] = store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+		}(n)
+	}
+	wg.Wait()
+
+	succeeded := make(map[string]int)
+	for n := 0; n < puts; n++ {
+		if errs[n] != nil {
+			continue
+		} else if _, dup := succeeded[ids[n]]; dup {
+			t.Fatalf("ID %q was inserted twice", ids[n])
+		}
+		succeeded[ids[n]] = n
+	}
+	if len(succeeded) != 3 {
+		t.Fatalf("%d Puts succeeded, expected one per unique ID: %v", len(succeeded), succeeded)
+	}
+
+	// Failed Puts must not have touched the files of the succeeded ones.
+	for id, n := range succeeded {
+		f, err := store.GetFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, bytes.Repeat([]byte{byte(n)}, 64*1024)) {
+			t.Fatalf("Content of %q was overwritten", id)
+		}
+	}
+	if err := store.Verify(ids[succeeded["dup0"]]); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatalf("Store has %d Items, expected 3", n)
+	}
+}
+
+func TestStoreRandomSource(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// The second ID duplicates the first one, forcing a retry of createID.
+	first, second := bytes.Repeat([]byte{0x01}, 8), bytes.Repeat([]byte{0x02}, 8)
+	source := bytes.NewReader(bytes.Join([][]byte{first, first, second}, nil))
+
+	store, err := NewStore(storageDir, WithCleanup(false), WithRandomSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expectedIds := []string{string(base58.Encode(first)), string(base58.Encode(second))}
+	for _, expectedId := range expectedIds {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		} else if id != expectedId {
+			t.Fatalf("Put returned ID %q, expected %q", id, expectedId)
+		}
+	}
+
+	if source.Len() != 0 {
+		t.Fatalf("Random source has %d unread bytes, the duplicate was not retried", source.Len())
+	}
+
+	// An exhausted source fails instead of creating weak IDs.
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err == nil {
+		t.Fatal("Put succeeded with an exhausted random source")
+	}
+}
+
+func TestStoreIDCollisionRate(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// Single byte IDs, where the second Put first gets the already taken one.
+	random := bytes.NewReader([]byte{0x00, 0x00, 0x01})
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGeneratorFrom(1, random)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if rate := store.IDCollisionRate(); rate != 0 {
+		t.Fatalf("Collision rate before any ID is %v", rate)
+	}
+
+	for n := 0; n < 2; n++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if collisions := store.IDCollisions(); collisions != 1 {
+		t.Fatalf("Store counted %d collisions, expected 1", collisions)
+	} else if rate := store.IDCollisionRate(); rate != 1.0/3 {
+		t.Fatalf("Collision rate is %v, expected 1/3", rate)
+	}
+}
+
+func TestAlphabetIdGenerator(t *testing.T) {
+	// 63 characters would favor the first 4 ones by a quarter by a naive
+	// modulo of random bytes.
+	alphabet := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_"
+	alphabetRunes := []rune(alphabet)
+
+	const (
+		length = 20
+		ids    = 10000
+	)
+
+	idGenerator, err := alphabetIdGenerator(alphabet, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	freqs := make(map[rune]int)
+	for i := 0; i < ids; i++ {
+		id, err := idGenerator()
+		if err != nil {
+			t.Fatal(err)
+		} else if n := len([]rune(id)); n != length {
+			t.Fatalf("ID %q has %d characters, expected %d", id, n, length)
+		}
+
+		for _, c := range id {
+			freqs[c]++
+		}
+	}
+
+	expected := float64(length*ids) / float64(len(alphabetRunes))
+	for _, c := range alphabetRunes {
+		if dev := math.Abs(float64(freqs[c])-expected) / expected; dev > 0.1 {
+			t.Fatalf("Character %q occurred %d times, deviating %.2f from %.0f", c, freqs[c], dev, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "a", "aba", "ab/", "ab.", "ab?", "ab#", "ab%", "a b", "ab\n", "aä"} {
+		if _, err := alphabetIdGenerator(invalid, length); err == nil {
+			t.Fatalf("Invalid alphabet %q was accepted", invalid)
+		}
+	}
+
+	for _, invalid := range []int{0, -1} {
+		if _, err := alphabetIdGenerator("ab", invalid); err == nil {
+			t.Fatalf("Invalid length %d was accepted", invalid)
+		}
+	}
+}
+
+func TestUlidIdGenerator(t *testing.T) {
+	// The time of the ULID specification's example "01ARYZ6S41TSV4RRFFQ69G5FAV".
+	clock := newManualClock(time.UnixMilli(1469918176385))
+
+	idGenerator, err := ulidIdGenerator(10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 256; i++ {
+		id, err := idGenerator()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(id) != 26 {
+			t.Fatalf("ID %q has %d characters, expected 26", id, len(id))
+		} else if i == 0 && !strings.HasPrefix(id, "01ARYZ6S41") {
+			t.Fatalf("ID %q does not start with the expected time", id)
+		}
+
+		created, err := ulidTime(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !created.Equal(clock.Now()) {
+			t.Fatalf("ID %q decodes to %v, expected %v", id, created, clock.Now())
+		}
+
+		ids = append(ids, id)
+		clock.Advance(time.Duration(1+i%37) * time.Millisecond)
+	}
+
+	if !sort.StringsAreSorted(ids) {
+		t.Fatalf("IDs are not sorted by their creation: %v", ids)
+	}
+
+	if _, err := ulidIdGenerator(0, clock); err == nil {
+		t.Fatalf("ULID generator without random part was accepted")
+	}
+	for _, invalid := range []string{"", "01ARYZ6S41", "01ARYZ6SUITSV4RRFFQ69G5FAV"} {
+		if _, err := ulidTime(invalid); err == nil {
+			t.Fatalf("Invalid ULID %q was accepted", invalid)
+		}
+	}
+}
+
+func TestStoreBadgerHoldSafe(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bh, err := store.BadgerHoldSafe(); err != nil {
+		t.Fatal(err)
+	} else if bh != store.BadgerHold() {
+		t.Fatalf("BadgerHoldSafe returned another reference")
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if bh, err := store.BadgerHoldSafe(); err != ErrStoreClosed {
+		t.Fatalf("BadgerHoldSafe after Close returned %v, %v", bh, err)
+	}
+	if err := store.Close(); err != ErrStoreClosed {
+		t.Fatalf("second Close returned %v", err)
+	}
+}
+
+// onlyReader hides all other methods of an io.Reader, e.g., to prevent the
+// *os.File fast path in copyItemFile.
+type onlyReader struct {
+	io.Reader
+}
+
+func (or onlyReader) Close() error {
+	return nil
+}
+
+// createSourceFile creates a temporary file in dir with random data of size.
+func createSourceFile(t testing.TB, dir string, size int) (*os.File, []byte) {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+
+	f, err := os.CreateTemp(dir, "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	return f, data
+}
+
+func TestStorePutFile(t *testing.T) {
+	tests := []struct {
+		name string
+		wrap func(*os.File) io.ReadCloser
+	}{
+		{"os.File", func(f *os.File) io.ReadCloser { return f }},
+		{"io.Reader", func(f *os.File) io.ReadCloser { return onlyReader{f} }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			src, data := createSourceFile(t, storageDir, 1024*1024)
+			defer src.Close()
+
+			itemId, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, test.wrap(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := store.GetFile(itemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			buff, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, buff) {
+				t.Fatalf("Store data mismatch for %d and %d bytes", len(data), len(buff))
+			}
+
+			item, err := store.Get(itemId)
+			if err != nil {
+				t.Fatal(err)
+			} else if item.Checksum != checksum(data) {
+				t.Fatalf("Item checksum %q mismatches, expected %q", item.Checksum, checksum(data))
+			}
+		})
+	}
+}
+
+func TestCopyFileRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, data := createSourceFile(t, dir, 4096)
+	defer src.Close()
+
+	dst, err := os.CreateTemp(dir, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	written, ok, err := copyFileRange(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Skip("copy_file_range is not supported on this platform or file system")
+	} else if written != int64(len(data)) {
+		t.Fatalf("copy_file_range wrote %d bytes, expected %d", written, len(data))
+	}
+
+	buff, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buff) {
+		t.Fatalf("copy_file_range data mismatch")
+	}
+}
+
+func BenchmarkStorePutFile(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		wrap func(*os.File) io.ReadCloser
+	}{
+		{"os.File", func(f *os.File) io.ReadCloser { return f }},
+		{"io.Reader", func(f *os.File) io.ReadCloser { return onlyReader{f} }},
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(8)), WithCleanup(false))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+
+			src, _ := createSourceFile(b, storageDir, 16*1024*1024)
+			_ = src.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				src, err := os.Open(src.Name())
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				_, err = store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, bench.wrap(src))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreInline(t *testing.T) {
+	const inlineSize = 64
+
+	tests := []struct {
+		name   string
+		size   int
+		inline bool
+	}{
+		{"empty", 0, false},
+		{"small", 11, true},
+		{"threshold", inlineSize, true},
+		{"spill", inlineSize + 1, false},
+		{"large", 1024 * 1024, false},
+	}
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(inlineSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			itemDataRaw := make([]byte, test.size)
+			_, _ = rand.Read(itemDataRaw)
+
+			item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+			itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			itemX, err := store.Get(itemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inline := len(itemX.Inline) > 0; inline != test.inline {
+				t.Fatalf("Item inline state is %t, expected %t", inline, test.inline)
+			}
+
+			_, statErr := os.Stat(filepath.Join(store.storageDir(), itemId))
+			if fileExists := statErr == nil; fileExists == test.inline {
+				t.Fatalf("Item file existence is %t for inline state %t", fileExists, test.inline)
+			}
+
+			f, err := store.GetFile(itemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buff, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = f.Close()
+
+			if !bytes.Equal(itemDataRaw, buff) {
+				t.Fatalf("Store data mismatch for %d and %d bytes", len(itemDataRaw), len(buff))
+			}
+
+			if err := store.Delete(itemId); err != nil {
+				t.Fatal(err)
+			} else if _, err := store.GetFile(itemId); err != ErrNotFound {
+				t.Fatalf("GetFile after Delete returned %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreCleanupNow(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 5; i++ {
+		expires := time.Now().Add(time.Minute)
+		if i < 3 {
+			expires = time.Now().Add(-time.Minute)
+		}
+
+		item := Item{Expires: expires.UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("Count returned %d, expected 5", n)
+	}
+
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 3 {
+		t.Fatalf("CleanupNow deleted %d, expected 3", deleted)
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("Count returned %d, expected 2", n)
+	}
+
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 0 {
+		t.Fatalf("CleanupNow deleted %d, expected 0", deleted)
+	}
+}
+
+func TestStoreExpiryGrace(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := newManualClock(t0)
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithExpiryPolicy(ExpiryDeleteOnAccess),
+		WithExpiryGrace(10*time.Minute),
+		WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var ids []string
+	for _, expires := range []time.Time{t0.Add(time.Minute), t0.Add(30 * time.Minute)} {
+		id, err := store.Put(Item{Expires: expires}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	pastGrace, withinGrace := ids[0], ids[1]
+
+	clock.Advance(35 * time.Minute)
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1 {
+		t.Fatalf("CleanupNow deleted %d Items, expected 1", deleted)
+	} else if _, err := store.Peek(pastGrace); err != ErrNotFound {
+		t.Fatalf("Item past its grace period was not deleted: %v", err)
+	}
+
+	// Within the grace period, the Item is hidden, but not deleted on access.
+	if _, err := store.Get(withinGrace); err != ErrNotFound {
+		t.Fatalf("Get of an expired Item within its grace period returned %v", err)
+	} else if _, err := store.Peek(withinGrace); err != nil {
+		t.Fatalf("Expired Item within its grace period was deleted: %v", err)
+	}
+
+	clock.Advance(10 * time.Minute)
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1 {
+		t.Fatalf("CleanupNow deleted %d Items after the grace period, expected 1", deleted)
+	}
+}
+
+func TestStoreCleanupRemoveRetry(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	failures := 1
+	store.removeFile = func(name string) error {
+		if failures > 0 {
+			failures--
+			return &fs.PathError{Op: "remove", Path: name, Err: unix.EBUSY}
+		}
+		return os.Remove(name)
+	}
+
+	item := Item{Expires: time.Now().Add(-time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemFile := filepath.Join(store.storageDir(), itemId)
+
+	if deleted, err := store.CleanupNow(); err == nil || deleted != 0 {
+		t.Fatalf("First cleanup should fail, deleted %d, error %v", deleted, err)
+	} else if n, err := store.Count(); err != nil || n != 1 {
+		t.Fatalf("Database entry should be kept, count %d, error %v", n, err)
+	} else if _, err := os.Stat(itemFile); err != nil {
+		t.Fatalf("File should be kept, %v", err)
+	}
+
+	if deleted, err := store.CleanupNow(); err != nil || deleted != 1 {
+		t.Fatalf("Second cleanup should succeed, deleted %d, error %v", deleted, err)
+	} else if n, err := store.Count(); err != nil || n != 0 {
+		t.Fatalf("Database entry should be gone, count %d, error %v", n, err)
+	} else if _, err := os.Stat(itemFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("File should be gone, %v", err)
+	}
+}
+
+func TestStoreTooManyOpenFiles(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE} {
+		store.openFile = func(name string, _ int, _ fs.FileMode) (*os.File, error) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errno}
+		}
+
+		_, getErr := store.GetFile(itemId)
+		_, putErr := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		for op, err := range map[string]error{"GetFile": getErr, "Put": putErr} {
+			if !errors.Is(err, ErrTooManyOpenFiles) || !errors.Is(err, errno) {
+				t.Fatalf("%s failing by %v returned %v", op, errno, err)
+			} else if code := ClassifyError(err); code != CodeTooManyOpenFiles {
+				t.Fatalf("%s failing by %v is classified as %v", op, errno, code)
+			}
+		}
+	}
+
+	// Other failures are not wrapped.
+	store.openFile = func(name string, _ int, _ fs.FileMode) (*os.File, error) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+	}
+	if _, err := store.GetFile(itemId); err == nil || errors.Is(err, ErrTooManyOpenFiles) {
+		t.Fatalf("GetFile failing by EACCES returned %v", err)
+	}
+
+	store.openFile = os.OpenFile
+	if f, err := store.GetFile(itemId); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.Count(); err != nil || n != 1 {
+		t.Fatalf("Failed Puts left Items, count %d, error %v", n, err)
+	}
+}
+
+func TestStoreMaxConcurrentReads(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithInlineSize(8),
+		WithMaxConcurrentReads(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	fileId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inlineId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFile(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetFile(fileId); err != ErrTooManyOpenFiles {
+		t.Fatalf("GetFile beyond the limit returned %v", err)
+	}
+
+	// Inline Items do not need a file descriptor.
+	if inline, err := store.GetFile(inlineId); err != nil {
+		t.Fatalf("GetFile of inline Item failed: %v", err)
+	} else if err := inline.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := store.GetFile(fileId); err != nil {
+		t.Fatalf("GetFile after Close failed: %v", err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreDeleteMissingFile(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(store.storageDir(), itemId)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(itemId); err != nil {
+		t.Fatal(err)
+	} else if _, err := store.Get(itemId); err != ErrNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreUpdateCAS(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	id, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UpdateCAS(id, 0, func(i *Item) { i.Filename = "first.txt"; i.ID = "nope" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateCAS(id, 0, func(i *Item) { i.Filename = "stale.txt" }); err != ErrVersionConflict {
+		t.Fatalf("Update of a stale version resulted in %v", err)
+	}
+	if err := store.UpdateCAS("nope", 0, func(i *Item) {}); err != ErrNotFound {
+		t.Fatalf("Update of a missing Item resulted in %v", err)
+	}
+
+	// Metadata changes increment the Version, while downloads do not.
+	if err := store.Extend(id, time.Now().Add(time.Hour).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.GetFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, f)
+	_ = f.Close()
+
+	item, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if item.Version != 2 || item.Filename != "first.txt" || item.Downloads != 1 {
+		t.Fatalf("Item has version %d, filename %q, and %d downloads", item.Version, item.Filename, item.Downloads)
+	}
+
+	// Of concurrent updates of the same version, exactly one succeeds.
+	for round := 0; round < 20; round++ {
+		item, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		errs := make([]error, 2)
+		for n := range errs {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				<-start
+				errs[n] = store.UpdateCAS(id, item.Version, func(i *Item) {
+					i.Filename = fmt.Sprintf("round-%d-%d.txt", round, n)
+				})
+			}(n)
+		}
+		close(start)
+		wg.Wait()
+
+		winner := -1
+		for n, err := range errs {
+			if err == nil && winner < 0 {
+				winner = n
+			} else if err != ErrVersionConflict {
+				t.Fatalf("Round %d: concurrent updates resulted in %v", round, errs)
+			}
+		}
+		if winner < 0 {
+			t.Fatalf("Round %d: no concurrent update succeeded", round)
+		}
+
+		updated, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if updated.Version != item.Version+1 || updated.Filename != fmt.Sprintf("round-%d-%d.txt", round, winner) {
+			t.Fatalf("Round %d: Item has version %d and filename %q", round, updated.Version, updated.Filename)
+		}
+	}
+}
+
+func TestStoreUpdateCASLocked(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{
+		Expires:     clock.Now().Add(24 * time.Hour),
+		LockedUntil: clock.Now().Add(time.Hour),
+	}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Item)
+	}{
+		{"shorten expiry", func(i *Item) { i.Expires = clock.Now().Add(time.Minute) }},
+		{"unlock", func(i *Item) { i.LockedUntil = time.Time{} }},
+		{"lower lock", func(i *Item) { i.LockedUntil = clock.Now().Add(time.Minute) }},
+		{"unlock and shorten expiry", func(i *Item) {
+			i.LockedUntil = time.Time{}
+			i.Expires = clock.Now().Add(time.Minute)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := store.UpdateCAS(id, 0, test.mutate); err != ErrLocked {
+				t.Fatalf("Update of a locked Item resulted in %v", err)
+			}
+
+			if i, err := store.Get(id); err != nil {
+				t.Fatal(err)
+			} else if i.Version != 0 || !i.Expires.Equal(item.Expires) || !i.LockedUntil.Equal(item.LockedUntil) {
+				t.Fatalf("Locked Item was changed to version %d, expiry %v, and lock %v", i.Version, i.Expires, i.LockedUntil)
+			}
+		})
+	}
+
+	// Extending both the lock and the expiry is allowed.
+	lockedUntil, expires := clock.Now().Add(2*time.Hour), clock.Now().Add(48*time.Hour)
+	err = store.UpdateCAS(id, 0, func(i *Item) { i.LockedUntil, i.Expires = lockedUntil, expires })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the lock has passed, it might be lowered again.
+	clock.Advance(3 * time.Hour)
+	if err := store.UpdateCAS(id, 1, func(i *Item) { i.LockedUntil = time.Time{} }); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if !i.LockedUntil.IsZero() || !i.Expires.Equal(expires) {
+		t.Fatalf("Item has lock %v and expiry %v", i.LockedUntil, i.Expires)
+	}
+}
+
+func TestStoreUpdateCASInternalFields(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	fileId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inlineId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, data := range map[string]string{fileId: "hello world", inlineId: "hello"} {
+		before, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.UpdateCAS(id, 0, func(i *Item) {
+			i.Filename = "renamed.txt"
+			i.Blob, i.Size, i.Checksum, i.ChecksumAlgorithm = "other", 1, "nope", "md5"
+			if len(i.Inline) > 0 {
+				i.Inline[0] = 'j'
+			} else {
+				i.Inline = []byte("nope")
+			}
+			i.Compressed, i.Encrypted = true, true
+			i.PasswordHash, i.KeyCheck, i.KeySalt = []byte("hash"), []byte("check"), []byte("salt")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if after.Filename != "renamed.txt" {
+			t.Fatalf("Item %q has filename %q", id, after.Filename)
+		}
+		after.Filename, after.Version = before.Filename, before.Version
+		if !reflect.DeepEqual(before, after) {
+			t.Fatalf("Internal fields of Item %q were changed from %+v to %+v", id, before, after)
+		}
+
+		f, err := store.GetFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buff, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(buff) != data {
+			t.Fatalf("Item %q has content %q, expected %q", id, buff, data)
+		}
+	}
+}
+
+func TestStoreUpdateCASUnrelatedWrites(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Both Items share the entry of their Checksum's index.
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			f, err := store.GetFile(otherId)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, f)
+			_ = f.Close()
+		}
+	}()
+
+	for version := uint64(0); version < 100; version++ {
+		err := store.UpdateCAS(id, version, func(i *Item) { i.Filename = fmt.Sprintf("%d.txt", version) })
+		if err != nil {
+			close(done)
+			wg.Wait()
+			t.Fatalf("Update of version %d resulted in %v", version, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestStoreTransfer(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	oldOwner, newOwner := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+
+	item := Item{
+		Expires: time.Now().Add(time.Minute).UTC(),
+		Owner:   map[OwnerType]net.IP{RemoteAddr: oldOwner},
+	}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items, err := store.ListByOwner(oldOwner); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 1 || items[0].ID != itemId {
+		t.Fatalf("ListByOwner for old owner returned %v", items)
+	}
+
+	if err := store.Transfer(itemId, map[OwnerType]net.IP{RemoteAddr: newOwner}); err != nil {
+		t.Fatal(err)
+	}
+
+	if items, err := store.ListByOwner(oldOwner); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 0 {
+		t.Fatalf("ListByOwner for old owner returned %v", items)
+	}
+	if items, err := store.ListByOwner(newOwner); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 1 || items[0].ID != itemId {
+		t.Fatalf("ListByOwner for new owner returned %v", items)
+	}
+
+	if err := store.Transfer("whatever", map[OwnerType]net.IP{RemoteAddr: newOwner}); err != ErrNotFound {
+		t.Fatalf("Transfer of a missing Item returned %v", err)
+	}
+}
+
+func TestStoreListOwners(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	owners := []map[OwnerType]net.IP{
+		{RemoteAddr: net.ParseIP("192.0.2.1")},
+		{RemoteAddr: net.ParseIP("192.0.2.1")},
+		{RemoteAddr: net.ParseIP("192.0.2.2"), XForwardedFor: net.ParseIP("2001:db8::1")},
+		{RemoteAddr: net.ParseIP("2001:db8::1")},
+		nil,
+		{},
+	}
+	for _, owner := range owners {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC(), Owner: owner}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	if owners, err := store.ListOwners(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(owners, expected) {
+		t.Fatalf("ListOwners returned %v, expected %v", owners, expected)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ListOwners(); err != ErrStoreClosed {
+		t.Fatalf("ListOwners on a closed Store returned %v", err)
+	}
+}
+
+func TestStoreFind(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{"a.txt", "b.txt", "c.png"} {
+		item := Item{Filename: filename, Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if items, err := store.Find(badgerhold.Where("Filename").HasSuffix(".txt")); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 2 {
+		t.Fatalf("Find returned %d Items, expected 2", len(items))
+	}
+
+	if items, err := store.Find(nil); err != nil {
+		t.Fatal(err)
+	} else if len(items) != 3 {
+		t.Fatalf("Find returned %d Items, expected 3", len(items))
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Find(nil); err != ErrStoreClosed {
+		t.Fatalf("Find on a closed Store returned %v", err)
+	}
+}
+
+func TestStoreStatsByType(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithInlineSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	uploads := []struct {
+		contentType string
+		size        int
+	}{
+		{"text/plain", 8},
+		{"text/plain", 1024},
+		{"image/png", 2048},
+		{"text/plain", 100},
+	}
+	for _, upload := range uploads {
+		item := Item{ContentType: upload.contentType, Expires: time.Now().Add(time.Minute).UTC()}
+		data := newDummyReadCloser(bytes.NewBuffer(make([]byte, upload.size)))
+		if _, err := store.Put(item, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]TypeStat{
+		"text/plain": {Count: 3, Bytes: 8 + 1024 + 100},
+		"image/png":  {Count: 1, Bytes: 2048},
+	}
+	if stats, err := store.StatsByType(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("StatsByType returned %v, expected %v", stats, expected)
+	}
+}
+
+func TestStoreAlreadyLocked(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store2, err := NewStore(storageDir, WithCleanup(false))
+	if !errors.Is(err, ErrAlreadyLocked) {
+		if store2 != nil {
+			_ = store2.Close()
+		}
+		t.Fatalf("Second NewStore returned %v", err)
+	} else if !strings.Contains(err.Error(), storageDir) {
+		t.Fatalf("Error misses the directory: %v", err)
+	}
+}
+
+func TestStoreIncompatibleVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		openErr    error
+		isIncompat bool
+	}{
+		{"unsupported-manifest", errors.New("manifest has unsupported version: 7 (we support 8)."), true},
+		{"external-magic", errors.New("Cannot open DB because the external magic number doesn't match. Expected: 1, version present in manifest: 0"), true},
+		{"generic", errors.New("something else broke"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			openBadgerHold = func(badgerhold.Options) (*badgerhold.Store, error) {
+				return nil, test.openErr
+			}
+			defer func() { openBadgerHold = badgerhold.Open }()
+
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, WithCleanup(false))
+			if err == nil {
+				_ = store.Close()
+				t.Fatalf("NewStore did not fail")
+			} else if errors.Is(err, ErrIncompatibleDatabaseVersion) != test.isIncompat {
+				t.Fatalf("NewStore returned %v", err)
+			} else if !strings.Contains(err.Error(), test.openErr.Error()) {
+				t.Fatalf("Error misses the original error: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreListIDs(t *testing.T) {
+	const items = 64
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < items; i++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		offset, limit int
+		expected      int
+	}{
+		{0, 0, items},
+		{0, 10, 10},
+		{10, 10, 10},
+		{60, 10, 4},
+		{items, 0, 0},
+	}
+
+	for _, test := range tests {
+		list, err := store.List(test.offset, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := store.ListIDs(test.offset, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list) != test.expected || len(ids) != test.expected {
+			t.Fatalf("List(%d, %d) returned %d Items and %d IDs, expected %d",
+				test.offset, test.limit, len(list), len(ids), test.expected)
+		}
+		for i := range list {
+			if list[i].ID != ids[i] {
+				t.Fatalf("List(%d, %d) mismatches at %d: %q != %q",
+					test.offset, test.limit, i, list[i].ID, ids[i])
+			}
+		}
+	}
+}
+
+func TestStoreListCorrupt(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 8; i++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	corruptKey, err := badgerhold.DefaultEncode("corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.BadgerHold().Badger().Update(func(tx *badger.Txn) error {
+		return tx.Set(append(append([]byte{}, itemKeyPrefix...), corruptKey...), []byte("garbage"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List(0, 0)
+	if err == nil {
+		t.Fatalf("List did not report the corrupt Item")
+	} else if !strings.Contains(err.Error(), `"corrupt"`) {
+		t.Fatalf("List error does not name the corrupt Item: %v", err)
+	}
+	if len(list) != 8 {
+		t.Fatalf("List returned %d Items, expected 8", len(list))
+	}
+}
+
+func BenchmarkStoreList(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithCleanup(false), WithInlineSize(64))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 1024; i++ {
+		item := Item{Filename: "hello.txt", Expires: time.Now().Add(time.Minute).UTC()}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("List", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.List(0, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ListIDs", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.ListIDs(0, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStoreExtend(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// The cleanup is enabled, but its interval is long enough to never tick.
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithClock(clock),
+		WithCleanupInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: clock.Now().Add(time.Minute)}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newExpires := clock.Now().Add(2 * time.Minute)
+	if err := store.Extend(itemId, newExpires); err != nil {
+		t.Fatal(err)
+	} else if itemX, err := store.Get(itemId); err != nil {
+		t.Fatal(err)
+	} else if !itemX.Expires.Equal(newExpires) {
+		t.Fatalf("Item expires at %v, expected %v", itemX.Expires, newExpires)
+	}
+
+	clock.Advance(3 * time.Minute)
+
+	if err := store.Extend(itemId, clock.Now().Add(time.Minute)); err != ErrNotFound {
+		t.Fatalf("Extend of an expired Item returned %v", err)
+	}
+
+	newExpires = clock.Now().Add(time.Minute)
+	if err := store.ForceExtend(itemId, newExpires); err != nil {
+		t.Fatal(err)
+	} else if itemX, err := store.Get(itemId); err != nil {
+		t.Fatalf("Revived Item is unavailable: %v", err)
+	} else if !itemX.Expires.Equal(newExpires) {
+		t.Fatalf("Item expires at %v, expected %v", itemX.Expires, newExpires)
+	}
+
+	if err := store.Extend("whatever", newExpires); err != ErrNotFound {
+		t.Fatalf("Extend of a missing Item returned %v", err)
+	} else if err := store.ForceExtend("whatever", newExpires); err != ErrNotFound {
+		t.Fatalf("ForceExtend of a missing Item returned %v", err)
+	}
+}
+
+func TestStoreMaxItems(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithMaxItems(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	put := func() (string, error) {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		return store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	}
+
+	var ids []string
+	for i := 0; i < 8; i++ {
+		id, err := put()
+		if err != nil {
+			t.Fatalf("Put of Item %d failed: %v", i, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := put(); err != ErrItemLimitReached {
+		t.Fatalf("Put beyond the limit returned %v", err)
+	}
+
+	batch := []ItemWithReader{{Item: Item{Expires: time.Now().Add(time.Minute).UTC()}, File: newDummyReadCloser(bytes.NewBufferString("hello world"))}}
+	if _, err := store.PutBatch(batch); err != ErrItemLimitReached {
+		t.Fatalf("PutBatch beyond the limit returned %v", err)
+	}
+
+	// Deleting an Item frees space for a new one.
+	if err := store.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := put(); err != nil {
+		t.Fatalf("Put after Delete failed: %v", err)
+	}
+	if _, err := put(); err != ErrItemLimitReached {
+		t.Fatalf("Put beyond the limit after Delete returned %v", err)
+	}
+}
+
+func TestStoreMaxItemsConcurrent(t *testing.T) {
+	const maxItems = 9
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+
+	// Both single Puts and batches of two race for the remaining slots.
+	inserts := map[string]func(*Store) (int64, error){
+		"put": func(store *Store) (int64, error) {
+			_, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+			return 1, err
+		},
+		"batch": func(store *Store) (int64, error) {
+			_, err := store.PutBatch([]ItemWithReader{
+				{item, newDummyReadCloser(bytes.NewBufferString("hello"))},
+				{item, newDummyReadCloser(bytes.NewBufferString("world"))},
+			})
+			return 2, err
+		},
+	}
+
+	for name, insert := range inserts {
+		t.Run(name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithMaxItems(maxItems))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			var (
+				wg       sync.WaitGroup
+				inserted atomic.Int64
+				start    = make(chan struct{})
+			)
+			for n := 0; n < 32; n++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+
+					if n, err := insert(store); err == nil {
+						inserted.Add(n)
+					} else if err != ErrItemLimitReached {
+						t.Errorf("Concurrent insertion failed: %v", err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if ids, err := store.ListIDs(0, 0); err != nil {
+				t.Fatal(err)
+			} else if len(ids) > maxItems || int64(len(ids)) != inserted.Load() {
+				t.Fatalf("Store has %d Items after inserting %d concurrently, limited to %d", len(ids), inserted.Load(), maxItems)
+			} else if count := store.itemCount.Load(); count != int64(len(ids)) {
+				t.Fatalf("Store counts %d Items, but has %d", count, len(ids))
+			} else if reserved := store.reservedItems.Load(); reserved != 0 {
+				t.Fatalf("Store has %d reserved Items left", reserved)
+			}
+		})
+	}
+}
+
+func TestStoreIdSpaceNearlyFull(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	// A single byte results in 256 IDs, where a tenth allows 26 Items.
+	opts := []Option{
+		WithIdGenerator(randomIdGenerator(1)),
+		WithIdSpace(randomIdSpace(1), 0.1),
+		WithCleanup(false),
+	}
+
+	store, err := NewStore(storageDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err == ErrIDSpaceNearlyFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+		if len(ids) > 26 {
+			t.Fatalf("Put did not fail after %d Items", len(ids))
+		}
+	}
+	if len(ids) != 26 {
+		t.Fatalf("Put failed after %d Items, expected 26", len(ids))
+	}
+
+	// The Item count must survive a restart.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewStore(storageDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != ErrIDSpaceNearlyFull {
+		t.Fatalf("Put after restart returned %v", err)
+	}
+
+	// Deleting an Item frees space for a new one.
+	if err := store.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreGetFileWithPassword(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+
+	protectedId, err := store.PutWithPassword(item, newDummyReadCloser(bytes.NewBufferString("secret")), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("public")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if protectedItem, err := store.Get(protectedId); err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(protectedItem.PasswordHash, []byte("hunter2")) {
+		t.Fatalf("Item stores its password in plaintext")
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		password string
+		err      error
+		data     string
+	}{
+		{"correct-password", protectedId, "hunter2", nil, "secret"},
+		{"wrong-password", protectedId, "hunter3", ErrUnauthorized, ""},
+		{"missing-password", protectedId, "", ErrUnauthorized, ""},
+		{"no-password-required", publicId, "", nil, "public"},
+		{"unknown-item", "nope", "", ErrNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := store.GetFileWithPassword(test.id, test.password)
+			if err != test.err {
+				t.Fatalf("GetFileWithPassword returned %v, expected %v", err, test.err)
+			} else if err != nil {
+				return
+			}
+			defer f.Close()
+
+			buff, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			} else if string(buff) != test.data {
+				t.Fatalf("GetFileWithPassword returned %q, expected %q", buff, test.data)
+			}
+		})
+	}
+
+	// All other readers refuse the protected content without its password.
+	if _, err := store.GetFile(protectedId); err != ErrUnauthorized {
+		t.Fatalf("GetFile of a protected Item returned %v", err)
+	} else if _, _, err := store.GetWithFile(protectedId); err != ErrUnauthorized {
+		t.Fatalf("GetWithFile of a protected Item returned %v", err)
+	} else if _, _, _, err := store.GetWithCompressedFile(protectedId); err != ErrUnauthorized {
+		t.Fatalf("GetWithCompressedFile of a protected Item returned %v", err)
+	} else if _, err := store.GetFileRange(protectedId, 0, 1); err != ErrUnauthorized {
+		t.Fatalf("GetFileRange of a protected Item returned %v", err)
+	} else if _, err := store.StreamTo(protectedId, io.Discard); err != ErrUnauthorized {
+		t.Fatalf("StreamTo of a protected Item returned %v", err)
+	} else if _, _, err := store.GetWithFileAndPassword(protectedId, "hunter3"); err != ErrUnauthorized {
+		t.Fatalf("GetWithFileAndPassword with a wrong password returned %v", err)
+	}
+
+	_, f, err := store.GetWithFileAndPassword(protectedId, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if buff, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != "secret" {
+		t.Fatalf("GetWithFileAndPassword returned %q", buff)
+	}
+}
+
+func TestStoreDeleteUndownloadedBefore(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC()
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+
+	uploads := []struct {
+		name       string
+		created    time.Time
+		download   bool
+		locked     bool
+		shouldKeep bool
+	}{
+		{"old-undownloaded", old, false, false, false},
+		{"old-undownloaded-2", old, false, false, false},
+		{"old-downloaded", old, true, false, true},
+		{"old-locked", old, false, true, true},
+		{"recent-undownloaded", recent, false, false, true},
+		{"recent-downloaded", recent, true, false, true},
+		{"unknown-creation", time.Time{}, false, false, true},
+	}
+	ids := make(map[string]string)
+	for _, upload := range uploads {
+		item := Item{Created: upload.created, Expires: now.Add(time.Hour)}
+		if upload.locked {
+			item.LockedUntil = now.Add(time.Hour)
+		}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(upload.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[upload.name] = id
+
+		if upload.download {
+			f, err := store.GetFile(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(f); err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if deleted, err := store.DeleteUndownloadedBefore(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if deleted != 2 {
+		t.Fatalf("DeleteUndownloadedBefore deleted %d Items, expected 2", deleted)
+	}
+
+	for _, upload := range uploads {
+		_, err := store.Get(ids[upload.name])
+		if upload.shouldKeep && err != nil {
+			t.Fatalf("Item %q was not kept: %v", upload.name, err)
+		} else if !upload.shouldKeep && err != ErrNotFound {
+			t.Fatalf("Item %q was not deleted: %v", upload.name, err)
+		}
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != len(uploads)-2 {
+		t.Fatalf("Store has %d Items, expected %d", n, len(uploads)-2)
+	}
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found inconsistencies: %+v", report)
+	}
+}
+
+func TestStoreDeleteUndownloadedBeforeMany(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(8)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// All Items share the same Expires, enlarging each deletion.
+	now := time.Now().UTC()
+	item := Item{Created: now.Add(-48 * time.Hour), Expires: now.Add(time.Hour)}
+	for n := 0; n < 5; n++ {
+		var batch []ItemWithReader
+		for i := 0; i < 300; i++ {
+			batch = append(batch, ItemWithReader{
+				Item: item,
+				File: newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d-%d", n, i))),
+			})
+		}
+		if _, err := store.PutBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keptId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("downloaded")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.GetFile(keptId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, f)
+	_ = f.Close()
+
+	if deleted, err := store.DeleteUndownloadedBefore(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1500 {
+		t.Fatalf("DeleteUndownloadedBefore deleted %d Items, expected 1500", deleted)
+	}
+
+	if ids, err := store.ListIDs(0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 1 || ids[0] != keptId {
+		t.Fatalf("Store kept the Items %v, expected only %q", ids, keptId)
+	}
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found %d orphan and %d missing files", len(report.OrphanFiles), len(report.MissingFiles))
+	}
+}
+
+func TestStoreDeleteByIDRange(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Now())
+	idGenerator, err := ulidIdGenerator(10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(storageDir, WithIdGenerator(idGenerator), WithClock(clock), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var ids []string
+	for n := 0; n < 5; n++ {
+		item := Item{Expires: clock.Now().Add(time.Hour).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d", n))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		clock.Advance(time.Minute)
+	}
+
+	if _, err := store.DeleteByIDRange(ids[3], ids[1]); err == nil {
+		t.Fatalf("Inverted ID range was accepted")
+	}
+
+	if deleted, err := store.DeleteByIDRange(ids[1], ids[3]); err != nil {
+		t.Fatal(err)
+	} else if deleted != 3 {
+		t.Fatalf("DeleteByIDRange deleted %d Items, expected 3", deleted)
+	}
+
+	for n, id := range ids {
+		_, err := store.Get(id)
+		if inRange := n >= 1 && n <= 3; inRange && err != ErrNotFound {
+			t.Fatalf("Item %q within the range was not deleted: %v", id, err)
+		} else if !inRange && err != nil {
+			t.Fatalf("Item %q outside the range was not kept: %v", id, err)
+		}
+	}
+
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found inconsistencies: %+v", report)
+	}
+}
+
+func TestStoreDeleteByIDRangeMany(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Now())
+	idGenerator, err := ulidIdGenerator(10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(storageDir, WithIdGenerator(idGenerator), WithClock(clock), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var ids []string
+	for n := 0; n < 4; n++ {
+		var batch []ItemWithReader
+		for i := 0; i < 300; i++ {
+			batch = append(batch, ItemWithReader{
+				Item: Item{Expires: clock.Now().Add(time.Hour).UTC()},
+				File: newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("item %d-%d", n, i))),
+			})
+		}
+		batchIds, err := store.PutBatch(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, batchIds...)
+		clock.Advance(time.Minute)
+	}
+	sort.Strings(ids)
+
+	if _, err := store.DeleteByIDRange(ids[0], ids[0]+"0"); err == nil {
+		t.Fatal("ID range of bounds with different lengths was accepted")
+	}
+
+	if deleted, err := store.DeleteByIDRange(ids[100], ids[1099]); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1000 {
+		t.Fatalf("DeleteByIDRange deleted %d Items, expected 1000", deleted)
+	}
+
+	kept, err := store.ListIDs(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(kept)
+	if expected := append(append([]string{}, ids[:100]...), ids[1100:]...); !reflect.DeepEqual(kept, expected) {
+		t.Fatalf("Store kept %d Items, expected %d outside the range", len(kept), len(expected))
+	}
+	if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 || len(report.MissingFiles) > 0 {
+		t.Fatalf("Scan found %d orphan and %d missing files", len(report.OrphanFiles), len(report.MissingFiles))
+	}
+}
+
+func TestStoreEvictLRU(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithLastAccess(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := make([]string, 5)
+	for i := range ids {
+		item := Item{Expires: clock.Now().Add(time.Hour)}
+		ids[i], err = store.Put(item, newDummyReadCloser(bytes.NewBuffer(make([]byte, 100))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	// Access the two oldest uploads, making them the most recently used ones.
+	for _, id := range ids[:2] {
+		f, err := store.GetFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		if item, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !item.LastAccess.Equal(clock.Now()) {
+			t.Fatalf("Item's last access is %v, expected %v", item.LastAccess, clock.Now())
+		}
+		clock.Advance(time.Minute)
+	}
+
+	if deleted, err := store.EvictLRU(500); err != nil {
+		t.Fatal(err)
+	} else if deleted != 0 {
+		t.Fatalf("EvictLRU deleted %d Items below the target", deleted)
+	}
+
+	if deleted, err := store.EvictLRU(250); err != nil {
+		t.Fatal(err)
+	} else if deleted != 3 {
+		t.Fatalf("EvictLRU deleted %d Items, expected 3", deleted)
+	}
+
+	for i, id := range ids {
+		_, err := store.Get(id)
+		if i < 2 && err != nil {
+			t.Fatalf("Recently accessed Item %d was evicted: %v", i, err)
+		} else if i >= 2 && err != ErrNotFound {
+			t.Fatalf("Least recently accessed Item %d was not evicted: %v", i, err)
+		}
+	}
+}
+
+func TestStoreSlug(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	put := func(slug string) (string, error) {
+		item := Item{Slug: slug, Expires: time.Now().Add(time.Minute).UTC()}
+		return store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	}
+
+	plainId, err := put("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := put(""); err != nil {
+		t.Fatalf("Second Item without a Slug failed: %v", err)
+	}
+
+	slugId, err := put("quarterly-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := store.GetBySlug("quarterly-report"); err != nil {
+		t.Fatal(err)
+	} else if item.ID != slugId || item.Slug != "quarterly-report" {
+		t.Fatalf("GetBySlug returned Item %q with Slug %q", item.ID, item.Slug)
+	}
+	if _, err := store.GetBySlug("nope"); err != ErrNotFound {
+		t.Fatalf("GetBySlug for unknown Slug returned %v", err)
+	}
+
+	tests := []struct {
+		name string
+		slug string
+		err  error
+	}{
+		{"duplicate-slug", "quarterly-report", ErrSlugTaken},
+		{"existing-id", plainId, ErrSlugTaken},
+		{"slash", "../etc", ErrInvalidSlug},
+		{"space", "quarterly report", ErrInvalidSlug},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := put(test.slug); err != test.err {
+				t.Fatalf("Put with Slug %q returned %v, expected %v", test.slug, err, test.err)
+			}
+		})
+	}
+
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatalf("Store has %d Items after rejected Puts, expected 3", n)
+	}
+}
+
+func TestStoreSlugIndexConcurrent(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const items, downloads = 16, 20
+
+	ids := make([]string, items)
+	for n := range ids {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		ids[n], err = store.Put(item, newDummyReadCloser(bytes.NewBufferString(fmt.Sprintf("hello %d", n))))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Downloads of distinct Items without a Slug must not conflict.
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			for n := 0; n < downloads; n++ {
+				f, err := store.GetFile(id)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, err = io.Copy(io.Discard, f)
+				_ = f.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if i, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if i.Downloads != downloads {
+			t.Fatalf("Item %q has %d downloads, expected %d", id, i.Downloads, downloads)
+		}
+	}
+}
+
+func TestStoreSlugIndexScaling(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(8)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for n := 0; n < 1000; n++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		if n == 0 {
+			item.Slug = "the-only-slug"
+		}
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the single Slug is indexed, instead of one ever-growing entry for
+	// all Items without a Slug, being rewritten by each Put.
+	prefix := itemIndexKey("Slug", nil)
+	var entries int
+	err = store.bh.Badger().View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			entries++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if entries != 1 {
+		t.Fatalf("Slug index has %d entries, expected 1", entries)
+	}
+
+	if i, err := store.GetBySlug("the-only-slug"); err != nil {
+		t.Fatal(err)
+	} else if i.Slug != "the-only-slug" {
+		t.Fatalf("GetBySlug returned Item with Slug %q", i.Slug)
+	}
+}
+
+func TestStoreGetWithFile(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	data := []byte("hello world")
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itemX, f, err := store.GetWithFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	} else if itemX.ID != itemId {
+		t.Fatalf("GetWithFile returned Item %q, expected %q", itemX.ID, itemId)
+	}
+
+	// The opened file must stay readable after the Item was deleted.
+	if err := store.Delete(itemId); err != nil {
+		t.Fatal(err)
+	}
+	buff, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buff, data) {
+		t.Fatalf("GetWithFile returned %q, expected %q", buff, data)
+	}
+
+	if _, _, err := store.GetWithFile(itemId); err != ErrNotFound {
+		t.Fatalf("GetWithFile of deleted Item returned %v", err)
+	}
+}
+
+func TestStoreGetWithFileConcurrentDelete(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	data := []byte("hello world")
+	for i := 0; i < 64; i++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- store.Delete(itemId)
+		}()
+
+		// Either the Item is gone or both the Item and its content are intact.
+		itemX, f, err := store.GetWithFile(itemId)
+		if err == nil {
+			buff, readErr := io.ReadAll(f)
+			_ = f.Close()
+			if readErr != nil {
+				t.Fatal(readErr)
+			} else if itemX.ID != itemId || !bytes.Equal(buff, data) {
+				t.Fatalf("GetWithFile returned inconsistent Item %q with %q", itemX.ID, buff)
+			}
+		} else if err != ErrNotFound {
+			t.Fatalf("GetWithFile returned %v", err)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreLocked(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{
+		Expires:     clock.Now().Add(time.Minute),
+		LockedUntil: clock.Now().Add(time.Hour),
+	}
+	lockedId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrideId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(lockedId); err != ErrLocked {
+		t.Fatalf("Delete of locked Item returned %v", err)
+	}
+	if err := store.Extend(lockedId, clock.Now().Add(time.Second)); err != ErrLocked {
+		t.Fatalf("Shortening Extend of locked Item returned %v", err)
+	}
+	if err := store.Extend(lockedId, clock.Now().Add(2*time.Minute)); err != nil {
+		t.Fatalf("Prolonging Extend of locked Item failed: %v", err)
+	}
+
+	if err := store.ForceDelete(overrideId); err != nil {
+		t.Fatalf("ForceDelete of locked Item failed: %v", err)
+	} else if _, err := store.Get(overrideId); err != ErrNotFound {
+		t.Fatalf("Get of force deleted Item returned %v", err)
+	}
+
+	// Expired, but still locked.
+	clock.Advance(10 * time.Minute)
+	store.expiryPolicy = ExpiryDeleteOnAccess
+	if _, err := store.Get(lockedId); err != ErrNotFound {
+		t.Fatalf("Get of expired locked Item returned %v", err)
+	}
+	store.expiryPolicy = ExpiryIgnore
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 0 {
+		t.Fatalf("CleanupNow deleted %d locked Items", deleted)
+	}
+
+	// Both expired and retention passed.
+	clock.Advance(time.Hour)
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1 {
+		t.Fatalf("CleanupNow deleted %d Items, expected 1", deleted)
+	} else if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Store still contains %d Items", n)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	opts := []Option{WithIdGenerator(randomIdGenerator(8)), WithCleanup(false), WithInlineSize(4096)}
+
+	// Closing the Store flushes its memtable to level 0. Having compactL0 set,
+	// level 0 will be compacted into a lower level afterwards.
+	reopen := func(store *Store, compactL0 bool) *Store {
+		if store != nil {
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		openBadgerHold = func(opts badgerhold.Options) (*badgerhold.Store, error) {
+			opts.Options.CompactL0OnClose = compactL0
+			return badgerhold.Open(opts)
+		}
+		defer func() { openBadgerHold = badgerhold.Open }()
+
+		store, err := NewStore(storageDir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	store := reopen(nil, true)
+
+	data := make([]byte, 4096)
+	ids := make([]string, 0, 1024)
+	for i := 0; i < cap(ids); i++ {
+		_, _ = rand.Read(data)
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// The Items are now on a lower level, their tombstones will be on level 0.
+	store = reopen(store, false)
+	for _, id := range ids {
+		if err := store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store = reopen(store, false)
+	defer store.Close()
+
+	sizeBefore, err := store.databaseSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizeAfter, err := store.databaseSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizeAfter >= sizeBefore {
+		t.Fatalf("Compact did not reduce the database size: %d before, %d after", sizeBefore, sizeAfter)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := store.Compact(); err != ErrStoreClosed {
+		t.Fatalf("Compact of closed Store returned %v", err)
+	}
+}
+
+func TestStoreWarm(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	opts := []Option{WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithBlockCacheSize(1 << 20)}
+
+	store, err := NewStore(storageDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0, 16)
+	for i := 0; i < cap(ids); i++ {
+		item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(storageDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Warm(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := store.Get(id); err != nil {
+			t.Fatalf("Get of %q after Warm failed: %v", id, err)
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := store.Warm(); err != ErrStoreClosed {
+		t.Fatalf("Warm of closed Store returned %v", err)
+	}
+}
+
+func BenchmarkStoreFirstRead(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithCleanup(false))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ids := make([]string, 0, 1024)
+	for i := 0; i < cap(ids); i++ {
+		item := Item{Filename: "hello.txt", Expires: time.Now().Add(time.Hour).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := store.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	// Each iteration reopens the Store to measure the first reads only.
+	for _, warm := range []bool{false, true} {
+		b.Run(fmt.Sprintf("warm=%t", warm), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				store, err := NewStore(storageDir, WithCleanup(false))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if warm {
+					if err := store.Warm(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+
+				for _, id := range ids {
+					if _, err := store.Get(id); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.StopTimer()
+				if err := store.Close(); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestStoreDefaultTTL(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	ttl := 7 * 24 * time.Hour
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithDefaultTTL(ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	tests := []struct {
+		name     string
+		expires  time.Time
+		expected time.Time
+	}{
+		{"default", time.Time{}, clock.Now().Add(ttl)},
+		{"explicit", clock.Now().Add(time.Hour), clock.Now().Add(time.Hour)},
+		{"explicit-beyond-default", clock.Now().Add(30 * 24 * time.Hour), clock.Now().Add(30 * 24 * time.Hour)},
+		{"never", NoExpiry, NoExpiry},
+	}
+
+	ids := make(map[string]string)
+	for _, test := range tests {
+		id, err := store.Put(Item{Expires: test.expires}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[test.name] = id
+
+		if item, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !item.Expires.Equal(test.expected) {
+			t.Fatalf("Item %q expires %v, expected %v", test.name, item.Expires, test.expected)
+		} else if item.NeverExpires() != (test.name == "never") {
+			t.Fatalf("Item %q reports NeverExpires %t", test.name, item.NeverExpires())
+		}
+	}
+
+	// After the default TTL, only the Items with a later expiry are left.
+	clock.Advance(ttl + time.Minute)
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 2 {
+		t.Fatalf("CleanupNow deleted %d Items, expected 2", deleted)
+	}
+	for name, id := range ids {
+		_, err := store.Get(id)
+		if kept := name == "explicit-beyond-default" || name == "never"; kept && err != nil {
+			t.Fatalf("Item %q was deleted: %v", name, err)
+		} else if !kept && err != ErrNotFound {
+			t.Fatalf("Item %q was not deleted: %v", name, err)
+		}
+	}
+
+	// Far in the future, the never expiring Item is still there.
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	if _, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if _, err := store.Get(ids["never"]); err != nil {
+		t.Fatalf("Never expiring Item was deleted: %v", err)
+	}
+}
+
+func TestStoreHideAfter(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	itemDataRaw := []byte("hello world")
+	item := Item{
+		Expires:   clock.Now().Add(time.Hour),
+		HideAfter: clock.Now().Add(time.Minute),
+	}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(itemId); err != nil {
+		t.Fatalf("Item should not be hidden yet: %v", err)
+	}
+
+	// Hidden, but present.
+	clock.Advance(10 * time.Minute)
+	if _, err := store.Get(itemId); err != ErrNotFound {
+		t.Fatalf("Get of hidden Item returned %v", err)
+	} else if _, err := store.GetFile(itemId); err != ErrNotFound {
+		t.Fatalf("GetFile of hidden Item returned %v", err)
+	}
+
+	if i, f, err := store.GetForced(itemId); err != nil {
+		t.Fatalf("GetForced of hidden Item failed: %v", err)
+	} else if buff, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(itemDataRaw, buff) {
+		t.Fatalf("Store data mismatch: %v != %v", itemDataRaw, buff)
+	} else if i.ID != itemId {
+		t.Fatalf("GetForced returned Item %q, expected %q", i.ID, itemId)
+	} else {
+		f.Close()
+	}
+
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 0 {
+		t.Fatalf("CleanupNow deleted %d hidden Items", deleted)
+	}
+
+	// Finally expired.
+	clock.Advance(time.Hour)
+	if deleted, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	} else if deleted != 1 {
+		t.Fatalf("CleanupNow deleted %d Items, expected 1", deleted)
+	} else if _, _, err := store.GetForced(itemId); err != ErrNotFound {
+		t.Fatalf("GetForced of deleted Item returned %v", err)
+	}
+}
+
+func TestStorePeek(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithExpiryPolicy(ExpiryDeleteOnAccess),
+		WithLastAccess(true),
+		WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: clock.Now().Add(time.Hour), BurnAfterReading: true}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Peek(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if i, err := store.Peek(itemId); err != nil {
+		t.Fatal(err)
+	} else if i.Downloads != 0 || !i.LastAccess.Equal(stored.LastAccess) {
+		t.Fatalf("Peek changed the Item's access: %d downloads, last access %v", i.Downloads, i.LastAccess)
+	}
+
+	// The Item to be burned is still available for its actual download.
+	f, err := store.GetFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := store.Peek(itemId); err != nil {
+		t.Fatal(err)
+	} else if i.Downloads != 1 {
+		t.Fatalf("Item has %d downloads, expected 1", i.Downloads)
+	}
+
+	// An expired Item is returned, but not deleted as on Get.
+	clock.Advance(2 * time.Hour)
+	if i, err := store.Peek(itemId); err != nil {
+		t.Fatalf("Peek of expired Item failed: %v", err)
+	} else if !i.Expired(clock.Now()) {
+		t.Fatal("Peeked Item is not reported as expired")
+	}
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Store has %d Items after Peek, expected 1", n)
+	}
+
+	if _, err := store.Get(itemId); err != ErrNotFound {
+		t.Fatalf("Get of expired Item returned %v", err)
+	} else if _, err := store.Peek(itemId); err != ErrNotFound {
+		t.Fatalf("Peek of deleted Item returned %v", err)
+	}
+}
+
+func TestStorePutSniffContentType(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false), WithInlineSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	pngData := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 4096)...)
+	_, _ = rand.Read(pngData[8:])
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		expected    string
+	}{
+		{"file", pngData, "", "image/png"},
+		{"file-given-type", pngData, "application/x-foo", "application/x-foo"},
+		{"inline", []byte("hello world"), "", "text/plain; charset=utf-8"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The pipe is neither seekable nor readable twice.
+			pr, pw := io.Pipe()
+			go func() {
+				_, _ = pw.Write(test.data)
+				_ = pw.Close()
+			}()
+
+			item := Item{ContentType: test.contentType, Expires: time.Now().Add(time.Minute).UTC()}
+			itemId, err := store.Put(item, pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			itemX, err := store.Get(itemId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if itemX.Size != int64(len(test.data)) {
+				t.Fatalf("Size is %d, expected %d", itemX.Size, len(test.data))
+			} else if itemX.Checksum != checksum(test.data) {
+				t.Fatalf("Checksum is %q, expected %q", itemX.Checksum, checksum(test.data))
+			} else if itemX.ContentType != test.expected {
+				t.Fatalf("ContentType is %q, expected %q", itemX.ContentType, test.expected)
+			}
+		})
+	}
+}
+
+func TestStoreDownloads(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithLastAccess(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: clock.Now().Add(time.Hour)}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(make([]byte, 64*1024))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := clock.Now()
+
+	checkDownloads := func(downloads int64, lastAccess time.Time) {
+		t.Helper()
+
+		var i Item
+		if err := store.bh.Get(itemId, &i); err != nil {
+			t.Fatal(err)
+		} else if i.Downloads != downloads {
+			t.Fatalf("Item has %d downloads, expected %d", i.Downloads, downloads)
+		} else if !i.LastAccess.Equal(lastAccess) {
+			t.Fatalf("Item's last access is %v, expected %v", i.LastAccess, lastAccess)
+		}
+	}
+
+	// Aborted reads have no effect.
+	clock.Advance(time.Minute)
+	if f, err := store.GetFile(itemId); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Read(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloads(0, uploaded)
+
+	// Completely read downloads are recorded on Close.
+	clock.Advance(time.Minute)
+	f, err := store.GetFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := io.Copy(io.Discard, f); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloads(0, uploaded)
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloads(1, clock.Now())
+
+	// Closing twice counts once.
+	_ = f.Close()
+	checkDownloads(1, clock.Now())
+
+	clock.Advance(time.Minute)
+	if _, f, err := store.GetWithFile(itemId); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.Copy(io.Discard, f); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloads(2, clock.Now())
+}
+
+func TestStoreDownloadsConcurrent(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const readers, downloads = 16, 20
+
+	var wg sync.WaitGroup
+	for n := 0; n < readers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < downloads; n++ {
+				f, err := store.GetFile(itemId)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, err = io.Copy(io.Discard, f)
+				_ = f.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if i, err := store.Get(itemId); err != nil {
+		t.Fatal(err)
+	} else if i.Downloads != readers*downloads {
+		t.Fatalf("Item has %d downloads, expected %d", i.Downloads, readers*downloads)
+	}
+}
+
+func TestStoreGetFileRange(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress-%t", compress), func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir,
+				WithIdGenerator(randomIdGenerator(4)),
+				WithCleanup(false),
+				WithCompression(compress))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			itemDataRaw := []byte("hello world")
+			itemId, err := store.Put(
+				Item{Expires: time.Now().Add(time.Hour).UTC()},
+				newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := store.GetFileRange(itemId, 6, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Close(); err != nil {
+				t.Fatal(err)
+			} else if string(data) != "world" {
+				t.Fatalf("Range data is %q", data)
+			}
+
+			for _, r := range [][2]int64{{-1, 1}, {0, -1}, {6, 6}, {12, 0}} {
+				if _, err := store.GetFileRange(itemId, r[0], r[1]); err != ErrInvalidRange {
+					t.Fatalf("Range %v resulted in %v, expected ErrInvalidRange", r, err)
+				}
+			}
+
+			if _, err := store.GetFileRange("nope", 0, 1); err != ErrNotFound {
+				t.Fatalf("Range of unknown Item resulted in %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteWithToken(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	withKey, err := store.Put(
+		Item{Expires: time.Now().Add(time.Hour).UTC(), DeletionKey: "s3cr3t"},
+		newDummyReadCloser(bytes.NewBufferString("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutKey, err := store.Put(
+		Item{Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("bar")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		id, token string
+		expected  error
+	}{
+		{withKey, "nope", ErrUnauthorized},
+		{withKey, "", ErrUnauthorized},
+		{withoutKey, "", ErrUnauthorized},
+		{"nope", "s3cr3t", ErrNotFound},
+		{withKey, "s3cr3t", nil},
+		{withKey, "s3cr3t", ErrNotFound},
+	} {
+		if err := store.DeleteWithToken(test.id, test.token); err != test.expected {
+			t.Fatalf("Deleting %q with token %q resulted in %v, expected %v", test.id, test.token, err, test.expected)
+		}
+	}
+}
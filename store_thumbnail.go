@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// thumbnailMaxPixels limits the size of images to create thumbnails for, as
+// they are decoded completely into memory.
+const thumbnailMaxPixels = 64 << 20
+
+// Thumbnail is a downscaled preview of an image Item, stored next to it in the
+// database and created if enabled by WithThumbnails.
+type Thumbnail struct {
+	ID string `badgerhold:"key"`
+
+	ContentType string
+	Data        []byte
+}
+
+// createThumbnail stores a Thumbnail for a new Item, if enabled and if the
+// Item is a supported image. Failures are only logged, as the Item itself was
+// already stored.
+//
+// Password protected Items, Items to be burned, and Stores with encryption are
+// skipped, as the Thumbnail would reveal their content unprotected.
+func (s *Store) createThumbnail(id string) {
+	if s.thumbnailWidth <= 0 || s.thumbnailHeight <= 0 || s.encryptionKey != nil {
+		return
+	}
+
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err != nil {
+		s.logger.Warn("Failed to fetch Item for its thumbnail", slog.String("id", id), slog.Any("error", err))
+		return
+	} else if len(i.PasswordHash) > 0 || i.BurnAfterReading || !strings.HasPrefix(i.ContentType, "image/") {
+		return
+	}
+
+	f, err := s.openContent(i)
+	if err != nil {
+		s.logger.Warn("Failed to open Item for its thumbnail", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+	defer f.Close()
+
+	thumb, err := s.renderThumbnail(f)
+	if err != nil {
+		s.logger.Debug("Skip thumbnail of undecodable image", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+	thumb.ID = id
+
+	err = s.bh.Upsert(id, &thumb)
+	if err != nil {
+		s.logger.Warn("Failed to store thumbnail", slog.String("id", id), slog.Any("error", err))
+	}
+}
+
+// renderThumbnail decodes an image and encodes its downscaled version as a
+// Thumbnail, as JPEG for JPEG images and as PNG otherwise.
+func (s *Store) renderThumbnail(r io.Reader) (thumb Thumbnail, err error) {
+	var head bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return
+	} else if config.Width*config.Height > thumbnailMaxPixels {
+		err = image.ErrFormat
+		return
+	}
+
+	var img image.Image
+	switch format {
+	case "jpeg":
+		img, err = jpeg.Decode(io.MultiReader(&head, r))
+	case "png":
+		img, err = png.Decode(io.MultiReader(&head, r))
+	case "gif":
+		img, err = gif.Decode(io.MultiReader(&head, r))
+	default:
+		err = image.ErrFormat
+	}
+	if err != nil {
+		return
+	}
+
+	img = downscale(img, s.thumbnailWidth, s.thumbnailHeight)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		thumb.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		thumb.ContentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	thumb.Data = buf.Bytes()
+	return
+}
+
+// downscale shrinks an image to fit within maxWidth and maxHeight, keeping its
+// aspect ratio, by averaging the covered pixels. Smaller images are returned
+// unchanged.
+func downscale(src image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if srcWidth <= maxWidth && srcHeight <= maxHeight {
+		return src
+	}
+
+	width, height := maxWidth, srcHeight*maxWidth/srcWidth
+	if height > maxHeight {
+		width, height = srcWidth*maxHeight/srcHeight, maxHeight
+	}
+	width, height = max(1, width), max(1, height)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := bounds.Min.Y+y*srcHeight/height, bounds.Min.Y+max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := bounds.Min.X+x*srcWidth/width, bounds.Min.X+max((x+1)*srcWidth/width, x*srcWidth/width+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// GetThumbnail returns an Item's Thumbnail. For both unavailable Items and
+// Items without a Thumbnail, e.g., non-images, ErrNotFound is returned.
+func (s *Store) GetThumbnail(id string) (Thumbnail, error) {
+	i, err := s.get(id)
+	if err != nil {
+		return Thumbnail{}, err
+	} else if len(i.PasswordHash) > 0 {
+		return Thumbnail{}, ErrNotFound
+	}
+
+	var thumb Thumbnail
+	err = s.bh.Get(id, &thumb)
+	if err == badgerhold.ErrNotFound {
+		return Thumbnail{}, ErrNotFound
+	}
+	return thumb, err
+}
+
+// deleteThumbnail removes an Item's Thumbnail, if any.
+func (s *Store) deleteThumbnail(id string) error {
+	err := s.bh.Delete(id, &Thumbnail{})
+	if err == badgerhold.ErrNotFound {
+		return nil
+	}
+	return err
+}
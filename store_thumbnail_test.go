@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestPNG encodes a gradient PNG image of the given size.
+func newTestPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStoreThumbnail(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithThumbnails(64, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expires := time.Now().Add(time.Minute).UTC()
+
+	tests := []struct {
+		name          string
+		item          Item
+		data          []byte
+		width, height int
+	}{
+		{"landscape", Item{ContentType: "image/png"}, newTestPNG(t, 256, 64), 64, 16},
+		{"portrait", Item{ContentType: "image/png"}, newTestPNG(t, 50, 200), 8, 32},
+		{"small", Item{ContentType: "image/png"}, newTestPNG(t, 10, 10), 10, 10},
+		{"text", Item{ContentType: "text/plain"}, []byte("hello world"), 0, 0},
+		{"broken-image", Item{ContentType: "image/png"}, []byte("hello world"), 0, 0},
+		{"burn", Item{ContentType: "image/png", BurnAfterReading: true}, newTestPNG(t, 256, 64), 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.item.Expires = expires
+			id, err := store.Put(test.item, newDummyReadCloser(bytes.NewBuffer(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			thumb, err := store.GetThumbnail(id)
+			if test.width == 0 {
+				if err != ErrNotFound {
+					t.Fatalf("Thumbnail resulted in %v, expected ErrNotFound", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if thumb.ContentType != "image/png" {
+				t.Fatalf("Thumbnail has content type %q", thumb.ContentType)
+			}
+
+			config, err := png.DecodeConfig(bytes.NewReader(thumb.Data))
+			if err != nil {
+				t.Fatal(err)
+			} else if config.Width != test.width || config.Height != test.height {
+				t.Fatalf("Thumbnail is %dx%d, expected %dx%d", config.Width, config.Height, test.width, test.height)
+			}
+
+			if err := store.Delete(id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetThumbnail(id); err != ErrNotFound {
+				t.Fatalf("Thumbnail of deleted Item resulted in %v", err)
+			}
+		})
+	}
+}
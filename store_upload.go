@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// dirUploads is the subdirectory of the storage directory for the content of
+// PartialUploads. As a directory, it is ignored by Scan and moved along by
+// MoveStore.
+const dirUploads = ".uploads"
+
+// defaultUploadExpiry is the default of WithUploadExpiry.
+const defaultUploadExpiry = 24 * time.Hour
+
+// ErrUploadOffsetMismatch is returned by Store.AppendUpload if the offset of a
+// chunk differs from the already received content or if another chunk is
+// currently being appended.
+var ErrUploadOffsetMismatch = errors.New("Upload offset does not match the received content")
+
+// PartialUpload is a resumable upload of an Item's content in multiple
+// chunks, created by Store.CreateUpload. Its content is persisted after each
+// chunk, allowing to resume an interrupted upload at its Offset.
+type PartialUpload struct {
+	ID string `badgerhold:"key"`
+
+	// Item to be created after the upload was completed.
+	Item Item
+
+	// Offset is the amount of received bytes, Length the expected total.
+	Offset int64
+	Length int64
+
+	// Expires is extended by each chunk. Afterwards, the PartialUpload is
+	// considered abandoned and deleted by the cleanup.
+	Expires time.Time
+
+	// ItemID is the ID of the Item created after the last chunk, recorded
+	// before its content is stored. If the PartialUpload could not be
+	// removed afterwards, e.g., after a crash, a retried final chunk returns
+	// this Item instead of creating it again.
+	ItemID string
+}
+
+// uploadsDir returns the directory of the PartialUploads' content.
+func (s *Store) uploadsDir() string {
+	return filepath.Join(s.storageDir(), dirUploads)
+}
+
+// CreateUpload starts a PartialUpload of length bytes for the Item, which is
+// created by the final AppendUpload. The Item's lifetime, as the difference
+// between its Created and Expires, starts after its completion.
+func (s *Store) CreateUpload(i Item, length int64) (u PartialUpload, err error) {
+	s.logger.Debug("Requested creation of a partial upload", slog.Int64("length", length))
+
+	if length <= 0 {
+		return PartialUpload{}, errors.New("upload length must be positive")
+	}
+
+	err = s.beginInFlight()
+	if err != nil {
+		return
+	}
+	defer s.inFlight.Done()
+
+	// The ID is a random secret like a DeletionKey, as it allows appending.
+	id, err := newDeletionKey()
+	if err != nil {
+		return
+	}
+
+	u = PartialUpload{
+		ID:      id,
+		Item:    i,
+		Length:  length,
+		Expires: s.clock.Now().Add(s.uploadExpiry),
+	}
+
+	err = os.MkdirAll(s.uploadsDir(), 0700)
+	if err != nil {
+		s.logger.Error("Failed to create uploads directory", slog.Any("error", err))
+		return
+	}
+
+	f, err := s.open(filepath.Join(s.uploadsDir(), id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		s.logger.Error("Failed to create partial upload file", slog.Any("error", err))
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	err = s.bh.Insert(id, &u)
+	if err != nil {
+		s.logger.Error("Failed to insert partial upload", slog.Any("error", err))
+		_ = s.removeFile(filepath.Join(s.uploadsDir(), id))
+		return
+	}
+
+	s.logger.Info("Created partial upload", slog.Int64("length", length), slog.Any("expires", u.Expires))
+	return
+}
+
+// GetUpload returns a PartialUpload, e.g., to resume it at its Offset. Both
+// unknown and abandoned PartialUploads result in ErrNotFound.
+func (s *Store) GetUpload(id string) (u PartialUpload, err error) {
+	err = s.bh.Get(id, &u)
+	if err == badgerhold.ErrNotFound {
+		err = ErrNotFound
+		return
+	} else if err != nil {
+		return
+	}
+
+	if !s.clock.Now().Before(u.Expires) {
+		err = ErrNotFound
+	}
+	return
+}
+
+// AppendUpload appends a chunk, read from r, to a PartialUpload at offset,
+// which must equal its current Offset. Content exceeding the expected Length
+// is dropped and ErrFileTooBig is returned.
+//
+// The received content is persisted even if reading r fails, e.g., for an
+// interrupted connection, and the updated PartialUpload is returned together
+// with the error. After the last chunk, the Item is created and its ID is
+// returned, while the PartialUpload is removed.
+func (s *Store) AppendUpload(ctx context.Context, id string, offset int64, r io.Reader) (u PartialUpload, itemId string, err error) {
+	err = s.beginInFlight()
+	if err != nil {
+		return
+	}
+	defer s.inFlight.Done()
+
+	// Concurrent chunks for the same upload cannot both match its offset.
+	if _, active := s.activeUploads.LoadOrStore(id, struct{}{}); active {
+		err = ErrUploadOffsetMismatch
+		return
+	}
+	defer s.activeUploads.Delete(id)
+
+	u, err = s.GetUpload(id)
+	if err != nil {
+		return
+	}
+
+	itemId, err = s.uploadedItem(u)
+	if err != nil || itemId != "" {
+		return
+	} else if offset != u.Offset {
+		err = ErrUploadOffsetMismatch
+		return
+	}
+
+	n, appendErr := s.appendUploadFile(u, r)
+	u.Offset += n
+	u.Expires = s.clock.Now().Add(s.uploadExpiry)
+
+	err = s.bh.Update(id, &u)
+	if err != nil {
+		s.logger.Error("Failed to update partial upload", slog.Any("error", err))
+		return
+	} else if appendErr != nil {
+		err = appendErr
+		return
+	}
+
+	s.logger.Debug("Appended to partial upload", slog.Int64("offset", u.Offset), slog.Int64("length", u.Length))
+	if u.Offset < u.Length {
+		return
+	}
+
+	itemId, err = s.completeUpload(ctx, u)
+	return
+}
+
+// appendUploadFile appends r to a PartialUpload's file, returning the amount
+// of appended bytes. A file exceeding the PartialUpload's Offset, e.g., after
+// a crash, is truncated first.
+func (s *Store) appendUploadFile(u PartialUpload, r io.Reader) (n int64, err error) {
+	f, err := s.open(filepath.Join(s.uploadsDir(), u.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	err = f.Truncate(u.Offset)
+	if err != nil {
+		return
+	}
+	_, err = f.Seek(u.Offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	remaining := u.Length - u.Offset
+	n, err = io.Copy(f, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		n = remaining
+		err = errors.Join(f.Truncate(u.Length), ErrFileTooBig)
+	}
+	if err != nil {
+		return
+	}
+
+	if s.fileSync != FileSyncNever {
+		err = f.Sync()
+	}
+	return
+}
+
+// completeUpload creates the Item of a completely received PartialUpload and
+// removes the PartialUpload afterwards.
+func (s *Store) completeUpload(ctx context.Context, u PartialUpload) (itemId string, err error) {
+	path := filepath.Join(s.uploadsDir(), u.ID)
+	f, err := s.open(path, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+
+	// Neither never expiring Items nor those left to the default TTL have a
+	// lifetime to be shifted.
+	i := u.Item
+	now := s.clock.Now().UTC()
+	lifetime := i.Expires.Sub(i.Created)
+	i.Created = now
+	if !i.Expires.IsZero() && !i.NeverExpires() {
+		i.Expires = now.Add(lifetime)
+	}
+
+	itemId, err = s.putContext(ctx, i, f, func(id string) error {
+		u.ItemID = id
+		return s.bh.Update(u.ID, &u)
+	})
+	if err != nil {
+		s.logger.Error("Failed to store completed upload", slog.Any("error", err))
+		return
+	}
+
+	err = s.deleteUpload(u.ID)
+	if err != nil {
+		s.logger.Warn("Failed to remove completed partial upload, left to the cleanup",
+			slog.String("id", itemId), slog.Any("error", err))
+		err = nil
+	}
+
+	s.logger.Info("Completed partial upload", slog.String("id", itemId))
+	return
+}
+
+// uploadedItem returns the ID of a PartialUpload's already created Item, if
+// any, and removes the left over PartialUpload. An Item whose content was not
+// stored completely, e.g., due to a crash, is removed instead, and an empty
+// ID is returned to create it again.
+func (s *Store) uploadedItem(u PartialUpload) (string, error) {
+	if u.ItemID == "" {
+		return "", nil
+	}
+
+	i, err := s.Peek(u.ItemID)
+	if err == ErrNotFound || (err == nil && i.Size != u.Length) {
+		s.logger.Warn("Recreate incomplete Item of partial upload", slog.String("id", u.ItemID))
+		if err == nil {
+			err = s.ForceDelete(u.ItemID)
+		}
+		if err != nil && err != ErrNotFound && err != badgerhold.ErrNotFound {
+			return "", err
+		}
+
+		u.ItemID = ""
+		return "", s.bh.Update(u.ID, &u)
+	} else if err != nil {
+		return "", err
+	}
+
+	s.logger.Info("Partial upload was already completed", slog.String("id", u.ItemID))
+	if err := s.deleteUpload(u.ID); err != nil {
+		s.logger.Warn("Failed to remove completed partial upload, left to the cleanup",
+			slog.String("id", u.ItemID), slog.Any("error", err))
+	}
+	return u.ItemID, nil
+}
+
+// deleteUpload removes a PartialUpload's file and database entry.
+func (s *Store) deleteUpload(id string) error {
+	err := s.removeFile(filepath.Join(s.uploadsDir(), id))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = s.bh.Delete(id, &PartialUpload{})
+	if err == badgerhold.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// deleteAbandonedUploads deletes all expired PartialUploads, except those
+// currently being appended to.
+func (s *Store) deleteAbandonedUploads() (deleted int, err error) {
+	var uploads []PartialUpload
+	err = s.bh.Find(&uploads, badgerhold.Where("Expires").Lt(s.clock.Now()))
+	if err != nil {
+		return
+	}
+
+	var errs []error
+	for _, u := range uploads {
+		if _, active := s.activeUploads.Load(u.ID); active {
+			continue
+		}
+
+		if delErr := s.deleteUpload(u.ID); delErr != nil {
+			errs = append(errs, fmt.Errorf("deleting upload %q failed: %w", u.ID, delErr))
+			continue
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		s.logger.Info("Deleted abandoned partial uploads", slog.Int("deleted", deleted))
+	}
+	err = errors.Join(errs...)
+	return
+}
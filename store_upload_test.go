@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreUpload(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	data := []byte("hello world")
+	item := Item{Filename: "hello.txt", Created: clock.Now(), Expires: clock.Now().Add(time.Hour)}
+
+	u, err := store.CreateUpload(item, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An interrupted chunk keeps its received content.
+	clock.Advance(time.Minute)
+	u, itemId, err := store.AppendUpload(context.Background(), u.ID, 0,
+		io.MultiReader(bytes.NewReader(data[:4]), failingReader{io.ErrUnexpectedEOF}))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Interrupted chunk resulted in %v", err)
+	} else if u.Offset != 4 || itemId != "" {
+		t.Fatalf("Interrupted chunk resulted in offset %d and ID %q", u.Offset, itemId)
+	}
+
+	if u, err := store.GetUpload(u.ID); err != nil {
+		t.Fatal(err)
+	} else if u.Offset != 4 {
+		t.Fatalf("Upload's offset is %d, expected 4", u.Offset)
+	}
+
+	if _, _, err := store.AppendUpload(context.Background(), u.ID, 0, bytes.NewReader(data)); err != ErrUploadOffsetMismatch {
+		t.Fatalf("Chunk with a wrong offset resulted in %v", err)
+	}
+	if _, _, err := store.AppendUpload(context.Background(), u.ID, 4, bytes.NewReader(data[4:6])); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lifetime starts with the completed upload.
+	clock.Advance(time.Minute)
+	u, itemId, err = store.AppendUpload(context.Background(), u.ID, 6, bytes.NewReader(data[6:]))
+	if err != nil {
+		t.Fatal(err)
+	} else if itemId == "" {
+		t.Fatal("Completed upload has no Item ID")
+	}
+
+	i, f, err := store.GetWithFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, _ := io.ReadAll(f); !bytes.Equal(content, data) {
+		t.Fatalf("Uploaded content is %q", content)
+	} else if i.Filename != "hello.txt" {
+		t.Fatalf("Uploaded Item has filename %q", i.Filename)
+	} else if !i.Created.Equal(clock.Now()) || !i.Expires.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Uploaded Item was created %v and expires %v", i.Created, i.Expires)
+	}
+
+	if _, err := store.GetUpload(u.ID); err != ErrNotFound {
+		t.Fatalf("Completed upload resulted in %v", err)
+	} else if _, err := os.Stat(filepath.Join(store.uploadsDir(), u.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Completed upload's file still exists: %v", err)
+	}
+}
+
+func TestStoreUploadRetryCompleted(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	data := []byte("hello world")
+	item := Item{Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+
+	u, err := store.CreateUpload(item, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, itemId, err := store.AppendUpload(context.Background(), u.ID, 0, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The PartialUpload is left over as if its removal had failed.
+	u.Offset, u.ItemID = u.Length, itemId
+	if err := store.bh.Insert(u.ID, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int64{0, u.Length} {
+		_, retriedId, err := store.AppendUpload(context.Background(), u.ID, offset, bytes.NewReader(nil))
+		if offset == 0 && (err != nil || retriedId != itemId) {
+			t.Fatalf("Retried final chunk returned %q and %v, expected %q", retriedId, err, itemId)
+		} else if offset != 0 && err != ErrNotFound {
+			t.Fatalf("Chunk after the removed upload resulted in %v", err)
+		}
+	}
+	if ids, err := store.ListIDs(0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 1 {
+		t.Fatalf("Store has %d Items, expected 1", len(ids))
+	}
+
+	// An Item whose content was not stored completely, e.g., due to a crash,
+	// is created again.
+	u, err = store.CreateUpload(item, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(filepath.Join(store.uploadsDir(), u.ID), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	u.Offset, u.ItemID = u.Length, "stale"
+	if err := store.bh.Update(u.ID, &u); err != nil {
+		t.Fatal(err)
+	} else if err := store.bh.Insert("stale", Item{ID: "stale", Expires: item.Expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, recreatedId, err := store.AppendUpload(context.Background(), u.ID, u.Length, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	} else if recreatedId == "" || recreatedId == "stale" {
+		t.Fatalf("Incomplete Item was returned as %q", recreatedId)
+	} else if _, err := store.Peek("stale"); err != ErrNotFound {
+		t.Fatalf("Incomplete Item was kept: %v", err)
+	}
+	assertItemContent(t, store, recreatedId, data)
+}
+
+func TestStoreUploadTooBig(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	u, err := store.CreateUpload(Item{Created: time.Now(), Expires: time.Now().Add(time.Hour)}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, itemId, err := store.AppendUpload(context.Background(), u.ID, 0, bytes.NewBufferString("hello"))
+	if !errors.Is(err, ErrFileTooBig) {
+		t.Fatalf("Oversized chunk resulted in %v", err)
+	} else if u.Offset != 4 || itemId != "" {
+		t.Fatalf("Oversized chunk resulted in offset %d and ID %q", u.Offset, itemId)
+	}
+}
+
+func TestStoreUploadAbandoned(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	clock := newManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	store, err := NewStore(storageDir,
+		WithIdGenerator(randomIdGenerator(4)),
+		WithCleanup(false),
+		WithClock(clock),
+		WithUploadExpiry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Created: clock.Now(), Expires: clock.Now().Add(time.Hour)}
+	abandoned, err := store.CreateUpload(item, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := store.CreateUpload(item, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each chunk extends the expiry.
+	clock.Advance(45 * time.Minute)
+	if _, _, err := store.AppendUpload(context.Background(), active.ID, 0, bytes.NewBufferString("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if _, err := store.GetUpload(abandoned.ID); err != ErrNotFound {
+		t.Fatalf("Abandoned upload resulted in %v", err)
+	} else if _, _, err := store.AppendUpload(context.Background(), abandoned.ID, 0, bytes.NewBufferString("foo")); err != ErrNotFound {
+		t.Fatalf("Appending to abandoned upload resulted in %v", err)
+	}
+
+	if _, err := store.CleanupNow(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.uploadsDir(), abandoned.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Abandoned upload's file still exists: %v", err)
+	} else if err := store.bh.Get(abandoned.ID, &PartialUpload{}); err == nil {
+		t.Fatal("Abandoned upload still exists")
+	}
+	if u, err := store.GetUpload(active.ID); err != nil {
+		t.Fatal(err)
+	} else if u.Offset != 3 {
+		t.Fatalf("Active upload's offset is %d", u.Offset)
+	}
+
+	// Partial uploads are no Items.
+	if n, err := store.Count(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Store counts %d Items", n)
+	} else if report, err := store.Scan(); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanFiles) > 0 {
+		t.Fatalf("Scan reports orphaned files %v", report.OrphanFiles)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// StreamZip writes a zip archive of the Items' contents into w, e.g., to
+// download several related files at once. Each entry is named by its Item's
+// Filename, or its ID without one. Duplicate names get a counter appended,
+// e.g., "notes (2).txt". The contents are streamed one after another without
+// being buffered.
+//
+// All Items are fetched before anything is written. An unavailable Item
+// results in ErrNotFound and a password protected one in ErrUnauthorized,
+// unless such Items are skipped by WithZipSkipMissing. An error while
+// streaming leaves an incomplete archive in w.
+//
+// Like for GetWithFile, BurnAfterReading is left to the caller.
+func (s *Store) StreamZip(ids []string, w io.Writer) error {
+	s.logger.Debug("Requested zip archive of Items", slog.Int("items", len(ids)))
+
+	items := make([]Item, 0, len(ids))
+	for _, id := range ids {
+		i, err := s.get(id)
+		if err == nil && len(i.PasswordHash) > 0 {
+			err = ErrUnauthorized
+		}
+		if err == ErrNotFound || err == ErrUnauthorized {
+			if s.zipSkipMissing {
+				s.logger.Debug("Skip Item for zip archive", slog.String("id", id), slog.Any("error", err))
+				continue
+			}
+			return fmt.Errorf("Item %q: %w", id, err)
+		} else if err != nil {
+			return err
+		}
+		items = append(items, i)
+	}
+
+	zw := zip.NewWriter(w)
+	names := make(map[string]struct{}, len(items))
+	for _, i := range items {
+		err := s.writeZipEntry(zw, i, zipEntryName(i, names))
+		if err != nil {
+			return fmt.Errorf("Item %q: %w", i.ID, err)
+		}
+	}
+	return zw.Close()
+}
+
+// writeZipEntry streams an Item's content as a new entry into the archive.
+func (s *Store) writeZipEntry(zw *zip.Writer, i Item, name string) error {
+	f, err := s.openDownload(i, s.openContent)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: i.Created})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
+
+// zipEntryName returns an unused name for an Item's archive entry and marks
+// it as used within names.
+func zipEntryName(i Item, names map[string]struct{}) string {
+	name := i.Filename
+	if name == "" {
+		name = i.ID
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		if _, taken := names[name]; !taken {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+
+	names[name] = struct{}{}
+	return name
+}
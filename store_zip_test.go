@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoreStreamZip(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, WithIdGenerator(randomIdGenerator(4)), WithCleanup(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	uploads := []struct {
+		filename string
+		data     string
+	}{
+		{"notes.txt", "first notes"},
+		{"notes.txt", "second notes"},
+		{"", "unnamed"},
+	}
+	var ids []string
+	for _, upload := range uploads {
+		item := Item{Filename: upload.filename, Expires: time.Now().Add(time.Minute).UTC()}
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString(upload.data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	var buf bytes.Buffer
+	if err := store.StreamZip(ids, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedNames := []string{"notes.txt", "notes (2).txt", ids[2]}
+	if len(zr.File) != len(expectedNames) {
+		t.Fatalf("Archive has %d entries, expected %d", len(zr.File), len(expectedNames))
+	}
+	for n, f := range zr.File {
+		if f.Name != expectedNames[n] {
+			t.Fatalf("Entry %d is named %q, expected %q", n, f.Name, expectedNames[n])
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(content) != uploads[n].data {
+			t.Fatalf("Entry %q has content %q, expected %q", f.Name, content, uploads[n].data)
+		}
+	}
+
+	// A missing Item fails the archive before anything is written, unless
+	// being skipped.
+	buf.Reset()
+	if err := store.StreamZip([]string{ids[0], "missing"}, &buf); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Missing Item resulted in %v", err)
+	} else if buf.Len() > 0 {
+		t.Fatalf("Failed archive wrote %d bytes", buf.Len())
+	}
+
+	store.zipSkipMissing = true
+	if err := store.StreamZip([]string{ids[0], "missing"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(zr.File) != 1 || zr.File[0].Name != "notes.txt" {
+		t.Fatalf("Archive skipping the missing Item has unexpected entries %v", zr.File)
+	}
+}
@@ -247,14 +247,7 @@ func (serv *Server) hasClientCachedRequest(r *http.Request, item Item) bool {
 }
 
 // handleRequestServe is called from handleRequest when a valid Item should be served.
-func (serv *Server) handleRequestServe(w http.ResponseWriter, r *http.Request, item Item) error {
-	f, err := serv.store.GetFile(item.ID, context.Background())
-	if err != nil {
-		return fmt.Errorf("reading file failed: %v", err)
-	}
-
-	defer f.Close()
-
+func (serv *Server) handleRequestServe(w http.ResponseWriter, r *http.Request, item Item, f io.Reader) error {
 	mimeType := item.ContentType
 	if mimeSubst, ok := serv.mimeMap[mimeType]; ok {
 		mimeType = mimeSubst
@@ -286,8 +279,24 @@ func (serv *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	_, reqId, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
 	reqId = strings.TrimLeft(reqId, "/")
 
-	item, err := serv.store.Get(reqId, context.Background())
-	if err == ErrNotFound {
+	// A conditional GET is answered before opening the file, unless the Item
+	// is password protected and the password must be checked first.
+	if item, err := serv.store.Get(reqId, context.Background()); err == nil &&
+		len(item.PasswordHash) == 0 && serv.hasClientCachedRequest(r, item) {
+		slog.Debug("Requested with conditional GET; HTTP Status Code 304", slog.String("id", reqId))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	_, password, _ := r.BasicAuth()
+	item, f, err := serv.store.GetWithFileAndPassword(reqId, password, context.Background())
+	if err == ErrUnauthorized {
+		slog.Debug("Requested protected ID without its password", slog.String("id", reqId))
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="gosh"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	} else if err == ErrNotFound {
 		slog.Debug("Requested non-existing ID", slog.String("id", reqId))
 
 		http.Error(w, msgNotExists, http.StatusNotFound)
@@ -298,12 +307,13 @@ func (serv *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msgGenericError, http.StatusBadRequest)
 		return
 	}
+	defer f.Close()
 
 	if serv.hasClientCachedRequest(r, item) {
 		slog.Debug("Requested with conditional GET; HTTP Status Code 304", slog.String("id", reqId))
 		w.WriteHeader(http.StatusNotModified)
 	} else {
-		err := serv.handleRequestServe(w, r, item)
+		err := serv.handleRequestServe(w, r, item, f)
 		if err != nil {
 			slog.Warn("Failed to serve request",
 				slog.Any("error", err), slog.String("id", reqId))